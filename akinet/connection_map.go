@@ -0,0 +1,107 @@
+package akinet
+
+import (
+	"time"
+)
+
+// Holds per-connection state for a parser factory whose parsers share
+// information across the flows of a TCP connection. Because the end of a
+// connection may never be seen, connections that have been idle for too long
+// are forgotten.
+//
+// A ConnectionMap is not safe for concurrent use. Factories guard it with
+// their own mutex, along with any other state derived from the connections.
+type ConnectionMap struct {
+	idleTimeout time.Duration
+	onForget    func(id TCPBidiID, state interface{})
+
+	connections map[TCPBidiID]*connectionMapEntry
+	lastSweep   time.Time
+
+	// Overridden in tests.
+	now func() time.Time
+}
+
+type connectionMapEntry struct {
+	state        interface{}
+	lastActivity time.Time
+}
+
+// Returns an empty ConnectionMap. If onForget is not nil, it is called with
+// the state of each connection that is forgotten for being idle, so that the
+// caller can update anything derived from it.
+func NewConnectionMap(idleTimeout time.Duration, onForget func(id TCPBidiID, state interface{})) *ConnectionMap {
+	return &ConnectionMap{
+		idleTimeout: idleTimeout,
+		onForget:    onForget,
+		connections: map[TCPBidiID]*connectionMapEntry{},
+		lastSweep:   time.Now(),
+		now:         time.Now,
+	}
+}
+
+// Returns the state of the given connection, or nil if it isn't known. Counts
+// as activity on the connection.
+func (m *ConnectionMap) Get(id TCPBidiID) interface{} {
+	now := m.now()
+	m.sweep(now)
+
+	e, ok := m.connections[id]
+	if !ok {
+		return nil
+	}
+	e.lastActivity = now
+	return e.state
+}
+
+// Returns the state of the given connection, creating it with newState if
+// necessary. Counts as activity on the connection.
+func (m *ConnectionMap) GetOrCreate(id TCPBidiID, newState func() interface{}) interface{} {
+	if state := m.Get(id); state != nil {
+		return state
+	}
+	e := &connectionMapEntry{
+		state:        newState(),
+		lastActivity: m.now(),
+	}
+	m.connections[id] = e
+	return e.state
+}
+
+// Forgets about the given connection. Does not call onForget.
+func (m *ConnectionMap) Delete(id TCPBidiID) {
+	delete(m.connections, id)
+}
+
+// Returns the number of connections being tracked.
+func (m *ConnectionMap) Len() int {
+	return len(m.connections)
+}
+
+// Calls f with the state of each connection, in no particular order, until f
+// returns false.
+func (m *ConnectionMap) Range(f func(id TCPBidiID, state interface{}) bool) {
+	for id, e := range m.connections {
+		if !f(id, e.state) {
+			return
+		}
+	}
+}
+
+// Forgets about connections that have been idle for too long. To keep the
+// cost down, this only runs once per idle timeout.
+func (m *ConnectionMap) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < m.idleTimeout {
+		return
+	}
+	m.lastSweep = now
+
+	for id, e := range m.connections {
+		if now.Sub(e.lastActivity) > m.idleTimeout {
+			delete(m.connections, id)
+			if m.onForget != nil {
+				m.onForget(id, e.state)
+			}
+		}
+	}
+}
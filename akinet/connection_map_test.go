@@ -0,0 +1,51 @@
+package akinet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestConnectionMap(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	var forgotten []TCPBidiID
+	m := NewConnectionMap(10*time.Minute, func(id TCPBidiID, state interface{}) {
+		forgotten = append(forgotten, id)
+	})
+	m.now = func() time.Time { return now }
+	m.lastSweep = now
+
+	idle := TCPBidiID(uuid.New())
+	active := TCPBidiID(uuid.New())
+	newState := func() interface{} { return new(int) }
+
+	if m.Get(idle) != nil {
+		t.Fatalf("expected unknown connection to have no state")
+	}
+	idleState := m.GetOrCreate(idle, newState).(*int)
+	*idleState = 1
+	if m.GetOrCreate(idle, newState).(*int) != idleState {
+		t.Errorf("expected existing state to be returned")
+	}
+	m.GetOrCreate(active, newState)
+
+	// Keep one connection active past the idle timeout.
+	now = now.Add(6 * time.Minute)
+	m.Get(active)
+	now = now.Add(6 * time.Minute)
+	m.Get(active)
+
+	if m.Len() != 1 || m.Get(idle) != nil || m.Get(active) == nil {
+		t.Errorf("expected only the idle connection to be forgotten")
+	}
+	if len(forgotten) != 1 || forgotten[0] != idle {
+		t.Errorf("expected onForget to be called for the idle connection, got %v", forgotten)
+	}
+
+	// Deleted connections are not passed to onForget.
+	m.Delete(active)
+	if m.Len() != 0 || len(forgotten) != 1 {
+		t.Errorf("expected deleted connection to be forgotten quietly")
+	}
+}
@@ -0,0 +1,305 @@
+// Package conntrack aggregates the TCP metadata parsed from packets into
+// reports about TCP connections.
+package conntrack
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/api_schema"
+)
+
+const (
+	// The default for Options.IdleTimeout.
+	DefaultIdleTimeout = 5 * time.Minute
+
+	// The default for Options.CloseTimeout.
+	DefaultCloseTimeout = 5 * time.Second
+
+	// How often, in traffic time, to look for connections to report.
+	sweepInterval = time.Second
+)
+
+type Options struct {
+	// Open connections that see no packets for this long are reported with
+	// the end state akinet.ConnectionOpen. Defaults to DefaultIdleTimeout.
+	IdleTimeout time.Duration
+
+	// Closed connections are reported once they have seen no packets for this
+	// long, so that the final ACKs and any retransmissions are counted.
+	// Defaults to DefaultCloseTimeout.
+	CloseTimeout time.Duration
+}
+
+// Tracks TCP connections from the akinet.TCPPacketMetadata and
+// akinet.TCPConnectionMetadata in parsed traffic. Time is measured by the
+// ObservationTime of the traffic, so that captures can be replayed faster than
+// real time.
+//
+// Not thread-safe.
+type Tracker struct {
+	opts Options
+
+	connections map[akid.ConnectionID]*connection
+	lastSweep   time.Time
+}
+
+// The state of a tracked connection.
+type connection struct {
+	id akid.ConnectionID
+
+	// The two endpoints, in the order that they were first seen.
+	a, b endpoint
+
+	// The endpoint that initiated the connection, or nil if unknown.
+	initiator *endpoint
+
+	// The time at which the initiator sent its SYN, if seen.
+	synTime time.Time
+
+	handshakeRTT *time.Duration
+
+	firstObserved, lastObserved time.Time
+	endState                    akinet.TCPConnectionEndState
+}
+
+type endpoint struct {
+	ip   net.IP
+	port int
+
+	// The traffic sent by this endpoint.
+	stats api_schema.TCPDirectionStats
+
+	// The sequence number that follows the latest data sent from this
+	// endpoint, once known.
+	nextSeq      uint32
+	nextSeqKnown bool
+
+	synSeen bool
+	finSeen bool
+}
+
+func NewTracker(opts Options) *Tracker {
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.CloseTimeout <= 0 {
+		opts.CloseTimeout = DefaultCloseTimeout
+	}
+	return &Tracker{
+		opts:        opts,
+		connections: map[akid.ConnectionID]*connection{},
+	}
+}
+
+// Reads traffic from in until it is closed, and sends a report to out for
+// each connection once it has been closed or timed out. Reports any remaining
+// connections when in is closed, and then closes out.
+func (t *Tracker) Run(in <-chan akinet.ParsedNetworkTraffic, out chan<- *api_schema.TCPConnectionReport) {
+	defer close(out)
+	for traffic := range in {
+		for _, r := range t.Add(traffic) {
+			out <- r
+		}
+	}
+	for _, r := range t.Flush() {
+		out <- r
+	}
+}
+
+// Adds TCP metadata to the tracker. Returns the reports of any connections
+// that have been closed or have timed out, as of the traffic's observation
+// time. Traffic other than TCP metadata is ignored.
+func (t *Tracker) Add(traffic akinet.ParsedNetworkTraffic) []*api_schema.TCPConnectionReport {
+	switch c := traffic.Content.(type) {
+	case akinet.TCPPacketMetadata:
+		t.addPacket(traffic, c)
+	case akinet.TCPConnectionMetadata:
+		t.addConnection(traffic, c)
+	default:
+		return nil
+	}
+
+	if now := traffic.ObservationTime; now.Sub(t.lastSweep) >= sweepInterval {
+		t.lastSweep = now
+		return t.sweep(now)
+	}
+	return nil
+}
+
+// Reports all tracked connections, and stops tracking them.
+func (t *Tracker) Flush() []*api_schema.TCPConnectionReport {
+	return t.sweep(time.Time{})
+}
+
+// Returns the state of the given connection, creating it if necessary.
+func (t *Tracker) connection(id akid.ConnectionID, traffic akinet.ParsedNetworkTraffic) *connection {
+	if conn, ok := t.connections[id]; ok {
+		return conn
+	}
+	conn := &connection{
+		id:            id,
+		a:             endpoint{ip: traffic.SrcIP, port: traffic.SrcPort},
+		b:             endpoint{ip: traffic.DstIP, port: traffic.DstPort},
+		firstObserved: traffic.ObservationTime,
+		lastObserved:  traffic.ObservationTime,
+		endState:      akinet.ConnectionOpen,
+	}
+	t.connections[id] = conn
+	return conn
+}
+
+func (t *Tracker) addPacket(traffic akinet.ParsedNetworkTraffic, p akinet.TCPPacketMetadata) {
+	conn := t.connection(p.ConnectionID, traffic)
+	conn.observe(traffic)
+
+	sender, receiver := conn.endpoints(traffic)
+	sender.stats.Packets++
+	sender.stats.PayloadBytes += int64(p.PayloadLength_bytes)
+
+	if p.SYN {
+		if sender.synSeen {
+			sender.stats.Retransmissions++
+		}
+		sender.synSeen = true
+
+		// A SYN without an ACK comes from the initiator; a SYN-ACK comes from the
+		// other side.
+		if conn.initiator == nil {
+			if p.ACK {
+				conn.initiator = receiver
+			} else {
+				conn.initiator = sender
+			}
+		}
+		if !p.ACK && sender == conn.initiator && conn.synTime.IsZero() {
+			conn.synTime = traffic.ObservationTime
+		}
+	} else if p.ACK && sender == conn.initiator && !conn.synTime.IsZero() && conn.handshakeRTT == nil {
+		// The initiator's first ACK completes the handshake.
+		rtt := traffic.ObservationTime.Sub(conn.synTime)
+		conn.handshakeRTT = &rtt
+	}
+
+	if p.SeqAckWindowKnown {
+		sender.addSequence(p)
+	}
+
+	if p.RST {
+		conn.endState = akinet.ConnectionReset
+	} else if p.FIN {
+		sender.finSeen = true
+		if conn.endState == akinet.ConnectionOpen {
+			conn.endState = akinet.ConnectionClosed
+		}
+	}
+}
+
+// Updates the zero-window and retransmission counts with a packet sent by this
+// endpoint whose sequence number and window are known.
+func (e *endpoint) addSequence(p akinet.TCPPacketMetadata) {
+	if p.Window == 0 && !p.SYN && !p.RST {
+		e.stats.ZeroWindows++
+	}
+
+	// A packet whose data ends at or before the end of the data already sent is
+	// a retransmission. Sequence numbers wrap, so they are compared by their
+	// signed difference.
+	end := p.Seq + uint32(p.PayloadLength_bytes)
+	if p.SYN || p.FIN {
+		end++
+	}
+	if p.PayloadLength_bytes > 0 && e.nextSeqKnown && int32(end-e.nextSeq) <= 0 {
+		e.stats.Retransmissions++
+	}
+	if !e.nextSeqKnown || int32(end-e.nextSeq) > 0 {
+		e.nextSeq, e.nextSeqKnown = end, true
+	}
+}
+
+func (t *Tracker) addConnection(traffic akinet.ParsedNetworkTraffic, c akinet.TCPConnectionMetadata) {
+	conn := t.connection(c.ConnectionID, traffic)
+	conn.observe(traffic)
+
+	src, dst := conn.endpoints(traffic)
+	if conn.initiator == nil {
+		switch c.Initiator {
+		case akinet.SourceInitiator:
+			conn.initiator = src
+		case akinet.DestInitiator:
+			conn.initiator = dst
+		}
+	}
+	if conn.endState == akinet.ConnectionOpen && c.EndState != "" {
+		conn.endState = c.EndState
+	}
+}
+
+// Reports and forgets connections that have been closed or have timed out as
+// of the given time. If now is zero, all connections are reported.
+func (t *Tracker) sweep(now time.Time) []*api_schema.TCPConnectionReport {
+	var reports []*api_schema.TCPConnectionReport
+	for id, conn := range t.connections {
+		if !now.IsZero() {
+			idle := now.Sub(conn.lastObserved)
+			closed := conn.endState == akinet.ConnectionReset || (conn.a.finSeen && conn.b.finSeen)
+			if idle < t.opts.IdleTimeout && (!closed || idle < t.opts.CloseTimeout) {
+				continue
+			}
+		}
+		reports = append(reports, conn.report())
+		delete(t.connections, id)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].FirstObserved.Before(reports[j].FirstObserved)
+	})
+	return reports
+}
+
+func (conn *connection) observe(traffic akinet.ParsedNetworkTraffic) {
+	if traffic.ObservationTime.Before(conn.firstObserved) {
+		conn.firstObserved = traffic.ObservationTime
+	}
+	last := traffic.FinalPacketTime
+	if last.Before(traffic.ObservationTime) {
+		last = traffic.ObservationTime
+	}
+	if last.After(conn.lastObserved) {
+		conn.lastObserved = last
+	}
+}
+
+// Returns the endpoints that sent and received the traffic.
+func (conn *connection) endpoints(traffic akinet.ParsedNetworkTraffic) (sender, receiver *endpoint) {
+	if conn.a.port == traffic.SrcPort && conn.a.ip.Equal(traffic.SrcIP) {
+		return &conn.a, &conn.b
+	}
+	return &conn.b, &conn.a
+}
+
+// If the initiator is known, it is the report's source. Otherwise, the
+// endpoint that was seen first is.
+func (conn *connection) report() *api_schema.TCPConnectionReport {
+	src, dst := &conn.a, &conn.b
+	if conn.initiator == dst {
+		src, dst = dst, src
+	}
+	return &api_schema.TCPConnectionReport{
+		ID:             conn.id,
+		SrcAddr:        src.ip,
+		SrcPort:        uint16(src.port),
+		DestAddr:       dst.ip,
+		DestPort:       uint16(dst.port),
+		FirstObserved:  conn.firstObserved,
+		LastObserved:   conn.lastObserved,
+		InitiatorKnown: conn.initiator != nil,
+		EndState:       conn.endState,
+		SrcToDest:      src.stats,
+		DestToSrc:      dst.stats,
+		HandshakeRTT:   conn.handshakeRTT,
+	}
+}
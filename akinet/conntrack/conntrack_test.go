@@ -0,0 +1,240 @@
+package conntrack
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/api_schema"
+)
+
+var (
+	testStart = time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	clientIP = net.IP{10, 0, 0, 1}
+	serverIP = net.IP{10, 0, 0, 2}
+)
+
+func ms(n int) time.Time {
+	return testStart.Add(time.Duration(n) * time.Millisecond)
+}
+
+func duration(d time.Duration) *time.Duration {
+	return &d
+}
+
+type flags struct {
+	syn, ack, fin, rst bool
+}
+
+var (
+	syn    = flags{syn: true}
+	synAck = flags{syn: true, ack: true}
+	ack    = flags{ack: true}
+	finAck = flags{fin: true, ack: true}
+	rst    = flags{rst: true}
+)
+
+// Returns a packet from the client if fromClient is true, and from the server
+// otherwise.
+func packet(id akid.ConnectionID, fromClient bool, f flags, seq uint32, payload int, window uint16, t time.Time) akinet.ParsedNetworkTraffic {
+	traffic := akinet.ParsedNetworkTraffic{
+		SrcIP:   clientIP,
+		SrcPort: 54321,
+		DstIP:   serverIP,
+		DstPort: 80,
+		Content: akinet.TCPPacketMetadata{
+			ConnectionID:        id,
+			SYN:                 f.syn,
+			ACK:                 f.ack,
+			FIN:                 f.fin,
+			RST:                 f.rst,
+			PayloadLength_bytes: payload,
+			Seq:                 seq,
+			Window:              window,
+			SeqAckWindowKnown:   true,
+		},
+		ObservationTime: t,
+		FinalPacketTime: t,
+	}
+	if !fromClient {
+		traffic.SrcIP, traffic.DstIP = traffic.DstIP, traffic.SrcIP
+		traffic.SrcPort, traffic.DstPort = traffic.DstPort, traffic.SrcPort
+	}
+	return traffic
+}
+
+func run(tracker *Tracker, traffic ...akinet.ParsedNetworkTraffic) []*api_schema.TCPConnectionReport {
+	in := make(chan akinet.ParsedNetworkTraffic, len(traffic))
+	for _, t := range traffic {
+		in <- t
+	}
+	close(in)
+
+	out := make(chan *api_schema.TCPConnectionReport)
+	go tracker.Run(in, out)
+
+	var results []*api_schema.TCPConnectionReport
+	for r := range out {
+		results = append(results, r)
+	}
+	return results
+}
+
+var cmpOptions = []cmp.Option{
+	cmp.Comparer(func(a, b akid.ConnectionID) bool { return a == b }),
+}
+
+func TestTracker(t *testing.T) {
+	id := akid.GenerateConnectionID()
+	tracker := NewTracker(Options{})
+	reports := run(tracker,
+		packet(id, true, syn, 100, 0, 65535, ms(0)),
+		packet(id, false, synAck, 500, 0, 65535, ms(10)),
+		packet(id, true, ack, 101, 0, 65535, ms(20)),
+		packet(id, true, ack, 101, 50, 65535, ms(21)),
+		// A retransmission of the client's data.
+		packet(id, true, ack, 101, 50, 65535, ms(30)),
+		packet(id, false, ack, 501, 200, 0, ms(40)),
+		packet(id, true, finAck, 151, 0, 65535, ms(50)),
+		packet(id, false, finAck, 701, 0, 65535, ms(60)),
+		packet(id, true, ack, 152, 0, 65535, ms(70)),
+	)
+
+	expected := []*api_schema.TCPConnectionReport{
+		{
+			ID:             id,
+			SrcAddr:        clientIP,
+			SrcPort:        54321,
+			DestAddr:       serverIP,
+			DestPort:       80,
+			FirstObserved:  ms(0),
+			LastObserved:   ms(70),
+			InitiatorKnown: true,
+			EndState:       akinet.ConnectionClosed,
+			SrcToDest: api_schema.TCPDirectionStats{
+				Packets:         6,
+				PayloadBytes:    100,
+				Retransmissions: 1,
+			},
+			DestToSrc: api_schema.TCPDirectionStats{
+				Packets:      3,
+				PayloadBytes: 200,
+				ZeroWindows:  1,
+			},
+			HandshakeRTT: duration(20 * time.Millisecond),
+		},
+	}
+	if diff := cmp.Diff(expected, reports, cmpOptions...); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+}
+
+func TestTrackerMidConnection(t *testing.T) {
+	id := akid.GenerateConnectionID()
+	tracker := NewTracker(Options{})
+	reports := run(tracker,
+		// The capture starts with the server's SYN-ACK, so the initiator is still
+		// known, but the handshake RTT isn't.
+		packet(id, false, synAck, 500, 0, 65535, ms(0)),
+		packet(id, true, ack, 101, 10, 65535, ms(10)),
+		packet(id, false, rst, 501, 0, 0, ms(20)),
+	)
+
+	expected := []*api_schema.TCPConnectionReport{
+		{
+			ID:             id,
+			SrcAddr:        clientIP,
+			SrcPort:        54321,
+			DestAddr:       serverIP,
+			DestPort:       80,
+			FirstObserved:  ms(0),
+			LastObserved:   ms(20),
+			InitiatorKnown: true,
+			EndState:       akinet.ConnectionReset,
+			SrcToDest:      api_schema.TCPDirectionStats{Packets: 1, PayloadBytes: 10},
+			DestToSrc:      api_schema.TCPDirectionStats{Packets: 2},
+		},
+	}
+	if diff := cmp.Diff(expected, reports, cmpOptions...); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+}
+
+func TestTrackerUnknownSequence(t *testing.T) {
+	id := akid.GenerateConnectionID()
+	unknown := func(traffic akinet.ParsedNetworkTraffic) akinet.ParsedNetworkTraffic {
+		p := traffic.Content.(akinet.TCPPacketMetadata)
+		p.Seq, p.Window, p.SeqAckWindowKnown = 0, 0, false
+		traffic.Content = p
+		return traffic
+	}
+
+	// Packets whose sequence number and window weren't recorded count neither
+	// as zero-window advertisements nor as retransmissions.
+	tracker := NewTracker(Options{})
+	reports := run(tracker,
+		unknown(packet(id, true, ack, 101, 50, 65535, ms(0))),
+		unknown(packet(id, true, ack, 151, 50, 65535, ms(10))),
+		unknown(packet(id, false, ack, 501, 200, 65535, ms(20))),
+	)
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reports))
+	}
+	expectedSrcToDest := api_schema.TCPDirectionStats{Packets: 2, PayloadBytes: 100}
+	expectedDestToSrc := api_schema.TCPDirectionStats{Packets: 1, PayloadBytes: 200}
+	if diff := cmp.Diff(expectedSrcToDest, reports[0].SrcToDest); diff != "" {
+		t.Errorf("found unexpected diff in client stats:\n%s", diff)
+	}
+	if diff := cmp.Diff(expectedDestToSrc, reports[0].DestToSrc); diff != "" {
+		t.Errorf("found unexpected diff in server stats:\n%s", diff)
+	}
+}
+
+func TestTrackerTimeouts(t *testing.T) {
+	idle := akid.GenerateConnectionID()
+	closed := akid.GenerateConnectionID()
+	tracker := NewTracker(Options{IdleTimeout: time.Minute, CloseTimeout: time.Second})
+
+	if reports := tracker.Add(packet(idle, true, ack, 1, 10, 100, ms(0))); len(reports) != 0 {
+		t.Errorf("expected no reports, got %d", len(reports))
+	}
+	tracker.Add(packet(closed, true, finAck, 1, 0, 100, ms(1000)))
+	tracker.Add(packet(closed, false, finAck, 1, 0, 100, ms(1100)))
+
+	// The closed connection is reported once it has been quiet for the close
+	// timeout; the idle connection is still open.
+	reports := tracker.Add(packet(closed, true, ack, 2, 0, 100, ms(1200)))
+	if len(reports) != 0 {
+		t.Errorf("expected no reports, got %d", len(reports))
+	}
+	reports = tracker.Add(akinet.ParsedNetworkTraffic{
+		Content:         akinet.TCPConnectionMetadata{ConnectionID: idle},
+		SrcIP:           clientIP,
+		SrcPort:         54321,
+		ObservationTime: ms(2500),
+	})
+	if len(reports) != 1 || reports[0].ID != closed {
+		t.Fatalf("expected the closed connection to be reported, got %v", reports)
+	}
+	if reports[0].InitiatorKnown || reports[0].EndState != akinet.ConnectionClosed {
+		t.Errorf("unexpected report for the closed connection: %+v", reports[0])
+	}
+
+	// The idle connection times out.
+	reports = tracker.Add(packet(akid.GenerateConnectionID(), true, ack, 1, 0, 100, ms(2500+60000)))
+	if len(reports) != 1 || reports[0].ID != idle {
+		t.Fatalf("expected the idle connection to be reported, got %v", reports)
+	}
+	if reports[0].EndState != akinet.ConnectionOpen || reports[0].LastObserved != ms(2500) {
+		t.Errorf("unexpected report for the idle connection: %+v", reports[0])
+	}
+
+	if reports := tracker.Flush(); len(reports) != 1 {
+		t.Errorf("expected one report from flush, got %d", len(reports))
+	}
+}
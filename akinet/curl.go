@@ -0,0 +1,836 @@
+package akinet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Replaces secrets in curl commands generated with CurlOptions.RedactSecrets.
+const CurlRedacted = "REDACTED"
+
+// The boundary used for multipart bodies built from --form options.
+const curlFormBoundary = "------------------------akitacurlform"
+
+// Headers whose values are replaced by CurlRedacted when
+// CurlOptions.RedactSecrets is set. For the authorization headers, the
+// authentication scheme is kept.
+var curlSecretHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"X-Api-Key":            true,
+	"X-Auth-Token":         true,
+	"X-Amz-Security-Token": true,
+	"X-Csrf-Token":         true,
+}
+
+type CurlOptions struct {
+	// If set, the values of cookies and of headers that commonly carry
+	// credentials are replaced with CurlRedacted.
+	RedactSecrets bool
+
+	// Additional headers to redact when RedactSecrets is set.
+	RedactHeaders []string
+
+	// If set, each option is put on its own line.
+	Multiline bool
+}
+
+// Returns a curl command that sends the request. The command is quoted for
+// POSIX shells.
+//
+// The scheme is https if the request was decrypted from a TLS connection.
+// Cookies are sent with --cookie, and multipart bodies made up only of simple
+// fields are sent with --form. Other bodies are sent verbatim.
+func (r HTTPRequest) ToCurl(opts CurlOptions) string {
+	redact := map[string]bool{}
+	if opts.RedactSecrets {
+		for name := range curlSecretHeaders {
+			redact[name] = true
+		}
+		for _, name := range opts.RedactHeaders {
+			redact[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+
+	u := r.curlURL()
+	// Each option, with its argument if it has one.
+	var args [][]string
+	add := func(a ...string) {
+		args = append(args, a)
+	}
+
+	var cookies []*http.Cookie
+	if len(r.Cookies) > 0 {
+		cookies = r.Cookies
+	} else {
+		cookies = (&http.Request{Header: r.Header}).Cookies()
+	}
+
+	form, isForm := curlFormFields(r.Header.Get("Content-Type"), r.Body)
+
+	// The method, if curl wouldn't use it by default.
+	hasBody := len(r.Body) > 0
+	switch {
+	case r.Method == http.MethodHead && !hasBody:
+		add("--head")
+	case r.Method == "", r.Method == http.MethodGet && !hasBody, r.Method == http.MethodPost && hasBody:
+	default:
+		add("--request", r.Method)
+	}
+
+	switch {
+	case r.ProtoMajor == 1 && r.ProtoMinor == 0:
+		add("--http1.0")
+	case r.ProtoMajor == 2:
+		add("--http2")
+	case r.ProtoMajor == 3:
+		add("--http3")
+	}
+
+	for _, name := range sortedKeys(r.Header) {
+		switch name {
+		case "Host":
+			if len(r.Header[name]) == 1 && r.Header.Get(name) == u.Host {
+				continue
+			}
+		case "Content-Length":
+			// curl computes the length from the body.
+			continue
+		case "Cookie":
+			continue
+		case "Content-Type":
+			if isForm {
+				// curl chooses the boundary.
+				continue
+			}
+		}
+
+		for _, value := range r.Header[name] {
+			if redact[name] {
+				value = redactCurlHeader(name, value)
+			}
+			if value == "" {
+				// curl removes headers with empty values, unless they are given with a
+				// semicolon.
+				add("--header", name+";")
+			} else {
+				add("--header", name+": "+value)
+			}
+		}
+	}
+
+	if r.Header.Get("Accept-Encoding") != "" {
+		// Have curl decode the response.
+		add("--compressed")
+	}
+
+	if len(cookies) > 0 {
+		pairs := make([]string, 0, len(cookies))
+		for _, c := range cookies {
+			value := c.Value
+			if opts.RedactSecrets {
+				value = CurlRedacted
+			}
+			pairs = append(pairs, c.Name+"="+value)
+		}
+		add("--cookie", strings.Join(pairs, "; "))
+	}
+
+	if isForm {
+		for _, f := range form {
+			add("--form-string", f[0]+"="+f[1])
+		}
+	} else if hasBody {
+		if bytes.HasPrefix(r.Body, []byte("@")) {
+			// Stop curl from reading the body from a file.
+			add("--data-raw", string(r.Body))
+		} else {
+			add("--data-binary", string(r.Body))
+		}
+	}
+
+	add(u.String())
+
+	sep := " "
+	if opts.Multiline {
+		sep = " \\\n  "
+	}
+	var b strings.Builder
+	b.WriteString("curl")
+	for _, arg := range args {
+		quoted := make([]string, len(arg))
+		for i, a := range arg {
+			quoted[i] = shellQuote(a)
+		}
+		b.WriteString(sep)
+		b.WriteString(strings.Join(quoted, " "))
+	}
+	return b.String()
+}
+
+// Returns the URL of the request, including its scheme and host.
+func (r HTTPRequest) curlURL() url.URL {
+	var u url.URL
+	if r.URL != nil {
+		u = *r.URL
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if r.TLSConnectionID != nil {
+			u.Scheme = "https"
+		}
+	}
+	if r.Host != "" {
+		u.Host = r.Host
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u
+}
+
+// Returns the name and value of each part of a multipart/form-data body, if
+// every part is a simple field that can be sent with --form-string.
+func curlFormFields(contentType string, body []byte) ([][2]string, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, false
+	}
+
+	var fields [][2]string
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return fields, err == io.EOF && len(fields) > 0
+		}
+		if part.FileName() != "" || part.FormName() == "" || len(part.Header) > 1 {
+			return nil, false
+		}
+		var value bytes.Buffer
+		if _, err := value.ReadFrom(part); err != nil {
+			return nil, false
+		}
+		if !utf8.Valid(value.Bytes()) || strings.HasPrefix(part.FormName(), "-") {
+			return nil, false
+		}
+		fields = append(fields, [2]string{part.FormName(), value.String()})
+	}
+}
+
+// Redacts the value of a header. The authentication scheme of authorization
+// headers is kept.
+func redactCurlHeader(name, value string) string {
+	if name == "Authorization" || name == "Proxy-Authorization" {
+		if i := strings.IndexByte(value, ' '); i > 0 {
+			return value[:i+1] + CurlRedacted
+		}
+	}
+	return CurlRedacted
+}
+
+// Characters that never need quoting in a POSIX shell.
+func isShellSafe(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_./:=@%+,", c)
+}
+
+// Quotes a string for a POSIX shell. Strings that aren't printable UTF-8 are
+// quoted with $'...', which bash, zsh and ksh understand.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe, printable := true, utf8.ValidString(s)
+	for _, c := range s {
+		if !isShellSafe(c) {
+			safe = false
+		}
+		if c < ' ' && c != '\n' && c != '\t' || c == 0x7f {
+			printable = false
+		}
+	}
+	if safe {
+		return s
+	}
+	if printable {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+
+	var b strings.Builder
+	b.WriteString("$'")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == '\'':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// curl options that take no argument, and that ParseCurl understands.
+var curlFlags = map[string]bool{
+	"--compressed":            true,
+	"--get":                   true,
+	"--head":                  true,
+	"--http1.0":               true,
+	"--http1.1":               true,
+	"--http2":                 true,
+	"--http2-prior-knowledge": true,
+	"--http3":                 true,
+
+	// Ignored.
+	"--fail":        true,
+	"--globoff":     true,
+	"--include":     true,
+	"--insecure":    true,
+	"--location":    true,
+	"--no-buffer":   true,
+	"--path-as-is":  true,
+	"--show-error":  true,
+	"--silent":      true,
+	"--verbose":     true,
+	"--ipv4":        true,
+	"--ipv6":        true,
+	"--remote-name": true,
+}
+
+// curl options that take an argument, and that ParseCurl understands.
+var curlOptionsWithArgs = map[string]bool{
+	"--request":        true,
+	"--header":         true,
+	"--cookie":         true,
+	"--data":           true,
+	"--data-ascii":     true,
+	"--data-binary":    true,
+	"--data-raw":       true,
+	"--data-urlencode": true,
+	"--form":           true,
+	"--form-string":    true,
+	"--user":           true,
+	"--user-agent":     true,
+	"--referer":        true,
+	"--url":            true,
+
+	// Ignored.
+	"--output":          true,
+	"--max-time":        true,
+	"--connect-timeout": true,
+	"--retry":           true,
+	"--cacert":          true,
+	"--cert":            true,
+	"--key":             true,
+	"--resolve":         true,
+	"--connect-to":      true,
+	"--proxy":           true,
+	"--write-out":       true,
+	"--max-redirs":      true,
+	"--cookie-jar":      true,
+	"--dump-header":     true,
+	"--interface":       true,
+	"--limit-rate":      true,
+}
+
+// Short forms of curl options.
+var curlShortOptions = map[byte]string{
+	'0': "--http1.0",
+	'G': "--get",
+	'I': "--head",
+	'f': "--fail",
+	'g': "--globoff",
+	'i': "--include",
+	'k': "--insecure",
+	'L': "--location",
+	'N': "--no-buffer",
+	'S': "--show-error",
+	's': "--silent",
+	'v': "--verbose",
+	'4': "--ipv4",
+	'6': "--ipv6",
+	'O': "--remote-name",
+
+	'X': "--request",
+	'H': "--header",
+	'b': "--cookie",
+	'd': "--data",
+	'F': "--form",
+	'u': "--user",
+	'A': "--user-agent",
+	'e': "--referer",
+	'o': "--output",
+	'm': "--max-time",
+	'x': "--proxy",
+	'w': "--write-out",
+	'c': "--cookie-jar",
+	'D': "--dump-header",
+	'E': "--cert",
+}
+
+// Parses a curl command into the request that it would send. The command is
+// split into arguments following POSIX shell quoting rules, including
+// backslash line continuations and $'...' strings.
+//
+// Options that read from files, such as --data @file, are not supported, and
+// result in an error, as do options that ParseCurl doesn't know. Options that
+// don't affect the request, such as --silent, are ignored.
+func ParseCurl(cmd string) (HTTPRequest, error) {
+	args, err := splitShellWords(cmd)
+	if err != nil {
+		return HTTPRequest{}, errors.Wrap(err, "failed to split curl command")
+	}
+	if len(args) == 0 || (args[0] != "curl" && !strings.HasSuffix(args[0], "/curl")) {
+		return HTTPRequest{}, errors.New("not a curl command")
+	}
+
+	var (
+		method     string
+		rawURL     string
+		header     = http.Header{}
+		cookies    []*http.Cookie
+		data       []string
+		form       [][2]string
+		hasData    bool
+		useGet     bool
+		head       bool
+		compressed bool
+		protoMajor = 1
+		protoMinor = 1
+	)
+
+	// Expand combined short options, and split --option=value.
+	var opts []string
+	var values []string
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			for _, a := range args[i+1:] {
+				opts, values = append(opts, "--url"), append(values, a)
+			}
+			i = len(args)
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := arg, "", false
+			if j := strings.IndexByte(arg, '='); j > 0 {
+				name, value, hasValue = arg[:j], arg[j+1:], true
+			}
+			switch {
+			case curlFlags[name] && !hasValue:
+				opts, values = append(opts, name), append(values, "")
+			case curlOptionsWithArgs[name]:
+				if !hasValue {
+					if i+1 >= len(args) {
+						return HTTPRequest{}, errors.Errorf("missing argument for %s", name)
+					}
+					i++
+					value = args[i]
+				}
+				opts, values = append(opts, name), append(values, value)
+			default:
+				return HTTPRequest{}, errors.Errorf("unsupported curl option %s", arg)
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for j := 1; j < len(arg); j++ {
+				name, ok := curlShortOptions[arg[j]]
+				if !ok {
+					return HTTPRequest{}, errors.Errorf("unsupported curl option -%c", arg[j])
+				}
+				if curlFlags[name] {
+					opts, values = append(opts, name), append(values, "")
+					continue
+				}
+
+				// The rest of the argument, or the next argument, is the value.
+				value := arg[j+1:]
+				if value == "" {
+					if i+1 >= len(args) {
+						return HTTPRequest{}, errors.Errorf("missing argument for -%c", arg[j])
+					}
+					i++
+					value = args[i]
+				}
+				opts, values = append(opts, name), append(values, value)
+				break
+			}
+		default:
+			opts, values = append(opts, "--url"), append(values, arg)
+		}
+	}
+
+	for i, opt := range opts {
+		value := values[i]
+		switch opt {
+		case "--request":
+			method = value
+		case "--head":
+			head = true
+		case "--get":
+			useGet = true
+		case "--url":
+			if rawURL != "" {
+				return HTTPRequest{}, errors.New("more than one URL")
+			}
+			rawURL = value
+		case "--header":
+			if err := addCurlHeader(header, value); err != nil {
+				return HTTPRequest{}, err
+			}
+		case "--user-agent":
+			header.Set("User-Agent", value)
+		case "--referer":
+			header.Set("Referer", value)
+		case "--user":
+			header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(value)))
+		case "--cookie":
+			if !strings.Contains(value, "=") {
+				return HTTPRequest{}, errors.Errorf("reading cookies from a file is not supported: %s", value)
+			}
+			cookies = append(cookies, (&http.Request{Header: http.Header{"Cookie": {value}}}).Cookies()...)
+		case "--compressed":
+			compressed = true
+		case "--http1.0":
+			protoMajor, protoMinor = 1, 0
+		case "--http1.1":
+			protoMajor, protoMinor = 1, 1
+		case "--http2", "--http2-prior-knowledge":
+			protoMajor, protoMinor = 2, 0
+		case "--http3":
+			protoMajor, protoMinor = 3, 0
+		case "--data", "--data-ascii", "--data-binary":
+			if strings.HasPrefix(value, "@") {
+				return HTTPRequest{}, errors.Errorf("reading data from a file is not supported: %s", value)
+			}
+			if opt != "--data-binary" {
+				// curl strips newlines from --data.
+				value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+			}
+			data, hasData = append(data, value), true
+		case "--data-raw":
+			data, hasData = append(data, value), true
+		case "--data-urlencode":
+			encoded, err := curlURLEncode(value)
+			if err != nil {
+				return HTTPRequest{}, err
+			}
+			data, hasData = append(data, encoded), true
+		case "--form", "--form-string":
+			j := strings.IndexByte(value, '=')
+			if j <= 0 {
+				return HTTPRequest{}, errors.Errorf("malformed form field: %s", value)
+			}
+			name, fieldValue := value[:j], value[j+1:]
+			if opt == "--form" && (strings.HasPrefix(fieldValue, "@") || strings.HasPrefix(fieldValue, "<")) {
+				return HTTPRequest{}, errors.Errorf("reading form fields from a file is not supported: %s", value)
+			}
+			form = append(form, [2]string{name, fieldValue})
+		}
+	}
+
+	if rawURL == "" {
+		return HTTPRequest{}, errors.New("missing URL")
+	}
+	if !strings.Contains(rawURL, "://") {
+		// curl defaults to http.
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return HTTPRequest{}, errors.Wrap(err, "failed to parse URL")
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	if hasData && len(form) > 0 {
+		return HTTPRequest{}, errors.New("cannot combine --data and --form")
+	}
+
+	var body []byte
+	switch {
+	case hasData && useGet:
+		// The data goes in the query string.
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += strings.Join(data, "&")
+	case hasData:
+		body = []byte(strings.Join(data, "&"))
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	case len(form) > 0:
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		if err := w.SetBoundary(curlFormBoundary); err != nil {
+			return HTTPRequest{}, errors.Wrap(err, "failed to build form")
+		}
+		for _, f := range form {
+			if err := w.WriteField(f[0], f[1]); err != nil {
+				return HTTPRequest{}, errors.Wrap(err, "failed to build form")
+			}
+		}
+		if err := w.Close(); err != nil {
+			return HTTPRequest{}, errors.Wrap(err, "failed to build form")
+		}
+		body = buf.Bytes()
+		header.Set("Content-Type", w.FormDataContentType())
+	}
+
+	if method == "" {
+		switch {
+		case head:
+			method = http.MethodHead
+		case body != nil:
+			method = http.MethodPost
+		default:
+			method = http.MethodGet
+		}
+	}
+
+	if compressed && header.Get("Accept-Encoding") == "" {
+		header.Set("Accept-Encoding", "deflate, gzip")
+	}
+
+	host := u.Host
+	if h := header.Get("Host"); h != "" {
+		host = h
+	}
+
+	return HTTPRequest{
+		StreamID:   uuid.New(),
+		Method:     method,
+		ProtoMajor: protoMajor,
+		ProtoMinor: protoMinor,
+		URL:        u,
+		Host:       host,
+		Header:     header,
+		Body:       body,
+		Cookies:    cookies,
+	}, nil
+}
+
+// Adds a header given to curl with --header. "Name:" removes the header, and
+// "Name;" adds it with an empty value.
+func addCurlHeader(header http.Header, value string) error {
+	if strings.HasPrefix(value, "@") {
+		return errors.Errorf("reading headers from a file is not supported: %s", value)
+	}
+
+	if i := strings.IndexByte(value, ':'); i > 0 {
+		name, v := value[:i], strings.TrimSpace(value[i+1:])
+		if v == "" {
+			header.Del(name)
+		} else {
+			header.Add(name, v)
+		}
+		return nil
+	}
+	if strings.HasSuffix(value, ";") && len(value) > 1 {
+		header.Add(value[:len(value)-1], "")
+		return nil
+	}
+	return errors.Errorf("malformed header: %s", value)
+}
+
+// Encodes an argument to --data-urlencode, which is one of "content",
+// "=content", or "name=content".
+func curlURLEncode(value string) (string, error) {
+	i := strings.IndexByte(value, '=')
+	switch {
+	case i < 0 && strings.Contains(value, "@"):
+		// "name@filename" reads the content from a file.
+		return "", errors.Errorf("reading data from a file is not supported: %s", value)
+	case i < 0:
+		return url.QueryEscape(value), nil
+	case i == 0:
+		return url.QueryEscape(value[1:]), nil
+	default:
+		return value[:i] + "=" + url.QueryEscape(value[i+1:]), nil
+	}
+}
+
+// Splits a command into words following POSIX shell quoting rules. Also
+// understands $'...' strings, and ignores backslash line continuations.
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			if i+1 < len(s) && s[i+1] == '\n' {
+				// A line continuation.
+				i++
+				continue
+			}
+			if i+2 < len(s) && s[i+1] == '\r' && s[i+2] == '\n' {
+				i += 2
+				continue
+			}
+			if i+1 >= len(s) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '$' && i+1 < len(s) && s[i+1] == '\'':
+			n, err := readANSIQuoted(s[i+2:], &word)
+			if err != nil {
+				return nil, err
+			}
+			i += n + 1
+			inWord = true
+		case c == '"':
+			n, err := readDoubleQuoted(s[i+1:], &word)
+			if err != nil {
+				return nil, err
+			}
+			i += n
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Reads the rest of a double-quoted string, up to and including the closing
+// quote. Returns the number of bytes read. Variables and command substitutions
+// are not expanded.
+func readDoubleQuoted(s string, word *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return i + 1, nil
+		case '\\':
+			if i+1 < len(s) {
+				switch next := s[i+1]; next {
+				case '\n':
+					i++
+					continue
+				case '"', '\\', '$', '`':
+					i++
+					word.WriteByte(next)
+					continue
+				}
+			}
+			word.WriteByte(c)
+		default:
+			word.WriteByte(c)
+		}
+	}
+	return 0, errors.New("unterminated double quote")
+}
+
+// Reads the rest of a $'...' string, up to and including the closing quote.
+// Returns the number of bytes read.
+func readANSIQuoted(s string, word *strings.Builder) (int, error) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' {
+			return i + 1, nil
+		}
+		if c != '\\' || i+1 >= len(s) {
+			word.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch esc := s[i]; esc {
+		case 'n':
+			word.WriteByte('\n')
+		case 't':
+			word.WriteByte('\t')
+		case 'r':
+			word.WriteByte('\r')
+		case 'a':
+			word.WriteByte('\a')
+		case 'b':
+			word.WriteByte('\b')
+		case 'e', 'E':
+			word.WriteByte(0x1b)
+		case 'f':
+			word.WriteByte('\f')
+		case 'v':
+			word.WriteByte('\v')
+		case 'x':
+			var v byte
+			n := 0
+			for ; n < 2 && i+1 < len(s) && isHexDigit(s[i+1]); n++ {
+				i++
+				v = v<<4 | hexValue(s[i])
+			}
+			if n == 0 {
+				word.WriteString(`\x`)
+			} else {
+				word.WriteByte(v)
+			}
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v := esc - '0'
+			for n := 1; n < 3 && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '7'; n++ {
+				i++
+				v = v<<3 | (s[i] - '0')
+			}
+			word.WriteByte(v)
+		default:
+			// Includes \\, \' and \".
+			word.WriteByte(esc)
+		}
+	}
+	return 0, errors.New("unterminated $' quote")
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,226 @@
+package akinet
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToCurl(t *testing.T) {
+	connID := akid.GenerateConnectionID()
+	testCases := []struct {
+		name     string
+		req      HTTPRequest
+		opts     CurlOptions
+		expected string
+	}{
+		{
+			name: "get",
+			req: HTTPRequest{
+				Method:     "GET",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				URL:        &url.URL{Path: "/v1/pets", RawQuery: "limit=10&tag=a"},
+				Host:       "example.com",
+				Header: http.Header{
+					"Host":            {"example.com"},
+					"Accept-Encoding": {"gzip"},
+					"Cookie":          {"session=abc; theme=dark"},
+					"X-Note":          {"it's"},
+				},
+			},
+			expected: `curl --header 'Accept-Encoding: gzip' --header 'X-Note: it'\''s' --compressed --cookie 'session=abc; theme=dark' 'http://example.com/v1/pets?limit=10&tag=a'`,
+		},
+		{
+			name: "put over TLS",
+			req: HTTPRequest{
+				Method:          "PUT",
+				ProtoMajor:      2,
+				URL:             &url.URL{Path: "/v1/pets/1"},
+				Host:            "example.com",
+				Header:          http.Header{"Content-Type": {"application/json"}, "Content-Length": {"14"}},
+				Body:            []byte(`{"name":"Rex"}`),
+				TLSConnectionID: &connID,
+			},
+			expected: `curl --request PUT --http2 --header 'Content-Type: application/json' --data-binary '{"name":"Rex"}' https://example.com/v1/pets/1`,
+		},
+		{
+			name: "binary body",
+			req: HTTPRequest{
+				Method:     "POST",
+				ProtoMajor: 1,
+				ProtoMinor: 0,
+				URL:        &url.URL{Path: "/upload"},
+				Host:       "example.com",
+				Body:       []byte{'a', 0x00, 0xff, '\''},
+			},
+			expected: `curl --http1.0 --data-binary $'a\x00\xff\'' http://example.com/upload`,
+		},
+		{
+			name: "form",
+			req: HTTPRequest{
+				Method:     "POST",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				URL:        &url.URL{Path: "/form"},
+				Host:       "example.com",
+				Header:     http.Header{"Content-Type": {"multipart/form-data; boundary=xyz"}},
+				Body:       []byte("--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n--xyz\r\nContent-Disposition: form-data; name=\"b\"\r\n\r\ntwo words\r\n--xyz--\r\n"),
+			},
+			expected: `curl --form-string a=1 --form-string 'b=two words' http://example.com/form`,
+		},
+		{
+			name: "redacted",
+			req: HTTPRequest{
+				Method:     "HEAD",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				URL:        &url.URL{Path: "/"},
+				Host:       "example.com",
+				Header: http.Header{
+					"Authorization": {"Bearer secret"},
+					"X-Api-Key":     {"secret"},
+					"X-Session":     {"secret"},
+				},
+				Cookies: []*http.Cookie{{Name: "session", Value: "secret"}},
+			},
+			opts:     CurlOptions{RedactSecrets: true, RedactHeaders: []string{"x-session"}, Multiline: true},
+			expected: "curl \\\n  --head \\\n  --header 'Authorization: Bearer REDACTED' \\\n  --header 'X-Api-Key: REDACTED' \\\n  --header 'X-Session: REDACTED' \\\n  --cookie session=REDACTED \\\n  http://example.com/",
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.req.ToCurl(tc.opts), tc.name)
+	}
+}
+
+func TestParseCurl(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cmd      string
+		expected HTTPRequest
+	}{
+		{
+			name: "data",
+			cmd: `curl -sSL -X PATCH "https://example.com/v1/pets?x=1" \
+  -H 'Content-Type: application/json' -H"X-Quoted: \"q\"" \
+  --data '{"name":
+"Rex"}' --compressed -u user:pass`,
+			expected: HTTPRequest{
+				Method:     "PATCH",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				URL:        &url.URL{Scheme: "https", Host: "example.com", Path: "/v1/pets", RawQuery: "x=1"},
+				Host:       "example.com",
+				Header: http.Header{
+					"Content-Type":    {"application/json"},
+					"X-Quoted":        {`"q"`},
+					"Authorization":   {"Basic dXNlcjpwYXNz"},
+					"Accept-Encoding": {"deflate, gzip"},
+				},
+				// curl strips newlines from --data.
+				Body: []byte(`{"name":"Rex"}`),
+			},
+		},
+		{
+			name: "get with data",
+			cmd:  `curl --get --data-urlencode 'q=a b' -d n=1 --http2 -b 'a=1; b=2' example.com/search`,
+			expected: HTTPRequest{
+				Method:     "GET",
+				ProtoMajor: 2,
+				ProtoMinor: 0,
+				URL:        &url.URL{Scheme: "http", Host: "example.com", Path: "/search", RawQuery: "q=a+b&n=1"},
+				Host:       "example.com",
+				Header:     http.Header{},
+				Cookies:    []*http.Cookie{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}},
+			},
+		},
+		{
+			name: "binary data",
+			cmd:  `curl --data-binary=$'\x00\n\'' -H 'Accept;' -I http://example.com`,
+			expected: HTTPRequest{
+				Method:     "HEAD",
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				URL:        &url.URL{Scheme: "http", Host: "example.com", Path: "/"},
+				Host:       "example.com",
+				Header: http.Header{
+					"Accept":       {""},
+					"Content-Type": {"application/x-www-form-urlencoded"},
+				},
+				Body: []byte("\x00\n'"),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		req, err := ParseCurl(tc.cmd)
+		if !assert.NoError(t, err, tc.name) {
+			continue
+		}
+		req.StreamID = tc.expected.StreamID
+		assert.Equal(t, tc.expected, req, tc.name)
+	}
+}
+
+func TestParseCurlForm(t *testing.T) {
+	req, err := ParseCurl(`curl -F name=Rex --form-string 'note=@home' http://example.com/pets`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "POST", req.Method)
+
+	fields, ok := curlFormFields(req.Header.Get("Content-Type"), req.Body)
+	assert.True(t, ok)
+	assert.Equal(t, [][2]string{{"name", "Rex"}, {"note", "@home"}}, fields)
+}
+
+func TestParseCurlErrors(t *testing.T) {
+	for _, cmd := range []string{
+		``,
+		`wget http://example.com`,
+		`curl`,
+		`curl 'http://example.com`,
+		`curl -d @body.json http://example.com`,
+		`curl -F file=@pet.png http://example.com`,
+		`curl --data-urlencode name@file http://example.com`,
+		`curl --unknown http://example.com`,
+		`curl -Z http://example.com`,
+		`curl -H`,
+		`curl -d a=1 -F b=2 http://example.com`,
+	} {
+		_, err := ParseCurl(cmd)
+		assert.Error(t, err, cmd)
+	}
+}
+
+func TestCurlRoundTrip(t *testing.T) {
+	req := HTTPRequest{
+		Method:     "DELETE",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		URL:        &url.URL{Path: "/v1/pets/1", RawQuery: "force=true"},
+		Host:       "example.com:8080",
+		Header: http.Header{
+			"Content-Type": {"text/plain"},
+			"X-Empty":      {""},
+			"X-Multi":      {"a", "b"},
+		},
+		Body:    []byte("@$`\"\\\n\t\x01é"),
+		Cookies: []*http.Cookie{{Name: "session", Value: "abc"}},
+	}
+
+	parsed, err := ParseCurl(req.ToCurl(CurlOptions{Multiline: true}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, req.Method, parsed.Method)
+	assert.Equal(t, "http://example.com:8080/v1/pets/1?force=true", parsed.URL.String())
+	assert.Equal(t, req.Host, parsed.Host)
+	assert.Equal(t, req.Header, parsed.Header)
+	assert.Equal(t, req.Body, parsed.Body)
+	assert.Equal(t, req.Cookies, parsed.Cookies)
+}
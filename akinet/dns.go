@@ -0,0 +1,179 @@
+package akinet
+
+import (
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// The type of a DNS resource record or question.
+type DNSType uint16
+
+const (
+	DNSTypeA     DNSType = 1
+	DNSTypeNS    DNSType = 2
+	DNSTypeCNAME DNSType = 5
+	DNSTypeSOA   DNSType = 6
+	DNSTypePTR   DNSType = 12
+	DNSTypeMX    DNSType = 15
+	DNSTypeTXT   DNSType = 16
+	DNSTypeAAAA  DNSType = 28
+	DNSTypeSRV   DNSType = 33
+	DNSTypeOPT   DNSType = 41
+	DNSTypeSVCB  DNSType = 64
+	DNSTypeHTTPS DNSType = 65
+	DNSTypeANY   DNSType = 255
+	DNSTypeCAA   DNSType = 257
+)
+
+func (t DNSType) String() string {
+	switch t {
+	case DNSTypeA:
+		return "A"
+	case DNSTypeNS:
+		return "NS"
+	case DNSTypeCNAME:
+		return "CNAME"
+	case DNSTypeSOA:
+		return "SOA"
+	case DNSTypePTR:
+		return "PTR"
+	case DNSTypeMX:
+		return "MX"
+	case DNSTypeTXT:
+		return "TXT"
+	case DNSTypeAAAA:
+		return "AAAA"
+	case DNSTypeSRV:
+		return "SRV"
+	case DNSTypeOPT:
+		return "OPT"
+	case DNSTypeSVCB:
+		return "SVCB"
+	case DNSTypeHTTPS:
+		return "HTTPS"
+	case DNSTypeANY:
+		return "ANY"
+	case DNSTypeCAA:
+		return "CAA"
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// The response code of a DNS response, including the extended bits from
+// EDNS(0).
+type DNSRCode uint16
+
+const (
+	DNSRCodeNoError  DNSRCode = 0
+	DNSRCodeFormErr  DNSRCode = 1
+	DNSRCodeServFail DNSRCode = 2
+	DNSRCodeNXDomain DNSRCode = 3
+	DNSRCodeNotImp   DNSRCode = 4
+	DNSRCodeRefused  DNSRCode = 5
+	DNSRCodeBadVers  DNSRCode = 16
+)
+
+func (r DNSRCode) String() string {
+	switch r {
+	case DNSRCodeNoError:
+		return "NOERROR"
+	case DNSRCodeFormErr:
+		return "FORMERR"
+	case DNSRCodeServFail:
+		return "SERVFAIL"
+	case DNSRCodeNXDomain:
+		return "NXDOMAIN"
+	case DNSRCodeNotImp:
+		return "NOTIMP"
+	case DNSRCodeRefused:
+		return "REFUSED"
+	case DNSRCodeBadVers:
+		return "BADVERS"
+	}
+	return "RCODE" + strconv.Itoa(int(r))
+}
+
+// A question in a DNS query or response.
+type DNSQuestion struct {
+	// The domain name, with a trailing dot.
+	Name  string
+	Type  DNSType
+	Class uint16
+}
+
+// A resource record in a DNS response.
+type DNSResourceRecord struct {
+	Name  string
+	Type  DNSType
+	Class uint16
+	TTL   uint32
+
+	// The record data in presentation format, for A, AAAA, CNAME, NS, PTR, MX,
+	// SRV and TXT records. Empty for other types.
+	Data string
+}
+
+// Represents a DNS query, sent over UDP or TCP.
+//
+// The corresponding DNSResponse has the same stream key. The time taken to
+// resolve the query is the difference between the observation times of the
+// two.
+type DNSQuery struct {
+	// StreamID and TransactionID uniquely identify a pair of query and
+	// response. For queries over UDP, StreamID is the UDPBidiID of the
+	// datagram; over TCP, it is the TCPBidiID of the connection.
+	StreamID      uuid.UUID
+	TransactionID uint16
+
+	// Whether the query was sent over TCP.
+	OverTCP bool
+
+	OpCode           int
+	RecursionDesired bool
+	Questions        []DNSQuestion
+}
+
+func (DNSQuery) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this query with its corresponding
+// response.
+func (q DNSQuery) GetStreamKey() string {
+	return q.StreamID.String() + ":" + strconv.Itoa(int(q.TransactionID))
+}
+
+// Represents a DNS response, sent over UDP or TCP.
+type DNSResponse struct {
+	// StreamID and TransactionID uniquely identify a pair of query and
+	// response.
+	StreamID      uuid.UUID
+	TransactionID uint16
+
+	// Whether the response was sent over TCP.
+	OverTCP bool
+
+	OpCode             int
+	RCode              DNSRCode
+	Authoritative      bool
+	RecursionAvailable bool
+
+	// Set if the response didn't fit in a UDP datagram. The client is expected
+	// to retry over TCP.
+	Truncated bool
+
+	Questions []DNSQuestion
+	Answers   []DNSResourceRecord
+
+	// The number of records in the authority and additional sections. The
+	// EDNS(0) OPT pseudo-record is not counted.
+	AuthorityCount  int
+	AdditionalCount int
+}
+
+func (DNSResponse) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this response with its corresponding
+// query.
+func (r DNSResponse) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(int(r.TransactionID))
+}
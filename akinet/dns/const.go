@@ -0,0 +1,20 @@
+package dns
+
+const (
+	// Length of the fixed DNS message header.
+	headerLength_bytes = 12
+
+	// Length of the size field that precedes every message sent over TCP.
+	tcpLengthPrefix_bytes = 2
+
+	// Largest number of questions that we accept in a message. In practice,
+	// every message has exactly one question, but some servers send none in
+	// responses to malformed queries.
+	maxQuestions = 4
+
+	// Largest opcode that has been assigned: DSO (RFC 8490).
+	maxOpCode = 6
+
+	// Opcode 3 is unassigned.
+	unassignedOpCode = 3
+)
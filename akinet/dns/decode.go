@@ -0,0 +1,207 @@
+package dns
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Performs cheap sanity checks on the header of a DNS message of the given
+// length, to tell DNS apart from other protocols.
+func isPlausibleHeader(msg []byte, length int64) bool {
+	if len(msg) < headerLength_bytes || length < headerLength_bytes {
+		return false
+	}
+
+	flags := uint16(msg[2])<<8 | uint16(msg[3])
+	opCode := int(flags>>11) & 0xF
+	if opCode > maxOpCode || opCode == unassignedOpCode {
+		return false
+	}
+	// The Z bit is reserved and must be zero.
+	if flags&0x40 != 0 {
+		return false
+	}
+
+	questions := int(msg[4])<<8 | int(msg[5])
+	if questions > maxQuestions {
+		return false
+	}
+	// A query with no questions is only expected for DNS Stateful Operations.
+	isResponse := flags&0x8000 != 0
+	if !isResponse && questions == 0 && opCode != maxOpCode {
+		return false
+	}
+
+	// Each resource record takes at least 11 bytes.
+	records := 0
+	for i := 6; i < headerLength_bytes; i += 2 {
+		records += int(msg[i])<<8 | int(msg[i+1])
+	}
+	return int64(records)*11 <= length-headerLength_bytes
+}
+
+// Determines whether msg is a DNS message by parsing its header and questions.
+func isDNSMessage(msg []byte) bool {
+	if !isPlausibleHeader(msg, int64(len(msg))) {
+		return false
+	}
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return false
+	}
+	_, err := p.AllQuestions()
+	return err == nil
+}
+
+// Parses a DNS message into either a DNSQuery or a DNSResponse.
+func parseMessage(streamID uuid.UUID, msg []byte, overTCP bool) (akinet.ParsedNetworkContent, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse DNS header")
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse DNS questions")
+	}
+
+	if !hdr.Response {
+		return akinet.DNSQuery{
+			StreamID:         streamID,
+			TransactionID:    hdr.ID,
+			OverTCP:          overTCP,
+			OpCode:           int(hdr.OpCode),
+			RecursionDesired: hdr.RecursionDesired,
+			Questions:        convertQuestions(questions),
+		}, nil
+	}
+
+	resp := akinet.DNSResponse{
+		StreamID:           streamID,
+		TransactionID:      hdr.ID,
+		OverTCP:            overTCP,
+		OpCode:             int(hdr.OpCode),
+		RCode:              akinet.DNSRCode(hdr.RCode),
+		Authoritative:      hdr.Authoritative,
+		RecursionAvailable: hdr.RecursionAvailable,
+		Truncated:          hdr.Truncated,
+		Questions:          convertQuestions(questions),
+	}
+
+	// A truncated response may end part way through a record, so keep whatever
+	// was parsed before the error.
+	if err := parseRecords(&p, hdr, &resp); err != nil && !hdr.Truncated {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Parses the answer, authority and additional sections of a response into
+// resp.
+func parseRecords(p *dnsmessage.Parser, hdr dnsmessage.Header, resp *akinet.DNSResponse) error {
+	for {
+		r, err := p.Answer()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "failed to parse DNS answers")
+		}
+		resp.Answers = append(resp.Answers, convertRecord(r))
+	}
+
+	for {
+		if _, err := p.AuthorityHeader(); err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "failed to parse DNS authority section")
+		}
+		if err := p.SkipAuthority(); err != nil {
+			return errors.Wrap(err, "failed to parse DNS authority section")
+		}
+		resp.AuthorityCount++
+	}
+
+	for {
+		rh, err := p.AdditionalHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "failed to parse DNS additional section")
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return errors.Wrap(err, "failed to parse DNS additional section")
+		}
+		if rh.Type == dnsmessage.TypeOPT {
+			// The OPT pseudo-record carries the upper bits of the rcode.
+			resp.RCode = akinet.DNSRCode(rh.ExtendedRCode(hdr.RCode))
+			continue
+		}
+		resp.AdditionalCount++
+	}
+	return nil
+}
+
+func convertQuestions(questions []dnsmessage.Question) []akinet.DNSQuestion {
+	if len(questions) == 0 {
+		return nil
+	}
+	result := make([]akinet.DNSQuestion, 0, len(questions))
+	for _, q := range questions {
+		result = append(result, akinet.DNSQuestion{
+			Name:  q.Name.String(),
+			Type:  akinet.DNSType(q.Type),
+			Class: uint16(q.Class),
+		})
+	}
+	return result
+}
+
+func convertRecord(r dnsmessage.Resource) akinet.DNSResourceRecord {
+	return akinet.DNSResourceRecord{
+		Name:  r.Header.Name.String(),
+		Type:  akinet.DNSType(r.Header.Type),
+		Class: uint16(r.Header.Class),
+		TTL:   r.Header.TTL,
+		Data:  recordData(r.Body),
+	}
+}
+
+// Returns the data of a resource record in presentation format, or an empty
+// string for record types that we don't format.
+func recordData(body dnsmessage.ResourceBody) string {
+	switch b := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return b.CNAME.String()
+	case *dnsmessage.NSResource:
+		return b.NS.String()
+	case *dnsmessage.PTRResource:
+		return b.PTR.String()
+	case *dnsmessage.MXResource:
+		return strconv.Itoa(int(b.Pref)) + " " + b.MX.String()
+	case *dnsmessage.SRVResource:
+		return strings.Join([]string{
+			strconv.Itoa(int(b.Priority)),
+			strconv.Itoa(int(b.Weight)),
+			strconv.Itoa(int(b.Port)),
+			b.Target.String(),
+		}, " ")
+	case *dnsmessage.TXTResource:
+		quoted := make([]string, 0, len(b.TXT))
+		for _, s := range b.TXT {
+			quoted = append(quoted, strconv.Quote(s))
+		}
+		return strings.Join(quoted, " ")
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"io"
+
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+func newDNSUDPParser(id akinet.UDPBidiID) *dnsUDPParser {
+	return &dnsUDPParser{id: id}
+}
+
+// Parses a DNS message sent in a single UDP datagram.
+type dnsUDPParser struct {
+	id akinet.UDPBidiID
+}
+
+var _ akinet.UDPParser = (*dnsUDPParser)(nil)
+
+func (*dnsUDPParser) Name() string {
+	return "DNS UDP Parser"
+}
+
+func (p *dnsUDPParser) Parse(datagram memview.MemView) (akinet.ParsedNetworkContent, error) {
+	return parseMessage(uuid.UUID(p.id), []byte(datagram.String()), false)
+}
+
+func newDNSTCPParser(id akinet.TCPBidiID) *dnsTCPParser {
+	return &dnsTCPParser{id: id}
+}
+
+// Parses a single DNS message sent over TCP.
+type dnsTCPParser struct {
+	id       akinet.TCPBidiID
+	allInput memview.MemView
+}
+
+var _ akinet.TCPParser = (*dnsTCPParser)(nil)
+
+func (*dnsTCPParser) Name() string {
+	return "DNS TCP Parser"
+}
+
+func (p *dnsTCPParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	p.allInput.Append(input)
+
+	if p.allInput.Len() >= tcpLengthPrefix_bytes {
+		length := int64(p.allInput.GetUint16(0))
+		end := tcpLengthPrefix_bytes + length
+		if p.allInput.Len() >= end {
+			msg := []byte(p.allInput.SubView(tcpLengthPrefix_bytes, end).String())
+			result, err = parseMessage(uuid.UUID(p.id), msg, true)
+			if err != nil {
+				return nil, p.allInput, err
+			}
+			return result, p.allInput.SubView(end, p.allInput.Len()), nil
+		}
+	}
+
+	if isEnd {
+		return nil, p.allInput, io.ErrUnexpectedEOF
+	}
+	return nil, memview.MemView{}, nil
+}
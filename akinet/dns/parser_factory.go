@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a factory for parsing DNS queries and responses sent in UDP
+// datagrams.
+func NewDNSUDPParserFactory() akinet.UDPParserFactory {
+	return dnsUDPParserFactory{}
+}
+
+// Returns a factory for parsing DNS queries and responses sent over TCP, where
+// each message is preceded by a 2-byte length.
+func NewDNSTCPParserFactory() akinet.TCPParserFactory {
+	return dnsTCPParserFactory{}
+}
+
+type dnsUDPParserFactory struct{}
+
+func (dnsUDPParserFactory) Name() string {
+	return "DNS UDP Parser Factory"
+}
+
+func (dnsUDPParserFactory) Accepts(datagram memview.MemView) akinet.AcceptDecision {
+	if !isDNSMessage([]byte(datagram.String())) {
+		return akinet.Reject
+	}
+	return akinet.Accept
+}
+
+func (dnsUDPParserFactory) CreateParser(id akinet.UDPBidiID) akinet.UDPParser {
+	return newDNSUDPParser(id)
+}
+
+type dnsTCPParserFactory struct{}
+
+func (dnsTCPParserFactory) Name() string {
+	return "DNS TCP Parser Factory"
+}
+
+func (factory dnsTCPParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	decision, discardFront = factory.accepts(input)
+
+	if decision == akinet.NeedMoreData && isEnd {
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+
+	return decision, discardFront
+}
+
+func (dnsTCPParserFactory) accepts(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	if input.Len() < tcpLengthPrefix_bytes+headerLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+	length := int64(input.GetUint16(0))
+	header := []byte(input.SubView(tcpLengthPrefix_bytes, tcpLengthPrefix_bytes+headerLength_bytes).String())
+	if !isPlausibleHeader(header, length) {
+		return akinet.Reject, input.Len()
+	}
+
+	// Check the questions as well, since the header alone is short and easily
+	// matched by chance.
+	if input.Len() < tcpLengthPrefix_bytes+length {
+		return akinet.NeedMoreData, 0
+	}
+	if !isDNSMessage([]byte(input.SubView(tcpLengthPrefix_bytes, tcpLengthPrefix_bytes+length).String())) {
+		return akinet.Reject, input.Len()
+	}
+	return akinet.Accept, 0
+}
+
+func (dnsTCPParserFactory) CreateParser(id akinet.TCPBidiID, _, _ reassembly.Sequence) akinet.TCPParser {
+	return newDNSTCPParser(id)
+}
@@ -0,0 +1,352 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/uuid"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/akinet/internal/parsertest"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var (
+	testUDPID = akinet.UDPBidiID(uuid.MustParse("8c1f4e2a-6b3d-4a9e-b7c5-2d0f1e3a5b68"))
+	testTCPID = akinet.TCPBidiID(uuid.MustParse("5e2a7c9b-1d4f-4b6e-8a3c-9f0d2e4b6a17"))
+)
+
+func mustName(s string) dnsmessage.Name {
+	return dnsmessage.MustNewName(s)
+}
+
+func header(name string, t dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{
+		Name:  mustName(name),
+		Type:  t,
+		Class: dnsmessage.ClassINET,
+		TTL:   ttl,
+	}
+}
+
+func query(id uint16, name string, t dnsmessage.Type) []byte {
+	m := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: mustName(name), Type: t, Class: dnsmessage.ClassINET},
+		},
+	}
+	b, err := m.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func pack(m dnsmessage.Message) []byte {
+	b, err := m.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Prepends the 2-byte length used for DNS over TCP.
+func withLength(msgs ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, m := range msgs {
+		binary.Write(&buf, binary.BigEndian, uint16(len(m)))
+		buf.Write(m)
+	}
+	return buf.Bytes()
+}
+
+func parseDatagram(t *testing.T, datagram []byte) akinet.ParsedNetworkContent {
+	factory := NewDNSUDPParserFactory()
+	if decision := factory.Accepts(memview.New(datagram)); decision != akinet.Accept {
+		t.Fatalf("expected factory to accept, got %s", decision)
+	}
+	result, err := factory.CreateParser(testUDPID).Parse(memview.New(datagram))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return result
+}
+
+func TestUDPQueryAndResponse(t *testing.T) {
+	streamID := uuid.UUID(testUDPID)
+	question := dnsmessage.Question{Name: mustName("www.example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+
+	opt := dnsmessage.ResourceHeader{Name: mustName(".")}
+	if err := opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false); err != nil {
+		t.Fatal(err)
+	}
+	response := pack(dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 0x1234,
+			Response:           true,
+			RecursionDesired:   true,
+			RecursionAvailable: true,
+		},
+		Questions: []dnsmessage.Question{question},
+		Answers: []dnsmessage.Resource{
+			{Header: header("www.example.com.", dnsmessage.TypeCNAME, 300), Body: &dnsmessage.CNAMEResource{CNAME: mustName("example.com.")}},
+			{Header: header("example.com.", dnsmessage.TypeA, 60), Body: &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}}},
+		},
+		Authorities: []dnsmessage.Resource{
+			{Header: header("example.com.", dnsmessage.TypeNS, 3600), Body: &dnsmessage.NSResource{NS: mustName("a.iana-servers.net.")}},
+		},
+		Additionals: []dnsmessage.Resource{
+			{Header: opt, Body: &dnsmessage.OPTResource{}},
+		},
+	})
+
+	expected := []akinet.ParsedNetworkContent{
+		akinet.DNSQuery{
+			StreamID:         streamID,
+			TransactionID:    0x1234,
+			RecursionDesired: true,
+			Questions: []akinet.DNSQuestion{
+				{Name: "www.example.com.", Type: akinet.DNSTypeA, Class: 1},
+			},
+		},
+		akinet.DNSResponse{
+			StreamID:           streamID,
+			TransactionID:      0x1234,
+			RCode:              akinet.DNSRCodeNoError,
+			RecursionAvailable: true,
+			Questions: []akinet.DNSQuestion{
+				{Name: "www.example.com.", Type: akinet.DNSTypeA, Class: 1},
+			},
+			Answers: []akinet.DNSResourceRecord{
+				{Name: "www.example.com.", Type: akinet.DNSTypeCNAME, Class: 1, TTL: 300, Data: "example.com."},
+				{Name: "example.com.", Type: akinet.DNSTypeA, Class: 1, TTL: 60, Data: "93.184.216.34"},
+			},
+			AuthorityCount: 1,
+		},
+	}
+
+	results := []akinet.ParsedNetworkContent{
+		parseDatagram(t, query(0x1234, "www.example.com.", dnsmessage.TypeA)),
+		parseDatagram(t, response),
+	}
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+	if results[0].(akinet.DNSQuery).GetStreamKey() != results[1].(akinet.DNSResponse).GetStreamKey() {
+		t.Errorf("expected query and response to have the same stream key")
+	}
+}
+
+func TestRecordTypesAndRCodes(t *testing.T) {
+	question := dnsmessage.Question{Name: mustName("example.com."), Type: dnsmessage.TypeALL, Class: dnsmessage.ClassINET}
+
+	// The extended rcode in the OPT record turns NOERROR into BADVERS.
+	opt := dnsmessage.ResourceHeader{Name: mustName(".")}
+	if err := opt.SetEDNS0(4096, dnsmessage.RCode(16), false); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		response dnsmessage.Message
+		expected akinet.DNSResponse
+	}{
+		{
+			name: "record types",
+			response: dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: 1, Response: true, Authoritative: true},
+				Questions: []dnsmessage.Question{question},
+				Answers: []dnsmessage.Resource{
+					{Header: header("example.com.", dnsmessage.TypeAAAA, 60), Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x26, 0x06, 0x28, 0x00, 15: 0x01}}},
+					{Header: header("example.com.", dnsmessage.TypeMX, 60), Body: &dnsmessage.MXResource{Pref: 10, MX: mustName("mail.example.com.")}},
+					{Header: header("example.com.", dnsmessage.TypeTXT, 60), Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all", "x"}}},
+					{Header: header("_sip._tcp.example.com.", dnsmessage.TypeSRV, 60), Body: &dnsmessage.SRVResource{Priority: 1, Weight: 5, Port: 5060, Target: mustName("sip.example.com.")}},
+					{Header: header("example.com.", dnsmessage.Type(257), 60), Body: &dnsmessage.UnknownResource{Type: dnsmessage.Type(257), Data: []byte{0, 5, 'i', 's', 's', 'u', 'e'}}},
+				},
+			},
+			expected: akinet.DNSResponse{
+				TransactionID: 1,
+				Authoritative: true,
+				Questions: []akinet.DNSQuestion{
+					{Name: "example.com.", Type: akinet.DNSTypeANY, Class: 1},
+				},
+				Answers: []akinet.DNSResourceRecord{
+					{Name: "example.com.", Type: akinet.DNSTypeAAAA, Class: 1, TTL: 60, Data: "2606:2800::1"},
+					{Name: "example.com.", Type: akinet.DNSTypeMX, Class: 1, TTL: 60, Data: "10 mail.example.com."},
+					{Name: "example.com.", Type: akinet.DNSTypeTXT, Class: 1, TTL: 60, Data: `"v=spf1 -all" "x"`},
+					{Name: "_sip._tcp.example.com.", Type: akinet.DNSTypeSRV, Class: 1, TTL: 60, Data: "1 5 5060 sip.example.com."},
+					{Name: "example.com.", Type: akinet.DNSTypeCAA, Class: 1, TTL: 60},
+				},
+			},
+		},
+		{
+			name: "NXDOMAIN",
+			response: dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: 2, Response: true, RCode: dnsmessage.RCodeNameError},
+				Questions: []dnsmessage.Question{question},
+				Authorities: []dnsmessage.Resource{
+					{Header: header("com.", dnsmessage.TypeSOA, 900), Body: &dnsmessage.SOAResource{NS: mustName("a.gtld-servers.net."), MBox: mustName("nstld.verisign-grs.com."), MinTTL: 900}},
+				},
+			},
+			expected: akinet.DNSResponse{
+				TransactionID: 2,
+				RCode:         akinet.DNSRCodeNXDomain,
+				Questions: []akinet.DNSQuestion{
+					{Name: "example.com.", Type: akinet.DNSTypeANY, Class: 1},
+				},
+				AuthorityCount: 1,
+			},
+		},
+		{
+			name: "extended rcode",
+			response: dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: 3, Response: true},
+				Questions: []dnsmessage.Question{question},
+				Additionals: []dnsmessage.Resource{
+					{Header: opt, Body: &dnsmessage.OPTResource{}},
+					{Header: header("ns.example.com.", dnsmessage.TypeA, 60), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+				},
+			},
+			expected: akinet.DNSResponse{
+				TransactionID: 3,
+				RCode:         akinet.DNSRCodeBadVers,
+				Questions: []akinet.DNSQuestion{
+					{Name: "example.com.", Type: akinet.DNSTypeANY, Class: 1},
+				},
+				AdditionalCount: 1,
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		c.expected.StreamID = uuid.UUID(testUDPID)
+		result := parseDatagram(t, pack(c.response))
+		if diff := cmp.Diff(c.expected, result, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("[%s] found unexpected diff:\n%s", c.name, diff)
+		}
+	}
+}
+
+func TestTruncatedResponse(t *testing.T) {
+	full := pack(dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 7, Response: true, Truncated: true},
+		Questions: []dnsmessage.Question{
+			{Name: mustName("example.com."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+		},
+		Answers: []dnsmessage.Resource{
+			{Header: header("example.com.", dnsmessage.TypeTXT, 60), Body: &dnsmessage.TXTResource{TXT: []string{"first"}}},
+			{Header: header("example.com.", dnsmessage.TypeTXT, 60), Body: &dnsmessage.TXTResource{TXT: []string{"second record"}}},
+		},
+	})
+
+	// Cut the datagram part way through the second record.
+	expected := akinet.DNSResponse{
+		StreamID:      uuid.UUID(testUDPID),
+		TransactionID: 7,
+		Truncated:     true,
+		Questions: []akinet.DNSQuestion{
+			{Name: "example.com.", Type: akinet.DNSTypeTXT, Class: 1},
+		},
+		Answers: []akinet.DNSResourceRecord{
+			{Name: "example.com.", Type: akinet.DNSTypeTXT, Class: 1, TTL: 60, Data: `"first"`},
+		},
+	}
+	result := parseDatagram(t, full[:len(full)-5])
+	if diff := cmp.Diff(expected, result, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	factory := NewDNSTCPParserFactory()
+	response := pack(dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 42, Response: true},
+		Questions: []dnsmessage.Question{
+			{Name: mustName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		},
+		Answers: []dnsmessage.Resource{
+			{Header: header("example.com.", dnsmessage.TypeA, 60), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+		},
+	})
+	data := withLength(query(41, "example.org.", dnsmessage.TypeAAAA), response)
+
+	expected := []akinet.ParsedNetworkContent{
+		akinet.DNSQuery{
+			StreamID:         uuid.UUID(testTCPID),
+			TransactionID:    41,
+			OverTCP:          true,
+			RecursionDesired: true,
+			Questions: []akinet.DNSQuestion{
+				{Name: "example.org.", Type: akinet.DNSTypeAAAA, Class: 1},
+			},
+		},
+		akinet.DNSResponse{
+			StreamID:      uuid.UUID(testTCPID),
+			TransactionID: 42,
+			OverTCP:       true,
+			Questions: []akinet.DNSQuestion{
+				{Name: "example.com.", Type: akinet.DNSTypeA, Class: 1},
+			},
+			Answers: []akinet.DNSResourceRecord{
+				{Name: "example.com.", Type: akinet.DNSTypeA, Class: 1, TTL: 60, Data: "192.0.2.1"},
+			},
+		},
+	}
+
+	results := parsertest.ParseAll(t, factory, testTCPID, 0, 0, data)
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+}
+
+func TestFactoryRejectsOtherProtocols(t *testing.T) {
+	testCases := parsertest.OtherProtocols("DNS")
+	parsertest.RunRejectTests(t, NewDNSTCPParserFactory(), testCases)
+
+	udpFactory := NewDNSUDPParserFactory()
+	for _, c := range testCases {
+		if decision := udpFactory.Accepts(memview.New([]byte(c.Input))); decision != akinet.Reject {
+			t.Errorf("[%s] expected %q to be rejected over UDP, got %s", c.Name, c.Input, decision)
+		}
+	}
+}
+
+func TestIncrementalParse(t *testing.T) {
+	factory := NewDNSTCPParserFactory()
+	message := withLength(query(9, "example.com.", dnsmessage.TypeA))
+	if decision, _ := factory.Accepts(memview.New(message[:20]), false); decision != akinet.NeedMoreData {
+		t.Errorf("expected factory to need more data, got %s", decision)
+	}
+
+	response := pack(dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 9, Response: true, RCode: dnsmessage.RCodeNameError},
+		Questions: []dnsmessage.Question{
+			{Name: mustName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		},
+	})
+	testCases := []parsertest.IncrementalTestCase{
+		{
+			Name:  "query",
+			Input: message,
+			Next:  withLength(query(10, "example.net.", dnsmessage.TypeA)),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				q, ok := result.(akinet.DNSQuery)
+				return ok && q.TransactionID == 9
+			},
+		},
+		{
+			Name:  "response",
+			Input: withLength(response),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				r, ok := result.(akinet.DNSResponse)
+				return ok && r.TransactionID == 9 && len(r.Answers) == 0
+			},
+		},
+	}
+	parsertest.RunIncrementalTests(t, factory, testTCPID, testCases)
+}
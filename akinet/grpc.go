@@ -0,0 +1,92 @@
+package akinet
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// A single length-prefixed message carried in the body of a gRPC request or
+// response.
+type GRPCMessage struct {
+	// Whether the compressed flag was set on the message.
+	Compressed bool
+
+	// Whether Data holds the decompressed message. False if the message was
+	// compressed with an encoding that we don't support.
+	Decompressed bool
+
+	// The length of the message on the wire, excluding the 5-byte prefix.
+	Length int
+
+	// The serialized message (e.g. protobuf bytes).
+	Data []byte
+}
+
+type GRPCRequest struct {
+	// StreamID and Seq uniquely identify a pair of request and response. These
+	// are taken from the HTTP request carrying the gRPC call.
+	StreamID uuid.UUID
+	Seq      int
+
+	// The fully qualified service name (e.g. "helloworld.Greeter") and the method
+	// name (e.g. "SayHello"), taken from the request path.
+	Service string
+	Method  string
+
+	Host   string
+	Header http.Header
+
+	// The message encoding, from the grpc-encoding header. Empty means
+	// "identity".
+	Encoding string
+
+	Messages []GRPCMessage
+}
+
+func (GRPCRequest) ImplParsedNetworkContent() {}
+
+// Returns the path of the gRPC method in the form "/service/method".
+func (r GRPCRequest) FullMethod() string {
+	return "/" + r.Service + "/" + r.Method
+}
+
+// Returns a string key that associates this request with its corresponding
+// response.
+func (r GRPCRequest) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(r.Seq)
+}
+
+type GRPCResponse struct {
+	// StreamID and Seq uniquely identify a pair of request and response.
+	StreamID uuid.UUID
+	Seq      int
+
+	// The HTTP status code of the response carrying the gRPC call.
+	HTTPStatusCode int
+
+	Header  http.Header
+	Trailer http.Header
+
+	// The message encoding, from the grpc-encoding header. Empty means
+	// "identity".
+	Encoding string
+
+	Messages []GRPCMessage
+
+	// The gRPC status code from the grpc-status trailer (or header, for
+	// trailers-only responses), if any.
+	Status *int
+
+	// The decoded grpc-message trailer, if any.
+	StatusMessage string
+}
+
+func (GRPCResponse) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this response with its corresponding
+// request.
+func (r GRPCResponse) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(r.Seq)
+}
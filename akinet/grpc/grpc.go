@@ -0,0 +1,207 @@
+package grpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+const (
+	// Each gRPC message is prefixed with a compressed flag (1 byte) and the
+	// message length (4 bytes, big endian).
+	messagePrefixLength_bytes = 5
+
+	// Compressed messages that decompress to more than this are left
+	// compressed. This is the default limit on received messages in gRPC.
+	maxDecompressedLength_bytes = 4 * 1024 * 1024
+)
+
+// Determines whether the given headers are for a gRPC request or response,
+// i.e. whether the content type is application/grpc, possibly with a subtype
+// such as application/grpc+proto. gRPC-Web is not included.
+func IsGRPC(header http.Header) bool {
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "application/grpc") {
+		return false
+	}
+	rest := contentType[len("application/grpc"):]
+	return rest == "" || rest[0] == '+' || rest[0] == ';'
+}
+
+// Converts an HTTP request carrying a gRPC call into a GRPCRequest.
+func FromHTTPRequest(r akinet.HTTPRequest) (akinet.GRPCRequest, error) {
+	if !IsGRPC(r.Header) {
+		return akinet.GRPCRequest{}, errors.New("not a gRPC request")
+	}
+	if r.URL == nil {
+		return akinet.GRPCRequest{}, errors.New("gRPC request has no path")
+	}
+
+	service, method, err := parseMethodPath(r.URL.Path)
+	if err != nil {
+		return akinet.GRPCRequest{}, err
+	}
+
+	encoding := r.Header.Get("Grpc-Encoding")
+	messages, err := SplitMessages(r.Body, encoding)
+	if err != nil {
+		return akinet.GRPCRequest{}, errors.Wrapf(err, "failed to split messages for %s", r.URL.Path)
+	}
+
+	return akinet.GRPCRequest{
+		StreamID: r.StreamID,
+		Seq:      r.Seq,
+		Service:  service,
+		Method:   method,
+		Host:     r.Host,
+		Header:   r.Header,
+		Encoding: encoding,
+		Messages: messages,
+	}, nil
+}
+
+// Converts an HTTP response carrying the result of a gRPC call into a
+// GRPCResponse.
+func FromHTTPResponse(r akinet.HTTPResponse) (akinet.GRPCResponse, error) {
+	if !IsGRPC(r.Header) {
+		return akinet.GRPCResponse{}, errors.New("not a gRPC response")
+	}
+
+	encoding := r.Header.Get("Grpc-Encoding")
+	messages, err := SplitMessages(r.Body, encoding)
+	if err != nil {
+		return akinet.GRPCResponse{}, errors.Wrap(err, "failed to split messages")
+	}
+
+	result := akinet.GRPCResponse{
+		StreamID:       r.StreamID,
+		Seq:            r.Seq,
+		HTTPStatusCode: r.StatusCode,
+		Header:         r.Header,
+		Trailer:        r.Trailer,
+		Encoding:       encoding,
+		Messages:       messages,
+	}
+
+	// The status is normally in the trailers, but responses without messages
+	// may be "trailers-only", in which case the status is in the headers.
+	for _, h := range []http.Header{r.Trailer, r.Header} {
+		if h == nil {
+			continue
+		}
+		if s := h.Get("Grpc-Status"); s != "" {
+			status, err := strconv.Atoi(s)
+			if err != nil {
+				return akinet.GRPCResponse{}, errors.Errorf("invalid grpc-status %q", s)
+			}
+			result.Status = &status
+			result.StatusMessage = decodeGRPCMessage(h.Get("Grpc-Message"))
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// Splits a gRPC request or response body into its length-prefixed messages.
+// Compressed messages are decompressed if the encoding is gzip or deflate.
+func SplitMessages(body []byte, encoding string) ([]akinet.GRPCMessage, error) {
+	var result []akinet.GRPCMessage
+	for len(body) > 0 {
+		if len(body) < messagePrefixLength_bytes {
+			return nil, errors.Errorf("truncated message prefix: %d bytes left", len(body))
+		}
+		compressed := body[0] != 0
+		length := binary.BigEndian.Uint32(body[1:messagePrefixLength_bytes])
+		body = body[messagePrefixLength_bytes:]
+		if uint64(length) > uint64(len(body)) {
+			return nil, errors.Errorf("message length %d exceeds remaining %d bytes", length, len(body))
+		}
+
+		msg := akinet.GRPCMessage{
+			Compressed:   compressed,
+			Decompressed: !compressed,
+			Length:       int(length),
+			Data:         body[:length],
+		}
+		body = body[length:]
+
+		if compressed {
+			if data, err := decompress(msg.Data, encoding); err == nil {
+				msg.Data = data
+				msg.Decompressed = true
+			}
+		}
+
+		result = append(result, msg)
+	}
+	return result, nil
+}
+
+func decompress(data []byte, encoding string) ([]byte, error) {
+	var r io.Reader
+	switch strings.ToLower(encoding) {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case "deflate":
+		// gRPC's deflate is zlib-wrapped, but fall back to a raw deflate stream,
+		// as some implementations send that instead.
+		if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+			defer zr.Close()
+			r = zr
+		} else {
+			fr := flate.NewReader(bytes.NewReader(data))
+			defer fr.Close()
+			r = fr
+		}
+	default:
+		return nil, errors.Errorf("unsupported gRPC message encoding %q", encoding)
+	}
+
+	result, err := ioutil.ReadAll(io.LimitReader(r, maxDecompressedLength_bytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > maxDecompressedLength_bytes {
+		return nil, errors.Errorf("decompressed gRPC message is longer than %d bytes", maxDecompressedLength_bytes)
+	}
+	return result, nil
+}
+
+// Splits a gRPC request path of the form "/package.Service/Method".
+func parseMethodPath(path string) (service, method string, err error) {
+	if !strings.HasPrefix(path, "/") {
+		return "", "", errors.Errorf("malformed gRPC method path %q", path)
+	}
+	parts := strings.Split(path[1:], "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.Errorf("malformed gRPC method path %q", path)
+	}
+	return parts[0], parts[1], nil
+}
+
+// The grpc-message value is percent-encoded. Fall back to the raw value if it
+// is malformed.
+func decodeGRPCMessage(s string) string {
+	if decoded, err := url.PathUnescape(s); err == nil {
+		return decoded
+	}
+	return s
+}
@@ -0,0 +1,230 @@
+package grpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+var testStreamID = uuid.MustParse("3744e3d7-2c08-4cd2-9ee9-2306dfba6727")
+
+func frame(compressed bool, data []byte) []byte {
+	prefix := make([]byte, messagePrefixLength_bytes)
+	if compressed {
+		prefix[0] = 1
+	}
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+	return append(prefix, data...)
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func deflated(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func rawDeflated(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestFromHTTPRequest(t *testing.T) {
+	compressed := gzipped([]byte("world"))
+	body := append(frame(false, []byte("hello")), frame(true, compressed)...)
+
+	req := akinet.HTTPRequest{
+		StreamID:   testStreamID,
+		Seq:        3,
+		Method:     "POST",
+		ProtoMajor: 2,
+		URL:        &url.URL{Path: "/helloworld.Greeter/SayHello"},
+		Host:       "example.com",
+		Header: http.Header{
+			"Content-Type":  {"application/grpc+proto"},
+			"Grpc-Encoding": {"gzip"},
+		},
+		Body: body,
+	}
+
+	expected := akinet.GRPCRequest{
+		StreamID: testStreamID,
+		Seq:      3,
+		Service:  "helloworld.Greeter",
+		Method:   "SayHello",
+		Host:     "example.com",
+		Header:   req.Header,
+		Encoding: "gzip",
+		Messages: []akinet.GRPCMessage{
+			{Decompressed: true, Length: 5, Data: []byte("hello")},
+			{Compressed: true, Decompressed: true, Length: len(compressed), Data: []byte("world")},
+		},
+	}
+
+	actual, err := FromHTTPRequest(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+	if actual.FullMethod() != "/helloworld.Greeter/SayHello" {
+		t.Errorf("unexpected full method %q", actual.FullMethod())
+	}
+}
+
+func TestFromHTTPResponse(t *testing.T) {
+	notFound := 5
+	ok := 0
+
+	testCases := []struct {
+		name     string
+		resp     akinet.HTTPResponse
+		expected akinet.GRPCResponse
+	}{
+		{
+			name: "status in trailers",
+			resp: akinet.HTTPResponse{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Type": {"application/grpc"}},
+				Body:       frame(false, []byte("hi")),
+				Trailer:    http.Header{"Grpc-Status": {"0"}},
+			},
+			expected: akinet.GRPCResponse{
+				HTTPStatusCode: 200,
+				Header:         http.Header{"Content-Type": {"application/grpc"}},
+				Trailer:        http.Header{"Grpc-Status": {"0"}},
+				Messages: []akinet.GRPCMessage{
+					{Decompressed: true, Length: 2, Data: []byte("hi")},
+				},
+				Status: &ok,
+			},
+		},
+		{
+			name: "trailers only",
+			resp: akinet.HTTPResponse{
+				StatusCode: 200,
+				Header: http.Header{
+					"Content-Type": {"application/grpc"},
+					"Grpc-Status":  {"5"},
+					"Grpc-Message": {"no%20such%20user"},
+				},
+			},
+			expected: akinet.GRPCResponse{
+				HTTPStatusCode: 200,
+				Header: http.Header{
+					"Content-Type": {"application/grpc"},
+					"Grpc-Status":  {"5"},
+					"Grpc-Message": {"no%20such%20user"},
+				},
+				Status:        &notFound,
+				StatusMessage: "no such user",
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		actual, err := FromHTTPResponse(c.resp)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %v", c.name, err)
+			continue
+		}
+		if diff := cmp.Diff(c.expected, actual, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("[%s] found diff: %s", c.name, diff)
+		}
+	}
+}
+
+func TestSplitMessagesErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		body []byte
+	}{
+		{"truncated prefix", []byte{0, 0, 0}},
+		{"truncated message", frame(false, []byte("hello"))[:7]},
+	}
+
+	for _, c := range testCases {
+		if _, err := SplitMessages(c.body, ""); err == nil {
+			t.Errorf("[%s] expected error", c.name)
+		}
+	}
+}
+
+func TestSplitMessagesDeflate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		compressed []byte
+	}{
+		{"zlib", deflated([]byte("hello"))},
+		{"raw deflate", rawDeflated([]byte("hello"))},
+	}
+
+	for _, c := range testCases {
+		messages, err := SplitMessages(frame(true, c.compressed), "deflate")
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", c.name, err)
+		}
+		expected := []akinet.GRPCMessage{
+			{Compressed: true, Decompressed: true, Length: len(c.compressed), Data: []byte("hello")},
+		}
+		if diff := cmp.Diff(expected, messages); diff != "" {
+			t.Errorf("[%s] found diff: %s", c.name, diff)
+		}
+	}
+}
+
+func TestSplitMessagesLeavesLargeMessagesCompressed(t *testing.T) {
+	compressed := gzipped(make([]byte, maxDecompressedLength_bytes+1))
+	messages, err := SplitMessages(frame(true, compressed), "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []akinet.GRPCMessage{
+		{Compressed: true, Length: len(compressed), Data: compressed},
+	}
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+}
+
+func TestIsGRPC(t *testing.T) {
+	testCases := map[string]bool{
+		"application/grpc":       true,
+		"application/grpc+proto": true,
+		"application/grpc+json":  true,
+		"application/grpc-web":   false,
+		"application/json":       false,
+		"":                       false,
+	}
+
+	for contentType, expected := range testCases {
+		h := http.Header{"Content-Type": {contentType}}
+		if IsGRPC(h) != expected {
+			t.Errorf("IsGRPC(%q) = %v, expected %v", contentType, !expected, expected)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package grpc
+
+import (
+	"github.com/golang/glog"
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a parser factory that wraps the given HTTP parser factory (usually
+// the one from akinet/http2). HTTP requests and responses that carry gRPC calls
+// are converted into GRPCRequest and GRPCResponse values; everything else is
+// passed through unchanged.
+func NewGRPCParserFactory(httpFactory akinet.TCPParserFactory) akinet.TCPParserFactory {
+	return &grpcParserFactory{
+		httpFactory: httpFactory,
+	}
+}
+
+type grpcParserFactory struct {
+	httpFactory akinet.TCPParserFactory
+}
+
+func (factory *grpcParserFactory) Name() string {
+	return "gRPC over " + factory.httpFactory.Name()
+}
+
+func (factory *grpcParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	return factory.httpFactory.Accepts(input, isEnd)
+}
+
+func (factory *grpcParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return &grpcParser{
+		httpParser: factory.httpFactory.CreateParser(id, seq, ack),
+	}
+}
+
+type grpcParser struct {
+	httpParser akinet.TCPParser
+}
+
+var _ akinet.TCPParser = (*grpcParser)(nil)
+
+func (p *grpcParser) Name() string {
+	return "gRPC over " + p.httpParser.Name()
+}
+
+func (p *grpcParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	result, unused, err = p.httpParser.Parse(input, isEnd)
+	if err != nil || result == nil {
+		return result, unused, err
+	}
+
+	switch r := result.(type) {
+	case akinet.HTTPRequest:
+		if IsGRPC(r.Header) {
+			if c, err := FromHTTPRequest(r); err == nil {
+				result = c
+			} else {
+				glog.V(6).Infof("leaving gRPC request as HTTP: %v", err)
+			}
+		}
+	case akinet.HTTPResponse:
+		if IsGRPC(r.Header) {
+			if c, err := FromHTTPResponse(r); err == nil {
+				result = c
+			} else {
+				glog.V(6).Infof("leaving gRPC response as HTTP: %v", err)
+			}
+		}
+	}
+	return result, unused, nil
+}
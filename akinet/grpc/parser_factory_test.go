@@ -0,0 +1,104 @@
+package grpc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/gopacket/reassembly"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	akihttp "github.com/akitasoftware/akita-libs/akinet/http"
+	akihttp2 "github.com/akitasoftware/akita-libs/akinet/http2"
+	"github.com/akitasoftware/akita-libs/akinet/internal/parsertest"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var testBidiID = akinet.TCPBidiID(testStreamID)
+
+const clientSeq = reassembly.Sequence(1000)
+
+func newTestParserFactory() akinet.TCPParserFactory {
+	return NewGRPCParserFactory(akihttp2.NewHTTP2ParserFactory(akihttp.ParserOptions{}))
+}
+
+// Returns the client's side of an HTTP/2 connection that makes a single call
+// with the given content type.
+func clientFlow(contentType string, body []byte) []byte {
+	var hbuf bytes.Buffer
+	enc := hpack.NewEncoder(&hbuf)
+	for _, f := range []hpack.HeaderField{
+		{Name: ":method", Value: "POST"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":authority", Value: "example.com"},
+		{Name: ":path", Value: "/helloworld.Greeter/SayHello"},
+		{Name: "content-type", Value: contentType},
+	} {
+		enc.WriteField(f)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(http2.ClientPreface)
+	framer := http2.NewFramer(&buf, nil)
+	framer.WriteSettings()
+	framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: hbuf.Bytes(),
+		EndHeaders:    true,
+	})
+	framer.WriteData(1, true, body)
+	return buf.Bytes()
+}
+
+func isHelloRequest(result akinet.ParsedNetworkContent) bool {
+	r, ok := result.(akinet.GRPCRequest)
+	return ok && r.Service == "helloworld.Greeter" && r.Method == "SayHello" &&
+		len(r.Messages) == 1 && string(r.Messages[0].Data) == "hello"
+}
+
+func TestParserConvertsGRPCCalls(t *testing.T) {
+	results := parsertest.ParseAll(t, newTestParserFactory(), testBidiID, clientSeq, 0,
+		clientFlow("application/grpc", frame(false, []byte("hello"))))
+	if len(results) != 1 || !isHelloRequest(results[0]) {
+		t.Errorf("expected a gRPC request, got %v", results)
+	}
+}
+
+func TestParserPassesThroughOtherHTTP(t *testing.T) {
+	results := parsertest.ParseAll(t, newTestParserFactory(), testBidiID, clientSeq, 0,
+		clientFlow("application/json", []byte(`{}`)))
+	if len(results) != 1 {
+		t.Fatalf("expected one result, got %v", results)
+	}
+	if r, ok := results[0].(akinet.HTTPRequest); !ok || string(r.Body) != `{}` {
+		t.Errorf("expected an HTTP request, got %v", results[0])
+	}
+}
+
+func TestFactoryAcceptsConnectionPreface(t *testing.T) {
+	factory := newTestParserFactory()
+	flow := clientFlow("application/grpc", frame(false, []byte("hello")))
+	if decision, _ := factory.Accepts(memview.New(flow[:10]), false); decision != akinet.NeedMoreData {
+		t.Errorf("expected factory to need more data, got %s", decision)
+	}
+	if decision, discardFront := factory.Accepts(memview.New(flow), false); decision != akinet.Accept || discardFront != 0 {
+		t.Errorf("expected factory to accept, got %s, discarding %d bytes", decision, discardFront)
+	}
+}
+
+func TestFactoryRejectsOtherProtocols(t *testing.T) {
+	parsertest.RunRejectTests(t, newTestParserFactory(), parsertest.OtherProtocols(""))
+}
+
+func TestIncrementalParse(t *testing.T) {
+	testCases := []parsertest.IncrementalTestCase{
+		{
+			Name:  "request",
+			Seq:   clientSeq,
+			Input: clientFlow("application/grpc", frame(false, []byte("hello"))),
+			Check: isHelloRequest,
+		},
+	}
+	parsertest.RunIncrementalTests(t, newTestParserFactory(), testBidiID, testCases)
+}
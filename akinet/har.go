@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/google/martian/v3/har"
 	"github.com/pkg/errors"
@@ -106,8 +107,7 @@ func (r *HTTPResponse) FromHAR(h *har.Response) error {
 	headers, _ := convertHARHeaders(h.Headers)
 	r.Header = headers
 
-	// TODO(kku): Our OpeanAPI converter does not expect cookies in response yet.
-	// r.Cookies = convertHARCookies(h.Cookies)
+	r.Cookies = convertHARCookies(h.Cookies)
 
 	if c := h.Content; c != nil {
 		r.Header.Set("Content-Type", c.MimeType)
@@ -146,12 +146,21 @@ func convertHARHeaders(headers []har.Header) (http.Header, string) {
 func convertHARCookies(cs []har.Cookie) []*http.Cookie {
 	results := make([]*http.Cookie, 0, len(cs))
 	for _, c := range cs {
+		// Expires isn't unmarshalled from JSON, so fall back to the ISO 8601
+		// representation.
+		expires := c.Expires
+		if expires.IsZero() && c.Expires8601 != "" {
+			if t, err := time.Parse(time.RFC3339, c.Expires8601); err == nil {
+				expires = t
+			}
+		}
+
 		results = append(results, &http.Cookie{
 			Name:     c.Name,
 			Value:    c.Value,
 			Path:     c.Path,
 			Domain:   c.Domain,
-			Expires:  c.Expires,
+			Expires:  expires,
 			HttpOnly: c.HTTPOnly,
 			Secure:   c.Secure,
 		})
@@ -0,0 +1,227 @@
+package akinet
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Reads the entries of a HAR log one at a time, so that large logs don't have
+// to be held in memory.
+type HARReader struct {
+	dec *json.Decoder
+
+	// Shared by all requests and responses read from the log. Each entry's
+	// index is used as the Seq of its request and response.
+	streamID uuid.UUID
+
+	// The index of the next entry.
+	index int
+
+	// Set once the decoder is positioned in the entries array.
+	started bool
+
+	// Set once the end of the entries has been reached, or a fatal error has
+	// occurred.
+	err error
+}
+
+func NewHARReader(r io.Reader) *HARReader {
+	return &HARReader{
+		dec:      json.NewDecoder(r),
+		streamID: uuid.New(),
+	}
+}
+
+// HAR timings, including the phases before the request is sent, which
+// har.Timings omits. A value of -1 means that the phase doesn't apply.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harReaderEntry struct {
+	HAREntry
+
+	// Shadows HAREntry.Timings.
+	Timings *harTimings `json:"timings"`
+}
+
+// Returns the request and response of the next entry in the log. The
+// response has nil Content if the entry has no response. Returns io.EOF once
+// there are no more entries.
+//
+// Errors in converting an entry name the entry's index, and Next can be called
+// again to skip to the following entry. Errors in the JSON syntax of the log
+// are fatal, and are returned by every subsequent call.
+func (hr *HARReader) Next() (req, resp ParsedNetworkTraffic, err error) {
+	if hr.err != nil {
+		return req, resp, hr.err
+	}
+	if !hr.started {
+		if err := hr.findEntries(); err != nil {
+			hr.err = err
+			return req, resp, err
+		}
+		hr.started = true
+	}
+
+	if !hr.dec.More() {
+		hr.err = io.EOF
+		return req, resp, io.EOF
+	}
+
+	index := hr.index
+	hr.index++
+
+	var entry harReaderEntry
+	if err := hr.dec.Decode(&entry); err != nil {
+		wrapped := errors.Wrapf(err, "failed to decode HAR entry %d", index)
+		if _, ok := err.(*json.SyntaxError); ok || err == io.EOF || err == io.ErrUnexpectedEOF {
+			// The decoder can't continue after a syntax error.
+			hr.err = wrapped
+		}
+		return req, resp, wrapped
+	}
+
+	req, resp, err = hr.convert(&entry, index)
+	if err != nil {
+		return req, resp, errors.Wrapf(err, "failed to convert HAR entry %d", index)
+	}
+	return req, resp, nil
+}
+
+// Positions the decoder at the first entry of the log.
+func (hr *HARReader) findEntries() error {
+	if err := hr.expectDelim('{'); err != nil {
+		return errors.Wrap(err, "failed to read HAR")
+	}
+	if err := hr.findKey("log"); err != nil {
+		return errors.Wrap(err, "failed to read HAR")
+	}
+	if err := hr.expectDelim('{'); err != nil {
+		return errors.Wrap(err, "failed to read HAR log")
+	}
+	if err := hr.findKey("entries"); err != nil {
+		return errors.Wrap(err, "failed to read HAR log")
+	}
+	if err := hr.expectDelim('['); err != nil {
+		return errors.Wrap(err, "failed to read HAR entries")
+	}
+	return nil
+}
+
+func (hr *HARReader) expectDelim(delim json.Delim) error {
+	tok, err := hr.dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return errors.Errorf("expected %s, got %v", delim, tok)
+	}
+	return nil
+}
+
+// Skips the members of the current object until the one with the given key.
+func (hr *HARReader) findKey(key string) error {
+	for hr.dec.More() {
+		tok, err := hr.dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok == key {
+			return nil
+		}
+		var skipped json.RawMessage
+		if err := hr.dec.Decode(&skipped); err != nil {
+			return err
+		}
+	}
+	return errors.Errorf("missing %q", key)
+}
+
+func (hr *HARReader) convert(entry *harReaderEntry, index int) (req, resp ParsedNetworkTraffic, err error) {
+	if entry.Entry == nil || entry.Request == nil {
+		return req, resp, errors.New("missing request")
+	}
+
+	httpReq := HTTPRequest{StreamID: hr.streamID, Seq: index}
+	if err := httpReq.FromHAR(entry.Request); err != nil {
+		return req, resp, errors.Wrap(err, "failed to convert request")
+	}
+
+	serverIP, serverPort := harServerAddress(entry.ServerIPAddress, httpReq.URL)
+
+	// The request is sent after any time spent blocked, resolving the host, and
+	// connecting.
+	var timings harTimings
+	if entry.Timings != nil {
+		timings = *entry.Timings
+	}
+	sendStart := entry.StartedDateTime.Add(harDuration(timings.Blocked) + harDuration(timings.DNS) + harDuration(timings.Connect))
+	sendEnd := sendStart.Add(harDuration(timings.Send))
+	receiveStart := sendEnd.Add(harDuration(timings.Wait))
+	receiveEnd := receiveStart.Add(harDuration(timings.Receive))
+
+	req = ParsedNetworkTraffic{
+		DstIP:           serverIP,
+		DstPort:         serverPort,
+		Content:         httpReq,
+		ObservationTime: sendStart,
+		FinalPacketTime: sendEnd,
+	}
+
+	if entry.Response != nil {
+		httpResp := HTTPResponse{StreamID: hr.streamID, Seq: index}
+		if err := httpResp.FromHAR(entry.Response); err != nil {
+			return req, resp, errors.Wrap(err, "failed to convert response")
+		}
+		resp = ParsedNetworkTraffic{
+			SrcIP:           serverIP,
+			SrcPort:         serverPort,
+			Content:         httpResp,
+			ObservationTime: receiveStart,
+			FinalPacketTime: receiveEnd,
+		}
+	}
+
+	return req, resp, nil
+}
+
+// Returns the server's IP address from serverIPAddress, which may be
+// bracketed if it's an IPv6 address, and the server's port from the URL.
+func harServerAddress(serverIPAddress string, u *url.URL) (net.IP, int) {
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(serverIPAddress, "["), "]"))
+
+	var port int
+	if u != nil {
+		if p, err := strconv.Atoi(u.Port()); err == nil {
+			port = p
+		} else if u.Scheme == "https" {
+			port = 443
+		} else if u.Scheme == "http" {
+			port = 80
+		}
+	}
+	return ip, port
+}
+
+// Converts a HAR timing, in milliseconds, to a duration. Negative timings mean
+// that the phase doesn't apply.
+func harDuration(ms float64) time.Duration {
+	if ms < 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
@@ -0,0 +1,187 @@
+package akinet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/har"
+	"github.com/stretchr/testify/assert"
+)
+
+var harLog = `{
+	"log": {
+		"version": "1.2",
+		"pages": [{"id": "page_1", "title": "example"}],
+		"entries": [
+			{
+				"startedDateTime": "2021-11-01T12:00:00.000Z",
+				"time": 20,
+				"request": {
+					"method": "GET",
+					"url": "https://example.com:8443/v1/pets?limit=10",
+					"httpVersion": "HTTP/1.1",
+					"cookies": [],
+					"headers": [{"name": "Host", "value": "example.com:8443"}],
+					"queryString": [{"name": "limit", "value": "10"}],
+					"headersSize": -1,
+					"bodySize": 0
+				},
+				"response": {
+					"status": 200,
+					"statusText": "OK",
+					"httpVersion": "HTTP/1.1",
+					"cookies": [{"name": "id", "value": "p1", "path": "/", "expires": "2022-01-01T00:00:00Z", "httpOnly": true}],
+					"headers": [{"name": "Set-Cookie", "value": "id=p1; Path=/; Expires=Sat, 01 Jan 2022 00:00:00 GMT; HttpOnly"}],
+					"content": {"size": 2, "mimeType": "application/json", "text": "[]"},
+					"redirectURL": "",
+					"headersSize": -1,
+					"bodySize": 2
+				},
+				"cache": {},
+				"timings": {"blocked": 1, "dns": -1, "connect": 4, "send": 2, "wait": 10, "receive": 3},
+				"serverIPAddress": "[::1]",
+				"connection": "1234"
+			},
+			{
+				"startedDateTime": "2021-11-01T12:00:01.000Z",
+				"request": {"method": "GET", "url": "http://example.com/", "httpVersion": "HTTP/1.1"},
+				"response": {"status": 99, "httpVersion": "HTTP/1.1"}
+			},
+			{
+				"startedDateTime": "2021-11-01T12:00:02.000Z",
+				"request": {"method": "GET", "url": "http://example.com/pending", "httpVersion": "HTTP/1.1"},
+				"timings": {"send": 1, "wait": -1, "receive": -1},
+				"serverIPAddress": "10.0.0.2"
+			}
+		],
+		"creator": {"name": "browser", "version": "1.0"}
+	}
+}`
+
+func TestHARReader(t *testing.T) {
+	r := NewHARReader(strings.NewReader(harLog))
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	// The first entry.
+	req, resp, err := r.Next()
+	if !assert.NoError(t, err) {
+		return
+	}
+	httpReq := req.Content.(HTTPRequest)
+	httpResp := resp.Content.(HTTPResponse)
+	assert.Equal(t, "/v1/pets", httpReq.URL.Path)
+	assert.Equal(t, httpReq.GetStreamKey(), httpResp.GetStreamKey())
+	assert.Equal(t, 0, httpReq.Seq)
+
+	assert.Equal(t, net.ParseIP("::1"), req.DstIP)
+	assert.Equal(t, 8443, req.DstPort)
+	assert.Equal(t, net.ParseIP("::1"), resp.SrcIP)
+	assert.Equal(t, 8443, resp.SrcPort)
+
+	// Blocked and connecting take 5ms before the request is sent.
+	assert.Equal(t, start.Add(5*time.Millisecond), req.ObservationTime)
+	assert.Equal(t, start.Add(7*time.Millisecond), req.FinalPacketTime)
+	assert.Equal(t, start.Add(17*time.Millisecond), resp.ObservationTime)
+	assert.Equal(t, start.Add(20*time.Millisecond), resp.FinalPacketTime)
+
+	assert.Equal(t, []*http.Cookie{{
+		Name:     "id",
+		Value:    "p1",
+		Path:     "/",
+		Expires:  time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		HttpOnly: true,
+	}}, httpResp.Cookies)
+
+	// The second entry has an invalid status code.
+	_, _, err = r.Next()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "HAR entry 1")
+	}
+
+	// The third entry has no response.
+	req, resp, err = r.Next()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 2, req.Content.(HTTPRequest).Seq)
+	assert.Equal(t, net.ParseIP("10.0.0.2"), req.DstIP)
+	assert.Equal(t, 80, req.DstPort)
+	assert.Equal(t, start.Add(2*time.Second+time.Millisecond), req.FinalPacketTime)
+	assert.Nil(t, resp.Content)
+
+	_, _, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+	_, _, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestHARReaderMalformed(t *testing.T) {
+	testCases := []struct {
+		name     string
+		har      string
+		expected string
+	}{
+		{
+			name:     "not an object",
+			har:      `[]`,
+			expected: "failed to read HAR",
+		},
+		{
+			name:     "missing entries",
+			har:      `{"log": {"version": "1.2"}}`,
+			expected: `missing "entries"`,
+		},
+		{
+			name:     "wrong type in entry",
+			har:      `{"log": {"entries": [{"request": {"method": 1}}]}}`,
+			expected: "HAR entry 0",
+		},
+		{
+			name:     "syntax error in entry",
+			har:      `{"log": {"entries": [{"request": {}}, {"request": }]}}`,
+			expected: "HAR entry 1",
+		},
+	}
+
+	for _, c := range testCases {
+		r := NewHARReader(strings.NewReader(c.har))
+		var err error
+		for i := 0; i < 3 && err == nil; i++ {
+			_, _, err = r.Next()
+		}
+		if assert.Error(t, err, c.name) {
+			assert.Contains(t, err.Error(), c.expected, c.name)
+		}
+	}
+}
+
+func TestHARReaderRoundTrip(t *testing.T) {
+	req, resp := harWriterExchange()
+
+	var buf bytes.Buffer
+	w := NewHARWriter(&buf, har.Creator{Name: "akita"})
+	assert.NoError(t, w.WriteExchange(req, resp))
+	assert.NoError(t, w.Close())
+
+	r := NewHARReader(&buf)
+	readReq, readResp, err := r.Next()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, req.DstIP.To16(), readReq.DstIP)
+	// The port comes from the URL, which has none, rather than the connection.
+	assert.Equal(t, 80, readReq.DstPort)
+	assert.True(t, req.ObservationTime.Equal(readReq.ObservationTime))
+	assert.True(t, req.FinalPacketTime.Equal(readReq.FinalPacketTime))
+	assert.True(t, resp.ObservationTime.Equal(readResp.ObservationTime))
+	assert.True(t, resp.FinalPacketTime.Equal(readResp.FinalPacketTime))
+	assert.Equal(t, resp.Content.(HTTPResponse).Body, readResp.Content.(HTTPResponse).Body)
+
+	_, _, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+}
@@ -102,6 +102,7 @@ func TestHTTPResponseFromHAR(t *testing.T) {
 		},
 		Body:             []byte("{\n  \"hello\": \"world\"\n}"),
 		BodyDecompressed: true,
+		Cookies:          []*http.Cookie{},
 	}
 	assert.Equal(t, expected, r)
 }
@@ -0,0 +1,310 @@
+package akinet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/martian/v3/har"
+	"github.com/pkg/errors"
+)
+
+// The HAR version written by HARWriter.
+const HARVersion = "1.2"
+
+// A HAR entry, with the fields of HAR 1.2 entries that are missing from
+// har.Entry.
+type HAREntry struct {
+	*har.Entry
+
+	// The IP address of the server.
+	ServerIPAddress string `json:"serverIPAddress,omitempty"`
+
+	// Identifies the TCP connection. We use the server's port.
+	Connection string `json:"connection,omitempty"`
+}
+
+// Writes a HAR log, one entry at a time, so that large captures don't have to
+// be held in memory. Close must be called to complete the log.
+type HARWriter struct {
+	w       io.Writer
+	creator har.Creator
+
+	numEntries int
+	closed     bool
+}
+
+func NewHARWriter(w io.Writer, creator har.Creator) *HARWriter {
+	return &HARWriter{w: w, creator: creator}
+}
+
+// Writes an entry for a request and its response. req must carry an
+// HTTPRequest, and resp must carry the matching HTTPResponse, or have nil
+// Content if the request was not answered.
+func (hw *HARWriter) WriteExchange(req, resp ParsedNetworkTraffic) error {
+	entry, err := NewHAREntry(req, resp)
+	if err != nil {
+		return err
+	}
+	return hw.WriteEntry(entry)
+}
+
+// Writes an entry to the log.
+func (hw *HARWriter) WriteEntry(entry *HAREntry) error {
+	if hw.closed {
+		return errors.New("HAR writer is closed")
+	}
+
+	if hw.numEntries == 0 {
+		if err := hw.writeHeader(); err != nil {
+			return err
+		}
+	} else if _, err := io.WriteString(hw.w, ","); err != nil {
+		return errors.Wrap(err, "failed to write HAR entry")
+	}
+
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal HAR entry %d", hw.numEntries)
+	}
+	if _, err := hw.w.Write(entryJSON); err != nil {
+		return errors.Wrap(err, "failed to write HAR entry")
+	}
+	hw.numEntries++
+	return nil
+}
+
+// Completes the log. Does not close the underlying writer.
+func (hw *HARWriter) Close() error {
+	if hw.closed {
+		return nil
+	}
+	if hw.numEntries == 0 {
+		if err := hw.writeHeader(); err != nil {
+			return err
+		}
+	}
+	hw.closed = true
+	_, err := io.WriteString(hw.w, "]}}\n")
+	return errors.Wrap(err, "failed to write HAR log")
+}
+
+// Writes everything in the log that precedes the entries.
+func (hw *HARWriter) writeHeader() error {
+	versionJSON, err := json.Marshal(HARVersion)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal HAR version")
+	}
+	creatorJSON, err := json.Marshal(hw.creator)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal HAR creator")
+	}
+	_, err = fmt.Fprintf(hw.w, `{"log":{"version":%s,"creator":%s,"entries":[`, versionJSON, creatorJSON)
+	return errors.Wrap(err, "failed to write HAR log")
+}
+
+// Converts a request and its response to a HAR entry. req must carry an
+// HTTPRequest, and resp must carry the matching HTTPResponse, or have nil
+// Content if the request was not answered.
+//
+// The entry's timings are derived from the ObservationTime and
+// FinalPacketTime of the request and response: sending is the time taken to
+// observe the whole request, waiting is the time from the end of the request to
+// the start of the response, and receiving is the time taken to observe the
+// whole response.
+func NewHAREntry(req, resp ParsedNetworkTraffic) (*HAREntry, error) {
+	httpReq, ok := req.Content.(HTTPRequest)
+	if !ok {
+		return nil, errors.Errorf("expected an HTTPRequest, got %T", req.Content)
+	}
+
+	entry := &HAREntry{
+		Entry: &har.Entry{
+			ID:              httpReq.GetStreamKey(),
+			StartedDateTime: req.ObservationTime.UTC(),
+			Request:         httpReq.ToHAR(req.DstIP, req.DstPort),
+			Cache:           &har.Cache{},
+			Timings: &har.Timings{
+				Send: milliseconds(req.FinalPacketTime.Sub(req.ObservationTime)),
+			},
+		},
+		ServerIPAddress: ipString(req.DstIP),
+	}
+	if req.DstPort != 0 {
+		entry.Connection = strconv.Itoa(req.DstPort)
+	}
+
+	if resp.Content != nil {
+		httpResp, ok := resp.Content.(HTTPResponse)
+		if !ok {
+			return nil, errors.Errorf("expected an HTTPResponse, got %T", resp.Content)
+		}
+		if httpResp.GetStreamKey() != httpReq.GetStreamKey() {
+			return nil, errors.Errorf("response %s does not match request %s", httpResp.GetStreamKey(), httpReq.GetStreamKey())
+		}
+		entry.Response = httpResp.ToHAR()
+		entry.Timings.Wait = milliseconds(resp.ObservationTime.Sub(req.FinalPacketTime))
+		entry.Timings.Receive = milliseconds(resp.FinalPacketTime.Sub(resp.ObservationTime))
+	}
+
+	entry.Time = entry.Timings.Send + entry.Timings.Wait + entry.Timings.Receive
+	return entry, nil
+}
+
+// Converts the request to a HAR request. The server's IP address and port are
+// used for the URL if the request has no host.
+func (r HTTPRequest) ToHAR(serverIP net.IP, serverPort int) *har.Request {
+	u := url.URL{Scheme: "http"}
+	if r.TLSConnectionID != nil {
+		u.Scheme = "https"
+	}
+	if r.URL != nil {
+		u.Path = r.URL.Path
+		u.RawPath = r.URL.RawPath
+		u.RawQuery = r.URL.RawQuery
+	}
+	u.Host = r.Host
+	if u.Host == "" && serverIP != nil {
+		u.Host = net.JoinHostPort(serverIP.String(), strconv.Itoa(serverPort))
+	}
+
+	headers := convertToHARHeaders(r.Header)
+	if r.Host != "" && r.Header.Get("Host") == "" {
+		headers = append([]har.Header{{Name: "Host", Value: r.Host}}, headers...)
+	}
+
+	result := &har.Request{
+		Method:      r.Method,
+		URL:         u.String(),
+		HTTPVersion: harHTTPVersion(r.ProtoMajor, r.ProtoMinor),
+		Cookies:     convertToHARCookies(r.Cookies),
+		Headers:     headers,
+		QueryString: []har.QueryString{},
+		HeadersSize: -1,
+		BodySize:    bodySize(r.Body, r.RawBodyLength),
+	}
+
+	query := u.Query()
+	for _, name := range sortedKeys(query) {
+		for _, value := range query[name] {
+			result.QueryString = append(result.QueryString, har.QueryString{Name: name, Value: value})
+		}
+	}
+
+	if len(r.Body) > 0 {
+		// PostData is base64-encoded when marshalled if it isn't valid UTF-8.
+		result.PostData = &har.PostData{
+			MimeType: r.Header.Get("Content-Type"),
+			Params:   []har.Param{},
+			Text:     string(r.Body),
+		}
+	}
+
+	return result
+}
+
+// Converts the response to a HAR response.
+func (r HTTPResponse) ToHAR() *har.Response {
+	cookies := r.Cookies
+	if len(cookies) == 0 {
+		cookies = (&http.Response{Header: r.Header}).Cookies()
+	}
+
+	content := &har.Content{
+		Size:     int64(len(r.Body)),
+		MimeType: r.Header.Get("Content-Type"),
+		Text:     r.Body,
+	}
+	if !utf8.Valid(r.Body) {
+		content.Encoding = "base64"
+	}
+
+	return &har.Response{
+		Status:      r.StatusCode,
+		StatusText:  http.StatusText(r.StatusCode),
+		HTTPVersion: harHTTPVersion(r.ProtoMajor, r.ProtoMinor),
+		Cookies:     convertToHARCookies(cookies),
+		Headers:     convertToHARHeaders(r.Header),
+		Content:     content,
+		RedirectURL: r.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    bodySize(r.Body, r.RawBodyLength),
+	}
+}
+
+// Converts headers to HAR headers, sorted by name so that the output is
+// deterministic.
+func convertToHARHeaders(headers http.Header) []har.Header {
+	results := make([]har.Header, 0, len(headers))
+	for _, name := range sortedKeys(headers) {
+		for _, value := range headers[name] {
+			results = append(results, har.Header{Name: name, Value: value})
+		}
+	}
+	return results
+}
+
+func convertToHARCookies(cs []*http.Cookie) []har.Cookie {
+	results := make([]har.Cookie, 0, len(cs))
+	for _, c := range cs {
+		var expires string
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Format(time.RFC3339)
+		}
+		results = append(results, har.Cookie{
+			Name:        c.Name,
+			Value:       c.Value,
+			Path:        c.Path,
+			Domain:      c.Domain,
+			Expires:     c.Expires,
+			Expires8601: expires,
+			HTTPOnly:    c.HttpOnly,
+			Secure:      c.Secure,
+		})
+	}
+	return results
+}
+
+func harHTTPVersion(major, minor int) string {
+	return fmt.Sprintf("HTTP/%d.%d", major, minor)
+}
+
+// Returns the size of a body as it appeared on the wire.
+func bodySize(body []byte, rawBodyLength int) int64 {
+	if rawBodyLength > 0 {
+		return int64(rawBodyLength)
+	}
+	return int64(len(body))
+}
+
+// Converts a duration to milliseconds. Negative durations, which can come from
+// clock skew between interfaces, are treated as zero.
+func milliseconds(d time.Duration) float32 {
+	if d < 0 {
+		return 0
+	}
+	return float32(d) / float32(time.Millisecond)
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,173 @@
+package akinet
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/martian/v3/har"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var harWriterStreamID = uuid.MustParse("6f3d0f9a-7d4a-4c1e-9a8b-6a0a4c3a2b1c")
+
+func harWriterExchange() (ParsedNetworkTraffic, ParsedNetworkTraffic) {
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	req := ParsedNetworkTraffic{
+		SrcIP:   net.IP{10, 0, 0, 1},
+		SrcPort: 54321,
+		DstIP:   net.IP{10, 0, 0, 2},
+		DstPort: 8080,
+		Content: HTTPRequest{
+			StreamID:   harWriterStreamID,
+			Seq:        7,
+			Method:     "POST",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			URL:        &url.URL{Path: "/v1/pets", RawQuery: "limit=10&tag=a&tag=b"},
+			Host:       "example.com",
+			Header: http.Header{
+				"Authorization": {"bearer 123"},
+				"Content-Type":  {"application/json"},
+			},
+			Body:    []byte(`{"name":"Rex"}`),
+			Cookies: []*http.Cookie{{Name: "session", Value: "abc"}},
+		},
+		ObservationTime: start,
+		FinalPacketTime: start.Add(2 * time.Millisecond),
+	}
+	resp := ParsedNetworkTraffic{
+		SrcIP:   net.IP{10, 0, 0, 2},
+		SrcPort: 8080,
+		DstIP:   net.IP{10, 0, 0, 1},
+		DstPort: 54321,
+		Content: HTTPResponse{
+			StreamID:   harWriterStreamID,
+			Seq:        7,
+			StatusCode: 201,
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header: http.Header{
+				"Content-Type": {"application/octet-stream"},
+				"Set-Cookie":   {"id=p1; Path=/; HttpOnly"},
+			},
+			Body: []byte{0xff, 0x00, 0xfe},
+		},
+		ObservationTime: start.Add(12 * time.Millisecond),
+		FinalPacketTime: start.Add(15 * time.Millisecond),
+	}
+	return req, resp
+}
+
+func TestHARWriter(t *testing.T) {
+	req, resp := harWriterExchange()
+
+	var buf bytes.Buffer
+	w := NewHARWriter(&buf, har.Creator{Name: "akita", Version: "1.0"})
+	assert.NoError(t, w.WriteExchange(req, resp))
+	assert.NoError(t, w.WriteExchange(req, ParsedNetworkTraffic{}))
+	assert.NoError(t, w.Close())
+
+	var log struct {
+		Log struct {
+			Version string
+			Creator har.Creator
+			Entries []struct {
+				har.Entry
+				ServerIPAddress string `json:"serverIPAddress"`
+				Connection      string `json:"connection"`
+			}
+		}
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+	assert.Equal(t, "1.2", log.Log.Version)
+	assert.Equal(t, har.Creator{Name: "akita", Version: "1.0"}, log.Log.Creator)
+	if !assert.Len(t, log.Log.Entries, 2) {
+		return
+	}
+
+	entry := log.Log.Entries[0]
+	assert.Equal(t, "10.0.0.2", entry.ServerIPAddress)
+	assert.Equal(t, "8080", entry.Connection)
+	assert.Equal(t, req.ObservationTime, entry.StartedDateTime)
+	assert.Equal(t, &har.Timings{Send: 2, Wait: 10, Receive: 3}, entry.Timings)
+	assert.Equal(t, float32(15), entry.Time)
+
+	assert.Equal(t, "http://example.com/v1/pets?limit=10&tag=a&tag=b", entry.Request.URL)
+	assert.Equal(t, []har.QueryString{{Name: "limit", Value: "10"}, {Name: "tag", Value: "a"}, {Name: "tag", Value: "b"}}, entry.Request.QueryString)
+	assert.Equal(t, []har.Header{
+		{Name: "Host", Value: "example.com"},
+		{Name: "Authorization", Value: "bearer 123"},
+		{Name: "Content-Type", Value: "application/json"},
+	}, entry.Request.Headers)
+	assert.Equal(t, []har.Cookie{{Name: "session", Value: "abc"}}, entry.Request.Cookies)
+
+	assert.Equal(t, "Created", entry.Response.StatusText)
+	assert.Equal(t, "base64", entry.Response.Content.Encoding)
+	assert.Equal(t, []byte{0xff, 0x00, 0xfe}, entry.Response.Content.Text)
+	assert.Equal(t, []har.Cookie{{Name: "id", Value: "p1", Path: "/", HTTPOnly: true}}, entry.Response.Cookies)
+
+	// The unanswered request has no response or waiting time.
+	assert.Nil(t, log.Log.Entries[1].Response)
+	assert.Equal(t, &har.Timings{Send: 2}, log.Log.Entries[1].Timings)
+}
+
+func TestHARWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewHARWriter(&buf, har.Creator{Name: "akita"})
+	assert.NoError(t, w.Close())
+
+	var log har.HAR
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+	assert.Empty(t, log.Log.Entries)
+	assert.Error(t, w.WriteExchange(harWriterExchange()))
+}
+
+func TestHARRoundTrip(t *testing.T) {
+	req, resp := harWriterExchange()
+	entry, err := NewHAREntry(req, resp)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	entryJSON, err := json.Marshal(entry)
+	assert.NoError(t, err)
+	var decoded har.Entry
+	assert.NoError(t, json.Unmarshal(entryJSON, &decoded))
+
+	var r HTTPRequest
+	assert.NoError(t, r.FromHAR(decoded.Request))
+	origReq := req.Content.(HTTPRequest)
+	assert.Equal(t, origReq.Method, r.Method)
+	assert.Equal(t, origReq.Host, r.Host)
+	assert.Equal(t, origReq.URL.Path, r.URL.Path)
+	assert.Equal(t, origReq.URL.Query(), r.URL.Query())
+	assert.Equal(t, origReq.Header, r.Header)
+	assert.Equal(t, origReq.Body, r.Body)
+	assert.Equal(t, origReq.Cookies, r.Cookies)
+
+	var s HTTPResponse
+	assert.NoError(t, s.FromHAR(decoded.Response))
+	origResp := resp.Content.(HTTPResponse)
+	assert.Equal(t, origResp.StatusCode, s.StatusCode)
+	assert.Equal(t, origResp.Header, s.Header)
+	assert.Equal(t, origResp.Body, s.Body)
+}
+
+func TestNewHAREntryMismatch(t *testing.T) {
+	req, resp := harWriterExchange()
+	httpResp := resp.Content.(HTTPResponse)
+	httpResp.Seq = 8
+	resp.Content = httpResp
+
+	_, err := NewHAREntry(req, resp)
+	assert.Error(t, err)
+
+	_, err = NewHAREntry(resp, req)
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Decodes the body of the request according to its Content-Encoding header.
+// If the body can't be decoded, or would decode to more than maxLength bytes,
+// it is left as is, and the error is recorded in BodyDecodeError. Truncated
+// bodies are not decoded.
+func DecodeRequestBody(r *akinet.HTTPRequest, maxLength int64) {
+	if r.BodyDecompressed || r.Truncated {
+		return
+	}
+	r.Body, r.RawBodyLength, r.BodyDecompressed, r.BodyDecodeError = decodeBody(r.Header, r.Body, maxLength)
+}
+
+// Decodes the body of the response according to its Content-Encoding header.
+// If the body can't be decoded, or would decode to more than maxLength bytes,
+// it is left as is, and the error is recorded in BodyDecodeError. Truncated
+// bodies are not decoded.
+func DecodeResponseBody(r *akinet.HTTPResponse, maxLength int64) {
+	if r.BodyDecompressed || r.Truncated {
+		return
+	}
+	r.Body, r.RawBodyLength, r.BodyDecompressed, r.BodyDecodeError = decodeBody(r.Header, r.Body, maxLength)
+}
+
+func decodeBody(header http.Header, raw []byte, maxLength int64) (body []byte, rawLength int, decompressed bool, decodeError string) {
+	encodings := contentEncodings(header)
+	if len(encodings) == 0 || len(raw) == 0 {
+		return raw, 0, false, ""
+	}
+
+	decoded, err := DecodeContentEncoding(encodings, raw, maxLength)
+	if err != nil {
+		return raw, len(raw), false, err.Error()
+	}
+	return decoded, len(raw), true, ""
+}
+
+// Returns the content codings listed in the Content-Encoding header, in the
+// order in which they were applied. The identity coding is omitted.
+func contentEncodings(header http.Header) []string {
+	var result []string
+	for _, v := range header.Values("Content-Encoding") {
+		for _, e := range strings.Split(v, ",") {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if e == "" || e == "identity" {
+				continue
+			}
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Reverses the given content codings, which are listed in the order in which
+// they were applied. Returns an error if a coding is not supported, the body is
+// malformed, or the decoded body would be longer than maxLength bytes.
+func DecodeContentEncoding(encodings []string, body []byte, maxLength int64) ([]byte, error) {
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		body, err = decodeOne(encodings[i], body, maxLength)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s content encoding", encodings[i])
+		}
+	}
+	return body, nil
+}
+
+func decodeOne(encoding string, body []byte, maxLength int64) ([]byte, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readAtMost(r, maxLength)
+
+	case "deflate":
+		// RFC 7230 defines deflate as zlib-wrapped, but some servers send a raw
+		// deflate stream instead.
+		if r, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			defer r.Close()
+			return readAtMost(r, maxLength)
+		}
+		r := flate.NewReader(bytes.NewReader(body))
+		defer r.Close()
+		return readAtMost(r, maxLength)
+
+	case "br":
+		return readAtMost(brotli.NewReader(bytes.NewReader(body)), maxLength)
+
+	case "zstd":
+		r, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readAtMost(r, maxLength)
+	}
+	return nil, errors.Errorf("unsupported content encoding")
+}
+
+func readAtMost(r io.Reader, maxLength int64) ([]byte, error) {
+	result, err := ioutil.ReadAll(io.LimitReader(r, maxLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(result)) > maxLength {
+		return nil, errors.Errorf("decoded body is longer than %d bytes", maxLength)
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func brotliBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	w := brotli.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func zstdBytes(b []byte) []byte {
+	w, _ := zstd.NewWriter(nil)
+	defer w.Close()
+	return w.EncodeAll(b, nil)
+}
+
+func TestDecodeResponseBody(t *testing.T) {
+	body := []byte(`{"foo": "bar", "baz": 123}`)
+	stacked := brotliBytes(gzipBytes(body))
+
+	testCases := []struct {
+		name     string
+		encoding []string
+		body     []byte
+		expected akinet.HTTPResponse
+	}{
+		{
+			name:     "gzip",
+			encoding: []string{"gzip"},
+			body:     gzipBytes(body),
+			expected: akinet.HTTPResponse{
+				Body:             body,
+				BodyDecompressed: true,
+				RawBodyLength:    len(gzipBytes(body)),
+			},
+		},
+		{
+			name:     "br",
+			encoding: []string{"br"},
+			body:     brotliBytes(body),
+			expected: akinet.HTTPResponse{
+				Body:             body,
+				BodyDecompressed: true,
+				RawBodyLength:    len(brotliBytes(body)),
+			},
+		},
+		{
+			name:     "zstd",
+			encoding: []string{"zstd"},
+			body:     zstdBytes(body),
+			expected: akinet.HTTPResponse{
+				Body:             body,
+				BodyDecompressed: true,
+				RawBodyLength:    len(zstdBytes(body)),
+			},
+		},
+		{
+			name:     "stacked encodings",
+			encoding: []string{"gzip, identity", "BR"},
+			body:     stacked,
+			expected: akinet.HTTPResponse{
+				Body:             body,
+				BodyDecompressed: true,
+				RawBodyLength:    len(stacked),
+			},
+		},
+		{
+			name:     "identity",
+			encoding: []string{"identity"},
+			body:     body,
+			expected: akinet.HTTPResponse{
+				Body: body,
+			},
+		},
+		{
+			name:     "malformed body",
+			encoding: []string{"gzip"},
+			body:     body,
+			expected: akinet.HTTPResponse{
+				Body:            body,
+				RawBodyLength:   len(body),
+				BodyDecodeError: "failed to decode gzip content encoding: gzip: invalid header",
+			},
+		},
+		{
+			name:     "unsupported encoding",
+			encoding: []string{"compress"},
+			body:     body,
+			expected: akinet.HTTPResponse{
+				Body:            body,
+				RawBodyLength:   len(body),
+				BodyDecodeError: "failed to decode compress content encoding: unsupported content encoding",
+			},
+		},
+	}
+
+	for _, c := range testCases {
+		header := http.Header{"Content-Encoding": c.encoding}
+		r := akinet.HTTPResponse{
+			Header: header,
+			Body:   c.body,
+		}
+		c.expected.Header = header
+
+		DecodeResponseBody(&r, DefaultMaxDecodedBodySize)
+		if diff := cmp.Diff(c.expected, r, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("[%s] found diff: %s", c.name, diff)
+		}
+	}
+}
+
+func TestDecodeBodyTooLong(t *testing.T) {
+	body := make([]byte, 1000)
+	_, err := DecodeContentEncoding([]string{"gzip"}, gzipBytes(body), 999)
+	if err == nil {
+		t.Errorf("expected error decoding body longer than the maximum")
+	}
+
+	decoded, err := DecodeContentEncoding([]string{"gzip"}, gzipBytes(body), 1000)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if len(decoded) != len(body) {
+		t.Errorf("expected %d decoded bytes, got %d", len(body), len(decoded))
+	}
+}
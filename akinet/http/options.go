@@ -0,0 +1,82 @@
+package http
+
+import (
+	"net/http"
+)
+
+const (
+	// Default maximum length of the request or status line and headers.
+	DefaultMaxHeaderSize int64 = http.DefaultMaxHeaderBytes
+
+	// Default maximum length of a body, as it appears on the wire.
+	DefaultMaxBodySize int64 = 1024 * 1024
+
+	// Default maximum length of a body after its Content-Encoding has been
+	// decoded.
+	DefaultMaxDecodedBodySize int64 = 10 * 1024 * 1024
+
+	// Default maximum request URI length. There is no standard, but 2000 bytes
+	// seem to be the de facto standard, so we double it.
+	// https://stackoverflow.com/questions/417142
+	DefaultMaxURILength int64 = 4000
+)
+
+// Determines what happens to bodies longer than the maximum body size.
+type OversizedBodyBehavior int
+
+const (
+	// Keep the first part of the body, up to the maximum body size.
+	TruncateOversizedBody OversizedBodyBehavior = iota
+
+	// Drop the body entirely.
+	DropOversizedBody
+)
+
+// Configures the HTTP/1.x parser factories and the parsers they create. Zero
+// values are replaced with their defaults.
+type ParserOptions struct {
+	// Maximum length of the request or status line and headers. Messages with
+	// longer heads are not parsed.
+	MaxHeaderSize int64
+
+	// Maximum length of a body, as it appears on the wire. Messages with longer
+	// bodies are still parsed, but are marked as truncated, and the rest of the
+	// body is skipped.
+	MaxBodySize int64
+
+	// What to do with bodies longer than MaxBodySize.
+	OversizedBodyBehavior OversizedBodyBehavior
+
+	// Maximum length of a body after its Content-Encoding has been decoded.
+	// Bodies that decode to more than this are left encoded.
+	MaxDecodedBodySize int64
+
+	// Request methods to recognize in addition to the standard ones (e.g.
+	// WebDAV's PROPFIND).
+	ExtraMethods []string
+
+	// Maximum length of the request URI.
+	MaxURILength int64
+}
+
+// Returns the default options for the HTTP/1.x parser factories.
+func DefaultParserOptions() ParserOptions {
+	return ParserOptions{}.WithDefaults()
+}
+
+// Returns a copy of the options with zero values replaced by their defaults.
+func (opts ParserOptions) WithDefaults() ParserOptions {
+	if opts.MaxHeaderSize <= 0 {
+		opts.MaxHeaderSize = DefaultMaxHeaderSize
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+	if opts.MaxDecodedBodySize <= 0 {
+		opts.MaxDecodedBodySize = DefaultMaxDecodedBodySize
+	}
+	if opts.MaxURILength <= 0 {
+		opts.MaxURILength = DefaultMaxURILength
+	}
+	return opts
+}
@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/google/gopacket/reassembly"
 	"github.com/google/uuid"
@@ -14,28 +16,77 @@ import (
 	"github.com/akitasoftware/akita-libs/memview"
 )
 
-var (
-	// Default maximum HTTP length supported.
-	// Can be altered by the CLI as a configuration setting, but doing so after parsing
-	// has started will be a race condition.
-	MaximumHTTPLength int64 = 1024 * 1024
+// Where the parser is in the HTTP message.
+type parserState int
+
+const (
+	// Reading the request or status line and the headers.
+	readingHead parserState = iota
+
+	// Reading a body whose length is given by Content-Length.
+	readingFixedLengthBody
+
+	// Reading the size line of the next chunk of a chunked body.
+	readingChunkSize
+
+	// Reading the data of a chunk.
+	readingChunkData
+
+	// Reading the CRLF that ends a chunk's data.
+	readingChunkDataEnd
+
+	// Reading the trailer section after the last chunk.
+	readingTrailer
+
+	// Reading a response body that ends when the connection closes.
+	readingUntilClose
+
+	// The message is complete.
+	done
 )
 
-// Implements TCPParser
+// Implements TCPParser. This is an incremental parser: it keeps track of how
+// far into the HTTP message it has gotten, so each call to Parse only looks
+// at the new input.
 type httpParser struct {
-	w *io.PipeWriter
+	isRequest bool
+	bidiID    akinet.TCPBidiID
+	seq, ack  reassembly.Sequence
 
+	// The input, which is returned as unused if the input can't be parsed. At
+	// most the maximum head and body sizes are held on to, so that a truncated
+	// body that is skipped over doesn't use up memory.
+	input akinet.RetainedInput
+
+	// The input that is still needed. Once the body is truncated, the input
+	// that has been consumed is dropped from the front of this.
 	allInput memview.MemView
 
-	// Signal that read side of the pipe has closed.
-	readClosed chan error
+	state parserState
+
+	// Offset into allInput of the first byte that has not been consumed.
+	pos int64
 
-	resultChan chan akinet.ParsedNetworkContent
-	isRequest  bool
+	// While reading the head, the offset into allInput from which to continue
+	// looking for the end of the head.
+	headScanPos int64
 
-	// Maximum length of HTTP protocol unit supported; larger requests
-	// or responses may be truncated.
-	maxHttpLength int64
+	// Set once the head has been read.
+	req  *http.Request
+	resp *http.Response
+
+	// Number of bytes left in the fixed-length body or current chunk.
+	remaining int64
+
+	body bytes.Buffer
+
+	// Whether the body was longer than the maximum body size.
+	truncated bool
+
+	// Header fields in the trailer section of a chunked body.
+	trailer http.Header
+
+	opts ParserOptions
 }
 
 func (p *httpParser) Name() string {
@@ -46,183 +97,324 @@ func (p *httpParser) Name() string {
 }
 
 func (p *httpParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
-	var consumedBytes int64
-	defer func() {
-		if err == nil {
-			return
+	p.input.Append(input)
+	p.allInput.Append(input)
+
+	if err := p.advance(); err != nil {
+		return nil, p.input.Unused(p.allInput), err
+	}
+
+	if p.state != done {
+		if !isEnd {
+			return nil, memview.MemView{}, nil
 		}
 
-		// Adjust the number of bytes that were read by the reader but were unused.
-		switch e := err.(type) {
-		case httpPipeReaderDone:
-			result = <-p.resultChan
-			unused = input.SubView(consumedBytes-int64(e), input.Len())
-			err = nil
-		case httpPipeReaderError:
-			unused = p.allInput
-			err = e.err
-		default:
-			err = errors.Wrap(err, "encountered unknown HTTP pipe reader error")
+		// No more data is forthcoming, so emit what we have, if we can.
+		if err := p.endOfInput(); err != nil {
+			return nil, p.input.Unused(p.allInput), err
 		}
-	}()
+		p.pos = p.allInput.Len()
+	}
 
-	p.allInput.Append(input)
+	return p.result(), p.allInput.SubView(p.pos, p.allInput.Len()), nil
+}
 
-	// The PipeWriter blocks until the reader is done consuming all the bytes.
-	consumedBytes, err = io.Copy(p.w, input.CreateReader())
-	if err != nil {
-		return
-	}
+// Consumes as much of the input as possible, stopping when more input is needed
+// or the message is complete.
+func (p *httpParser) advance() error {
+	for {
+		switch p.state {
+		case readingHead:
+			headEnd := p.findHeadEnd()
+			if headEnd < 0 {
+				if p.allInput.Len() > p.opts.MaxHeaderSize {
+					return errors.Errorf("HTTP head longer than %d bytes", p.opts.MaxHeaderSize)
+				}
+				return nil
+			}
+			if headEnd > p.opts.MaxHeaderSize {
+				return errors.Errorf("HTTP head longer than %d bytes", p.opts.MaxHeaderSize)
+			}
+			if err := p.parseHead(p.allInput.SubView(0, headEnd)); err != nil {
+				return err
+			}
+			p.pos = headEnd
 
-	// The reader might close (aka parse complete) after the write returns, so we
-	// need to check. We force an empty write such that:
-	// - If the parse is indeed complete, the reader no longer consumes anything,
-	// 	 so this call will block until the reader closes.
-	// - If the parse is not done yet, the empty write doesn't change things.
-	_, err = p.w.Write([]byte{})
-	if err != nil {
-		return
-	}
+		case readingFixedLengthBody, readingChunkData:
+			n := p.allInput.Len() - p.pos
+			if n > p.remaining {
+				n = p.remaining
+			}
+			p.consumeBody(n)
+			if p.remaining > 0 {
+				return nil
+			}
 
-	// If the reader has not closed yet, tell it we have no more input. This case
-	// happens if there's no content-length and we're reading until connection
-	// close.
-	if isEnd {
-		p.w.Close()
-		err = <-p.readClosed
-	}
+			if p.state == readingFixedLengthBody {
+				p.state = done
+			} else {
+				p.state = readingChunkDataEnd
+			}
+
+		case readingChunkSize:
+			line, ok, err := p.readLine()
+			if !ok || err != nil {
+				return err
+			}
+			size, err := parseChunkSize(line)
+			if err != nil {
+				return err
+			}
+			if size == 0 {
+				p.state = readingTrailer
+			} else {
+				p.remaining = size
+				p.state = readingChunkData
+			}
+
+		case readingChunkDataEnd:
+			if p.allInput.Len()-p.pos < 2 {
+				return nil
+			}
+			if p.allInput.GetByte(p.pos) != '\r' || p.allInput.GetByte(p.pos+1) != '\n' {
+				return errors.New("malformed chunked encoding")
+			}
+			p.pos += 2
+			p.state = readingChunkSize
+
+		case readingTrailer:
+			// The trailer section is a list of header fields, terminated by an empty
+			// line.
+			line, ok, err := p.readLine()
+			if !ok || err != nil {
+				return err
+			}
+			if len(line) == 0 {
+				p.state = done
+				continue
+			}
+			if err := p.addTrailer(line); err != nil {
+				return err
+			}
 
-	// If the HTTP request or response is longer than our maximum length, close the pipe
-	// anyway. This will leave the input stream in a state where it probably can't find
-	// the next header until the accumulated data in the reassembly buffer is all skipped.
-	if p.allInput.Len() > p.maxHttpLength {
-		p.w.Close()
-		err = <-p.readClosed
+		case readingUntilClose:
+			p.consumeBody(p.allInput.Len() - p.pos)
+			return nil
+
+		case done:
+			return nil
+		}
 	}
+}
+
+// Looks for the blank line that ends the head of the HTTP message. Returns the
+// offset into allInput just past the blank line, or -1 if it hasn't been seen
+// yet. Like Go's net/http, lines may end in either CRLF or a bare LF.
+func (p *httpParser) findHeadEnd() int64 {
+	for {
+		lf := p.allInput.Index(p.headScanPos, []byte("\n"))
+		if lf < 0 {
+			// Nothing more to scan. Keep headScanPos at the start of the partial
+			// line.
+			return -1
+		}
 
-	return
+		lineStart := p.headScanPos
+		lineLen := lf - lineStart
+		isBlank := lineLen == 0 || (lineLen == 1 && p.allInput.GetByte(lineStart) == '\r')
+		p.headScanPos = lf + 1
+
+		// The first line is the request or status line, so it can't end the head.
+		if isBlank && lineStart > 0 {
+			return lf + 1
+		}
+	}
 }
 
-func newHTTPParser(isRequest bool, bidiID akinet.TCPBidiID, seq, ack reassembly.Sequence) *httpParser {
-	// Unfortunately, go's http request parser blocks. So we need to run it in a
-	// separate goroutine. This needs to be addressed as part of
-	// https://app.clubhouse.io/akita-software/story/600
-	resultChan := make(chan akinet.ParsedNetworkContent)
-	readClosed := make(chan error, 1)
-	r, w := io.Pipe()
-	go func() {
-		var req *http.Request
-		var resp *http.Response
-		var body []byte
-		var err error
-		br := bufio.NewReader(r)
-		if isRequest {
-			req, body, err = readSingleHTTPRequest(br)
-		} else {
-			resp, body, err = readSingleHTTPResponse(br)
+// Parses the head of the HTTP message with Go's net/http and figures out how
+// the body is framed.
+func (p *httpParser) parseHead(head memview.MemView) error {
+	br := bufio.NewReader(head.CreateReader())
+
+	var contentLength int64
+	var transferEncoding []string
+	var untilClose bool
+	if p.isRequest {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return err
 		}
+		p.req = req
+		contentLength = req.ContentLength
+		transferEncoding = req.TransferEncoding
+	} else {
+		resp, err := http.ReadResponse(br, nil)
 		if err != nil {
-			err = httpPipeReaderError{
-				err:         err,
-				unusedBytes: int64(br.Buffered()),
-			}
-			r.CloseWithError(err)
-			readClosed <- err
-			return
+			return err
 		}
+		p.resp = resp
+		contentLength = resp.ContentLength
+		transferEncoding = resp.TransferEncoding
+
+		// Without a Content-Length, the response body ends when the connection
+		// is closed. net/http has already accounted for statuses that can't have
+		// a body.
+		untilClose = contentLength < 0
+	}
 
-		// Close the reader to signal to the pipe writer that result is ready.
-		err = httpPipeReaderDone(br.Buffered())
-		r.CloseWithError(err)
-		readClosed <- err
-
-		var c akinet.ParsedNetworkContent
-		if isRequest {
-			// Because HTTP requires the request to finish before sending a response,
-			// TCP ack number on the first segment of the HTTP request is equal to the
-			// TCP seq number on the first segment of the corresponding HTTP response.
-			// Hence we use it to differntiate differnt pairs of HTTP request and
-			// response on the same TCP stream.
-			c = akinet.FromStdRequest(uuid.UUID(bidiID), int(ack), req, body)
-		} else {
-			// Because HTTP requires the request to finish before sending a response,
-			// TCP ack number on the first segment of the HTTP request is equal to the
-			// TCP seq number on the first segment of the corresponding HTTP response.
-			// Hence we use it to differntiate differnt pairs of HTTP request and
-			// response on the same TCP stream.
-			c = akinet.FromStdResponse(uuid.UUID(bidiID), int(seq), resp, body)
+	switch {
+	case len(transferEncoding) > 0 && transferEncoding[0] == "chunked":
+		p.state = readingChunkSize
+	case contentLength > 0:
+		p.remaining = contentLength
+		p.state = readingFixedLengthBody
+	case untilClose:
+		p.state = readingUntilClose
+	default:
+		p.state = done
+	}
+	return nil
+}
+
+// Reads a line starting at pos, and consumes it if it is complete. The line
+// is returned without the line terminator.
+func (p *httpParser) readLine() (line []byte, ok bool, err error) {
+	lf := p.allInput.Index(p.pos, []byte("\n"))
+	if lf < 0 {
+		if p.allInput.Len()-p.pos > maxChunkLineLength {
+			return nil, false, errors.New("header line too long")
 		}
-		resultChan <- c
-	}()
+		return nil, false, nil
+	}
 
-	return &httpParser{
-		w:             w,
-		resultChan:    resultChan,
-		readClosed:    readClosed,
-		isRequest:     isRequest,
-		maxHttpLength: MaximumHTTPLength,
+	line = []byte(p.allInput.SubView(p.pos, lf).String())
+	p.pos = lf + 1
+	return bytes.TrimSuffix(line, []byte("\r")), true, nil
+}
+
+// Adds a header field from the trailer section of a chunked body.
+func (p *httpParser) addTrailer(line []byte) error {
+	i := bytes.IndexByte(line, ':')
+	if i <= 0 {
+		return errors.Errorf("malformed trailer line %q", line)
 	}
+	if p.trailer == nil {
+		p.trailer = make(http.Header)
+	}
+	key := strings.TrimSpace(string(line[:i]))
+	p.trailer.Add(key, strings.TrimSpace(string(line[i+1:])))
+	return nil
 }
 
-// Reads a single HTTP request, only consuming the exact number of bytes that
-// form the request and its body, but there may be unused bytes left in the
-// bufio.Reader's buffer.
-func readSingleHTTPRequest(r *bufio.Reader) (*http.Request, []byte, error) {
-	req, err := http.ReadRequest(r)
-	if err != nil {
-		return nil, nil, err
+// Adds the next n bytes of input to the body. Once the body is longer than the
+// maximum body size, the rest of it is skipped, so that the parser stays in
+// step with the stream without holding on to the input.
+func (p *httpParser) consumeBody(n int64) {
+	if n <= 0 {
+		return
+	}
+
+	keep := n
+	if p.truncated {
+		keep = 0
+	} else if room := p.opts.MaxBodySize - int64(p.body.Len()); keep > room {
+		keep = room
+		p.truncated = true
+		if p.opts.OversizedBodyBehavior == DropOversizedBody {
+			keep = 0
+			p.body.Reset()
+		}
 	}
 
-	if req.Body == nil {
-		return req, nil, nil
+	if keep > 0 {
+		data := p.allInput.SubView(p.pos, p.pos+keep)
+		io.Copy(&p.body, data.CreateReader())
 	}
+	p.pos += n
+	p.remaining -= n
 
-	// Read the body to move the reader's position to the end of the body.
-	var body bytes.Buffer
-	_, bodyErr := io.Copy(&body, req.Body)
-	req.Body.Close()
-	return req, body.Bytes(), bodyErr
+	if p.truncated {
+		// Drop the input that has been consumed.
+		p.allInput = p.allInput.SubView(p.pos, p.allInput.Len())
+		p.pos = 0
+	}
 }
 
-// Reads a single HTTP response, only consuming the exact number of bytes that
-// form the responseand its body, but there may be unused bytes left in the
-// bufio.Reader's buffer.
-func readSingleHTTPResponse(r *bufio.Reader) (*http.Response, []byte, error) {
-	resp, err := http.ReadResponse(r, nil)
-	if err != nil {
-		return nil, nil, err
+// Handles the end of the input before the message is complete. Responses are
+// emitted with whatever body has been read so far, but incomplete requests are
+// an error, matching the behaviour of Go's net/http.
+func (p *httpParser) endOfInput() error {
+	switch p.state {
+	case readingHead:
+		return io.ErrUnexpectedEOF
+	case readingUntilClose:
+		p.state = done
+		return nil
 	}
 
-	if resp.Body == nil {
-		return resp, nil, nil
+	if p.isRequest {
+		return io.ErrUnexpectedEOF
 	}
+	p.state = done
+	return nil
+}
 
-	// Read the body to move the reader's position to the end of the body.
-	var body bytes.Buffer
-	_, bodyErr := io.Copy(&body, resp.Body)
-	resp.Body.Close()
+func (p *httpParser) result() akinet.ParsedNetworkContent {
+	var body []byte
+	if p.body.Len() > 0 {
+		body = p.body.Bytes()
+	}
 
-	if errors.Is(bodyErr, io.ErrUnexpectedEOF) {
-		// Let the next level try to handle a body that was truncated.
-		bodyErr = nil
+	if p.isRequest {
+		// Because HTTP requires the request to finish before sending a response,
+		// TCP ack number on the first segment of the HTTP request is equal to the
+		// TCP seq number on the first segment of the corresponding HTTP response.
+		// Hence we use it to differntiate differnt pairs of HTTP request and
+		// response on the same TCP stream.
+		r := akinet.FromStdRequest(uuid.UUID(p.bidiID), int(p.ack), p.req, body)
+		r.Trailer = p.trailer
+		r.Truncated = p.truncated
+		DecodeRequestBody(&r, p.opts.MaxDecodedBodySize)
+		return r
 	}
 
-	return resp, body.Bytes(), bodyErr
+	// Because HTTP requires the request to finish before sending a response,
+	// TCP ack number on the first segment of the HTTP request is equal to the
+	// TCP seq number on the first segment of the corresponding HTTP response.
+	// Hence we use it to differntiate differnt pairs of HTTP request and
+	// response on the same TCP stream.
+	r := akinet.FromStdResponse(uuid.UUID(p.bidiID), int(p.seq), p.resp, body)
+	r.Trailer = p.trailer
+	r.Truncated = p.truncated
+	DecodeResponseBody(&r, p.opts.MaxDecodedBodySize)
+	return r
 }
 
-// Indicates the pipe reader has successfully completed parsing. The integer
-// specifies the number of bytes read from the pipe writer but were unused.
-type httpPipeReaderDone int64
-
-func (httpPipeReaderDone) Error() string {
-	return "HTTP pipe reader success"
+func newHTTPParser(isRequest bool, bidiID akinet.TCPBidiID, seq, ack reassembly.Sequence, opts ParserOptions) *httpParser {
+	opts = opts.WithDefaults()
+	return &httpParser{
+		isRequest: isRequest,
+		bidiID:    bidiID,
+		seq:       seq,
+		ack:       ack,
+		input:     akinet.NewRetainedInput(opts.MaxHeaderSize + opts.MaxBodySize),
+		opts:      opts,
+	}
 }
 
-type httpPipeReaderError struct {
-	err         error // the actual err
-	unusedBytes int64 // number of bytes read from the pipe writer but were unused
-}
+// Parses the size from a chunk size line, ignoring any chunk extensions.
+func parseChunkSize(line []byte) (int64, error) {
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	s := strings.TrimSpace(string(line))
+	if s == "" {
+		return 0, errors.New("empty chunk size")
+	}
 
-func (e httpPipeReaderError) Error() string {
-	return e.err.Error()
+	size, err := strconv.ParseInt(s, 16, 64)
+	if err != nil || size < 0 {
+		return 0, errors.Errorf("invalid chunk size %q", s)
+	}
+	return size, nil
 }
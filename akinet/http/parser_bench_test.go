@@ -0,0 +1,97 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+type benchmarkParser interface {
+	Parse(input memview.MemView, isEnd bool) (akinet.ParsedNetworkContent, memview.MemView, error)
+}
+
+type benchmarkCase struct {
+	name      string
+	isRequest bool
+	input     string
+}
+
+func benchmarkCases() []benchmarkCase {
+	body := randomString(16 * 1024)
+	return []benchmarkCase{
+		{
+			name:      "small request",
+			isRequest: true,
+			input:     "GET /foo HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\n\r\n",
+		},
+		{
+			name:      "content-length response",
+			isRequest: false,
+			input:     fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(body), body),
+		},
+		{
+			name:      "chunked response",
+			isRequest: false,
+			input: strings.Join([]string{
+				"HTTP/1.1 200 OK\r\n",
+				"Transfer-Encoding: chunked\r\n",
+				"\r\n",
+				chunkedBody.String(),
+			}, ""),
+		},
+	}
+}
+
+// Feeds the input to the parser in packet-sized segments.
+func runBenchmark(b *testing.B, newParser func(isRequest bool) benchmarkParser, c benchmarkCase) {
+	const segmentSize = 1460
+
+	segments := []memview.MemView{}
+	for i := 0; i < len(c.input); i += segmentSize {
+		end := i + segmentSize
+		if end > len(c.input) {
+			end = len(c.input)
+		}
+		segments = append(segments, memview.New([]byte(c.input[i:end])))
+	}
+
+	b.SetBytes(int64(len(c.input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p := newParser(c.isRequest)
+		for j, s := range segments {
+			pnc, _, err := p.Parse(s, j == len(segments)-1)
+			if err != nil {
+				b.Fatalf("[%s] unexpected error: %v", c.name, err)
+			}
+			if pnc != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkHTTPParser(b *testing.B) {
+	for _, c := range benchmarkCases() {
+		b.Run(c.name, func(b *testing.B) {
+			runBenchmark(b, func(isRequest bool) benchmarkParser {
+				return newHTTPParser(isRequest, testBidiID, 522, 1203, DefaultParserOptions())
+			}, c)
+		})
+	}
+}
+
+func BenchmarkLegacyHTTPParser(b *testing.B) {
+	for _, c := range benchmarkCases() {
+		b.Run(c.name, func(b *testing.B) {
+			runBenchmark(b, func(isRequest bool) benchmarkParser {
+				return newLegacyHTTPParser(isRequest, testBidiID, 522, 1203)
+			}, c)
+		})
+	}
+}
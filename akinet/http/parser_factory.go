@@ -8,21 +8,52 @@ import (
 	"github.com/akitasoftware/akita-libs/memview"
 )
 
-func NewHTTPRequestParserFactory() akinet.TCPParserFactory {
-	return httpRequestParserFactory{}
+// Returns a factory for HTTP/1.x request parsers configured with the given
+// options.
+func NewHTTPRequestParserFactory(opts ParserOptions) akinet.TCPParserFactory {
+	opts = opts.WithDefaults()
+
+	methods := append(append([]string{}, supportedHTTPMethods...), opts.ExtraMethods...)
+	minMethodLength, maxMethodLength := len(methods[0]), len(methods[0])
+	for _, m := range methods {
+		if len(m) < minMethodLength {
+			minMethodLength = len(m)
+		}
+		if len(m) > maxMethodLength {
+			maxMethodLength = len(m)
+		}
+	}
+
+	return httpRequestParserFactory{
+		opts:            opts,
+		methods:         methods,
+		minMethodLength: int64(minMethodLength),
+		maxMethodLength: int64(maxMethodLength),
+	}
 }
 
-func NewHTTPResponseParserFactory() akinet.TCPParserFactory {
-	return httpResponseParserFactory{}
+// Returns a factory for HTTP/1.x response parsers configured with the given
+// options.
+func NewHTTPResponseParserFactory(opts ParserOptions) akinet.TCPParserFactory {
+	return httpResponseParserFactory{
+		opts: opts.WithDefaults(),
+	}
 }
 
-type httpRequestParserFactory struct{}
+type httpRequestParserFactory struct {
+	opts ParserOptions
+
+	// The HTTP methods to accept, and the lengths of the shortest and longest of
+	// them.
+	methods                          []string
+	minMethodLength, maxMethodLength int64
+}
 
 func (httpRequestParserFactory) Name() string {
 	return "HTTP/1.x Request Parser Factory"
 }
 
-func (httpRequestParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+func (factory httpRequestParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
 	defer func() {
 		if decision == akinet.NeedMoreData && isEnd {
 			decision = akinet.Reject
@@ -30,13 +61,13 @@ func (httpRequestParserFactory) Accepts(input memview.MemView, isEnd bool) (deci
 		}
 	}()
 
-	if input.Len() < minSupportedHTTPMethodLength {
+	if input.Len() < factory.minMethodLength {
 		return akinet.NeedMoreData, 0
 	}
 
-	for _, m := range supportedHTTPMethods {
+	for _, m := range factory.methods {
 		if start := input.Index(0, []byte(m)); start >= 0 {
-			d := hasValidHTTPRequestLine(input.SubView(start+int64(len(m)), input.Len()))
+			d := hasValidHTTPRequestLine(input.SubView(start+int64(len(m)), input.Len()), factory.opts.MaxURILength)
 			switch d {
 			case akinet.Accept:
 				return akinet.Accept, start
@@ -48,17 +79,19 @@ func (httpRequestParserFactory) Accepts(input memview.MemView, isEnd bool) (deci
 	// Handle the case where the suffix of input is a prefix of the method in a
 	// HTTP request line (e.g.  input=`<garbage>GE` where the next input is
 	// `T / HTTP/1.1`.
-	if input.Len() < maxSupportedHTTPMethodLength {
+	if input.Len() < factory.maxMethodLength {
 		return akinet.NeedMoreData, 0
 	}
 	return akinet.Reject, input.Len()
 }
 
-func (httpRequestParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
-	return newHTTPParser(true, id, seq, ack)
+func (factory httpRequestParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newHTTPParser(true, id, seq, ack, factory.opts)
 }
 
-type httpResponseParserFactory struct{}
+type httpResponseParserFactory struct {
+	opts ParserOptions
+}
 
 func (httpResponseParserFactory) Name() string {
 	return "HTTP/1.x Response Parser Factory"
@@ -89,13 +122,13 @@ func (httpResponseParserFactory) Accepts(input memview.MemView, isEnd bool) (dec
 	return akinet.Reject, input.Len()
 }
 
-func (httpResponseParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
-	return newHTTPParser(false, id, seq, ack)
+func (factory httpResponseParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newHTTPParser(false, id, seq, ack, factory.opts)
 }
 
 // Checks whether there is a valid HTTP request line as defiend in RFC 2616
 // Section 5. The input should start right after the HTTP method.
-func hasValidHTTPRequestLine(input memview.MemView) akinet.AcceptDecision {
+func hasValidHTTPRequestLine(input memview.MemView, maxURILength int64) akinet.AcceptDecision {
 	if input.Len() == 0 {
 		return akinet.NeedMoreData
 	}
@@ -109,8 +142,8 @@ func hasValidHTTPRequestLine(input memview.MemView) akinet.AcceptDecision {
 	nextSP := input.Index(1, []byte(" "))
 	if nextSP < 0 {
 		// Could be dealing with a very long request URI.
-		if input.Len()-1 > maxHTTPRequestURILength {
-			glog.Warning("rejecting potential HTTP request with request URI longer than ", maxHTTPRequestURILength)
+		if input.Len()-1 > maxURILength {
+			glog.Warning("rejecting potential HTTP request with request URI longer than ", maxURILength)
 			return akinet.Reject
 		}
 		return akinet.NeedMoreData
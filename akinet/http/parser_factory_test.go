@@ -17,14 +17,15 @@ type acceptTestCase struct {
 	verbatimInput    []memview.MemView
 	expectedDecision akinet.AcceptDecision
 	expectedDF       int64 // expected discard front
+	opts             ParserOptions
 }
 
 func runAcceptTest(isRequest bool, c acceptTestCase) error {
 	var fact akinet.TCPParserFactory
 	if isRequest {
-		fact = NewHTTPRequestParserFactory()
+		fact = NewHTTPRequestParserFactory(c.opts)
 	} else {
-		fact = NewHTTPResponseParserFactory()
+		fact = NewHTTPResponseParserFactory(c.opts)
 	}
 
 	var segments <-chan []memview.MemView
@@ -81,6 +82,12 @@ func TestHTTPRequestParserFactoryAccepts(t *testing.T) {
 			expectedDecision: akinet.Reject,
 			expectedDF:       16,
 		},
+		{
+			name:             "extra HTTP method",
+			input:            "PROPFIND / HTTP/1.1\r\n",
+			expectedDecision: akinet.Accept,
+			opts:             ParserOptions{ExtraMethods: []string{"PROPFIND"}},
+		},
 		{
 			name:             "non-supported HTTP version",
 			input:            "GET / HTTP/0.3\r\n",
@@ -96,7 +103,7 @@ func TestHTTPRequestParserFactoryAccepts(t *testing.T) {
 		{
 			name: "accept long request-URI within limit",
 			verbatimInput: []memview.MemView{
-				memview.New([]byte("GET /" + randomString(maxHTTPRequestURILength-100))),
+				memview.New([]byte("GET /" + randomString(int(DefaultMaxURILength)-100))),
 				memview.New([]byte(randomString(50))),
 				memview.New([]byte(randomString(49) + " HTTP/1.1\r\n")),
 			},
@@ -106,11 +113,18 @@ func TestHTTPRequestParserFactoryAccepts(t *testing.T) {
 		{
 			name: "reject long request-URI beyond limit",
 			verbatimInput: []memview.MemView{
-				memview.New([]byte("GET /" + randomString(maxHTTPRequestURILength-100))),
+				memview.New([]byte("GET /" + randomString(int(DefaultMaxURILength)-100))),
 				memview.New([]byte(randomString(500))), // should reach a reject here
 			},
 			expectedDecision: akinet.Reject,
-			expectedDF:       int64(len("GET /") + maxHTTPRequestURILength - 100 + 500),
+			expectedDF:       int64(len("GET /")) + DefaultMaxURILength - 100 + 500,
+		},
+		{
+			name:             "reject request-URI beyond configured limit",
+			input:            "GET /" + randomString(20),
+			expectedDecision: akinet.Reject,
+			expectedDF:       25,
+			opts:             ParserOptions{MaxURILength: 10},
 		},
 		{
 			name:             "reject stray bytes at end of request line",
@@ -84,7 +84,7 @@ func runParseTestCase(isRequest bool, c parseTestCase) error {
 	var unused memview.MemView
 	var err error
 	for inputs := range segments {
-		p := newHTTPParser(isRequest, testBidiID, 522, 1203)
+		p := newHTTPParser(isRequest, testBidiID, 522, 1203, DefaultParserOptions())
 		for i, input := range inputs {
 			pnc, unused, err = p.Parse(input, i == len(inputs)-1)
 			if err != nil {
@@ -391,23 +391,47 @@ func TestHTTPResponseParser(t *testing.T) {
 			},
 		},
 		{
-			name: "content-encoding unchanged",
+			name: "content-encoding decoded",
 			input: strings.Join([]string{
 				"HTTP/1.1 200 OK\r\n",
 				"Content-Encoding: deflate\r\n",
 				"\r\n",
 				string(deflatedBody.Bytes()),
 			}, ""),
+			expected: akinet.HTTPResponse{
+				StreamID:         uuid.UUID(testBidiID),
+				Seq:              522,
+				ProtoMajor:       1,
+				ProtoMinor:       1,
+				StatusCode:       200,
+				Header:           map[string][]string{"Content-Encoding": {"deflate"}},
+				Body:             []byte("hello this is deflated body"),
+				BodyDecompressed: true,
+				RawBodyLength:    deflatedBody.Len(),
+			},
+		},
+		{
+			name: "chunked body with trailers",
+			input: strings.Join([]string{
+				"HTTP/1.1 200 OK\r\n",
+				"Transfer-Encoding: chunked\r\n",
+				"Trailer: Expires\r\n",
+				"\r\n",
+				"5\r\nhello\r\n",
+				"0\r\n",
+				"Expires: Wed, 21 Oct 2015 07:28:00 GMT\r\n",
+				"\r\n",
+			}, ""),
 			expected: akinet.HTTPResponse{
 				StreamID:   uuid.UUID(testBidiID),
 				Seq:        522,
 				ProtoMajor: 1,
 				ProtoMinor: 1,
 				StatusCode: 200,
-				// We expect the body to not get inflated because this library does not
-				// handle content-encoding.
-				Header: map[string][]string{"Content-Encoding": {"deflate"}},
-				Body:   deflatedBody.Bytes(),
+				// Go's net/http removes the Trailer header.
+				Header:  map[string][]string{},
+				Body:    []byte("hello"),
+				Trailer: map[string][]string{"Expires": {"Wed, 21 Oct 2015 07:28:00 GMT"}},
 			},
 		},
 		{
@@ -473,7 +497,7 @@ func TestOversizedResponse(t *testing.T) {
 		memview.New(bigPayload[1800000:2000000]),
 	}
 
-	p := newHTTPParser(false, testBidiID, 522, 1203)
+	p := newHTTPParser(false, testBidiID, 522, 1203, DefaultParserOptions())
 	var pnc akinet.ParsedNetworkContent
 	var unused memview.MemView
 	var err error
@@ -495,8 +519,96 @@ func TestOversizedResponse(t *testing.T) {
 	}
 
 	response := pnc.(akinet.HTTPResponse)
-	// First payload larger than http limit
-	if len(response.Body) > 1200000 || len(response.Body) < 1000000 {
+	if !response.Truncated {
+		t.Errorf("expected response to be marked as truncated")
+	}
+	if int64(len(response.Body)) != DefaultMaxBodySize {
 		t.Errorf("got packet with body length %v", len(response.Body))
 	}
 }
+
+func TestOversizedBodyDoesNotWreckStream(t *testing.T) {
+	opts := ParserOptions{
+		MaxBodySize:           10,
+		OversizedBodyBehavior: DropOversizedBody,
+	}
+	input := strings.Join([]string{
+		"POST / HTTP/1.1\r\n",
+		"Host: example.com\r\n",
+		"Content-Length: 26\r\n",
+		"\r\n",
+		"abcdefghijklmnopqrstuvwxyz",
+		"GET / HTTP/1.1\r\n",
+		"Host: example.com\r\n",
+		"\r\n",
+	}, "")
+
+	p := newHTTPParser(true, testBidiID, 522, 1203, opts)
+	pnc, unused, err := p.Parse(memview.New([]byte(input[:40])), false)
+	if err != nil || pnc != nil {
+		t.Fatalf("expected parser to need more data, got %v, %v", pnc, err)
+	}
+	pnc, unused, err = p.Parse(memview.New([]byte(input[40:])), false)
+	if err != nil {
+		t.Fatalf("Got error: %v", err)
+	}
+
+	request := pnc.(akinet.HTTPRequest)
+	if !request.Truncated || request.Body != nil {
+		t.Errorf("expected body to be dropped, got truncated=%v body=%q", request.Truncated, request.Body)
+	}
+	if unused.String() != input[len(input)-37:] {
+		t.Errorf("expected next request to be unused, got %q", unused.String())
+	}
+}
+
+func TestErrorAfterTruncatedBodyReturnsAllInput(t *testing.T) {
+	opts := ParserOptions{MaxBodySize: 10}
+	input := strings.Join([]string{
+		"POST / HTTP/1.1\r\n",
+		"Host: example.com\r\n",
+		"Transfer-Encoding: chunked\r\n",
+		"\r\n",
+		"1a\r\n",
+		"abcdefghijklmnopqrstuvwxyz\r\n",
+		"not a chunk size\r\n",
+	}, "")
+
+	p := newHTTPParser(true, testBidiID, 522, 1203, opts)
+	pnc, _, err := p.Parse(memview.New([]byte(input[:80])), false)
+	if err != nil || pnc != nil {
+		t.Fatalf("expected parser to need more data, got %v, %v", pnc, err)
+	}
+	_, unused, err := p.Parse(memview.New([]byte(input[80:])), false)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if unused.String() != input {
+		t.Errorf("expected all input to be unused, got %q", unused.String())
+	}
+}
+
+func TestErrorAfterSkippedBodyReturnsUnconsumedInput(t *testing.T) {
+	opts := ParserOptions{MaxHeaderSize: 100, MaxBodySize: 10}
+	input := strings.Join([]string{
+		"POST / HTTP/1.1\r\n",
+		"Host: example.com\r\n",
+		"Transfer-Encoding: chunked\r\n",
+		"\r\n",
+		"40\r\n",
+		strings.Repeat("a", 0x40) + "\r\n",
+		"not a chunk size\r\n",
+	}, "")
+
+	// Once more than the maximum head and body sizes have been supplied, the
+	// skipped part of the body isn't held on to, and only the input from the
+	// end of the skipped chunk is returned.
+	p := newHTTPParser(true, testBidiID, 522, 1203, opts)
+	_, unused, err := p.Parse(memview.New([]byte(input)), false)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if unused.String() != "\r\nnot a chunk size\r\n" {
+		t.Errorf("expected only the unconsumed input to be unused, got %q", unused.String())
+	}
+}
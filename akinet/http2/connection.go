@@ -0,0 +1,315 @@
+package http2
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	akihttp "github.com/akitasoftware/akita-libs/akinet/http"
+)
+
+// Tracks the state of an HTTP/2 connection across the TCPParsers created for
+// its two flows. Because TCPParsers are single use, and HPACK compression and
+// stream multiplexing span the whole connection, this state outlives any
+// single parser.
+type connection struct {
+	bidiID akinet.TCPBidiID
+
+	mu sync.Mutex
+
+	// Each is nil until the corresponding connection preface has been seen.
+	client *halfConnection
+	server *halfConnection
+}
+
+// The state for one direction of an HTTP/2 connection.
+type halfConnection struct {
+	isClient bool
+
+	opts akihttp.ParserOptions
+
+	// Decodes header blocks sent in this direction.
+	decoder *hpack.Decoder
+
+	// Partially received messages, keyed by HTTP/2 stream identifier.
+	streams map[uint32]*stream
+
+	// A header block that is being continued with CONTINUATION frames.
+	pendingHeaderBlock *headerBlock
+
+	// The highest stream identifier opened in this direction, either by a
+	// header block or by a PUSH_PROMISE.
+	lastStreamID uint32
+
+	// The TCP sequence number most recently given to a parser for this
+	// direction. Used to tell which direction a new parser is for.
+	lastSeq reassembly.Sequence
+
+	// Set when the flow has ended or could not be parsed. Nothing more will be
+	// parsed in this direction.
+	done bool
+}
+
+type headerBlock struct {
+	streamID uint32
+
+	// Whether the header block is from a PUSH_PROMISE frame.
+	isPushPromise bool
+
+	// Whether END_STREAM was set on the HEADERS frame that started this block.
+	endStream bool
+
+	fragments []byte
+}
+
+// A request or response being received on an HTTP/2 stream.
+type stream struct {
+	pseudoHeaders map[string]string
+	header        http.Header
+	trailer       http.Header
+	headersSeen   bool
+
+	body []byte
+
+	// Whether the body was longer than the maximum body size.
+	truncated bool
+}
+
+func newHalfConnection(isClient bool, seq reassembly.Sequence, opts akihttp.ParserOptions) *halfConnection {
+	decoder := hpack.NewDecoder(initialHeaderTableSize_bytes, nil)
+	decoder.SetAllowedMaxDynamicTableSize(maxHeaderTableSize_bytes)
+	return &halfConnection{
+		isClient: isClient,
+		opts:     opts,
+		decoder:  decoder,
+		streams:  map[uint32]*stream{},
+		lastSeq:  seq,
+	}
+}
+
+// Processes a single frame, whose header and payload are given. Returns a
+// non-nil result if the frame completed a request or response.
+func (hc *halfConnection) processFrame(bidiID akinet.TCPBidiID, h frameHeader, payload []byte) (akinet.ParsedNetworkContent, error) {
+	if hc.pendingHeaderBlock != nil {
+		// RFC 7540 Section 6.2: a header block must be followed by CONTINUATION
+		// frames for the same stream, without anything interleaved.
+		if h.typ != continuationFrameType || h.streamID != hc.pendingHeaderBlock.streamID {
+			return nil, errors.Errorf("expected CONTINUATION frame for stream %d, got frame type %d on stream %d", hc.pendingHeaderBlock.streamID, h.typ, h.streamID)
+		}
+		hc.pendingHeaderBlock.fragments = append(hc.pendingHeaderBlock.fragments, payload...)
+		if h.flags.has(endHeadersFlag) {
+			block := hc.pendingHeaderBlock
+			hc.pendingHeaderBlock = nil
+			return hc.processHeaderBlock(bidiID, block)
+		}
+		return nil, nil
+	}
+
+	switch h.typ {
+	case dataFrameType:
+		data, err := unpadPayload(h, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		s, ok := hc.streams[h.streamID]
+		if !ok {
+			// We missed the start of the stream, or it has been reset.
+			return nil, nil
+		}
+		if s.truncated {
+			// The rest of the body is skipped.
+		} else if remaining := hc.opts.MaxBodySize - int64(len(s.body)); int64(len(data)) > remaining {
+			s.truncated = true
+			if hc.opts.OversizedBodyBehavior == akihttp.DropOversizedBody {
+				s.body = nil
+			} else {
+				s.body = append(s.body, data[:remaining]...)
+			}
+		} else {
+			s.body = append(s.body, data...)
+		}
+
+		if h.flags.has(endStreamFlag) {
+			return hc.completeStream(bidiID, h.streamID)
+		}
+		return nil, nil
+
+	case headersFrameType, pushPromiseFrameType:
+		fragment, err := unpadPayload(h, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		block := &headerBlock{
+			streamID:  h.streamID,
+			endStream: h.flags.has(endStreamFlag),
+		}
+		hc.sawStream(h.streamID)
+		if h.typ == pushPromiseFrameType {
+			if len(fragment) < 4 {
+				return nil, errors.Errorf("PUSH_PROMISE frame on stream %d is too short", h.streamID)
+			}
+			hc.sawStream(binary.BigEndian.Uint32(fragment) &^ (1 << 31))
+			fragment = fragment[4:]
+			block.isPushPromise = true
+		}
+		block.fragments = append(block.fragments, fragment...)
+
+		if h.flags.has(endHeadersFlag) {
+			return hc.processHeaderBlock(bidiID, block)
+		}
+		hc.pendingHeaderBlock = block
+		return nil, nil
+
+	case continuationFrameType:
+		return nil, errors.Errorf("unexpected CONTINUATION frame on stream %d", h.streamID)
+
+	case rstStreamFrameType:
+		delete(hc.streams, h.streamID)
+		return nil, nil
+	}
+
+	// Other frame types only carry connection management information that we
+	// don't need.
+	return nil, nil
+}
+
+// Records that the stream with the given identifier has been opened.
+func (hc *halfConnection) sawStream(streamID uint32) {
+	if streamID > hc.lastStreamID {
+		hc.lastStreamID = streamID
+	}
+}
+
+func (hc *halfConnection) processHeaderBlock(bidiID akinet.TCPBidiID, block *headerBlock) (akinet.ParsedNetworkContent, error) {
+	// Header blocks must always be decoded, even if we end up ignoring them, to
+	// keep the HPACK decoder state in sync with the encoder.
+	fields, err := hc.decoder.DecodeFull(block.fragments)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode header block on stream %d", block.streamID)
+	}
+
+	if block.isPushPromise {
+		// The promised request was synthesized by the server. Ignore it.
+		return nil, nil
+	}
+
+	s, ok := hc.streams[block.streamID]
+	if !ok {
+		s = &stream{
+			pseudoHeaders: map[string]string{},
+		}
+		hc.streams[block.streamID] = s
+	}
+
+	if !s.headersSeen {
+		header := make(http.Header, len(fields))
+		for _, f := range fields {
+			if f.IsPseudo() {
+				s.pseudoHeaders[f.Name] = f.Value
+			} else {
+				header.Add(f.Name, f.Value)
+			}
+		}
+
+		// Informational (1xx) responses are followed by another header block with
+		// the final response.
+		if status := s.pseudoHeaders[":status"]; !hc.isClient && strings.HasPrefix(status, "1") && !block.endStream {
+			delete(hc.streams, block.streamID)
+			return nil, nil
+		}
+
+		s.header = header
+		s.headersSeen = true
+	} else {
+		// A second header block carries trailers.
+		s.trailer = make(http.Header, len(fields))
+		for _, f := range fields {
+			if !f.IsPseudo() {
+				s.trailer.Add(f.Name, f.Value)
+			}
+		}
+	}
+
+	if block.endStream {
+		return hc.completeStream(bidiID, block.streamID)
+	}
+	return nil, nil
+}
+
+// Converts the stream with the given ID into an HTTPRequest or HTTPResponse
+// and forgets about it.
+func (hc *halfConnection) completeStream(bidiID akinet.TCPBidiID, streamID uint32) (akinet.ParsedNetworkContent, error) {
+	s := hc.streams[streamID]
+	delete(hc.streams, streamID)
+	if !s.headersSeen {
+		return nil, errors.Errorf("stream %d ended without headers", streamID)
+	}
+
+	var body []byte
+	if len(s.body) > 0 {
+		body = s.body
+	}
+
+	// HTTP/2 stream identifiers are never reused within a connection and the
+	// request and response share the same identifier, so they are used to pair
+	// requests with responses.
+	if hc.isClient {
+		u, err := url.ParseRequestURI(s.pseudoHeaders[":path"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse :path on stream %d", streamID)
+		}
+
+		host := s.pseudoHeaders[":authority"]
+		if host == "" {
+			// RFC 7540 Section 8.1.2.3 allows a Host header in place of :authority.
+			host = s.header.Get("Host")
+		}
+		s.header.Del("Host")
+
+		r := akinet.HTTPRequest{
+			StreamID:   uuid.UUID(bidiID),
+			Seq:        int(streamID),
+			Method:     s.pseudoHeaders[":method"],
+			ProtoMajor: 2,
+			ProtoMinor: 0,
+			URL:        u,
+			Host:       host,
+			Header:     s.header,
+			Body:       body,
+			Trailer:    s.trailer,
+			Truncated:  s.truncated,
+		}
+		akihttp.DecodeRequestBody(&r, hc.opts.MaxDecodedBodySize)
+		return r, nil
+	}
+
+	status, err := strconv.Atoi(s.pseudoHeaders[":status"])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid :status on stream %d", streamID)
+	}
+
+	r := akinet.HTTPResponse{
+		StreamID:   uuid.UUID(bidiID),
+		Seq:        int(streamID),
+		StatusCode: status,
+		ProtoMajor: 2,
+		ProtoMinor: 0,
+		Header:     s.header,
+		Body:       body,
+		Trailer:    s.trailer,
+		Truncated:  s.truncated,
+	}
+	akihttp.DecodeResponseBody(&r, hc.opts.MaxDecodedBodySize)
+	return r, nil
+}
@@ -0,0 +1,66 @@
+package http2
+
+import "time"
+
+const (
+	// Length of an HTTP/2 frame header (RFC 7540 Section 4.1).
+	//
+	//   Length (3 bytes)
+	//   Type (1 byte)
+	//   Flags (1 byte)
+	//   R (1 bit) + Stream Identifier (31 bits)
+	frameHeaderLength_bytes = 9
+
+	// Length of the payload of an HTTP/2 SETTINGS parameter.
+	settingLength_bytes = 6
+
+	// Largest frame payload that a peer may advertise through
+	// SETTINGS_MAX_FRAME_SIZE (RFC 7540 Section 6.5.2).
+	maxFrameLength_bytes = 1<<24 - 1
+
+	// Size of the HPACK dynamic table at the start of a connection (RFC 7540
+	// Section 6.5.2).
+	initialHeaderTableSize_bytes = 4096
+
+	// Upper bound on the HPACK dynamic table size that we allow an encoder to
+	// select. We don't track SETTINGS_HEADER_TABLE_SIZE across flows, so this is
+	// deliberately generous.
+	maxHeaderTableSize_bytes = 1 << 20
+
+	// Connections that have not seen any traffic for this long are forgotten.
+	connectionIdleTimeout = 10 * time.Minute
+)
+
+// The client connection preface (RFC 7540 Section 3.5). Clients using prior
+// knowledge, and clients that have upgraded from HTTP/1.1 using h2c, start
+// their side of the connection with this.
+var clientPreface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+type frameType byte
+
+const (
+	dataFrameType         frameType = 0x0
+	headersFrameType      frameType = 0x1
+	priorityFrameType     frameType = 0x2
+	rstStreamFrameType    frameType = 0x3
+	settingsFrameType     frameType = 0x4
+	pushPromiseFrameType  frameType = 0x5
+	pingFrameType         frameType = 0x6
+	goAwayFrameType       frameType = 0x7
+	windowUpdateFrameType frameType = 0x8
+	continuationFrameType frameType = 0x9
+)
+
+type frameFlags byte
+
+const (
+	endStreamFlag  frameFlags = 0x1
+	ackFlag        frameFlags = 0x1
+	endHeadersFlag frameFlags = 0x4
+	paddedFlag     frameFlags = 0x8
+	priorityFlag   frameFlags = 0x20
+)
+
+func (f frameFlags) has(flag frameFlags) bool {
+	return f&flag != 0
+}
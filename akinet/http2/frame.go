@@ -0,0 +1,89 @@
+package http2
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+type frameHeader struct {
+	length   uint32
+	typ      frameType
+	flags    frameFlags
+	streamID uint32
+}
+
+// Reads the frame header at the start of input. The caller must ensure that
+// input has at least frameHeaderLength_bytes.
+func readFrameHeader(input memview.MemView) frameHeader {
+	return frameHeader{
+		length:   input.GetUint24(0),
+		typ:      frameType(input.GetByte(3)),
+		flags:    frameFlags(input.GetByte(4)),
+		streamID: input.GetUint32(5),
+	}
+}
+
+// Determines whether the frame header is plausible for a frame sent on an
+// HTTP/2 connection. This is used to decide whether to accept bytes in the
+// middle of a flow, so it is stricter than RFC 7540 requires: frame types
+// that we don't know about are rejected rather than ignored.
+func (h frameHeader) plausible() bool {
+	// The reserved bit must be unset.
+	if h.streamID&(1<<31) != 0 {
+		return false
+	}
+
+	switch h.typ {
+	case dataFrameType, headersFrameType, continuationFrameType:
+		return h.streamID != 0
+	case priorityFrameType:
+		return h.streamID != 0 && h.length == 5
+	case rstStreamFrameType:
+		return h.streamID != 0 && h.length == 4
+	case pushPromiseFrameType:
+		return h.streamID != 0 && h.length >= 4
+	case settingsFrameType:
+		return h.streamID == 0 && h.length%settingLength_bytes == 0
+	case pingFrameType:
+		return h.streamID == 0 && h.length == 8
+	case goAwayFrameType:
+		return h.streamID == 0 && h.length >= 8
+	case windowUpdateFrameType:
+		return h.length == 4
+	}
+	return false
+}
+
+// Determines whether the frame header is for the SETTINGS frame that a server
+// sends as its connection preface (RFC 7540 Section 3.5).
+func (h frameHeader) isServerPreface() bool {
+	return h.typ == settingsFrameType && h.flags == 0 && h.streamID == 0 && h.length%settingLength_bytes == 0
+}
+
+// Strips padding and priority information from the payload of a DATA,
+// HEADERS, or PUSH_PROMISE frame, returning the part of the payload that
+// carries data or a header block fragment.
+func unpadPayload(h frameHeader, payload []byte) ([]byte, error) {
+	if h.flags.has(paddedFlag) {
+		if len(payload) < 1 {
+			return nil, errors.Errorf("padded frame on stream %d is too short", h.streamID)
+		}
+		padLen := int(payload[0])
+		payload = payload[1:]
+		if padLen > len(payload) {
+			return nil, errors.Errorf("padding on stream %d exceeds frame payload", h.streamID)
+		}
+		payload = payload[:len(payload)-padLen]
+	}
+
+	if h.typ == headersFrameType && h.flags.has(priorityFlag) {
+		// Exclusive bit, stream dependency (4 bytes) and weight (1 byte).
+		if len(payload) < 5 {
+			return nil, errors.Errorf("HEADERS frame on stream %d is too short for priority", h.streamID)
+		}
+		payload = payload[5:]
+	}
+
+	return payload, nil
+}
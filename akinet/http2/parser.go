@@ -0,0 +1,210 @@
+package http2
+
+import (
+	"github.com/google/gopacket/reassembly"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+func newHTTP2Parser(factory *http2ParserFactory, bidiID akinet.TCPBidiID, seq reassembly.Sequence) *http2Parser {
+	return &http2Parser{
+		factory: factory,
+		bidiID:  bidiID,
+		seq:     seq,
+	}
+}
+
+// Parses frames from one flow of an HTTP/2 connection until a request or
+// response is complete.
+type http2Parser struct {
+	factory *http2ParserFactory
+	bidiID  akinet.TCPBidiID
+	seq     reassembly.Sequence
+
+	allInput memview.MemView
+
+	// Offset into allInput of the next frame to process.
+	pos int64
+
+	conn *connection
+	half *halfConnection
+}
+
+var _ akinet.TCPParser = (*http2Parser)(nil)
+
+func (p *http2Parser) Name() string {
+	if p.half != nil && !p.half.isClient {
+		return "HTTP/2 Response Parser"
+	}
+	return "HTTP/2 Request Parser"
+}
+
+func (p *http2Parser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	p.allInput.Append(input)
+
+	if p.conn == nil {
+		// Only a connection preface starts tracking a connection, so that flows
+		// that merely look like HTTP/2 frames don't leave state behind.
+		startsConnection, ready := p.startsConnection()
+		if !ready && !isEnd {
+			return nil, p.allInput, nil
+		}
+		p.conn = p.factory.getConnection(p.bidiID, startsConnection)
+		if p.conn == nil {
+			return nil, p.allInput, errors.New("HTTP/2 frames seen without a connection preface")
+		}
+	}
+
+	result, err = p.parse()
+	if err == nil && result == nil && isEnd {
+		err = errors.New("HTTP/2 flow ended without a complete request or response")
+	}
+
+	if err != nil {
+		// Nothing more can be parsed in this direction: the HPACK state is no
+		// longer trustworthy.
+		if p.half != nil {
+			p.conn.mu.Lock()
+			p.half.done = true
+			p.conn.mu.Unlock()
+		}
+		p.factory.release(p.conn)
+		return nil, p.allInput, err
+	}
+
+	if result != nil && isEnd && p.pos == p.allInput.Len() {
+		p.conn.mu.Lock()
+		p.half.done = true
+		p.conn.mu.Unlock()
+		p.factory.release(p.conn)
+	}
+
+	return result, p.allInput.SubView(p.pos, p.allInput.Len()), nil
+}
+
+func (p *http2Parser) parse() (akinet.ParsedNetworkContent, error) {
+	p.conn.mu.Lock()
+	defer p.conn.mu.Unlock()
+
+	if p.half == nil {
+		ready, err := p.selectHalf()
+		if !ready || err != nil {
+			return nil, err
+		}
+	}
+
+	if p.half.done {
+		return nil, errors.New("HTTP/2 flow is no longer being parsed")
+	}
+
+	for {
+		remaining := p.allInput.SubView(p.pos, p.allInput.Len())
+		if remaining.Len() < frameHeaderLength_bytes {
+			return nil, nil
+		}
+
+		h := readFrameHeader(remaining)
+		if limit := maxFrameLength(p.factory.opts); int64(h.length) > limit {
+			return nil, errors.Errorf("HTTP/2 frame of %d bytes is longer than %d bytes", h.length, limit)
+		}
+		frameEnd := int64(frameHeaderLength_bytes) + int64(h.length)
+		if remaining.Len() < frameEnd {
+			return nil, nil
+		}
+
+		payload := []byte(remaining.SubView(frameHeaderLength_bytes, frameEnd).String())
+		p.pos += frameEnd
+
+		result, err := p.half.processFrame(p.bidiID, h, payload)
+		if err != nil || result != nil {
+			return result, err
+		}
+	}
+}
+
+// Determines whether the input starts with a client or server connection
+// preface. Returns false for ready if more input is needed to decide.
+func (p *http2Parser) startsConnection() (startsConnection, ready bool) {
+	if p.allInput.Len() < int64(len(clientPreface)) && isPrefixOfPreface(p.allInput) {
+		return false, false
+	}
+	if p.allInput.Index(0, clientPreface) == 0 {
+		return true, true
+	}
+	if p.allInput.Len() < frameHeaderLength_bytes {
+		return false, false
+	}
+	return readFrameHeader(p.allInput).isServerPreface(), true
+}
+
+// Figures out which direction of the connection this parser is for. Returns
+// false if more input is needed to decide. Must hold p.conn.mu when calling
+// selectHalf.
+func (p *http2Parser) selectHalf() (ready bool, err error) {
+	// Look for the client connection preface.
+	if p.allInput.Len() < int64(len(clientPreface)) && isPrefixOfPreface(p.allInput) {
+		return false, nil
+	}
+	if p.allInput.Index(0, clientPreface) == 0 {
+		if p.conn.client != nil {
+			return false, errors.New("saw multiple HTTP/2 client connection prefaces")
+		}
+		p.conn.client = newHalfConnection(true, p.seq, p.factory.opts)
+		p.half = p.conn.client
+		p.pos = int64(len(clientPreface))
+		return true, nil
+	}
+
+	if p.allInput.Len() < frameHeaderLength_bytes {
+		return false, nil
+	}
+
+	// A SETTINGS frame is the server's connection preface if we haven't seen the
+	// server half of the connection yet. If we have seen the client half, the
+	// parser's TCP sequence number should be far from the client's sequence
+	// numbers.
+	if p.conn.server == nil && readFrameHeader(p.allInput).isServerPreface() {
+		if p.conn.client == nil || !isNearby(p.conn.client.lastSeq, p.seq) {
+			p.conn.server = newHalfConnection(false, p.seq, p.factory.opts)
+			p.half = p.conn.server
+			return true, nil
+		}
+	}
+
+	// Otherwise, we are continuing a flow that we've already seen. Pick the
+	// direction whose sequence numbers are closest to ours.
+	switch {
+	case p.conn.client != nil && p.conn.server != nil:
+		if distance(p.conn.client.lastSeq, p.seq) <= distance(p.conn.server.lastSeq, p.seq) {
+			p.half = p.conn.client
+		} else {
+			p.half = p.conn.server
+		}
+	case p.conn.client != nil && isNearby(p.conn.client.lastSeq, p.seq):
+		p.half = p.conn.client
+	case p.conn.server != nil && isNearby(p.conn.server.lastSeq, p.seq):
+		p.half = p.conn.server
+	default:
+		return false, errors.New("HTTP/2 frames seen without a connection preface")
+	}
+
+	p.half.lastSeq = p.seq
+	return true, nil
+}
+
+// Determines whether two TCP sequence numbers could plausibly belong to the
+// same flow. Initial sequence numbers are chosen at random, so sequence numbers
+// from the two flows of a connection are usually far apart.
+func isNearby(a, b reassembly.Sequence) bool {
+	return distance(a, b) < 1<<30
+}
+
+func distance(a, b reassembly.Sequence) int {
+	d := a.Difference(b)
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,182 @@
+package http2
+
+import (
+	"sync"
+
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	akihttp "github.com/akitasoftware/akita-libs/akinet/http"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a parser factory for both halves of a cleartext HTTP/2 connection,
+// whether it was established with prior knowledge or upgraded from HTTP/1.1
+// using h2c. In the latter case, the upgrade request and the 101 response are
+// left to the HTTP/1.x parsers, and are paired with each other. The real
+// response to the upgrade request is sent on stream 1 of the HTTP/2
+// connection; it is reported with Seq 1, like other HTTP/2 responses, so it
+// has no matching request.
+//
+// HTTP/2 multiplexes requests and responses over a single connection, so the
+// returned factory keeps track of the connections it has seen. The same
+// factory must be used for both flows of a connection.
+//
+// Bodies are limited according to the body size options of the HTTP/1.x
+// parsers, and frames according to both the head and body size options (see
+// maxFrameLength). Zero values are replaced with their defaults.
+func NewHTTP2ParserFactory(opts akihttp.ParserOptions) akinet.TCPParserFactory {
+	return &http2ParserFactory{
+		opts:        opts.WithDefaults(),
+		connections: akinet.NewConnectionMap(connectionIdleTimeout, nil),
+	}
+}
+
+type http2ParserFactory struct {
+	opts akihttp.ParserOptions
+
+	mu          sync.Mutex
+	connections *akinet.ConnectionMap // of *connection, protected by mu
+}
+
+func (*http2ParserFactory) Name() string {
+	return "HTTP/2 Parser Factory"
+}
+
+func (factory *http2ParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	decision, discardFront = factory.accepts(input)
+
+	if decision == akinet.NeedMoreData && isEnd {
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+
+	return decision, discardFront
+}
+
+func (factory *http2ParserFactory) accepts(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	// Look for the client connection preface.
+	if start := input.Index(0, clientPreface); start >= 0 {
+		return akinet.Accept, start
+	}
+	if isPrefixOfPreface(input) {
+		return akinet.NeedMoreData, 0
+	}
+
+	if input.Len() < frameHeaderLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+
+	h := readFrameHeader(input)
+	if h.isServerPreface() {
+		return akinet.Accept, 0
+	}
+
+	// Frames in the middle of a connection can only be recognized by their
+	// header, which isn't very distinctive. Only accept them if they are
+	// consistent with the state of a connection whose preface we have seen. The
+	// parser checks that the flow really does belong to that connection.
+	if h.plausible() && int64(h.length) <= maxFrameLength(factory.opts) && factory.continuesConnection(h) {
+		return akinet.Accept, 0
+	}
+
+	return akinet.Reject, input.Len()
+}
+
+// Determines whether input is a proper prefix of the client connection
+// preface.
+func isPrefixOfPreface(input memview.MemView) bool {
+	if input.Len() >= int64(len(clientPreface)) {
+		return false
+	}
+	for i := int64(0); i < input.Len(); i++ {
+		if input.GetByte(i) != clientPreface[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Determines whether a frame with the given header could belong to a
+// connection whose preface has been seen. A frame for a stream must be for a
+// stream that has been opened on that connection, unless it is a HEADERS frame
+// that opens a new stream from the client.
+func (factory *http2ParserFactory) continuesConnection(h frameHeader) bool {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	// Lock ordering: factory.mu before conn.mu.
+	found := false
+	factory.connections.Range(func(_ akinet.TCPBidiID, state interface{}) bool {
+		conn := state.(*connection)
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+
+		if conn.client == nil && conn.server == nil {
+			return true
+		}
+		switch {
+		case h.streamID == 0:
+			found = true
+		case h.typ == headersFrameType && h.streamID%2 == 1:
+			found = true
+		case conn.client != nil && h.streamID <= conn.client.lastStreamID:
+			found = true
+		case conn.server != nil && h.streamID <= conn.server.lastStreamID:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// Returns the largest frame that the parsers will buffer. RFC 7540 allows
+// frames of up to maxFrameLength_bytes, but only if the receiver has raised
+// SETTINGS_MAX_FRAME_SIZE. Frames longer than both the maximum head and body
+// sizes are not parsed.
+func maxFrameLength(opts akihttp.ParserOptions) int64 {
+	limit := opts.MaxHeaderSize
+	if opts.MaxBodySize > limit {
+		limit = opts.MaxBodySize
+	}
+	if limit > maxFrameLength_bytes {
+		limit = maxFrameLength_bytes
+	}
+	return limit
+}
+
+func (factory *http2ParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newHTTP2Parser(factory, id, seq)
+}
+
+// Returns the state for the given connection. If create is false, returns nil
+// if the connection isn't known; otherwise, creates the state if necessary.
+func (factory *http2ParserFactory) getConnection(id akinet.TCPBidiID, create bool) *connection {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	if !create {
+		conn, _ := factory.connections.Get(id).(*connection)
+		return conn
+	}
+	return factory.connections.GetOrCreate(id, func() interface{} {
+		return &connection{bidiID: id}
+	}).(*connection)
+}
+
+// Forgets about the given connection if neither of its flows can yield any
+// more results.
+func (factory *http2ParserFactory) release(conn *connection) {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	// Lock ordering: factory.mu before conn.mu.
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	clientDone := conn.client == nil || conn.client.done
+	serverDone := conn.server == nil || conn.server.done
+	if clientDone && serverDone {
+		factory.connections.Delete(conn.bidiID)
+	}
+}
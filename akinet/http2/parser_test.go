@@ -0,0 +1,389 @@
+package http2
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	akihttp "github.com/akitasoftware/akita-libs/akinet/http"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var testBidiID = akinet.TCPBidiID(uuid.MustParse("3744e3d7-2c08-4cd2-9ee9-2306dfba6727"))
+
+const (
+	clientSeq = reassembly.Sequence(1000)
+	serverSeq = reassembly.Sequence(1 << 31)
+)
+
+// Helps build the bytes for one flow of an HTTP/2 connection.
+type flowWriter struct {
+	buf    bytes.Buffer
+	framer *http2.Framer
+	hbuf   bytes.Buffer
+	enc    *hpack.Encoder
+}
+
+func newFlowWriter(isClient bool) *flowWriter {
+	w := &flowWriter{}
+	if isClient {
+		w.buf.Write(clientPreface)
+	}
+	w.framer = http2.NewFramer(&w.buf, nil)
+	w.enc = hpack.NewEncoder(&w.hbuf)
+	w.framer.WriteSettings(http2.Setting{ID: http2.SettingMaxFrameSize, Val: 1 << 14})
+	return w
+}
+
+func (w *flowWriter) encode(fields ...string) []byte {
+	w.hbuf.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		w.enc.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	return append([]byte{}, w.hbuf.Bytes()...)
+}
+
+func (w *flowWriter) headers(streamID uint32, endStream bool, fields ...string) {
+	w.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: w.encode(fields...),
+		EndStream:     endStream,
+		EndHeaders:    true,
+	})
+}
+
+func (w *flowWriter) bytes() []byte {
+	return w.buf.Bytes()
+}
+
+// Runs a sequence of parsers over the given flow, the way a caller would,
+// collecting results until the flow is exhausted.
+func parseFlow(t *testing.T, factory akinet.TCPParserFactory, seq reassembly.Sequence, flow []byte) []akinet.ParsedNetworkContent {
+	results := []akinet.ParsedNetworkContent{}
+	input := memview.New(flow)
+	for input.Len() > 0 {
+		decision, discardFront := factory.Accepts(input, true)
+		if decision != akinet.Accept {
+			t.Fatalf("expected factory to accept, got %s", decision)
+		}
+		input = input.SubView(discardFront, input.Len())
+
+		p := factory.CreateParser(testBidiID, seq, 0)
+		result, unused, err := p.Parse(input, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		results = append(results, result)
+		input = unused
+	}
+	return results
+}
+
+func TestHTTP2MultiplexedStreams(t *testing.T) {
+	client := newFlowWriter(true)
+	client.headers(1, false,
+		":method", "POST",
+		":scheme", "http",
+		":authority", "example.com",
+		":path", "/foo?x=1",
+		"content-type", "application/json",
+	)
+	client.headers(3, true,
+		":method", "GET",
+		":scheme", "http",
+		":authority", "example.com",
+		":path", "/bar",
+	)
+	client.framer.WriteData(1, false, []byte(`{"a":`))
+	client.framer.WriteDataPadded(1, true, []byte(`1}`), []byte{0, 0, 0})
+
+	server := newFlowWriter(false)
+	server.framer.WriteSettingsAck()
+	server.headers(3, false, ":status", "404")
+	server.headers(1, false, ":status", "200", "content-type", "application/grpc")
+	server.framer.WriteData(1, false, []byte("hello"))
+	server.framer.WriteData(3, true, nil)
+	server.headers(1, true, "grpc-status", "0")
+
+	factory := NewHTTP2ParserFactory(akihttp.ParserOptions{})
+	requests := parseFlow(t, factory, clientSeq, client.bytes())
+	responses := parseFlow(t, factory, serverSeq, server.bytes())
+
+	expectedRequests := []akinet.ParsedNetworkContent{
+		akinet.HTTPRequest{
+			StreamID:   uuid.UUID(testBidiID),
+			Seq:        3,
+			Method:     "GET",
+			ProtoMajor: 2,
+			URL:        &url.URL{Path: "/bar"},
+			Host:       "example.com",
+		},
+		akinet.HTTPRequest{
+			StreamID:   uuid.UUID(testBidiID),
+			Seq:        1,
+			Method:     "POST",
+			ProtoMajor: 2,
+			URL:        &url.URL{Path: "/foo", RawQuery: "x=1"},
+			Host:       "example.com",
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       []byte(`{"a":1}`),
+		},
+	}
+	if diff := cmp.Diff(expectedRequests, requests, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff in requests: %s", diff)
+	}
+
+	expectedResponses := []akinet.ParsedNetworkContent{
+		akinet.HTTPResponse{
+			StreamID:   uuid.UUID(testBidiID),
+			Seq:        3,
+			StatusCode: 404,
+			ProtoMajor: 2,
+		},
+		akinet.HTTPResponse{
+			StreamID:   uuid.UUID(testBidiID),
+			Seq:        1,
+			StatusCode: 200,
+			ProtoMajor: 2,
+			Header:     http.Header{"Content-Type": {"application/grpc"}},
+			Body:       []byte("hello"),
+			Trailer:    http.Header{"Grpc-Status": {"0"}},
+		},
+	}
+	if diff := cmp.Diff(expectedResponses, responses, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff in responses: %s", diff)
+	}
+
+	// Each request should pair with the response on the same HTTP/2 stream.
+	for i := range requests {
+		if requests[i].(akinet.HTTPRequest).GetStreamKey() != responses[i].(akinet.HTTPResponse).GetStreamKey() {
+			t.Errorf("request %d does not pair with response %d", i, i)
+		}
+	}
+}
+
+func TestHTTP2Continuation(t *testing.T) {
+	client := newFlowWriter(true)
+	block := client.encode(
+		":method", "GET",
+		":scheme", "http",
+		":authority", "example.com",
+		":path", "/",
+		"x-akita-dog", "prince",
+	)
+	client.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: block[:5],
+		EndStream:     true,
+	})
+	client.framer.WriteContinuation(1, false, block[5:8])
+	client.framer.WriteContinuation(1, true, block[8:])
+
+	// Feed the flow one byte at a time.
+	flow := client.bytes()
+	factory := NewHTTP2ParserFactory(akihttp.ParserOptions{})
+	p := factory.CreateParser(testBidiID, clientSeq, 0)
+	var result akinet.ParsedNetworkContent
+	for i := range flow {
+		var err error
+		result, _, err = p.Parse(memview.New(flow[i:i+1]), i == len(flow)-1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result != nil {
+			break
+		}
+	}
+
+	expected := akinet.HTTPRequest{
+		StreamID:   uuid.UUID(testBidiID),
+		Seq:        1,
+		Method:     "GET",
+		ProtoMajor: 2,
+		URL:        &url.URL{Path: "/"},
+		Host:       "example.com",
+		Header:     http.Header{"X-Akita-Dog": {"prince"}},
+	}
+	if diff := cmp.Diff(expected, result, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+}
+
+func TestHTTP2ParserFactoryAccepts(t *testing.T) {
+	server := newFlowWriter(false)
+
+	testCases := []struct {
+		name             string
+		input            []byte
+		expectedDecision akinet.AcceptDecision
+		expectedDF       int64
+	}{
+		{
+			name:             "client preface",
+			input:            clientPreface,
+			expectedDecision: akinet.Accept,
+		},
+		{
+			name:             "client preface after garbage",
+			input:            append([]byte("garbage"), clientPreface...),
+			expectedDecision: akinet.Accept,
+			expectedDF:       7,
+		},
+		{
+			name:             "partial client preface",
+			input:            clientPreface[:10],
+			expectedDecision: akinet.NeedMoreData,
+		},
+		{
+			name:             "server preface",
+			input:            server.bytes(),
+			expectedDecision: akinet.Accept,
+		},
+		{
+			name:             "HTTP/1.1",
+			input:            []byte("GET / HTTP/1.1\r\n\r\n"),
+			expectedDecision: akinet.Reject,
+			expectedDF:       18,
+		},
+		{
+			// Without a known connection, a HEADERS frame isn't enough evidence.
+			name:             "frame without preface",
+			input:            []byte{0, 0, 1, byte(headersFrameType), 0, 0, 0, 0, 1, 0x82},
+			expectedDecision: akinet.Reject,
+			expectedDF:       10,
+		},
+	}
+
+	for _, c := range testCases {
+		decision, df := NewHTTP2ParserFactory(akihttp.ParserOptions{}).Accepts(memview.New(c.input), false)
+		if decision != c.expectedDecision {
+			t.Errorf("[%s] expected decision %s, got %s", c.name, c.expectedDecision, decision)
+		}
+		if df != c.expectedDF {
+			t.Errorf("[%s] expected discard front %d, got %d", c.name, c.expectedDF, df)
+		}
+	}
+}
+
+func TestHTTP2ParserFactoryAcceptsFramesForKnownStreams(t *testing.T) {
+	client := newFlowWriter(true)
+	client.headers(1, false,
+		":method", "POST",
+		":scheme", "http",
+		":authority", "example.com",
+		":path", "/",
+	)
+	factory := NewHTTP2ParserFactory(akihttp.ParserOptions{MaxHeaderSize: 100, MaxBodySize: 100})
+	if _, _, err := factory.CreateParser(testBidiID, clientSeq, 0).Parse(memview.New(client.bytes()), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	frame := func(length uint32, typ frameType, streamID uint32) []byte {
+		header := []byte{byte(length >> 16), byte(length >> 8), byte(length), byte(typ), 0, 0, 0, 0, byte(streamID)}
+		return append(header, make([]byte, length)...)
+	}
+	testCases := []struct {
+		name     string
+		input    []byte
+		expected akinet.AcceptDecision
+	}{
+		{"DATA on open stream", frame(10, dataFrameType, 1), akinet.Accept},
+		{"DATA on unopened stream", frame(10, dataFrameType, 5), akinet.Reject},
+		{"HEADERS opening a stream", frame(10, headersFrameType, 3), akinet.Accept},
+		{"HEADERS on unpromised server stream", frame(10, headersFrameType, 2), akinet.Reject},
+		{"WINDOW_UPDATE on connection", frame(4, windowUpdateFrameType, 0), akinet.Accept},
+		{"frame longer than limit", frame(101, dataFrameType, 1), akinet.Reject},
+	}
+	for _, c := range testCases {
+		if decision, _ := factory.Accepts(memview.New(c.input), false); decision != c.expected {
+			t.Errorf("[%s] expected decision %s, got %s", c.name, c.expected, decision)
+		}
+	}
+}
+
+func TestHTTP2ParserRejectsLongFrames(t *testing.T) {
+	client := newFlowWriter(true)
+	client.headers(1, false,
+		":method", "POST",
+		":scheme", "http",
+		":authority", "example.com",
+		":path", "/",
+	)
+	client.framer.WriteData(1, true, make([]byte, 101))
+
+	factory := NewHTTP2ParserFactory(akihttp.ParserOptions{MaxHeaderSize: 100, MaxBodySize: 100})
+	input := memview.New(client.bytes())
+	_, unused, err := factory.CreateParser(testBidiID, clientSeq, 0).Parse(input, false)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if unused.String() != input.String() {
+		t.Errorf("expected all input to be unused")
+	}
+}
+
+func TestHTTP2ParserIgnoresUnknownConnections(t *testing.T) {
+	client := newFlowWriter(true)
+	factory := NewHTTP2ParserFactory(akihttp.ParserOptions{}).(*http2ParserFactory)
+	if _, _, err := factory.CreateParser(testBidiID, clientSeq, 0).Parse(memview.New(client.bytes()), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Frames on another connection are accepted, since they could belong to the
+	// known connection, but the parser rejects them without remembering the
+	// other connection.
+	otherBidiID := akinet.TCPBidiID(uuid.MustParse("9ad1b6ae-8b4c-4f1e-a2c5-26d1b7e1f0a3"))
+	frame := []byte{0, 0, 1, byte(headersFrameType), byte(endHeadersFlag), 0, 0, 0, 1, 0x82}
+	if decision, _ := factory.Accepts(memview.New(frame), false); decision != akinet.Accept {
+		t.Errorf("expected frame to be accepted, got %s", decision)
+	}
+	input := memview.New(frame)
+	_, unused, err := factory.CreateParser(otherBidiID, clientSeq, 0).Parse(input, false)
+	if err == nil {
+		t.Errorf("expected error for frames without a connection preface")
+	}
+	if unused.String() != input.String() {
+		t.Errorf("expected all input to be unused")
+	}
+	if factory.connections.Get(otherBidiID) != nil {
+		t.Errorf("expected unknown connection to be forgotten")
+	}
+}
+
+func TestHTTP2BodySizeOptions(t *testing.T) {
+	client := newFlowWriter(true)
+	client.headers(1, false,
+		":method", "POST",
+		":scheme", "http",
+		":authority", "example.com",
+		":path", "/",
+	)
+	client.framer.WriteData(1, false, []byte("hello "))
+	client.framer.WriteData(1, true, []byte("world"))
+
+	testCases := []struct {
+		name     string
+		opts     akihttp.ParserOptions
+		expected string
+	}{
+		{"truncate", akihttp.ParserOptions{MaxBodySize: 8}, "hello wo"},
+		{"drop", akihttp.ParserOptions{MaxBodySize: 8, OversizedBodyBehavior: akihttp.DropOversizedBody}, ""},
+	}
+
+	for _, c := range testCases {
+		results := parseFlow(t, NewHTTP2ParserFactory(c.opts), clientSeq, client.bytes())
+		req := results[0].(akinet.HTTPRequest)
+		if string(req.Body) != c.expected || !req.Truncated {
+			t.Errorf("[%s] expected truncated body %q, got %q (truncated=%v)", c.name, c.expected, req.Body, req.Truncated)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package parsertest has helpers shared by the tests of the TCP protocol
+// parsers.
+package parsertest
+
+import (
+	"testing"
+
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Parses all of the input with the factory, the way a caller would. Fails the
+// test if the factory doesn't accept the input, or if the input can't be
+// parsed.
+func ParseAll(t *testing.T, factory akinet.TCPParserFactory, id akinet.TCPBidiID, seq, ack reassembly.Sequence, data []byte) []akinet.ParsedNetworkContent {
+	t.Helper()
+
+	results := []akinet.ParsedNetworkContent{}
+	input := memview.New(data)
+	for input.Len() > 0 {
+		decision, discardFront := factory.Accepts(input, true)
+		if decision != akinet.Accept {
+			t.Fatalf("expected factory to accept, got %s after %d results: %q", decision, len(results), input.String())
+		}
+		input = input.SubView(discardFront, input.Len())
+
+		p := factory.CreateParser(id, seq, ack)
+		result, unused, err := p.Parse(input, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		results = append(results, result)
+		input = unused
+	}
+	return results
+}
+
+type RejectTestCase struct {
+	Name  string
+	Input string
+}
+
+// Messages from other protocols that a factory should reject. Where a protocol
+// has a fixed-size header, the message is padded so that factories can't
+// reject it just for being short.
+var otherProtocols = map[string][]RejectTestCase{
+	"HTTP": {
+		{Name: "HTTP request", Input: "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"},
+		{Name: "HTTP response", Input: "HTTP/1.1 200 OK\r\n\r\n"},
+	},
+	"TLS": {
+		{Name: "TLS client hello", Input: "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+	},
+	"Redis": {
+		{Name: "Redis command", Input: "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"},
+	},
+	"Postgres": {
+		{Name: "Postgres SSLRequest", Input: "\x00\x00\x00\x08\x04\xd2\x16\x2f\x00\x00\x00\x00\x00\x00\x00\x00"},
+	},
+}
+
+// Returns test cases for messages of the protocols other than the given one
+// that a factory should reject.
+func OtherProtocols(except string) []RejectTestCase {
+	var result []RejectTestCase
+	for _, protocol := range []string{"HTTP", "TLS", "Redis", "Postgres"} {
+		if protocol != except {
+			result = append(result, otherProtocols[protocol]...)
+		}
+	}
+	return result
+}
+
+// Checks that the factory rejects the input of each test case at the end of
+// the stream.
+func RunRejectTests(t *testing.T, factory akinet.TCPParserFactory, cases []RejectTestCase) {
+	t.Helper()
+
+	for _, c := range cases {
+		if decision, _ := factory.Accepts(memview.New([]byte(c.Input)), true); decision != akinet.Reject {
+			t.Errorf("[%s] expected %q to be rejected, got %s", c.Name, c.Input, decision)
+		}
+	}
+}
+
+type IncrementalTestCase struct {
+	Name     string
+	Seq, Ack reassembly.Sequence
+
+	// A complete message, which is fed to the parser one byte at a time.
+	Input []byte
+
+	// Input that follows the message, which the parser should leave unused.
+	Next []byte
+
+	// Returns true if the parser's result is as expected.
+	Check func(result akinet.ParsedNetworkContent) bool
+}
+
+// Feeds the input of each test case to a new parser one byte at a time, and
+// checks that the parser only yields a result once it has seen the whole
+// message.
+func RunIncrementalTests(t *testing.T, factory akinet.TCPParserFactory, id akinet.TCPBidiID, cases []IncrementalTestCase) {
+	t.Helper()
+
+	for _, c := range cases {
+		p := factory.CreateParser(id, c.Seq, c.Ack)
+		last := len(c.Input) - 1
+		for i := range c.Input[:last] {
+			result, _, err := p.Parse(memview.New(c.Input[i:i+1]), false)
+			if err != nil || result != nil {
+				t.Fatalf("[%s] expected parser to need more data after %d bytes, got %v, %v", c.Name, i+1, result, err)
+			}
+		}
+
+		final := append(append([]byte{}, c.Input[last:]...), c.Next...)
+		result, unused, err := p.Parse(memview.New(final), false)
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", c.Name, err)
+		}
+		if !c.Check(result) {
+			t.Errorf("[%s] unexpected result: %v", c.Name, result)
+		}
+		if unused.String() != string(c.Next) {
+			t.Errorf("[%s] expected %q to be unused, got %q", c.Name, c.Next, unused.String())
+		}
+	}
+}
+
+type ErrorTestCase struct {
+	Name     string
+	Seq, Ack reassembly.Sequence
+
+	// The input, which is fed to the parser in chunks. The parser should fail
+	// on the last chunk.
+	Input [][]byte
+
+	// Whether the end of the flow is signalled with the last chunk.
+	IsEnd bool
+}
+
+// Feeds the input of each test case to a new parser, and checks that the
+// parser fails and returns all of the input as unused.
+func RunErrorTests(t *testing.T, factory akinet.TCPParserFactory, id akinet.TCPBidiID, cases []ErrorTestCase) {
+	t.Helper()
+
+	for _, c := range cases {
+		p := factory.CreateParser(id, c.Seq, c.Ack)
+		var input memview.MemView
+		var unused memview.MemView
+		var err error
+		for i, chunk := range c.Input {
+			last := i == len(c.Input)-1
+			var result akinet.ParsedNetworkContent
+			input.Append(memview.New(chunk))
+			result, unused, err = p.Parse(memview.New(chunk), c.IsEnd && last)
+			if !last && (err != nil || result != nil) {
+				t.Fatalf("[%s] expected parser to need more data after %d chunks, got %v, %v", c.Name, i+1, result, err)
+			}
+		}
+		if err == nil {
+			t.Errorf("[%s] expected an error", c.Name)
+			continue
+		}
+		if unused.Len() != input.Len() || unused.String() != input.String() {
+			t.Errorf("[%s] expected all %d bytes of input to be unused, got %d", c.Name, input.Len(), unused.Len())
+		}
+	}
+}
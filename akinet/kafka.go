@@ -0,0 +1,219 @@
+package akinet
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Identifies the type of a Kafka request.
+type KafkaAPIKey int16
+
+const (
+	KafkaProduce          KafkaAPIKey = 0
+	KafkaFetch            KafkaAPIKey = 1
+	KafkaListOffsets      KafkaAPIKey = 2
+	KafkaMetadata         KafkaAPIKey = 3
+	KafkaOffsetCommit     KafkaAPIKey = 8
+	KafkaOffsetFetch      KafkaAPIKey = 9
+	KafkaFindCoordinator  KafkaAPIKey = 10
+	KafkaJoinGroup        KafkaAPIKey = 11
+	KafkaHeartbeat        KafkaAPIKey = 12
+	KafkaLeaveGroup       KafkaAPIKey = 13
+	KafkaSyncGroup        KafkaAPIKey = 14
+	KafkaDescribeGroups   KafkaAPIKey = 15
+	KafkaListGroups       KafkaAPIKey = 16
+	KafkaSaslHandshake    KafkaAPIKey = 17
+	KafkaAPIVersions      KafkaAPIKey = 18
+	KafkaCreateTopics     KafkaAPIKey = 19
+	KafkaDeleteTopics     KafkaAPIKey = 20
+	KafkaInitProducerID   KafkaAPIKey = 22
+	KafkaSaslAuthenticate KafkaAPIKey = 36
+)
+
+func (k KafkaAPIKey) String() string {
+	switch k {
+	case KafkaProduce:
+		return "Produce"
+	case KafkaFetch:
+		return "Fetch"
+	case KafkaListOffsets:
+		return "ListOffsets"
+	case KafkaMetadata:
+		return "Metadata"
+	case KafkaOffsetCommit:
+		return "OffsetCommit"
+	case KafkaOffsetFetch:
+		return "OffsetFetch"
+	case KafkaFindCoordinator:
+		return "FindCoordinator"
+	case KafkaJoinGroup:
+		return "JoinGroup"
+	case KafkaHeartbeat:
+		return "Heartbeat"
+	case KafkaLeaveGroup:
+		return "LeaveGroup"
+	case KafkaSyncGroup:
+		return "SyncGroup"
+	case KafkaDescribeGroups:
+		return "DescribeGroups"
+	case KafkaListGroups:
+		return "ListGroups"
+	case KafkaSaslHandshake:
+		return "SaslHandshake"
+	case KafkaAPIVersions:
+		return "ApiVersions"
+	case KafkaCreateTopics:
+		return "CreateTopics"
+	case KafkaDeleteTopics:
+		return "DeleteTopics"
+	case KafkaInitProducerID:
+		return "InitProducerId"
+	case KafkaSaslAuthenticate:
+		return "SaslAuthenticate"
+	}
+	return "KafkaAPIKey(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Describes a topic partition in a Kafka request or response.
+type KafkaPartition struct {
+	// The topic name. Fetch requests and responses from version 13 identify
+	// topics by ID instead of name.
+	Topic   string
+	TopicID *uuid.UUID
+
+	Partition int32
+
+	// The number of records being produced or fetched.
+	RecordCount int
+
+	// The size of the record batches being produced or fetched.
+	RecordSize_bytes int
+
+	// The error code for the partition, in a response. 0 means no error.
+	ErrorCode int16
+}
+
+// Represents a request sent by a Kafka client.
+//
+// The corresponding KafkaResponse has the same stream key. The time taken to
+// handle the request is the difference between the observation times of the
+// two.
+type KafkaRequest struct {
+	// StreamID and CorrelationID uniquely identify a pair of request and
+	// response.
+	StreamID      uuid.UUID
+	CorrelationID int32
+
+	APIKey     KafkaAPIKey
+	APIVersion int16
+	ClientID   string
+
+	// Whether the body of the request was decoded. Only Produce, Fetch,
+	// Metadata and ApiVersions requests are decoded, and only if they aren't
+	// too large.
+	BodyDecoded bool
+
+	// The partitions being produced to or fetched from.
+	Partitions []KafkaPartition
+
+	// The topics that a Metadata request asks about. Nil if the request asks
+	// about all topics.
+	Topics []string
+
+	// The size of the request, not including the size field.
+	Size_bytes int
+}
+
+func (KafkaRequest) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this request with its corresponding
+// response.
+func (r KafkaRequest) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(int(r.CorrelationID))
+}
+
+// Returns the names of the topics in the request, sorted and without
+// duplicates.
+func (r KafkaRequest) TopicNames() []string {
+	return topicNames(r.Partitions, r.Topics)
+}
+
+// Represents the reply of a Kafka broker to a request.
+type KafkaResponse struct {
+	// StreamID and CorrelationID uniquely identify a pair of request and
+	// response.
+	StreamID      uuid.UUID
+	CorrelationID int32
+
+	// The type and version of the request. Responses don't identify their
+	// type, so these are only known if the request was parsed first; see
+	// RequestSeen.
+	APIKey      KafkaAPIKey
+	APIVersion  int16
+	RequestSeen bool
+
+	// Whether the body of the response was decoded. Responses to Produce,
+	// Fetch, Metadata and ApiVersions requests are decoded, if the request was
+	// seen and the response isn't too large.
+	BodyDecoded bool
+
+	// The error code for the request as a whole, for Fetch (version 7 and
+	// above) and ApiVersions responses. 0 means no error.
+	ErrorCode int16
+
+	// The partitions that were produced to or fetched from, or the partitions
+	// described by a Metadata response.
+	Partitions []KafkaPartition
+
+	// The topics described by a Metadata response, mapped to their error
+	// codes.
+	TopicErrorCodes map[string]int16
+
+	// The number of brokers described by a Metadata response.
+	BrokerCount int
+
+	// The time for which the request was throttled.
+	ThrottleTime_ms int32
+
+	// The size of the response, not including the size field.
+	Size_bytes int
+}
+
+func (KafkaResponse) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this response with its corresponding
+// request.
+func (r KafkaResponse) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(int(r.CorrelationID))
+}
+
+// Returns the names of the topics in the response, sorted and without
+// duplicates.
+func (r KafkaResponse) TopicNames() []string {
+	topics := make([]string, 0, len(r.TopicErrorCodes))
+	for topic := range r.TopicErrorCodes {
+		topics = append(topics, topic)
+	}
+	return topicNames(r.Partitions, topics)
+}
+
+func topicNames(partitions []KafkaPartition, topics []string) []string {
+	seen := map[string]struct{}{}
+	for _, p := range partitions {
+		if p.Topic != "" {
+			seen[p.Topic] = struct{}{}
+		}
+	}
+	for _, topic := range topics {
+		seen[topic] = struct{}{}
+	}
+
+	result := make([]string, 0, len(seen))
+	for topic := range seen {
+		result = append(result, topic)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+const (
+	// Length of the size field that precedes every message.
+	sizeLength_bytes = 4
+
+	// Length of the fixed part of a request header: api_key, api_version,
+	// correlation_id and the length of client_id.
+	requestHeaderLength_bytes = 10
+
+	// Length of a response header, without tagged fields: correlation_id.
+	responseHeaderLength_bytes = 4
+
+	// Largest message that we parse, matching the broker's default
+	// socket.request.max.bytes.
+	maxMessageLength_bytes = 100 * 1024 * 1024
+
+	// Largest message whose body we decode. The bodies of larger messages are
+	// skipped as they arrive, rather than buffered.
+	maxDecodedLength_bytes = 16 * 1024 * 1024
+
+	// Largest API key and version that we accept.
+	maxAPIKey     = 100
+	maxAPIVersion = 20
+
+	// Largest number of requests per connection that we remember while waiting
+	// for their responses.
+	maxPendingRequests = 1000
+
+	// Largest number of elements that we accept in an array.
+	maxArrayLength = 1 << 20
+
+	// Connections that have not seen any traffic for this long are forgotten.
+	connectionIdleTimeout = 10 * time.Minute
+
+	// Sequence numbers within this distance of a flow's last known sequence
+	// number are assumed to belong to that flow.
+	maxSeqDistance = 1 << 28
+
+	// Number of bytes at the start of a message that are kept when its body
+	// is too large to decode. This is enough for any request header.
+	maxHeaderLength_bytes = 64 * 1024
+)
+
+// The first version of each API that uses the flexible encoding, with compact
+// strings and arrays, and tagged fields.
+var firstFlexibleVersion = map[akinet.KafkaAPIKey]int16{
+	akinet.KafkaProduce:     9,
+	akinet.KafkaFetch:       12,
+	akinet.KafkaMetadata:    9,
+	akinet.KafkaAPIVersions: 3,
+}
+
+// Record batch layout (magic 2).
+const (
+	// Offset of the batchLength field.
+	batchLengthOffset = 8
+
+	// Offset of the magic byte.
+	batchMagicOffset = 16
+
+	// Offset of the record count.
+	batchRecordCountOffset = 57
+
+	// Length of the header of a record batch, up to and including the record
+	// count.
+	batchHeaderLength_bytes = 61
+
+	// Length of the offset and size fields that precede each message in a
+	// legacy message set (magic 0 and 1).
+	legacyMessageHeaderLength_bytes = 12
+)
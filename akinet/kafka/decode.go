@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"encoding/binary"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+var errMalformedMessage = errors.New("malformed Kafka message")
+
+// Reads big-endian fields from a message body. Errors are sticky: once a read
+// fails, all later reads return zero values, and err is set.
+//
+// If flexible is set, strings, arrays and bytes use the compact encoding, and
+// tagged fields are expected.
+type reader struct {
+	buf      []byte
+	flexible bool
+	err      error
+}
+
+func (r *reader) fixed(n int) []byte {
+	if r.err != nil || n < 0 || len(r.buf) < n {
+		r.err = errMalformedMessage
+		return nil
+	}
+	result := r.buf[:n]
+	r.buf = r.buf[n:]
+	return result
+}
+
+func (r *reader) int8() int8 {
+	if b := r.fixed(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *reader) int16() int16 {
+	if b := r.fixed(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *reader) int32() int32 {
+	if b := r.fixed(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *reader) int64() int64 {
+	if b := r.fixed(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *reader) uuid() uuid.UUID {
+	var result uuid.UUID
+	copy(result[:], r.fixed(len(result)))
+	return result
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errMalformedMessage
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// Reads the length of a string, array or byte sequence. Returns -1 for null.
+func (r *reader) length(legacy func() int) int {
+	var n int
+	if r.flexible {
+		// Compact lengths are stored plus one, so that 0 means null.
+		n = int(r.uvarint()) - 1
+	} else {
+		n = legacy()
+	}
+	if r.err == nil && (n < -1 || n > len(r.buf)) {
+		r.err = errMalformedMessage
+	}
+	if r.err != nil {
+		return -1
+	}
+	return n
+}
+
+// Reads a string or nullable string. Null is returned as "".
+func (r *reader) string() string {
+	n := r.length(func() int { return int(r.int16()) })
+	if n < 0 {
+		return ""
+	}
+	return string(r.fixed(n))
+}
+
+// Skips a byte sequence, returning its contents. Null is returned as nil.
+func (r *reader) bytes() []byte {
+	n := r.length(func() int { return int(r.int32()) })
+	if n < 0 {
+		return nil
+	}
+	return r.fixed(n)
+}
+
+// Reads the length of an array. Returns -1 for null.
+func (r *reader) arrayLength() int {
+	var n int
+	if r.flexible {
+		n = int(r.uvarint()) - 1
+	} else {
+		n = int(r.int32())
+	}
+	// Every element takes up at least one byte.
+	if r.err == nil && (n < -1 || n > maxArrayLength || n > len(r.buf)) {
+		r.err = errMalformedMessage
+	}
+	if r.err != nil {
+		return -1
+	}
+	return n
+}
+
+// Reads an array, calling f for each element.
+func (r *reader) array(f func()) {
+	n := r.arrayLength()
+	for i := 0; i < n && r.err == nil; i++ {
+		f()
+	}
+}
+
+// Skips tagged fields, if the message uses the flexible encoding.
+func (r *reader) taggedFields() {
+	if !r.flexible {
+		return
+	}
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		r.uvarint() // Tag
+		size := r.uvarint()
+		if size > uint64(len(r.buf)) {
+			r.err = errMalformedMessage
+			return
+		}
+		r.fixed(int(size))
+	}
+}
+
+// Counts the records in a sequence of record batches, or in a legacy message
+// set. A partial batch at the end, which brokers may return from a Fetch, is
+// not counted.
+func countRecords(records []byte) int {
+	count := 0
+	for len(records) >= legacyMessageHeaderLength_bytes {
+		batchLength := int(int32(binary.BigEndian.Uint32(records[batchLengthOffset:])))
+		total := legacyMessageHeaderLength_bytes + batchLength
+		if batchLength < 0 || total > len(records) {
+			break
+		}
+
+		if total >= batchHeaderLength_bytes && records[batchMagicOffset] >= 2 {
+			count += int(int32(binary.BigEndian.Uint32(records[batchRecordCountOffset:])))
+		} else {
+			// A legacy message. Compressed messages wrap a message set, which we
+			// don't decompress, so they count as one.
+			count++
+		}
+		records = records[total:]
+	}
+	return count
+}
@@ -0,0 +1,316 @@
+package kafka
+
+import (
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Determines whether the given version of an API uses the flexible encoding.
+// Returns false for APIs that we don't decode.
+func isFlexible(apiKey akinet.KafkaAPIKey, apiVersion int16) bool {
+	v, ok := firstFlexibleVersion[apiKey]
+	return ok && apiVersion >= v
+}
+
+// Determines whether we decode the bodies of requests and responses of the
+// given API.
+func isDecoded(apiKey akinet.KafkaAPIKey) bool {
+	_, ok := firstFlexibleVersion[apiKey]
+	return ok
+}
+
+// Checks whether a request header looks valid: that the API key and version
+// are plausible, and that the client ID fits in the message.
+func isPlausibleRequestHeader(apiKey, apiVersion int16, clientIDLength int16, size int64) bool {
+	return 0 <= apiKey && apiKey <= maxAPIKey &&
+		0 <= apiVersion && apiVersion <= maxAPIVersion &&
+		-1 <= clientIDLength && int64(requestHeaderLength_bytes)+int64(clientIDLength) <= size
+}
+
+// Parses a request header (version 1 or 2). Returns the header fields, and a
+// reader positioned at the start of the request body.
+func parseRequestHeader(buf []byte) (*akinet.KafkaRequest, *reader) {
+	r := &reader{buf: buf}
+	req := &akinet.KafkaRequest{
+		APIKey:        akinet.KafkaAPIKey(r.int16()),
+		APIVersion:    r.int16(),
+		CorrelationID: r.int32(),
+	}
+	// The client ID never uses the compact encoding.
+	req.ClientID = r.string()
+
+	r.flexible = isFlexible(req.APIKey, req.APIVersion)
+	r.taggedFields()
+	return req, r
+}
+
+// Parses a response header (version 0 or 1), given the request that the
+// response is for. Returns a reader positioned at the start of the response
+// body.
+func parseResponseHeader(buf []byte, apiKey akinet.KafkaAPIKey, apiVersion int16) (correlationID int32, r *reader) {
+	r = &reader{buf: buf}
+	correlationID = r.int32()
+
+	// ApiVersions responses always use header version 0, so that clients can
+	// parse them before they know which versions the broker supports.
+	r.flexible = isFlexible(apiKey, apiVersion)
+	if apiKey != akinet.KafkaAPIVersions {
+		r.taggedFields()
+	}
+	return correlationID, r
+}
+
+// Decodes the body of a request, for the APIs that we decode.
+func decodeRequestBody(r *reader, req *akinet.KafkaRequest) error {
+	v := req.APIVersion
+	switch req.APIKey {
+	case akinet.KafkaProduce:
+		if v >= 3 {
+			r.string() // Transactional ID
+		}
+		r.int16() // Acks
+		r.int32() // Timeout
+		r.array(func() {
+			topic := r.string()
+			r.array(func() {
+				p := akinet.KafkaPartition{Topic: topic, Partition: r.int32()}
+				records := r.bytes()
+				p.RecordCount = countRecords(records)
+				p.RecordSize_bytes = len(records)
+				r.taggedFields()
+				req.Partitions = append(req.Partitions, p)
+			})
+			r.taggedFields()
+		})
+
+	case akinet.KafkaFetch:
+		if v < 15 {
+			r.int32() // Replica ID
+		}
+		r.int32() // Max wait
+		r.int32() // Min bytes
+		if v >= 3 {
+			r.int32() // Max bytes
+		}
+		if v >= 4 {
+			r.int8() // Isolation level
+		}
+		if v >= 7 {
+			r.int32() // Session ID
+			r.int32() // Session epoch
+		}
+		r.array(func() {
+			var p akinet.KafkaPartition
+			readTopic(r, v, &p)
+			r.array(func() {
+				p.Partition = r.int32()
+				if v >= 9 {
+					r.int32() // Current leader epoch
+				}
+				r.int64() // Fetch offset
+				if v >= 12 {
+					r.int32() // Last fetched epoch
+				}
+				if v >= 5 {
+					r.int64() // Log start offset
+				}
+				r.int32() // Partition max bytes
+				r.taggedFields()
+				req.Partitions = append(req.Partitions, p)
+			})
+			r.taggedFields()
+		})
+		// Forgotten topics and the rack ID follow, which we don't capture.
+
+	case akinet.KafkaMetadata:
+		n := r.arrayLength()
+		if n >= 0 && !(v == 0 && n == 0) {
+			// In version 0, an empty array means all topics.
+			req.Topics = []string{}
+		}
+		for i := 0; i < n && r.err == nil; i++ {
+			if v >= 10 {
+				r.uuid() // Topic ID
+			}
+			if name := r.string(); name != "" {
+				req.Topics = append(req.Topics, name)
+			}
+			r.taggedFields()
+		}
+		// Flags about topic creation and authorized operations follow, which we
+		// don't capture.
+
+	case akinet.KafkaAPIVersions:
+		if v >= 3 {
+			r.string() // Client software name
+			r.string() // Client software version
+			r.taggedFields()
+		}
+	}
+
+	return r.err
+}
+
+// Decodes the body of a response, for the APIs that we decode.
+func decodeResponseBody(r *reader, resp *akinet.KafkaResponse) error {
+	v := resp.APIVersion
+	switch resp.APIKey {
+	case akinet.KafkaProduce:
+		r.array(func() {
+			topic := r.string()
+			r.array(func() {
+				p := akinet.KafkaPartition{
+					Topic:     topic,
+					Partition: r.int32(),
+					ErrorCode: r.int16(),
+				}
+				r.int64() // Base offset
+				if v >= 2 {
+					r.int64() // Log append time
+				}
+				if v >= 5 {
+					r.int64() // Log start offset
+				}
+				if v >= 8 {
+					r.array(func() {
+						r.int32()  // Batch index
+						r.string() // Batch index error message
+						r.taggedFields()
+					})
+					r.string() // Error message
+				}
+				r.taggedFields()
+				resp.Partitions = append(resp.Partitions, p)
+			})
+			r.taggedFields()
+		})
+		if v >= 1 {
+			resp.ThrottleTime_ms = r.int32()
+		}
+
+	case akinet.KafkaFetch:
+		if v >= 1 {
+			resp.ThrottleTime_ms = r.int32()
+		}
+		if v >= 7 {
+			resp.ErrorCode = r.int16()
+			r.int32() // Session ID
+		}
+		r.array(func() {
+			var topic akinet.KafkaPartition
+			readTopic(r, v, &topic)
+			r.array(func() {
+				p := topic
+				p.Partition = r.int32()
+				p.ErrorCode = r.int16()
+				r.int64() // High watermark
+				if v >= 4 {
+					r.int64() // Last stable offset
+				}
+				if v >= 5 {
+					r.int64() // Log start offset
+				}
+				if v >= 4 {
+					r.array(func() {
+						r.int64() // Producer ID
+						r.int64() // First offset
+						r.taggedFields()
+					})
+				}
+				if v >= 11 {
+					r.int32() // Preferred read replica
+				}
+				records := r.bytes()
+				p.RecordCount = countRecords(records)
+				p.RecordSize_bytes = len(records)
+				r.taggedFields()
+				resp.Partitions = append(resp.Partitions, p)
+			})
+			r.taggedFields()
+		})
+
+	case akinet.KafkaMetadata:
+		if v >= 3 {
+			resp.ThrottleTime_ms = r.int32()
+		}
+		r.array(func() {
+			resp.BrokerCount++
+			r.int32()  // Node ID
+			r.string() // Host
+			r.int32()  // Port
+			if v >= 1 {
+				r.string() // Rack
+			}
+			r.taggedFields()
+		})
+		if v >= 2 {
+			r.string() // Cluster ID
+		}
+		if v >= 1 {
+			r.int32() // Controller ID
+		}
+		resp.TopicErrorCodes = map[string]int16{}
+		r.array(func() {
+			errorCode := r.int16()
+			topic := r.string()
+			if v >= 10 {
+				r.uuid() // Topic ID
+			}
+			if v >= 1 {
+				r.int8() // Is internal
+			}
+			resp.TopicErrorCodes[topic] = errorCode
+			r.array(func() {
+				p := akinet.KafkaPartition{
+					Topic:     topic,
+					ErrorCode: r.int16(),
+					Partition: r.int32(),
+				}
+				r.int32() // Leader ID
+				if v >= 7 {
+					r.int32() // Leader epoch
+				}
+				skipInt32Array(r) // Replicas
+				skipInt32Array(r) // In-sync replicas
+				if v >= 5 {
+					skipInt32Array(r) // Offline replicas
+				}
+				r.taggedFields()
+				resp.Partitions = append(resp.Partitions, p)
+			})
+			if v >= 8 {
+				r.int32() // Topic authorized operations
+			}
+			r.taggedFields()
+		})
+
+	case akinet.KafkaAPIVersions:
+		resp.ErrorCode = r.int16()
+		r.array(func() {
+			r.int16() // API key
+			r.int16() // Min version
+			r.int16() // Max version
+			r.taggedFields()
+		})
+		if v >= 1 {
+			resp.ThrottleTime_ms = r.int32()
+		}
+	}
+
+	return r.err
+}
+
+// Reads a topic name or, from version 13 of Fetch, a topic ID.
+func readTopic(r *reader, fetchVersion int16, p *akinet.KafkaPartition) {
+	if fetchVersion >= 13 {
+		id := r.uuid()
+		p.TopicID = &id
+	} else {
+		p.Topic = r.string()
+	}
+}
+
+func skipInt32Array(r *reader) {
+	r.array(func() {
+		r.int32()
+	})
+}
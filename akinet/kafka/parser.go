@@ -0,0 +1,193 @@
+package kafka
+
+import (
+	"io"
+
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+func newKafkaParser(factory *kafkaParserFactory, bidiID akinet.TCPBidiID, seq, ack reassembly.Sequence) *kafkaParser {
+	return &kafkaParser{
+		factory: factory,
+		bidiID:  bidiID,
+		seq:     seq,
+		ack:     ack,
+		size:    -1,
+		input:   akinet.NewRetainedInput(0),
+	}
+}
+
+// Parses a single Kafka request or response.
+type kafkaParser struct {
+	factory  *kafkaParserFactory
+	bidiID   akinet.TCPBidiID
+	seq, ack reassembly.Sequence
+
+	// The input, which is returned as unused if the message can't be parsed.
+	input akinet.RetainedInput
+
+	// Input that has not been consumed yet. The size prefix is dropped once it
+	// has been read, and the rest of a large message as it is skipped.
+	allInput memview.MemView
+
+	// The size of the message, not including the size field, or -1 if not yet
+	// known.
+	size int64
+
+	// Set when the message is too large to decode. The start of the message is
+	// kept in head, and the rest is skipped.
+	head      []byte
+	remaining int64
+}
+
+var _ akinet.TCPParser = (*kafkaParser)(nil)
+
+func (*kafkaParser) Name() string {
+	return "Kafka Parser"
+}
+
+func (p *kafkaParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	p.input.Append(input)
+	p.allInput.Append(input)
+
+	result, err = p.parse()
+	if err != nil {
+		return nil, p.input.Unused(p.allInput), err
+	}
+	if result == nil {
+		if isEnd {
+			return nil, p.input.Unused(p.allInput), io.ErrUnexpectedEOF
+		}
+		return nil, memview.MemView{}, nil
+	}
+	return result, p.allInput, nil
+}
+
+func (p *kafkaParser) parse() (akinet.ParsedNetworkContent, error) {
+	if p.size < 0 {
+		if p.allInput.Len() < sizeLength_bytes {
+			return nil, nil
+		}
+		p.size = int64(int32(p.allInput.GetUint32(0)))
+		if p.size < responseHeaderLength_bytes || p.size > maxMessageLength_bytes {
+			return nil, errors.Errorf("invalid Kafka message size %d", p.size)
+		}
+		p.allInput = p.allInput.SubView(sizeLength_bytes, p.allInput.Len())
+	}
+
+	if p.head != nil {
+		// Skip the rest of a large message.
+		n := p.allInput.Len()
+		if n > p.remaining {
+			n = p.remaining
+		}
+		p.allInput = p.allInput.SubView(n, p.allInput.Len())
+		p.remaining -= n
+		if p.remaining > 0 {
+			return nil, nil
+		}
+		return p.getResult(p.head, false)
+	}
+
+	if p.size > maxDecodedLength_bytes {
+		if p.allInput.Len() < maxHeaderLength_bytes {
+			return nil, nil
+		}
+		p.head = []byte(p.allInput.SubView(0, maxHeaderLength_bytes).String())
+		p.remaining = p.size - maxHeaderLength_bytes
+		p.allInput = p.allInput.SubView(maxHeaderLength_bytes, p.allInput.Len())
+		return p.parse()
+	}
+
+	if p.allInput.Len() < p.size {
+		return nil, nil
+	}
+	message := []byte(p.allInput.SubView(0, p.size).String())
+	p.allInput = p.allInput.SubView(p.size, p.allInput.Len())
+	return p.getResult(message, true)
+}
+
+// Builds the result from the given message. If complete is false, the message
+// is only the start of a large message, and its body isn't decoded.
+func (p *kafkaParser) getResult(message []byte, complete bool) (akinet.ParsedNetworkContent, error) {
+	if p.isResponse(message) {
+		return p.getResponse(message, complete)
+	}
+	return p.getRequest(message, complete)
+}
+
+// Determines whether the message is a response. Requests and responses can
+// look alike: a response starts with its correlation ID, and correlation IDs
+// are often small enough to pass for an API key and version.
+func (p *kafkaParser) isResponse(message []byte) bool {
+	if isClient, known := p.factory.isClientFlow(p.bidiID, p.seq); known {
+		return !isClient
+	}
+
+	correlationID, _ := parseResponseHeader(message, akinet.KafkaAPIVersions, 0)
+	if _, ok := p.factory.pendingRequest(p.bidiID, correlationID); ok {
+		return true
+	}
+
+	req, r := parseRequestHeader(message)
+	return r.err != nil || !isPlausibleRequestHeader(int16(req.APIKey), req.APIVersion, 0, p.size)
+}
+
+func (p *kafkaParser) getRequest(message []byte, complete bool) (akinet.ParsedNetworkContent, error) {
+	req, r := parseRequestHeader(message)
+	if r.err != nil {
+		return nil, errors.Wrap(r.err, "failed to parse Kafka request header")
+	}
+	if !isPlausibleRequestHeader(int16(req.APIKey), req.APIVersion, 0, p.size) {
+		return nil, errors.Errorf("unsupported Kafka request with API key %d and version %d", req.APIKey, req.APIVersion)
+	}
+	req.StreamID = uuid.UUID(p.bidiID)
+	req.Size_bytes = int(p.size)
+
+	p.factory.addRequest(p.bidiID, p.seq, req)
+
+	// If the body can't be decoded (e.g. because of a protocol version that we
+	// don't know about), report the request without it.
+	if complete && isDecoded(req.APIKey) {
+		decoded := *req
+		if err := decodeRequestBody(r, &decoded); err == nil {
+			decoded.BodyDecoded = true
+			return decoded, nil
+		}
+	}
+	return *req, nil
+}
+
+func (p *kafkaParser) getResponse(message []byte, complete bool) (akinet.ParsedNetworkContent, error) {
+	correlationID, _ := parseResponseHeader(message, akinet.KafkaAPIVersions, 0)
+	req, ok := p.factory.pendingRequest(p.bidiID, correlationID)
+
+	resp := akinet.KafkaResponse{
+		StreamID:      uuid.UUID(p.bidiID),
+		CorrelationID: correlationID,
+		Size_bytes:    int(p.size),
+	}
+	if !ok {
+		return resp, nil
+	}
+	p.factory.removeRequest(p.bidiID, correlationID)
+
+	resp.APIKey = req.apiKey
+	resp.APIVersion = req.apiVersion
+	resp.RequestSeen = true
+
+	if complete && isDecoded(req.apiKey) {
+		_, r := parseResponseHeader(message, req.apiKey, req.apiVersion)
+		decoded := resp
+		if err := decodeResponseBody(r, &decoded); err == nil {
+			decoded.BodyDecoded = true
+			return decoded, nil
+		}
+	}
+	return resp, nil
+}
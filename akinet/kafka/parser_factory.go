@@ -0,0 +1,179 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a parser factory for both halves of a Kafka connection.
+//
+// Responses don't say which API they belong to, so they can only be decoded
+// after their request has been parsed. The returned factory keeps track of
+// requests that are waiting for a response, so the same factory must be used
+// for both flows of a connection.
+func NewKafkaParserFactory() akinet.TCPParserFactory {
+	factory := &kafkaParserFactory{
+		pendingIDs: map[int32]int{},
+	}
+	factory.connections = akinet.NewConnectionMap(connectionIdleTimeout, func(_ akinet.TCPBidiID, state interface{}) {
+		for correlationID := range state.(*connection).pending {
+			factory.removePendingID(correlationID)
+		}
+	})
+	return factory
+}
+
+type kafkaParserFactory struct {
+	mu          sync.Mutex
+	connections *akinet.ConnectionMap // of *connection, protected by mu
+
+	// The number of pending requests on all connections with each correlation
+	// ID, used to recognize responses. Protected by mu.
+	pendingIDs map[int32]int
+}
+
+// A request that is waiting for a response.
+type pendingRequest struct {
+	apiKey     akinet.KafkaAPIKey
+	apiVersion int16
+}
+
+// The state of a connection. Protected by kafkaParserFactory.mu.
+type connection struct {
+	// The last sequence number seen on the client's flow, used to tell which
+	// flow a parser is on.
+	clientSeq   reassembly.Sequence
+	clientKnown bool
+
+	// Requests that are waiting for a response, keyed by correlation ID.
+	pending map[int32]pendingRequest
+}
+
+func (*kafkaParserFactory) Name() string {
+	return "Kafka Parser Factory"
+}
+
+func (factory *kafkaParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	decision, discardFront = factory.accepts(input)
+
+	if decision == akinet.NeedMoreData && isEnd {
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+
+	return decision, discardFront
+}
+
+func (factory *kafkaParserFactory) accepts(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	if input.Len() < sizeLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+	size := int64(int32(input.GetUint32(0)))
+	if size < responseHeaderLength_bytes || size > maxMessageLength_bytes {
+		return akinet.Reject, input.Len()
+	}
+
+	if input.Len() < sizeLength_bytes+responseHeaderLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+	if factory.isPendingID(int32(input.GetUint32(sizeLength_bytes))) {
+		return akinet.Accept, 0
+	}
+
+	if size < requestHeaderLength_bytes {
+		return akinet.Reject, input.Len()
+	}
+	if input.Len() < sizeLength_bytes+requestHeaderLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+	apiKey := int16(input.GetUint16(sizeLength_bytes))
+	apiVersion := int16(input.GetUint16(sizeLength_bytes + 2))
+	clientIDLength := int16(input.GetUint16(sizeLength_bytes + 8))
+	if !isPlausibleRequestHeader(apiKey, apiVersion, clientIDLength, size) {
+		return akinet.Reject, input.Len()
+	}
+	return akinet.Accept, 0
+}
+
+func (factory *kafkaParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newKafkaParser(factory, id, seq, ack)
+}
+
+func (factory *kafkaParserFactory) isPendingID(correlationID int32) bool {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+	return factory.pendingIDs[correlationID] > 0
+}
+
+// Calls f with the state of the given connection, creating it if necessary.
+func (factory *kafkaParserFactory) withConnection(id akinet.TCPBidiID, f func(*connection)) {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	conn := factory.connections.GetOrCreate(id, func() interface{} {
+		return &connection{
+			pending: map[int32]pendingRequest{},
+		}
+	}).(*connection)
+	f(conn)
+}
+
+// Determines whether the flow with the given sequence number is the client's,
+// if known.
+func (factory *kafkaParserFactory) isClientFlow(id akinet.TCPBidiID, seq reassembly.Sequence) (isClient, known bool) {
+	factory.withConnection(id, func(conn *connection) {
+		if conn.clientKnown {
+			d := seq.Difference(conn.clientSeq)
+			isClient, known = -maxSeqDistance < d && d < maxSeqDistance, true
+		}
+	})
+	return isClient, known
+}
+
+// Records a request on the client flow with the given sequence number.
+func (factory *kafkaParserFactory) addRequest(id akinet.TCPBidiID, seq reassembly.Sequence, req *akinet.KafkaRequest) {
+	factory.withConnection(id, func(conn *connection) {
+		conn.clientSeq, conn.clientKnown = seq, true
+
+		if _, ok := conn.pending[req.CorrelationID]; ok || len(conn.pending) >= maxPendingRequests {
+			return
+		}
+		conn.pending[req.CorrelationID] = pendingRequest{
+			apiKey:     req.APIKey,
+			apiVersion: req.APIVersion,
+		}
+		factory.pendingIDs[req.CorrelationID]++
+	})
+}
+
+// Returns the request with the given correlation ID, if it is pending, without
+// removing it.
+func (factory *kafkaParserFactory) pendingRequest(id akinet.TCPBidiID, correlationID int32) (req pendingRequest, ok bool) {
+	factory.withConnection(id, func(conn *connection) {
+		req, ok = conn.pending[correlationID]
+	})
+	return req, ok
+}
+
+// Removes the request with the given correlation ID, once its response has
+// been parsed.
+func (factory *kafkaParserFactory) removeRequest(id akinet.TCPBidiID, correlationID int32) {
+	factory.withConnection(id, func(conn *connection) {
+		if _, ok := conn.pending[correlationID]; ok {
+			delete(conn.pending, correlationID)
+			factory.removePendingID(correlationID)
+		}
+	})
+}
+
+// Must hold mu when calling removePendingID.
+func (factory *kafkaParserFactory) removePendingID(correlationID int32) {
+	factory.pendingIDs[correlationID]--
+	if factory.pendingIDs[correlationID] <= 0 {
+		delete(factory.pendingIDs, correlationID)
+	}
+}
@@ -0,0 +1,392 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/akinet/internal/parsertest"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var testBidiID = akinet.TCPBidiID(uuid.MustParse("3d6e9f1a-2b4c-4d8e-a0f2-7c5b3a1e9d24"))
+
+const (
+	clientSeq = reassembly.Sequence(1000)
+	serverSeq = reassembly.Sequence(2000000000)
+)
+
+// Builds Kafka messages. If flexible is set, strings and arrays use the
+// compact encoding.
+type messageBuilder struct {
+	bytes.Buffer
+	flexible bool
+}
+
+func msg(flexible bool) *messageBuilder {
+	return &messageBuilder{flexible: flexible}
+}
+
+func (b *messageBuilder) int8(v int8) *messageBuilder {
+	b.WriteByte(byte(v))
+	return b
+}
+
+func (b *messageBuilder) int16(v int16) *messageBuilder {
+	binary.Write(b, binary.BigEndian, v)
+	return b
+}
+
+func (b *messageBuilder) int32(v int32) *messageBuilder {
+	binary.Write(b, binary.BigEndian, v)
+	return b
+}
+
+func (b *messageBuilder) int64(v int64) *messageBuilder {
+	binary.Write(b, binary.BigEndian, v)
+	return b
+}
+
+func (b *messageBuilder) uvarint(v uint64) *messageBuilder {
+	b.Write(binary.AppendUvarint(nil, v))
+	return b
+}
+
+// Writes the length of a string, array or byte sequence.
+func (b *messageBuilder) length(n int, legacySize int) *messageBuilder {
+	if b.flexible {
+		return b.uvarint(uint64(n + 1))
+	}
+	if legacySize == 2 {
+		return b.int16(int16(n))
+	}
+	return b.int32(int32(n))
+}
+
+func (b *messageBuilder) str(s string) *messageBuilder {
+	b.length(len(s), 2)
+	b.WriteString(s)
+	return b
+}
+
+// Writes a nullable string that never uses the compact encoding.
+func (b *messageBuilder) legacyStr(s string) *messageBuilder {
+	b.int16(int16(len(s)))
+	b.WriteString(s)
+	return b
+}
+
+func (b *messageBuilder) bytes(data []byte) *messageBuilder {
+	b.length(len(data), 4)
+	b.Write(data)
+	return b
+}
+
+func (b *messageBuilder) array(n int) *messageBuilder {
+	return b.length(n, 4)
+}
+
+// Writes empty tagged fields, if the message is flexible.
+func (b *messageBuilder) tags() *messageBuilder {
+	if b.flexible {
+		b.uvarint(0)
+	}
+	return b
+}
+
+// Returns the message, preceded by its size.
+func (b *messageBuilder) message() []byte {
+	result := make([]byte, 4)
+	binary.BigEndian.PutUint32(result, uint32(b.Len()))
+	return append(result, b.Bytes()...)
+}
+
+// Writes a request header.
+func (b *messageBuilder) requestHeader(apiKey akinet.KafkaAPIKey, apiVersion int16, correlationID int32) *messageBuilder {
+	return b.int16(int16(apiKey)).int16(apiVersion).int32(correlationID).legacyStr("app").tags()
+}
+
+// Returns a record batch with the given number of records. The records
+// themselves are not valid, but their contents are never looked at.
+func recordBatch(numRecords int32) []byte {
+	b := msg(false)
+	b.int64(0)  // Base offset
+	b.int32(49) // Batch length
+	b.int32(0)  // Partition leader epoch
+	b.int8(2)   // Magic
+	b.int32(0)  // CRC
+	b.int16(0)  // Attributes
+	b.int32(numRecords - 1)
+	b.int64(0)  // Base timestamp
+	b.int64(0)  // Max timestamp
+	b.int64(-1) // Producer ID
+	b.int16(-1) // Producer epoch
+	b.int32(-1) // Base sequence
+	b.int32(numRecords)
+	return b.Bytes()
+}
+
+func TestProduceAndMetadata(t *testing.T) {
+	factory := NewKafkaParserFactory()
+	streamID := uuid.UUID(testBidiID)
+
+	batch := append(recordBatch(3), recordBatch(2)...)
+	metadataRequest := msg(false).requestHeader(akinet.KafkaMetadata, 1, 1).
+		array(1).str("orders").
+		message()
+	produceRequest := msg(false).requestHeader(akinet.KafkaProduce, 3, 2).
+		str("").int16(1).int32(30000).
+		array(1).str("orders").
+		array(1).int32(0).bytes(batch).
+		message()
+
+	metadataResponse := msg(false).int32(1).
+		array(1).int32(1).str("broker-1").int32(9092).str("").
+		int32(1).
+		array(1).int16(0).str("orders").int8(0).
+		array(2).
+		int16(0).int32(0).int32(1).array(1).int32(1).array(1).int32(1).
+		int16(5).int32(1).int32(-1).array(0).array(0).
+		message()
+	produceResponse := msg(false).int32(2).
+		array(1).str("orders").
+		array(1).int32(0).int16(0).int64(42).int64(-1).
+		int32(0).
+		message()
+
+	requests := parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, append(metadataRequest, produceRequest...))
+	responses := parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq, append(metadataResponse, produceResponse...))
+
+	expectedRequests := []akinet.ParsedNetworkContent{
+		akinet.KafkaRequest{
+			StreamID:      streamID,
+			CorrelationID: 1,
+			APIKey:        akinet.KafkaMetadata,
+			APIVersion:    1,
+			ClientID:      "app",
+			BodyDecoded:   true,
+			Topics:        []string{"orders"},
+			Size_bytes:    len(metadataRequest) - 4,
+		},
+		akinet.KafkaRequest{
+			StreamID:      streamID,
+			CorrelationID: 2,
+			APIKey:        akinet.KafkaProduce,
+			APIVersion:    3,
+			ClientID:      "app",
+			BodyDecoded:   true,
+			Partitions: []akinet.KafkaPartition{
+				{Topic: "orders", Partition: 0, RecordCount: 5, RecordSize_bytes: len(batch)},
+			},
+			Size_bytes: len(produceRequest) - 4,
+		},
+	}
+	if diff := cmp.Diff(expectedRequests, requests, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff in requests: %s", diff)
+	}
+
+	expectedResponses := []akinet.ParsedNetworkContent{
+		akinet.KafkaResponse{
+			StreamID:      streamID,
+			CorrelationID: 1,
+			APIKey:        akinet.KafkaMetadata,
+			APIVersion:    1,
+			RequestSeen:   true,
+			BodyDecoded:   true,
+			Partitions: []akinet.KafkaPartition{
+				{Topic: "orders", Partition: 0},
+				{Topic: "orders", Partition: 1, ErrorCode: 5},
+			},
+			TopicErrorCodes: map[string]int16{"orders": 0},
+			BrokerCount:     1,
+			Size_bytes:      len(metadataResponse) - 4,
+		},
+		akinet.KafkaResponse{
+			StreamID:      streamID,
+			CorrelationID: 2,
+			APIKey:        akinet.KafkaProduce,
+			APIVersion:    3,
+			RequestSeen:   true,
+			BodyDecoded:   true,
+			Partitions: []akinet.KafkaPartition{
+				{Topic: "orders", Partition: 0},
+			},
+			Size_bytes: len(produceResponse) - 4,
+		},
+	}
+	if diff := cmp.Diff(expectedResponses, responses, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff in responses: %s", diff)
+	}
+}
+
+func TestFlexibleFetchAndAPIVersions(t *testing.T) {
+	factory := NewKafkaParserFactory()
+	streamID := uuid.UUID(testBidiID)
+	batch := recordBatch(4)
+
+	apiVersionsRequest := msg(true).requestHeader(akinet.KafkaAPIVersions, 3, 0).
+		str("kafka-go").str("1.0").tags().
+		message()
+	fetchRequest := msg(true).requestHeader(akinet.KafkaFetch, 12, 1).
+		int32(-1).int32(500).int32(1).int32(52428800).int8(0).int32(0).int32(-1).
+		array(1).str("payments").
+		array(1).int32(3).int32(-1).int64(100).int32(-1).int64(-1).int32(1048576).tags().
+		tags().
+		array(0).str("").tags().
+		message()
+
+	// ApiVersions responses use the non-flexible response header.
+	apiVersionsResponse := msg(true).int32(0).
+		int16(0).
+		array(1).int16(int16(akinet.KafkaFetch)).int16(0).int16(13).tags().
+		int32(0).tags().
+		message()
+	fetchResponse := msg(true).int32(1).tags().
+		int32(0).int16(0).int32(0).
+		array(1).str("payments").
+		array(1).int32(3).int16(0).int64(104).int64(104).int64(0).array(0).int32(-1).bytes(batch).tags().
+		tags().
+		tags().
+		message()
+
+	requests := parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, append(apiVersionsRequest, fetchRequest...))
+	responses := parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq, append(apiVersionsResponse, fetchResponse...))
+
+	expected := []akinet.ParsedNetworkContent{
+		akinet.KafkaRequest{
+			StreamID:      streamID,
+			CorrelationID: 0,
+			APIKey:        akinet.KafkaAPIVersions,
+			APIVersion:    3,
+			ClientID:      "app",
+			BodyDecoded:   true,
+			Size_bytes:    len(apiVersionsRequest) - 4,
+		},
+		akinet.KafkaRequest{
+			StreamID:      streamID,
+			CorrelationID: 1,
+			APIKey:        akinet.KafkaFetch,
+			APIVersion:    12,
+			ClientID:      "app",
+			BodyDecoded:   true,
+			Partitions: []akinet.KafkaPartition{
+				{Topic: "payments", Partition: 3},
+			},
+			Size_bytes: len(fetchRequest) - 4,
+		},
+		akinet.KafkaResponse{
+			StreamID:      streamID,
+			CorrelationID: 0,
+			APIKey:        akinet.KafkaAPIVersions,
+			APIVersion:    3,
+			RequestSeen:   true,
+			BodyDecoded:   true,
+			Size_bytes:    len(apiVersionsResponse) - 4,
+		},
+		akinet.KafkaResponse{
+			StreamID:      streamID,
+			CorrelationID: 1,
+			APIKey:        akinet.KafkaFetch,
+			APIVersion:    12,
+			RequestSeen:   true,
+			BodyDecoded:   true,
+			Partitions: []akinet.KafkaPartition{
+				{Topic: "payments", Partition: 3, RecordCount: 4, RecordSize_bytes: len(batch)},
+			},
+			Size_bytes: len(fetchResponse) - 4,
+		},
+	}
+	if diff := cmp.Diff(expected, append(requests, responses...), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+}
+
+func TestResponseWithoutRequest(t *testing.T) {
+	factory := NewKafkaParserFactory()
+	response := msg(false).int32(7).int16(0).int32(0).message()
+
+	// Mark the flow as the server's by parsing a request on the other flow.
+	parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, msg(false).requestHeader(akinet.KafkaHeartbeat, 0, 6).message())
+
+	p := factory.CreateParser(testBidiID, serverSeq, clientSeq)
+	result, _, err := p.Parse(memview.New(response), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := akinet.KafkaResponse{
+		StreamID:      uuid.UUID(testBidiID),
+		CorrelationID: 7,
+		Size_bytes:    len(response) - 4,
+	}
+	if diff := cmp.Diff(expected, result); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+}
+
+func TestFactoryRejectsOtherProtocols(t *testing.T) {
+	testCases := append(parsertest.OtherProtocols("Kafka"),
+		parsertest.RejectTestCase{Name: "client ID longer than request", Input: "\x00\x00\x00\x0e\x00\x00\x00\x00\x00\x00\x00\x01\x7f\xff"},
+	)
+	parsertest.RunRejectTests(t, NewKafkaParserFactory(), testCases)
+}
+
+func TestIncrementalParse(t *testing.T) {
+	testCases := []parsertest.IncrementalTestCase{
+		{
+			Name:  "request",
+			Seq:   clientSeq,
+			Ack:   serverSeq,
+			Input: msg(false).requestHeader(akinet.KafkaMetadata, 0, 9).array(0).message(),
+			Next:  msg(false).requestHeader(akinet.KafkaHeartbeat, 0, 10).message(),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				r, ok := result.(akinet.KafkaRequest)
+				return ok && r.CorrelationID == 9 && r.Topics == nil
+			},
+		},
+		{
+			Name:  "response",
+			Seq:   serverSeq,
+			Ack:   clientSeq,
+			Input: msg(false).int32(9).array(0).array(0).message(),
+			Next:  msg(false).int32(10).int16(0).message(),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				r, ok := result.(akinet.KafkaResponse)
+				return ok && r.CorrelationID == 9 && r.RequestSeen && r.APIKey == akinet.KafkaMetadata
+			},
+		},
+	}
+	parsertest.RunIncrementalTests(t, NewKafkaParserFactory(), testBidiID, testCases)
+}
+
+func TestErrorReturnsAllInput(t *testing.T) {
+	request := msg(false).requestHeader(akinet.KafkaMetadata, 0, 9).array(0).message()
+	unsupported := msg(false).requestHeader(akinet.KafkaMetadata, 99, 10).message()
+
+	// Once a request has been seen, the flow is known to be the client's, and
+	// messages that aren't plausible requests are rejected.
+	factory := NewKafkaParserFactory()
+	parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, request)
+
+	testCases := []parsertest.ErrorTestCase{
+		{
+			Name:  "unsupported request",
+			Seq:   clientSeq,
+			Ack:   serverSeq,
+			Input: [][]byte{unsupported[:6], unsupported[6:]},
+		},
+		{
+			Name:  "end of input",
+			Seq:   clientSeq,
+			Ack:   serverSeq,
+			Input: [][]byte{request[:6], request[6:10]},
+			IsEnd: true,
+		},
+	}
+	parsertest.RunErrorTests(t, factory, testBidiID, testCases)
+}
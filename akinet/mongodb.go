@@ -0,0 +1,143 @@
+package akinet
+
+import (
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Identifies the type of a MongoDB wire protocol message.
+type MongoDBOpCode int32
+
+const (
+	MongoDBOpReply       MongoDBOpCode = 1
+	MongoDBOpUpdate      MongoDBOpCode = 2001
+	MongoDBOpInsert      MongoDBOpCode = 2002
+	MongoDBOpQuery       MongoDBOpCode = 2004
+	MongoDBOpGetMore     MongoDBOpCode = 2005
+	MongoDBOpDelete      MongoDBOpCode = 2006
+	MongoDBOpKillCursors MongoDBOpCode = 2007
+	MongoDBOpCompressed  MongoDBOpCode = 2012
+	MongoDBOpMsg         MongoDBOpCode = 2013
+)
+
+func (c MongoDBOpCode) String() string {
+	switch c {
+	case MongoDBOpReply:
+		return "OP_REPLY"
+	case MongoDBOpUpdate:
+		return "OP_UPDATE"
+	case MongoDBOpInsert:
+		return "OP_INSERT"
+	case MongoDBOpQuery:
+		return "OP_QUERY"
+	case MongoDBOpGetMore:
+		return "OP_GET_MORE"
+	case MongoDBOpDelete:
+		return "OP_DELETE"
+	case MongoDBOpKillCursors:
+		return "OP_KILL_CURSORS"
+	case MongoDBOpCompressed:
+		return "OP_COMPRESSED"
+	case MongoDBOpMsg:
+		return "OP_MSG"
+	}
+	return "MongoDBOpCode(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Represents a command sent by a MongoDB client.
+//
+// The corresponding MongoDBReply has the same stream key. The time taken to
+// handle the command is the difference between the observation times of the
+// two.
+type MongoDBCommand struct {
+	// StreamID and RequestID uniquely identify a pair of command and reply.
+	StreamID  uuid.UUID
+	RequestID int32
+
+	// The type of the message. For compressed messages, this is the type of
+	// the message that was compressed; see Compressor.
+	OpCode MongoDBOpCode
+
+	// The name of the compressor used for an OP_COMPRESSED message: "noop",
+	// "snappy", "zlib" or "zstd". Empty if the message was not compressed.
+	Compressor string
+
+	// Whether the command document was decoded. Messages that can't be
+	// decompressed or decoded are reported without it. For messages that are
+	// too large to buffer, only the command document at the start is decoded.
+	BodyDecoded bool
+
+	// The name of the command, e.g. "find" or "insert". Legacy OP_QUERY
+	// messages that are not commands are reported as "find", and the other
+	// legacy operations are reported as the equivalent command.
+	Command string
+
+	// The database and collection that the command operates on. Collection is
+	// empty for commands that don't take one, such as "hello".
+	Database   string
+	Collection string
+
+	// The number of documents in OP_MSG document sequences or in an
+	// OP_INSERT, e.g. the documents being inserted. Only counted for messages
+	// that are small enough to be decoded in full.
+	DocumentCount int
+
+	// Set if the client does not expect a reply.
+	MoreToCome bool
+
+	// The size of the message, including the header.
+	Size_bytes int
+}
+
+func (MongoDBCommand) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this command with its corresponding
+// reply.
+func (c MongoDBCommand) GetStreamKey() string {
+	return c.StreamID.String() + ":" + strconv.Itoa(int(c.RequestID))
+}
+
+// Represents the reply of a MongoDB server to a command.
+type MongoDBReply struct {
+	// StreamID and ResponseTo uniquely identify a pair of command and reply.
+	// For exhaust cursors, ResponseTo refers to the previous reply.
+	StreamID   uuid.UUID
+	ResponseTo int32
+	RequestID  int32
+
+	// The type of the message. For compressed messages, this is the type of
+	// the message that was compressed; see Compressor.
+	OpCode     MongoDBOpCode
+	Compressor string
+
+	// Whether the reply document was decoded. Replies that are too large to
+	// buffer, or that can't be decompressed or decoded, are reported without
+	// it.
+	BodyDecoded bool
+
+	// Whether the command succeeded, according to the "ok" field of the reply,
+	// or the QueryFailure flag of an OP_REPLY.
+	OK bool
+
+	// The error code and name reported by the server. If the command itself
+	// succeeded, this is the first of its write errors, if any. 0 means no
+	// error.
+	ErrorCode     int32
+	ErrorCodeName string
+
+	// Set if more replies will follow without another command, as happens
+	// with exhaust cursors.
+	MoreToCome bool
+
+	// The size of the message, including the header.
+	Size_bytes int
+}
+
+func (MongoDBReply) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this reply with its corresponding
+// command.
+func (r MongoDBReply) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(int(r.ResponseTo))
+}
@@ -0,0 +1,160 @@
+package mongodb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+var errMalformedBSON = errors.New("malformed BSON document")
+
+// A top-level element of a BSON document. Only the elements that we need are
+// interpreted; the values of the others are kept as raw bytes.
+type element struct {
+	name  string
+	typ   byte
+	value []byte
+}
+
+// Splits a BSON document from the start of buf. Returns the document and the
+// bytes that follow it.
+func readDocument(buf []byte) (doc, rest []byte, err error) {
+	if len(buf) < minDocumentLength_bytes {
+		return nil, nil, errMalformedBSON
+	}
+	n := int64(int32(binary.LittleEndian.Uint32(buf)))
+	if n < minDocumentLength_bytes || n > int64(len(buf)) || buf[n-1] != 0 {
+		return nil, nil, errMalformedBSON
+	}
+	return buf[:n], buf[n:], nil
+}
+
+// Returns the top-level elements of a BSON document.
+func elements(doc []byte) ([]element, error) {
+	doc, _, err := readDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []element
+	buf := doc[4 : len(doc)-1]
+	for len(buf) > 0 {
+		typ := buf[0]
+		end := bytes.IndexByte(buf[1:], 0)
+		if end < 0 {
+			return nil, errMalformedBSON
+		}
+		name := string(buf[1 : 1+end])
+		buf = buf[2+end:]
+
+		n, err := valueLength(typ, buf)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, element{name: name, typ: typ, value: buf[:n]})
+		buf = buf[n:]
+	}
+	return result, nil
+}
+
+// Returns the first element with the given name.
+func lookup(elems []element, name string) (element, bool) {
+	for _, e := range elems {
+		if e.name == name {
+			return e, true
+		}
+	}
+	return element{}, false
+}
+
+// Returns the length of a value of the given type at the start of buf.
+func valueLength(typ byte, buf []byte) (int, error) {
+	var n int
+	switch typ {
+	case bsonUndefined, bsonNull, bsonMinKey, bsonMaxKey:
+		n = 0
+	case bsonBoolean:
+		n = 1
+	case bsonInt32:
+		n = 4
+	case bsonDouble, bsonDateTime, bsonTimestamp, bsonInt64:
+		n = 8
+	case bsonObjectID:
+		n = 12
+	case bsonDecimal128:
+		n = 16
+	case bsonString, bsonJavaScript, bsonSymbol:
+		n = 4 + int(int32OrZero(buf))
+		if n <= 4 {
+			return 0, errMalformedBSON
+		}
+	case bsonDBPointer:
+		n = 4 + int(int32OrZero(buf)) + 12
+		if n <= 16 {
+			return 0, errMalformedBSON
+		}
+	case bsonBinary:
+		n = 5 + int(int32OrZero(buf))
+		if n < 5 {
+			return 0, errMalformedBSON
+		}
+	case bsonDocument, bsonArray, bsonCodeWScope:
+		n = int(int32OrZero(buf))
+		if n < minDocumentLength_bytes {
+			return 0, errMalformedBSON
+		}
+	case bsonRegex:
+		// Two C strings: the pattern and the options.
+		first := bytes.IndexByte(buf, 0)
+		if first < 0 {
+			return 0, errMalformedBSON
+		}
+		second := bytes.IndexByte(buf[first+1:], 0)
+		if second < 0 {
+			return 0, errMalformedBSON
+		}
+		n = first + second + 2
+	default:
+		return 0, errors.Errorf("unknown BSON type 0x%02x", typ)
+	}
+
+	if n > len(buf) {
+		return 0, errMalformedBSON
+	}
+	return n, nil
+}
+
+func int32OrZero(buf []byte) int32 {
+	if len(buf) < 4 {
+		return 0
+	}
+	return int32(binary.LittleEndian.Uint32(buf))
+}
+
+// Returns the value of a string element.
+func (e element) str() (string, bool) {
+	if e.typ != bsonString || len(e.value) < 5 {
+		return "", false
+	}
+	return string(e.value[4 : len(e.value)-1]), true
+}
+
+// Returns the value of a numeric element as a float64.
+func (e element) number() (float64, bool) {
+	switch e.typ {
+	case bsonDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(e.value)), true
+	case bsonInt32:
+		return float64(int32(binary.LittleEndian.Uint32(e.value))), true
+	case bsonInt64:
+		return float64(int64(binary.LittleEndian.Uint64(e.value))), true
+	case bsonBoolean:
+		if e.value[0] != 0 {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,88 @@
+package mongodb
+
+const (
+	// Length of the header that starts every message: messageLength,
+	// requestID, responseTo and opCode.
+	headerLength_bytes = 16
+
+	// Largest message that we parse, matching the server's
+	// maxMessageSizeBytes.
+	maxMessageLength_bytes = 48000000
+
+	// Largest message whose body we decode. The bodies of larger messages are
+	// skipped as they arrive, rather than buffered.
+	maxDecodedLength_bytes = 16 * 1024 * 1024
+
+	// Number of bytes at the start of a message that are kept when its body
+	// is too large to decode. The command document of an OP_MSG comes first,
+	// and is almost always smaller than this.
+	maxHeaderLength_bytes = 64 * 1024
+
+	// Length of the fields of an OP_COMPRESSED message that precede the
+	// compressed message: originalOpcode, uncompressedSize and compressorId.
+	compressedHeaderLength_bytes = 9
+)
+
+// OP_MSG flag bits.
+const (
+	msgChecksumPresent = 1 << 0
+	msgMoreToCome      = 1 << 1
+
+	// Bits 0-15 must be understood by the receiver, so no others are set in
+	// practice.
+	msgRequiredBits = 0xFFFF
+)
+
+// OP_MSG section kinds.
+const (
+	sectionBody             = 0
+	sectionDocumentSequence = 1
+)
+
+// OP_REPLY flag bits.
+const (
+	replyQueryFailure = 1 << 1
+)
+
+// Compressor IDs used in OP_COMPRESSED messages.
+const (
+	compressorNoop   = 0
+	compressorSnappy = 1
+	compressorZlib   = 2
+	compressorZstd   = 3
+)
+
+var compressorNames = map[byte]string{
+	compressorNoop:   "noop",
+	compressorSnappy: "snappy",
+	compressorZlib:   "zlib",
+	compressorZstd:   "zstd",
+}
+
+// BSON element types.
+const (
+	bsonDouble     = 0x01
+	bsonString     = 0x02
+	bsonDocument   = 0x03
+	bsonArray      = 0x04
+	bsonBinary     = 0x05
+	bsonUndefined  = 0x06
+	bsonObjectID   = 0x07
+	bsonBoolean    = 0x08
+	bsonDateTime   = 0x09
+	bsonNull       = 0x0A
+	bsonRegex      = 0x0B
+	bsonDBPointer  = 0x0C
+	bsonJavaScript = 0x0D
+	bsonSymbol     = 0x0E
+	bsonCodeWScope = 0x0F
+	bsonInt32      = 0x10
+	bsonTimestamp  = 0x11
+	bsonInt64      = 0x12
+	bsonDecimal128 = 0x13
+	bsonMinKey     = 0xFF
+	bsonMaxKey     = 0x7F
+
+	// Length of the length prefix and terminator of an empty document.
+	minDocumentLength_bytes = 5
+)
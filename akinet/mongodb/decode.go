@@ -0,0 +1,156 @@
+package mongodb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+var errMalformedMessage = errors.New("malformed MongoDB message")
+
+// Reads little-endian fields from a message body. Errors are sticky: once a
+// read fails, all later reads return zero values, and err is set.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) fixed(n int) []byte {
+	if r.err != nil || n < 0 || len(r.buf) < n {
+		r.err = errMalformedMessage
+		return nil
+	}
+	result := r.buf[:n]
+	r.buf = r.buf[n:]
+	return result
+}
+
+func (r *reader) uint8() uint8 {
+	if b := r.fixed(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) int32() int32 {
+	if b := r.fixed(4); b != nil {
+		return int32(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	return uint32(r.int32())
+}
+
+func (r *reader) int64() int64 {
+	if b := r.fixed(8); b != nil {
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+// Reads a null-terminated string.
+func (r *reader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.buf, 0)
+	if end < 0 {
+		r.err = errMalformedMessage
+		return ""
+	}
+	result := string(r.buf[:end])
+	r.buf = r.buf[end+1:]
+	return result
+}
+
+// Reads a BSON document.
+func (r *reader) document() []byte {
+	if r.err != nil {
+		return nil
+	}
+	doc, rest, err := readDocument(r.buf)
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	r.buf = rest
+	return doc
+}
+
+// The header that starts every message.
+type header struct {
+	length     int32
+	requestID  int32
+	responseTo int32
+	opCode     akinet.MongoDBOpCode
+}
+
+func parseHeader(buf []byte) header {
+	return header{
+		length:     int32(binary.LittleEndian.Uint32(buf[0:])),
+		requestID:  int32(binary.LittleEndian.Uint32(buf[4:])),
+		responseTo: int32(binary.LittleEndian.Uint32(buf[8:])),
+		opCode:     akinet.MongoDBOpCode(binary.LittleEndian.Uint32(buf[12:])),
+	}
+}
+
+// Unwraps the body of an OP_COMPRESSED message. Returns the opcode of the
+// original message, the name of the compressor, and the original body.
+func decompress(body []byte) (opCode akinet.MongoDBOpCode, compressor string, result []byte, err error) {
+	r := reader{buf: body}
+	opCode = akinet.MongoDBOpCode(r.int32())
+	size := int64(r.int32())
+	compressorID := r.uint8()
+	if r.err != nil {
+		return 0, "", nil, r.err
+	}
+
+	compressor, ok := compressorNames[compressorID]
+	if !ok {
+		return opCode, "", nil, errors.Errorf("unknown MongoDB compressor %d", compressorID)
+	}
+	if size < 0 || size > maxDecodedLength_bytes {
+		return opCode, compressor, nil, errors.Errorf("invalid uncompressed size %d", size)
+	}
+
+	switch compressorID {
+	case compressorNoop:
+		result = r.buf
+	case compressorSnappy:
+		if n, err := snappy.DecodedLen(r.buf); err != nil {
+			return opCode, compressor, nil, err
+		} else if int64(n) != size {
+			return opCode, compressor, nil, errors.Errorf("expected %d bytes after decompression, got %d", size, n)
+		}
+		result, err = snappy.Decode(nil, r.buf)
+	case compressorZlib:
+		var zr io.ReadCloser
+		if zr, err = zlib.NewReader(bytes.NewReader(r.buf)); err == nil {
+			defer zr.Close()
+			result, err = ioutil.ReadAll(io.LimitReader(zr, size+1))
+		}
+	case compressorZstd:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(bytes.NewReader(r.buf), zstd.WithDecoderConcurrency(1)); err == nil {
+			defer zr.Close()
+			result, err = ioutil.ReadAll(io.LimitReader(zr, size+1))
+		}
+	}
+	if err != nil {
+		return opCode, compressor, nil, errors.Wrapf(err, "failed to decompress %s message", compressor)
+	}
+	if int64(len(result)) != size {
+		return opCode, compressor, nil, errors.Errorf("expected %d bytes after decompression, got %d", size, len(result))
+	}
+	return opCode, compressor, result, nil
+}
@@ -0,0 +1,327 @@
+package mongodb
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// The number of bytes at the start of a message that checkMessageStart looks
+// at.
+const messageStartLength_bytes = headerLength_bytes + compressedHeaderLength_bytes
+
+// Determines whether buf looks like the start of a MongoDB message. The
+// header is checked first, followed by the first few bytes of the body,
+// depending on the opcode.
+func checkMessageStart(buf []byte) akinet.AcceptDecision {
+	if len(buf) < headerLength_bytes {
+		return akinet.NeedMoreData
+	}
+	h := parseHeader(buf)
+	if h.length < headerLength_bytes || h.length > maxMessageLength_bytes {
+		return akinet.Reject
+	}
+	if !isClientOpCode(h.opCode) && h.opCode != akinet.MongoDBOpReply && h.opCode != akinet.MongoDBOpCompressed && h.opCode != akinet.MongoDBOpMsg {
+		return akinet.Reject
+	}
+	// Clients don't send legacy requests in response to anything, and replies
+	// always respond to something.
+	if isClientOpCode(h.opCode) && h.responseTo != 0 {
+		return akinet.Reject
+	}
+	if h.opCode == akinet.MongoDBOpReply && h.responseTo == 0 {
+		return akinet.Reject
+	}
+
+	switch h.opCode {
+	case akinet.MongoDBOpMsg:
+		// flagBits and the kind of the first section.
+		if h.length < headerLength_bytes+4+1+minDocumentLength_bytes {
+			return akinet.Reject
+		}
+		if len(buf) < headerLength_bytes+5 {
+			return akinet.NeedMoreData
+		}
+		flags := binary.LittleEndian.Uint32(buf[headerLength_bytes:])
+		if flags&msgRequiredBits&^(msgChecksumPresent|msgMoreToCome) != 0 {
+			return akinet.Reject
+		}
+		if kind := buf[headerLength_bytes+4]; kind != sectionBody && kind != sectionDocumentSequence {
+			return akinet.Reject
+		}
+
+	case akinet.MongoDBOpQuery, akinet.MongoDBOpReply:
+		// Only the low bits of the flags are in use.
+		if len(buf) < headerLength_bytes+4 {
+			return akinet.NeedMoreData
+		}
+		flags := binary.LittleEndian.Uint32(buf[headerLength_bytes:])
+		if flags > 0xFF {
+			return akinet.Reject
+		}
+
+	case akinet.MongoDBOpCompressed:
+		if h.length < messageStartLength_bytes {
+			return akinet.Reject
+		}
+		if len(buf) < messageStartLength_bytes {
+			return akinet.NeedMoreData
+		}
+		r := reader{buf: buf[headerLength_bytes:]}
+		original := akinet.MongoDBOpCode(r.int32())
+		size := r.int32()
+		compressorID := r.uint8()
+		if original == akinet.MongoDBOpCompressed || !isClientOpCode(original) && original != akinet.MongoDBOpReply && original != akinet.MongoDBOpMsg {
+			return akinet.Reject
+		}
+		if size < 0 || size > maxMessageLength_bytes {
+			return akinet.Reject
+		}
+		if _, ok := compressorNames[compressorID]; !ok {
+			return akinet.Reject
+		}
+	}
+	return akinet.Accept
+}
+
+// Whether the opcode is for a legacy message that is only sent by clients.
+func isClientOpCode(opCode akinet.MongoDBOpCode) bool {
+	switch opCode {
+	case akinet.MongoDBOpUpdate, akinet.MongoDBOpInsert, akinet.MongoDBOpQuery,
+		akinet.MongoDBOpGetMore, akinet.MongoDBOpDelete, akinet.MongoDBOpKillCursors:
+		return true
+	}
+	return false
+}
+
+// Decodes the body of a command, which follows the message header. If complete
+// is false, body is only the start of a large message, and only the command
+// document is decoded.
+func decodeCommand(opCode akinet.MongoDBOpCode, body []byte, complete bool, cmd *akinet.MongoDBCommand) error {
+	r := reader{buf: body}
+
+	switch opCode {
+	case akinet.MongoDBOpMsg:
+		flags := r.uint32()
+		cmd.MoreToCome = flags&msgMoreToCome != 0
+		doc, count, err := readSections(&r, flags, complete)
+		if err != nil {
+			return err
+		}
+		if complete {
+			cmd.DocumentCount = count
+		}
+		return applyCommandDocument(doc, cmd)
+
+	case akinet.MongoDBOpQuery:
+		r.int32() // flags
+		database, collection := splitNamespace(r.cstring())
+		r.int32() // numberToSkip
+		r.int32() // numberToReturn
+		doc := r.document()
+		if r.err != nil {
+			return r.err
+		}
+		cmd.Database = database
+		if collection != "$cmd" {
+			cmd.Command = "find"
+			cmd.Collection = collection
+			return nil
+		}
+		return applyCommandDocument(unwrapQuery(doc), cmd)
+
+	case akinet.MongoDBOpInsert, akinet.MongoDBOpUpdate, akinet.MongoDBOpDelete, akinet.MongoDBOpGetMore:
+		r.int32() // flags, or reserved
+		cmd.Database, cmd.Collection = splitNamespace(r.cstring())
+		cmd.Command = legacyCommandNames[opCode]
+		if opCode == akinet.MongoDBOpInsert && complete {
+			for r.err == nil && len(r.buf) > 0 {
+				r.document()
+				cmd.DocumentCount++
+			}
+		}
+		return r.err
+
+	case akinet.MongoDBOpKillCursors:
+		cmd.Command = legacyCommandNames[opCode]
+		return nil
+	}
+	return errors.Errorf("unexpected opcode %s for a MongoDB command", opCode)
+}
+
+var legacyCommandNames = map[akinet.MongoDBOpCode]string{
+	akinet.MongoDBOpInsert:      "insert",
+	akinet.MongoDBOpUpdate:      "update",
+	akinet.MongoDBOpDelete:      "delete",
+	akinet.MongoDBOpGetMore:     "getMore",
+	akinet.MongoDBOpKillCursors: "killCursors",
+}
+
+// Reads the sections of an OP_MSG. Returns the body document and the number of
+// documents in document sequences. If complete is false, the sections may be
+// cut short, and reading stops without error once the body has been read.
+func readSections(r *reader, flags uint32, complete bool) (body []byte, count int, err error) {
+	if flags&msgChecksumPresent != 0 && complete {
+		if len(r.buf) < 4 {
+			return nil, 0, errMalformedMessage
+		}
+		r.buf = r.buf[:len(r.buf)-4]
+	}
+
+	for r.err == nil && len(r.buf) > 0 {
+		if !complete && body != nil {
+			break
+		}
+		switch kind := r.uint8(); kind {
+		case sectionBody:
+			if body != nil {
+				return nil, 0, errors.New("OP_MSG has more than one body section")
+			}
+			body = r.document()
+		case sectionDocumentSequence:
+			size := int(r.int32())
+			seq := reader{buf: r.fixed(size - 4)}
+			seq.cstring() // identifier
+			for seq.err == nil && len(seq.buf) > 0 {
+				seq.document()
+				count++
+			}
+			if seq.err != nil {
+				return nil, 0, seq.err
+			}
+		default:
+			return nil, 0, errors.Errorf("unknown OP_MSG section kind %d", kind)
+		}
+	}
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if body == nil {
+		return nil, 0, errors.New("OP_MSG has no body section")
+	}
+	return body, count, nil
+}
+
+// Fills in the command name, database and collection from a command document.
+func applyCommandDocument(doc []byte, cmd *akinet.MongoDBCommand) error {
+	elems, err := elements(doc)
+	if err != nil {
+		return err
+	}
+	if len(elems) == 0 {
+		return errors.New("empty MongoDB command document")
+	}
+
+	// The command name is always the first key. For most commands that
+	// operate on a collection, its value is the collection name.
+	cmd.Command = elems[0].name
+	if collection, ok := elems[0].str(); ok {
+		cmd.Collection = collection
+	} else if e, ok := lookup(elems, "collection"); ok && cmd.Command == "getMore" {
+		cmd.Collection, _ = e.str()
+	}
+	if e, ok := lookup(elems, "$db"); ok {
+		cmd.Database, _ = e.str()
+	}
+	return nil
+}
+
+// Returns the command wrapped in an OP_QUERY of the form {$query: {...}}, as
+// sent by older drivers when read preferences are set.
+func unwrapQuery(doc []byte) []byte {
+	elems, err := elements(doc)
+	if err != nil || len(elems) == 0 {
+		return doc
+	}
+	if (elems[0].name == "$query" || elems[0].name == "query") && elems[0].typ == bsonDocument {
+		return elems[0].value
+	}
+	return doc
+}
+
+// Splits a namespace of the form "db.collection".
+func splitNamespace(ns string) (database, collection string) {
+	if i := strings.IndexByte(ns, '.'); i >= 0 {
+		return ns[:i], ns[i+1:]
+	}
+	return ns, ""
+}
+
+// Decodes the body of a reply, which follows the message header.
+func decodeReply(opCode akinet.MongoDBOpCode, body []byte, reply *akinet.MongoDBReply) error {
+	r := reader{buf: body}
+
+	switch opCode {
+	case akinet.MongoDBOpMsg:
+		flags := r.uint32()
+		reply.MoreToCome = flags&msgMoreToCome != 0
+		doc, _, err := readSections(&r, flags, true)
+		if err != nil {
+			return err
+		}
+		return applyReplyDocument(doc, false, reply)
+
+	case akinet.MongoDBOpReply:
+		flags := r.int32()
+		r.int64() // cursorID
+		r.int32() // startingFrom
+		numberReturned := r.int32()
+		if r.err != nil {
+			return r.err
+		}
+		queryFailure := flags&replyQueryFailure != 0
+		if numberReturned == 0 {
+			reply.OK = !queryFailure
+			return nil
+		}
+		doc := r.document()
+		if r.err != nil {
+			return r.err
+		}
+		return applyReplyDocument(doc, !queryFailure, reply)
+	}
+	return errors.Errorf("unexpected opcode %s for a MongoDB reply", opCode)
+}
+
+// Fills in the status of a reply from its first document. If the document has
+// no "ok" field, as is the case for replies to legacy queries, defaultOK is
+// used.
+func applyReplyDocument(doc []byte, defaultOK bool, reply *akinet.MongoDBReply) error {
+	elems, err := elements(doc)
+	if err != nil {
+		return err
+	}
+
+	reply.OK = defaultOK
+	if e, ok := lookup(elems, "ok"); ok {
+		v, _ := e.number()
+		reply.OK = v == 1
+	}
+	reply.ErrorCode, reply.ErrorCodeName = errorCode(elems)
+
+	if reply.OK && reply.ErrorCode == 0 {
+		// Report the first write error of a successful write command.
+		if e, ok := lookup(elems, "writeErrors"); ok && e.typ == bsonArray {
+			if writeErrors, err := elements(e.value); err == nil && len(writeErrors) > 0 && writeErrors[0].typ == bsonDocument {
+				if writeError, err := elements(writeErrors[0].value); err == nil {
+					reply.ErrorCode, reply.ErrorCodeName = errorCode(writeError)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func errorCode(elems []element) (code int32, name string) {
+	if e, ok := lookup(elems, "code"); ok {
+		v, _ := e.number()
+		code = int32(v)
+	}
+	if e, ok := lookup(elems, "codeName"); ok {
+		name, _ = e.str()
+	}
+	return code, name
+}
@@ -0,0 +1,159 @@
+package mongodb
+
+import (
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+func newMongoDBParser(bidiID akinet.TCPBidiID) *mongoDBParser {
+	return &mongoDBParser{
+		bidiID: bidiID,
+		input:  akinet.NewRetainedInput(0),
+	}
+}
+
+// Parses a single MongoDB command or reply.
+type mongoDBParser struct {
+	bidiID akinet.TCPBidiID
+
+	// The input, which is returned as unused if the message can't be parsed.
+	input akinet.RetainedInput
+
+	// Input that has not been consumed yet. The header is dropped once it has
+	// been read, and the rest of a large message as it is skipped.
+	allInput memview.MemView
+
+	// The header of the message, once it has been read.
+	header *header
+
+	// Set when the message is too large to decode. The start of the body is
+	// kept in head, and the rest is skipped.
+	head      []byte
+	remaining int64
+}
+
+var _ akinet.TCPParser = (*mongoDBParser)(nil)
+
+func (*mongoDBParser) Name() string {
+	return "MongoDB Parser"
+}
+
+func (p *mongoDBParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	p.input.Append(input)
+	p.allInput.Append(input)
+
+	result, err = p.parse()
+	if err != nil {
+		return nil, p.input.Unused(p.allInput), err
+	}
+	if result == nil {
+		if isEnd {
+			return nil, p.input.Unused(p.allInput), io.ErrUnexpectedEOF
+		}
+		return nil, memview.MemView{}, nil
+	}
+	return result, p.allInput, nil
+}
+
+func (p *mongoDBParser) parse() (akinet.ParsedNetworkContent, error) {
+	if p.header == nil {
+		if p.allInput.Len() < headerLength_bytes {
+			return nil, nil
+		}
+		h := parseHeader([]byte(p.allInput.SubView(0, headerLength_bytes).String()))
+		if h.length < headerLength_bytes || h.length > maxMessageLength_bytes {
+			return nil, errors.Errorf("invalid MongoDB message length %d", h.length)
+		}
+		p.header = &h
+		p.allInput = p.allInput.SubView(headerLength_bytes, p.allInput.Len())
+	}
+	bodyLength := int64(p.header.length) - headerLength_bytes
+
+	if p.head != nil {
+		// Skip the rest of a large message.
+		n := p.allInput.Len()
+		if n > p.remaining {
+			n = p.remaining
+		}
+		p.allInput = p.allInput.SubView(n, p.allInput.Len())
+		p.remaining -= n
+		if p.remaining > 0 {
+			return nil, nil
+		}
+		return p.getResult(p.head, false), nil
+	}
+
+	if bodyLength > maxDecodedLength_bytes {
+		if p.allInput.Len() < maxHeaderLength_bytes {
+			return nil, nil
+		}
+		p.head = []byte(p.allInput.SubView(0, maxHeaderLength_bytes).String())
+		p.remaining = bodyLength - maxHeaderLength_bytes
+		p.allInput = p.allInput.SubView(maxHeaderLength_bytes, p.allInput.Len())
+		return p.parse()
+	}
+
+	if p.allInput.Len() < bodyLength {
+		return nil, nil
+	}
+	body := []byte(p.allInput.SubView(0, bodyLength).String())
+	p.allInput = p.allInput.SubView(bodyLength, p.allInput.Len())
+	return p.getResult(body, true), nil
+}
+
+// Builds the result from the given message body. If complete is false, the
+// body is only the start of a large message. The command document is decoded
+// from it, if it isn't compressed, but replies are not decoded.
+//
+// Bodies that fail to decode are reported without the decoded fields, since
+// the framing of the message was still valid.
+func (p *mongoDBParser) getResult(body []byte, complete bool) akinet.ParsedNetworkContent {
+	opCode, compressor := p.header.opCode, ""
+	decodable := true
+	if opCode == akinet.MongoDBOpCompressed {
+		var err error
+		opCode, compressor, body, err = decompress(body)
+		decodable = complete && err == nil
+	}
+	isReply := p.header.responseTo != 0 || opCode == akinet.MongoDBOpReply
+
+	if isReply {
+		reply := akinet.MongoDBReply{
+			StreamID:   uuid.UUID(p.bidiID),
+			ResponseTo: p.header.responseTo,
+			RequestID:  p.header.requestID,
+			OpCode:     opCode,
+			Compressor: compressor,
+			Size_bytes: int(p.header.length),
+		}
+		if decodable && complete {
+			decoded := reply
+			if err := decodeReply(opCode, body, &decoded); err == nil {
+				decoded.BodyDecoded = true
+				return decoded
+			}
+		}
+		return reply
+	}
+
+	cmd := akinet.MongoDBCommand{
+		StreamID:   uuid.UUID(p.bidiID),
+		RequestID:  p.header.requestID,
+		OpCode:     opCode,
+		Compressor: compressor,
+		Size_bytes: int(p.header.length),
+	}
+	if decodable {
+		decoded := cmd
+		if err := decodeCommand(opCode, body, complete, &decoded); err == nil {
+			decoded.BodyDecoded = true
+			return decoded
+		}
+	}
+	return cmd
+}
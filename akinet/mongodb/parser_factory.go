@@ -0,0 +1,42 @@
+package mongodb
+
+import (
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a parser factory for both halves of a MongoDB connection. Commands
+// and replies are told apart by the responseTo field of the message header,
+// so no state is shared between the two flows.
+func NewMongoDBParserFactory() akinet.TCPParserFactory {
+	return mongoDBParserFactory{}
+}
+
+type mongoDBParserFactory struct{}
+
+func (mongoDBParserFactory) Name() string {
+	return "MongoDB Parser Factory"
+}
+
+func (mongoDBParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	n := input.Len()
+	if n > messageStartLength_bytes {
+		n = messageStartLength_bytes
+	}
+	decision = checkMessageStart([]byte(input.SubView(0, n).String()))
+
+	switch {
+	case decision == akinet.Reject:
+		discardFront = input.Len()
+	case decision == akinet.NeedMoreData && isEnd:
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+	return decision, discardFront
+}
+
+func (mongoDBParserFactory) CreateParser(id akinet.TCPBidiID, _, _ reassembly.Sequence) akinet.TCPParser {
+	return newMongoDBParser(id)
+}
@@ -0,0 +1,432 @@
+package mongodb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/akinet/internal/parsertest"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var testBidiID = akinet.TCPBidiID(uuid.MustParse("b4d2e6f8-3a1c-4e5b-9d7f-0c2a4e6b8d13"))
+
+// An embedded BSON array, given as its elements.
+type testArray []interface{}
+
+// Builds a BSON document from alternating keys and values. Values may be
+// strings, float64s, int32s, int64s, bools, embedded documents ([]byte) or
+// arrays.
+func bsonDoc(kv ...interface{}) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(kv); i += 2 {
+		key := kv[i].(string)
+		writeElement := func(typ byte) {
+			buf.WriteByte(typ)
+			buf.WriteString(key)
+			buf.WriteByte(0)
+		}
+		switch v := kv[i+1].(type) {
+		case string:
+			writeElement(bsonString)
+			binary.Write(&buf, binary.LittleEndian, int32(len(v)+1))
+			buf.WriteString(v)
+			buf.WriteByte(0)
+		case float64:
+			writeElement(bsonDouble)
+			binary.Write(&buf, binary.LittleEndian, math.Float64bits(v))
+		case int32:
+			writeElement(bsonInt32)
+			binary.Write(&buf, binary.LittleEndian, v)
+		case int64:
+			writeElement(bsonInt64)
+			binary.Write(&buf, binary.LittleEndian, v)
+		case bool:
+			writeElement(bsonBoolean)
+			if v {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+		case []byte:
+			writeElement(bsonDocument)
+			buf.Write(v)
+		case testArray:
+			writeElement(bsonArray)
+			elems := make([]interface{}, 0, 2*len(v))
+			for j, e := range v {
+				elems = append(elems, strconv.Itoa(j), e)
+			}
+			buf.Write(bsonDoc(elems...))
+		default:
+			panic("unsupported BSON value")
+		}
+	}
+
+	result := make([]byte, 4, buf.Len()+5)
+	binary.LittleEndian.PutUint32(result, uint32(buf.Len()+5))
+	result = append(result, buf.Bytes()...)
+	return append(result, 0)
+}
+
+func int32Bytes(v int32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	return b
+}
+
+func cstring(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// Builds a message with the given header fields and body.
+func message(requestID, responseTo int32, opCode akinet.MongoDBOpCode, body ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, b := range body {
+		buf.Write(b)
+	}
+	result := bytes.Join([][]byte{
+		int32Bytes(int32(headerLength_bytes + buf.Len())),
+		int32Bytes(requestID),
+		int32Bytes(responseTo),
+		int32Bytes(int32(opCode)),
+	}, nil)
+	return append(result, buf.Bytes()...)
+}
+
+// Builds an OP_MSG body with a body section and optional document sequence.
+func opMsg(flags uint32, doc []byte, sequence ...[]byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, flags)
+	buf.WriteByte(sectionBody)
+	buf.Write(doc)
+	if len(sequence) > 0 {
+		var seq bytes.Buffer
+		seq.Write(cstring("documents"))
+		for _, d := range sequence {
+			seq.Write(d)
+		}
+		buf.WriteByte(sectionDocumentSequence)
+		binary.Write(&buf, binary.LittleEndian, int32(seq.Len()+4))
+		buf.Write(seq.Bytes())
+	}
+	return buf.Bytes()
+}
+
+// Wraps a message in an OP_COMPRESSED message.
+func compressed(t *testing.T, compressorID byte, msg []byte) []byte {
+	h := parseHeader(msg)
+	body := msg[headerLength_bytes:]
+
+	var data []byte
+	switch compressorID {
+	case compressorNoop:
+		data = body
+	case compressorSnappy:
+		data = snappy.Encode(nil, body)
+	case compressorZlib:
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(body)
+		w.Close()
+		data = buf.Bytes()
+	case compressorZstd:
+		w, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = w.EncodeAll(body, nil)
+		w.Close()
+	}
+	return message(h.requestID, h.responseTo, akinet.MongoDBOpCompressed,
+		int32Bytes(int32(h.opCode)), int32Bytes(int32(len(body))), []byte{compressorID}, data)
+}
+
+func TestOpMsg(t *testing.T) {
+	streamID := uuid.UUID(testBidiID)
+	find := message(1, 0, akinet.MongoDBOpMsg, opMsg(0,
+		bsonDoc("find", "users", "filter", bsonDoc("name", "alice"), "$db", "app")))
+	findReply := message(101, 1, akinet.MongoDBOpMsg, opMsg(0,
+		bsonDoc("cursor", bsonDoc("id", int64(0), "ns", "app.users"), "ok", 1.0)))
+	insert := message(2, 0, akinet.MongoDBOpMsg, opMsg(0,
+		bsonDoc("insert", "users", "ordered", true, "$db", "app"),
+		bsonDoc("_id", int32(1)), bsonDoc("_id", int32(2))))
+	insertReply := message(102, 2, akinet.MongoDBOpMsg, opMsg(0,
+		bsonDoc("n", int32(1), "writeErrors", testArray{bsonDoc("index", int32(1), "code", int32(11000))}, "ok", 1.0)))
+	hello := message(3, 0, akinet.MongoDBOpMsg, opMsg(msgMoreToCome,
+		bsonDoc("hello", int32(1), "$db", "admin")))
+	unauthorized := message(103, 3, akinet.MongoDBOpMsg, opMsg(0,
+		bsonDoc("ok", 0.0, "errmsg", "not authorized", "code", int32(13), "codeName", "Unauthorized")))
+
+	expected := []akinet.ParsedNetworkContent{
+		akinet.MongoDBCommand{
+			StreamID:    streamID,
+			RequestID:   1,
+			OpCode:      akinet.MongoDBOpMsg,
+			BodyDecoded: true,
+			Command:     "find",
+			Database:    "app",
+			Collection:  "users",
+			Size_bytes:  len(find),
+		},
+		akinet.MongoDBReply{
+			StreamID:    streamID,
+			ResponseTo:  1,
+			RequestID:   101,
+			OpCode:      akinet.MongoDBOpMsg,
+			BodyDecoded: true,
+			OK:          true,
+			Size_bytes:  len(findReply),
+		},
+		akinet.MongoDBCommand{
+			StreamID:      streamID,
+			RequestID:     2,
+			OpCode:        akinet.MongoDBOpMsg,
+			BodyDecoded:   true,
+			Command:       "insert",
+			Database:      "app",
+			Collection:    "users",
+			DocumentCount: 2,
+			Size_bytes:    len(insert),
+		},
+		akinet.MongoDBReply{
+			StreamID:    streamID,
+			ResponseTo:  2,
+			RequestID:   102,
+			OpCode:      akinet.MongoDBOpMsg,
+			BodyDecoded: true,
+			OK:          true,
+			ErrorCode:   11000,
+			Size_bytes:  len(insertReply),
+		},
+		akinet.MongoDBCommand{
+			StreamID:    streamID,
+			RequestID:   3,
+			OpCode:      akinet.MongoDBOpMsg,
+			BodyDecoded: true,
+			Command:     "hello",
+			Database:    "admin",
+			MoreToCome:  true,
+			Size_bytes:  len(hello),
+		},
+		akinet.MongoDBReply{
+			StreamID:      streamID,
+			ResponseTo:    3,
+			RequestID:     103,
+			OpCode:        akinet.MongoDBOpMsg,
+			BodyDecoded:   true,
+			ErrorCode:     13,
+			ErrorCodeName: "Unauthorized",
+			Size_bytes:    len(unauthorized),
+		},
+	}
+
+	data := bytes.Join([][]byte{find, findReply, insert, insertReply, hello, unauthorized}, nil)
+	results := parsertest.ParseAll(t, NewMongoDBParserFactory(), testBidiID, 0, 0, data)
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+	if results[0].(akinet.MongoDBCommand).GetStreamKey() != results[1].(akinet.MongoDBReply).GetStreamKey() {
+		t.Errorf("expected command and reply to have the same stream key")
+	}
+}
+
+func TestLegacyOpcodes(t *testing.T) {
+	streamID := uuid.UUID(testBidiID)
+	isMaster := message(1, 0, akinet.MongoDBOpQuery,
+		int32Bytes(0), cstring("admin.$cmd"), int32Bytes(0), int32Bytes(-1),
+		bsonDoc("$query", bsonDoc("isMaster", int32(1)), "$readPreference", bsonDoc("mode", "primary")))
+	isMasterReply := message(101, 1, akinet.MongoDBOpReply,
+		int32Bytes(8), make([]byte, 8), int32Bytes(0), int32Bytes(1),
+		bsonDoc("ismaster", true, "ok", 1.0))
+	query := message(2, 0, akinet.MongoDBOpQuery,
+		int32Bytes(0), cstring("app.users"), int32Bytes(0), int32Bytes(10),
+		bsonDoc("name", "bob"))
+	queryFailure := message(102, 2, akinet.MongoDBOpReply,
+		int32Bytes(replyQueryFailure), make([]byte, 8), int32Bytes(0), int32Bytes(1),
+		bsonDoc("$err", "bad query", "code", int32(2)))
+	insert := message(3, 0, akinet.MongoDBOpInsert,
+		int32Bytes(0), cstring("app.users"), bsonDoc("_id", int32(1)), bsonDoc("_id", int32(2)))
+
+	expected := []akinet.ParsedNetworkContent{
+		akinet.MongoDBCommand{
+			StreamID:    streamID,
+			RequestID:   1,
+			OpCode:      akinet.MongoDBOpQuery,
+			BodyDecoded: true,
+			Command:     "isMaster",
+			Database:    "admin",
+			Size_bytes:  len(isMaster),
+		},
+		akinet.MongoDBReply{
+			StreamID:    streamID,
+			ResponseTo:  1,
+			RequestID:   101,
+			OpCode:      akinet.MongoDBOpReply,
+			BodyDecoded: true,
+			OK:          true,
+			Size_bytes:  len(isMasterReply),
+		},
+		akinet.MongoDBCommand{
+			StreamID:    streamID,
+			RequestID:   2,
+			OpCode:      akinet.MongoDBOpQuery,
+			BodyDecoded: true,
+			Command:     "find",
+			Database:    "app",
+			Collection:  "users",
+			Size_bytes:  len(query),
+		},
+		akinet.MongoDBReply{
+			StreamID:    streamID,
+			ResponseTo:  2,
+			RequestID:   102,
+			OpCode:      akinet.MongoDBOpReply,
+			BodyDecoded: true,
+			ErrorCode:   2,
+			Size_bytes:  len(queryFailure),
+		},
+		akinet.MongoDBCommand{
+			StreamID:      streamID,
+			RequestID:     3,
+			OpCode:        akinet.MongoDBOpInsert,
+			BodyDecoded:   true,
+			Command:       "insert",
+			Database:      "app",
+			Collection:    "users",
+			DocumentCount: 2,
+			Size_bytes:    len(insert),
+		},
+	}
+
+	data := bytes.Join([][]byte{isMaster, isMasterReply, query, queryFailure, insert}, nil)
+	results := parsertest.ParseAll(t, NewMongoDBParserFactory(), testBidiID, 0, 0, data)
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+}
+
+func TestCompressed(t *testing.T) {
+	find := message(7, 0, akinet.MongoDBOpMsg, opMsg(0,
+		bsonDoc("find", "orders", "$db", "shop")))
+	reply := message(8, 7, akinet.MongoDBOpMsg, opMsg(0,
+		bsonDoc("ok", 0.0, "code", int32(50), "codeName", "MaxTimeMSExpired")))
+
+	for compressorID, name := range compressorNames {
+		cmdMsg := compressed(t, compressorID, find)
+		replyMsg := compressed(t, compressorID, reply)
+		expected := []akinet.ParsedNetworkContent{
+			akinet.MongoDBCommand{
+				StreamID:    uuid.UUID(testBidiID),
+				RequestID:   7,
+				OpCode:      akinet.MongoDBOpMsg,
+				Compressor:  name,
+				BodyDecoded: true,
+				Command:     "find",
+				Database:    "shop",
+				Collection:  "orders",
+				Size_bytes:  len(cmdMsg),
+			},
+			akinet.MongoDBReply{
+				StreamID:      uuid.UUID(testBidiID),
+				ResponseTo:    7,
+				RequestID:     8,
+				OpCode:        akinet.MongoDBOpMsg,
+				Compressor:    name,
+				BodyDecoded:   true,
+				ErrorCode:     50,
+				ErrorCodeName: "MaxTimeMSExpired",
+				Size_bytes:    len(replyMsg),
+			},
+		}
+
+		results := parsertest.ParseAll(t, NewMongoDBParserFactory(), testBidiID, 0, 0, append(cmdMsg, replyMsg...))
+		if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("[%s] found unexpected diff:\n%s", name, diff)
+		}
+	}
+}
+
+func TestUndecodableBody(t *testing.T) {
+	// The uncompressed size doesn't match the data, so the message is reported
+	// without its command document.
+	find := message(7, 0, akinet.MongoDBOpMsg, opMsg(0, bsonDoc("find", "orders", "$db", "shop")))
+	msg := compressed(t, compressorSnappy, find)
+	binary.LittleEndian.PutUint32(msg[headerLength_bytes+4:], 1000)
+
+	expected := []akinet.ParsedNetworkContent{
+		akinet.MongoDBCommand{
+			StreamID:   uuid.UUID(testBidiID),
+			RequestID:  7,
+			OpCode:     akinet.MongoDBOpMsg,
+			Compressor: "snappy",
+			Size_bytes: len(msg),
+		},
+	}
+	results := parsertest.ParseAll(t, NewMongoDBParserFactory(), testBidiID, 0, 0, msg)
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+}
+
+func TestFactoryRejectsOtherProtocols(t *testing.T) {
+	testCases := append(parsertest.OtherProtocols("MongoDB"),
+		parsertest.RejectTestCase{Name: "Kafka request", Input: "\x00\x00\x00\x1c\x00\x03\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+	)
+	parsertest.RunRejectTests(t, NewMongoDBParserFactory(), testCases)
+}
+
+func TestIncrementalParse(t *testing.T) {
+	factory := NewMongoDBParserFactory()
+	ping := message(9, 0, akinet.MongoDBOpMsg, opMsg(0, bsonDoc("ping", int32(1), "$db", "admin")))
+	if decision, _ := factory.Accepts(memview.New(ping[:12]), false); decision != akinet.NeedMoreData {
+		t.Errorf("expected factory to need more data, got %s", decision)
+	}
+
+	testCases := []parsertest.IncrementalTestCase{
+		{
+			Name:  "command",
+			Input: ping,
+			Next:  message(10, 0, akinet.MongoDBOpMsg, opMsg(0, bsonDoc("ping", int32(1), "$db", "admin"))),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				c, ok := result.(akinet.MongoDBCommand)
+				return ok && c.RequestID == 9 && c.Command == "ping"
+			},
+		},
+		{
+			Name:  "reply",
+			Input: message(109, 9, akinet.MongoDBOpMsg, opMsg(0, bsonDoc("ok", 1.0))),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				r, ok := result.(akinet.MongoDBReply)
+				return ok && r.ResponseTo == 9 && r.OK
+			},
+		},
+	}
+	parsertest.RunIncrementalTests(t, factory, testBidiID, testCases)
+}
+
+func TestErrorReturnsAllInput(t *testing.T) {
+	ping := message(9, 0, akinet.MongoDBOpMsg, opMsg(0, bsonDoc("ping", int32(1), "$db", "admin")))
+	testCases := []parsertest.ErrorTestCase{
+		{
+			Name:  "invalid length",
+			Input: [][]byte{append(int32Bytes(8), make([]byte, 12)...)},
+		},
+		{
+			Name:  "end of input",
+			Input: [][]byte{ping[:16], ping[16:19]},
+			IsEnd: true,
+		},
+	}
+	parsertest.RunErrorTests(t, NewMongoDBParserFactory(), testBidiID, testCases)
+}
@@ -0,0 +1,232 @@
+package akinet
+
+import (
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Identifies a command sent by a MySQL client.
+type MySQLCommand byte
+
+const (
+	MySQLComQuit             MySQLCommand = 0x01
+	MySQLComInitDB           MySQLCommand = 0x02
+	MySQLComQuery            MySQLCommand = 0x03
+	MySQLComFieldList        MySQLCommand = 0x04
+	MySQLComStatistics       MySQLCommand = 0x09
+	MySQLComPing             MySQLCommand = 0x0e
+	MySQLComChangeUser       MySQLCommand = 0x11
+	MySQLComStmtPrepare      MySQLCommand = 0x16
+	MySQLComStmtExecute      MySQLCommand = 0x17
+	MySQLComStmtSendLongData MySQLCommand = 0x18
+	MySQLComStmtClose        MySQLCommand = 0x19
+	MySQLComStmtReset        MySQLCommand = 0x1a
+	MySQLComSetOption        MySQLCommand = 0x1b
+	MySQLComStmtFetch        MySQLCommand = 0x1c
+	MySQLComResetConnection  MySQLCommand = 0x1f
+)
+
+func (c MySQLCommand) String() string {
+	switch c {
+	case MySQLComQuit:
+		return "COM_QUIT"
+	case MySQLComInitDB:
+		return "COM_INIT_DB"
+	case MySQLComQuery:
+		return "COM_QUERY"
+	case MySQLComFieldList:
+		return "COM_FIELD_LIST"
+	case MySQLComStatistics:
+		return "COM_STATISTICS"
+	case MySQLComPing:
+		return "COM_PING"
+	case MySQLComChangeUser:
+		return "COM_CHANGE_USER"
+	case MySQLComStmtPrepare:
+		return "COM_STMT_PREPARE"
+	case MySQLComStmtExecute:
+		return "COM_STMT_EXECUTE"
+	case MySQLComStmtSendLongData:
+		return "COM_STMT_SEND_LONG_DATA"
+	case MySQLComStmtClose:
+		return "COM_STMT_CLOSE"
+	case MySQLComStmtReset:
+		return "COM_STMT_RESET"
+	case MySQLComSetOption:
+		return "COM_SET_OPTION"
+	case MySQLComStmtFetch:
+		return "COM_STMT_FETCH"
+	case MySQLComResetConnection:
+		return "COM_RESET_CONNECTION"
+	}
+	return "MySQLCommand(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Whether the server replies to the command. The server does not reply to
+// COM_QUIT, COM_STMT_SEND_LONG_DATA or COM_STMT_CLOSE.
+func (c MySQLCommand) HasResponse() bool {
+	switch c {
+	case MySQLComQuit, MySQLComStmtSendLongData, MySQLComStmtClose:
+		return false
+	}
+	return true
+}
+
+// Represents the initial handshake packet sent by a MySQL server when a client
+// connects.
+type MySQLHandshake struct {
+	StreamID uuid.UUID
+	Seq      int
+
+	ProtocolVersion int
+	ServerVersion   string
+	ConnectionID    uint32
+	CapabilityFlags uint32
+	AuthPluginName  string
+}
+
+func (MySQLHandshake) ImplParsedNetworkContent() {}
+
+// Represents the reply of a MySQL client to the server's handshake, or further
+// authentication data sent by the client during the handshake. The server's
+// reply is a MySQLResponse with the same stream key.
+type MySQLHandshakeResponse struct {
+	// StreamID and Seq uniquely identify a pair of handshake response and
+	// server response.
+	StreamID uuid.UUID
+	Seq      int
+
+	// True if this is a request to switch the connection to TLS, which the
+	// client sends in place of the handshake response.
+	SSLRequest bool
+
+	// True if this is additional authentication data, sent after the
+	// handshake response at the server's request. The other fields are then
+	// not set. The data itself is not captured.
+	AuthData bool
+
+	CapabilityFlags uint32
+	Username        string
+	Database        string
+	AuthPluginName  string
+}
+
+func (MySQLHandshakeResponse) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this handshake response with its
+// corresponding server response.
+func (r MySQLHandshakeResponse) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(r.Seq)
+}
+
+// Represents a command sent by a MySQL client. Parameter values are not
+// captured.
+//
+// The corresponding MySQLResponse, if any, has the same stream key. The time
+// taken to run the command is the difference between the observation times of
+// the two.
+type MySQLQuery struct {
+	// StreamID and Seq uniquely identify a pair of query and response.
+	StreamID uuid.UUID
+	Seq      int
+
+	Command MySQLCommand
+
+	// The SQL text for COM_QUERY and COM_STMT_PREPARE, the database name for
+	// COM_INIT_DB, or the table name for COM_FIELD_LIST. For COM_STMT_EXECUTE,
+	// the text of the prepared statement, if the statement was prepared on a
+	// connection that we observed.
+	Statement string
+
+	// The statement that the command refers to, for commands on prepared
+	// statements.
+	StatementID *uint32
+}
+
+func (MySQLQuery) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this query with its corresponding
+// response.
+func (q MySQLQuery) GetStreamKey() string {
+	return q.StreamID.String() + ":" + strconv.Itoa(q.Seq)
+}
+
+// Identifies the kind of response sent by a MySQL server.
+type MySQLResponseType int
+
+const (
+	MySQLOKResponse MySQLResponseType = iota
+	MySQLErrorResponse
+
+	// A result set. If the server returned several result sets (e.g. from a
+	// stored procedure), they are combined into one response.
+	MySQLResultSetResponse
+
+	// The response to COM_STMT_PREPARE.
+	MySQLPrepareOKResponse
+
+	// A request from the server during the handshake to switch to another
+	// authentication method, or to send more authentication data.
+	MySQLAuthRequestResponse
+)
+
+func (t MySQLResponseType) String() string {
+	switch t {
+	case MySQLOKResponse:
+		return "OK"
+	case MySQLErrorResponse:
+		return "ERR"
+	case MySQLResultSetResponse:
+		return "ResultSet"
+	case MySQLPrepareOKResponse:
+		return "PrepareOK"
+	case MySQLAuthRequestResponse:
+		return "AuthRequest"
+	}
+	return "MySQLResponseType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Represents the reply of a MySQL server to a command or handshake response.
+// Row values are not captured.
+type MySQLResponse struct {
+	// StreamID and Seq uniquely identify a pair of query and response.
+	StreamID uuid.UUID
+	Seq      int
+
+	Type MySQLResponseType
+
+	// From an OK packet, or the packet that ends a result set.
+	AffectedRows uint64
+	LastInsertID uint64
+	StatusFlags  uint16
+	Warnings     uint16
+
+	// From an ERR packet.
+	ErrorCode    uint16
+	SQLState     string
+	ErrorMessage string
+
+	// The names of the columns in a result set, or in the result of a prepared
+	// statement.
+	Columns []string
+
+	// The number of rows in a result set.
+	RowCount int
+
+	// From the response to COM_STMT_PREPARE.
+	StatementID    uint32
+	ParameterCount int
+
+	// The authentication method that the server asked the client to switch
+	// to, for a MySQLAuthRequestResponse.
+	AuthPluginName string
+}
+
+func (MySQLResponse) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this response with its corresponding
+// query.
+func (r MySQLResponse) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(r.Seq)
+}
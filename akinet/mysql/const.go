@@ -0,0 +1,75 @@
+package mysql
+
+import "time"
+
+const (
+	// Length of the header of a MySQL packet: a 3-byte little-endian payload
+	// length followed by a 1-byte sequence ID.
+	packetHeaderLength_bytes = 4
+
+	// Payloads of this length are continued in the next packet.
+	maxPacketPayloadLength_bytes = 0xffffff
+
+	// Largest logical payload that we parse. The protocol allows up to 1 GB,
+	// which is the largest max_allowed_packet setting.
+	maxPayloadLength_bytes = 1 << 30
+
+	// Version of the protocol sent in the server's handshake packet.
+	handshakeProtocolVersion10 = 0x0a
+
+	// Length of the zero filler in the client's handshake response.
+	handshakeResponseFillerLength_bytes = 23
+
+	// Length of the fixed part of the client's handshake response: capability
+	// flags, max packet size, character set and filler. An SSL request consists
+	// only of this.
+	handshakeResponseFixedLength_bytes = 32
+
+	// Length of the payload of the response to COM_STMT_PREPARE.
+	prepareOKLength_bytes = 12
+
+	// Length of the payload of an EOF packet.
+	eofLength_bytes = 5
+
+	// Largest number of columns in a MySQL table.
+	maxColumns = 4096
+
+	// Largest number of prepared statements that we remember per connection.
+	maxStatementsPerConnection = 1000
+
+	// Connections that have not seen any traffic for this long are forgotten.
+	connectionIdleTimeout = 10 * time.Minute
+)
+
+// Capability flags.
+const (
+	clientConnectWithDB              = 0x00000008
+	clientProtocol41                 = 0x00000200
+	clientSSL                        = 0x00000800
+	clientSecureConnection           = 0x00008000
+	clientPluginAuth                 = 0x00080000
+	clientPluginAuthLenencClientData = 0x00200000
+	clientDeprecateEOF               = 0x01000000
+)
+
+// Server status flags.
+const (
+	serverMoreResultsExist   = 0x0008
+	serverStatusCursorExists = 0x0040
+)
+
+// The first byte of various server packets.
+const (
+	okPacketHeader     = 0x00
+	authMoreDataHeader = 0x01
+	eofPacketHeader    = 0xfe
+	authSwitchHeader   = 0xfe
+	errPacketHeader    = 0xff
+)
+
+// Values sent in an AuthMoreData packet during caching_sha2_password
+// authentication.
+const (
+	fastAuthSuccess = 0x03
+	performFullAuth = 0x04
+)
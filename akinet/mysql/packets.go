@@ -0,0 +1,439 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var errMalformedPacket = errors.New("malformed MySQL packet")
+
+// A logical MySQL packet. Payloads of 16 MB or more are split across several
+// physical packets; they are joined back together here.
+type packet struct {
+	// The sequence ID of the first physical packet.
+	seqID byte
+
+	payload memview.MemView
+
+	// The number of bytes taken up by the packet, including headers.
+	length int64
+}
+
+// Returns the first byte of the payload, or 0 if the payload is empty.
+func (p packet) header() byte {
+	if p.payload.Len() == 0 {
+		return 0
+	}
+	return p.payload.GetByte(0)
+}
+
+func (p packet) bytes() []byte {
+	return []byte(p.payload.String())
+}
+
+// Reads the packet starting at the given offset. Returns false if the input
+// does not contain the whole packet.
+func readPacket(input memview.MemView, offset int64) (result packet, ok bool, err error) {
+	pos := offset
+	for {
+		if input.Len()-pos < packetHeaderLength_bytes {
+			return packet{}, false, nil
+		}
+		payloadLength := int64(input.GetByte(pos)) | int64(input.GetByte(pos+1))<<8 | int64(input.GetByte(pos+2))<<16
+		if pos == offset {
+			result.seqID = input.GetByte(pos + 3)
+		}
+		pos += packetHeaderLength_bytes
+
+		if input.Len()-pos < payloadLength {
+			return packet{}, false, nil
+		}
+		result.payload.Append(input.SubView(pos, pos+payloadLength))
+		pos += payloadLength
+
+		if result.payload.Len() > maxPayloadLength_bytes {
+			return packet{}, false, errors.New("MySQL packet too long")
+		}
+		if payloadLength < maxPacketPayloadLength_bytes {
+			break
+		}
+	}
+
+	result.length = pos - offset
+	return result, true, nil
+}
+
+// Reads little-endian fields from a packet payload. Errors are sticky: once a
+// read fails, all later reads return zero values, and err is set.
+type payloadReader struct {
+	buf []byte
+	err error
+}
+
+func (r *payloadReader) byte() byte {
+	if r.err != nil || len(r.buf) < 1 {
+		r.err = errMalformedPacket
+		return 0
+	}
+	result := r.buf[0]
+	r.buf = r.buf[1:]
+	return result
+}
+
+func (r *payloadReader) uint16() uint16 {
+	if r.err != nil || len(r.buf) < 2 {
+		r.err = errMalformedPacket
+		return 0
+	}
+	result := binary.LittleEndian.Uint16(r.buf)
+	r.buf = r.buf[2:]
+	return result
+}
+
+func (r *payloadReader) uint32() uint32 {
+	if r.err != nil || len(r.buf) < 4 {
+		r.err = errMalformedPacket
+		return 0
+	}
+	result := binary.LittleEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return result
+}
+
+func (r *payloadReader) fixed(n int) []byte {
+	if r.err != nil || n < 0 || len(r.buf) < n {
+		r.err = errMalformedPacket
+		return nil
+	}
+	result := r.buf[:n]
+	r.buf = r.buf[n:]
+	return result
+}
+
+// Reads a length-encoded integer.
+func (r *payloadReader) lenencInt() uint64 {
+	b := r.byte()
+	switch {
+	case b < 0xfb:
+		return uint64(b)
+	case b == 0xfc:
+		return uint64(r.uint16())
+	case b == 0xfd:
+		lo := r.uint16()
+		hi := r.byte()
+		return uint64(lo) | uint64(hi)<<16
+	case b == 0xfe:
+		lo := r.uint32()
+		hi := r.uint32()
+		return uint64(lo) | uint64(hi)<<32
+	}
+	// 0xfb represents NULL, and 0xff is not a valid length.
+	r.err = errMalformedPacket
+	return 0
+}
+
+func (r *payloadReader) lenencString() string {
+	n := r.lenencInt()
+	if n > uint64(len(r.buf)) {
+		r.err = errMalformedPacket
+		return ""
+	}
+	return string(r.fixed(int(n)))
+}
+
+func (r *payloadReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.buf, 0)
+	if i < 0 {
+		r.err = errMalformedPacket
+		return ""
+	}
+	result := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return result
+}
+
+func (r *payloadReader) rest() []byte {
+	result := r.buf
+	r.buf = nil
+	return result
+}
+
+// Returns an error if a read failed, or if there are unread bytes left.
+func (r *payloadReader) finish() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.buf) > 0 {
+		return errMalformedPacket
+	}
+	return nil
+}
+
+// Parses the server's initial handshake packet.
+func parseHandshake(payload []byte) (akinet.MySQLHandshake, error) {
+	r := payloadReader{buf: payload}
+	var result akinet.MySQLHandshake
+	result.ProtocolVersion = int(r.byte())
+	if r.err == nil && result.ProtocolVersion != handshakeProtocolVersion10 {
+		return akinet.MySQLHandshake{}, errors.Errorf("unsupported MySQL handshake version %d", result.ProtocolVersion)
+	}
+	result.ServerVersion = r.cstring()
+	result.ConnectionID = r.uint32()
+	authDataPart1 := r.fixed(8)
+	if filler := r.byte(); r.err == nil && filler != 0 {
+		return akinet.MySQLHandshake{}, errMalformedPacket
+	}
+	result.CapabilityFlags = uint32(r.uint16())
+	if r.err != nil {
+		return akinet.MySQLHandshake{}, r.err
+	}
+
+	if len(r.buf) > 0 {
+		r.byte()   // Character set
+		r.uint16() // Status flags
+		result.CapabilityFlags |= uint32(r.uint16()) << 16
+		authDataLength := int(r.byte())
+		r.fixed(10) // Reserved
+
+		if result.CapabilityFlags&clientSecureConnection != 0 {
+			n := authDataLength - len(authDataPart1)
+			if n < 13 {
+				n = 13
+			}
+			r.fixed(n)
+		}
+		if result.CapabilityFlags&clientPluginAuth != 0 {
+			// Some servers omit the NUL terminator.
+			result.AuthPluginName = string(bytes.TrimSuffix(r.rest(), []byte{0}))
+		}
+	}
+
+	if r.err != nil {
+		return akinet.MySQLHandshake{}, r.err
+	}
+	return result, nil
+}
+
+// Parses the client's handshake response, or an SSL request.
+func parseHandshakeResponse(payload []byte) (akinet.MySQLHandshakeResponse, error) {
+	r := payloadReader{buf: payload}
+	var result akinet.MySQLHandshakeResponse
+	result.CapabilityFlags = r.uint32()
+	r.uint32() // Max packet size
+	r.byte()   // Character set
+	filler := r.fixed(handshakeResponseFillerLength_bytes)
+	if r.err != nil {
+		return akinet.MySQLHandshakeResponse{}, r.err
+	}
+	if result.CapabilityFlags&clientProtocol41 == 0 {
+		return akinet.MySQLHandshakeResponse{}, errors.New("unsupported MySQL protocol version")
+	}
+	for _, b := range filler {
+		if b != 0 {
+			return akinet.MySQLHandshakeResponse{}, errMalformedPacket
+		}
+	}
+
+	if len(r.buf) == 0 {
+		if result.CapabilityFlags&clientSSL == 0 {
+			return akinet.MySQLHandshakeResponse{}, errMalformedPacket
+		}
+		result.SSLRequest = true
+		return result, nil
+	}
+
+	result.Username = r.cstring()
+	switch {
+	case result.CapabilityFlags&clientPluginAuthLenencClientData != 0:
+		r.lenencString()
+	case result.CapabilityFlags&clientSecureConnection != 0:
+		r.fixed(int(r.byte()))
+	default:
+		r.cstring()
+	}
+	if result.CapabilityFlags&clientConnectWithDB != 0 && len(r.buf) > 0 {
+		result.Database = r.cstring()
+	}
+	if result.CapabilityFlags&clientPluginAuth != 0 && len(r.buf) > 0 {
+		result.AuthPluginName = r.cstring()
+	}
+	// Connection attributes may follow, which we don't capture.
+
+	if r.err != nil {
+		return akinet.MySQLHandshakeResponse{}, r.err
+	}
+	return result, nil
+}
+
+// Parses a command packet sent by the client.
+func parseCommand(payload []byte) (akinet.MySQLQuery, error) {
+	r := payloadReader{buf: payload}
+	result := akinet.MySQLQuery{
+		Command: akinet.MySQLCommand(r.byte()),
+	}
+
+	switch result.Command {
+	case akinet.MySQLComQuery, akinet.MySQLComStmtPrepare, akinet.MySQLComInitDB:
+		result.Statement = string(r.rest())
+
+	case akinet.MySQLComFieldList:
+		result.Statement = r.cstring()
+		r.rest() // Field wildcard
+
+	case akinet.MySQLComStmtExecute:
+		id := r.uint32()
+		result.StatementID = &id
+		r.byte()   // Flags
+		r.uint32() // Iteration count, always 1
+		r.rest()   // Parameters, which we don't capture
+
+	case akinet.MySQLComStmtSendLongData:
+		id := r.uint32()
+		result.StatementID = &id
+		r.rest() // Parameter ID and data
+
+	case akinet.MySQLComStmtFetch:
+		id := r.uint32()
+		result.StatementID = &id
+		r.uint32() // Number of rows
+
+	case akinet.MySQLComStmtClose, akinet.MySQLComStmtReset:
+		id := r.uint32()
+		result.StatementID = &id
+
+	case akinet.MySQLComSetOption:
+		r.uint16()
+
+	case akinet.MySQLComChangeUser:
+		r.rest()
+
+	case akinet.MySQLComQuit, akinet.MySQLComStatistics, akinet.MySQLComPing, akinet.MySQLComResetConnection:
+
+	default:
+		return akinet.MySQLQuery{}, errors.Errorf("unknown MySQL command 0x%x", byte(result.Command))
+	}
+
+	if err := r.finish(); err != nil {
+		return akinet.MySQLQuery{}, err
+	}
+	return result, nil
+}
+
+// Parses an OK packet, or the OK packet that ends a result set when
+// CLIENT_DEPRECATE_EOF is in effect.
+func parseOK(payload []byte, resp *akinet.MySQLResponse) error {
+	r := payloadReader{buf: payload}
+	if h := r.byte(); h != okPacketHeader && h != eofPacketHeader {
+		return errMalformedPacket
+	}
+	resp.AffectedRows = r.lenencInt()
+	resp.LastInsertID = r.lenencInt()
+	resp.StatusFlags = r.uint16()
+	resp.Warnings = r.uint16()
+	r.rest() // Human-readable information and session state
+	return r.err
+}
+
+// Parses an EOF packet.
+func parseEOF(payload []byte, resp *akinet.MySQLResponse) error {
+	r := payloadReader{buf: payload}
+	if r.byte() != eofPacketHeader {
+		return errMalformedPacket
+	}
+	resp.Warnings = r.uint16()
+	resp.StatusFlags = r.uint16()
+	return r.finish()
+}
+
+func parseErr(payload []byte, resp *akinet.MySQLResponse) error {
+	r := payloadReader{buf: payload}
+	if r.byte() != errPacketHeader {
+		return errMalformedPacket
+	}
+	resp.ErrorCode = r.uint16()
+	if len(r.buf) > 0 && r.buf[0] == '#' {
+		r.byte()
+		resp.SQLState = string(r.fixed(5))
+	}
+	resp.ErrorMessage = string(r.rest())
+	return r.err
+}
+
+// Parses the response to COM_STMT_PREPARE, returning the number of column
+// definitions and parameter definitions that follow.
+func parsePrepareOK(payload []byte, resp *akinet.MySQLResponse) (numColumns, numParams int, err error) {
+	r := payloadReader{buf: payload}
+	if r.byte() != okPacketHeader {
+		return 0, 0, errMalformedPacket
+	}
+	resp.StatementID = r.uint32()
+	numColumns = int(r.uint16())
+	numParams = int(r.uint16())
+	if filler := r.byte(); filler != 0 {
+		return 0, 0, errMalformedPacket
+	}
+	resp.Warnings = r.uint16()
+	resp.ParameterCount = numParams
+	return numColumns, numParams, r.finish()
+}
+
+// Parses a column definition (Protocol::ColumnDefinition41), returning the
+// column name.
+func parseColumnDefinition(payload []byte) (string, error) {
+	r := payloadReader{buf: payload}
+	if catalog := r.lenencString(); r.err == nil && catalog != "def" {
+		return "", errMalformedPacket
+	}
+	r.lenencString() // Schema
+	r.lenencString() // Table
+	r.lenencString() // Original table
+	name := r.lenencString()
+	r.rest() // Original name, character set, type, etc.
+	return name, r.err
+}
+
+// Parses the packet that starts a result set, returning the number of
+// columns.
+func parseColumnCount(payload []byte) (int, error) {
+	r := payloadReader{buf: payload}
+	n := r.lenencInt()
+	if err := r.finish(); err != nil {
+		return 0, err
+	}
+	if n == 0 || n > maxColumns {
+		return 0, errMalformedPacket
+	}
+	return int(n), nil
+}
+
+// Parses an AuthSwitchRequest, returning the name of the authentication
+// method.
+func parseAuthSwitch(payload []byte) (string, error) {
+	r := payloadReader{buf: payload}
+	if r.byte() != authSwitchHeader {
+		return "", errMalformedPacket
+	}
+	name := r.cstring()
+	r.rest() // Authentication data
+	return name, r.err
+}
+
+// Determines whether the packet is an EOF packet. EOF packets share their
+// header with length-encoded integers in rows, but are much shorter.
+func isEOF(p packet) bool {
+	return p.header() == eofPacketHeader && p.payload.Len() == eofLength_bytes
+}
+
+// Determines whether the packet ends a result set: either an EOF packet, or an
+// OK packet with the EOF header when CLIENT_DEPRECATE_EOF is in effect.
+func isResultSetEnd(p packet) bool {
+	return p.header() == eofPacketHeader && p.payload.Len() < maxPacketPayloadLength_bytes
+}
@@ -0,0 +1,477 @@
+package mysql
+
+import (
+	"io"
+
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Identifies what a parser is reading: one side's turn in the conversation,
+// which ends when the other side has to reply.
+type turn int
+
+const (
+	unknownTurn turn = iota
+
+	// The server's initial handshake packet.
+	handshakeTurn
+
+	// The client's handshake response, or SSL request.
+	handshakeResponseTurn
+
+	// Additional authentication data from the client.
+	authDataTurn
+
+	// A command from the client.
+	commandTurn
+
+	// The server's response to a command or to authentication data.
+	responseTurn
+)
+
+// Where the parser is in a response from the server.
+type responseState int
+
+const (
+	// Expecting the first packet of a response, or of the next result set.
+	responseStart responseState = iota
+
+	// Reading column definitions.
+	readingColumns
+
+	// Expecting the EOF packet that may follow column definitions.
+	afterColumns
+
+	// Reading rows, up to the packet that ends the result set.
+	readingRows
+
+	// Reading the parameter definitions that follow the response to
+	// COM_STMT_PREPARE.
+	readingPrepareParams
+
+	// Expecting the EOF packet that may follow the parameter definitions.
+	afterPrepareParams
+
+	// Reading the column definitions that follow the parameter definitions.
+	readingPrepareColumns
+
+	// Expecting the EOF packet that may follow the column definitions.
+	afterPrepareColumns
+)
+
+func newMySQLParser(factory *mysqlParserFactory, bidiID akinet.TCPBidiID, seq, ack reassembly.Sequence) *mysqlParser {
+	return &mysqlParser{
+		factory: factory,
+		bidiID:  bidiID,
+		seq:     seq,
+		ack:     ack,
+		input:   akinet.NewRetainedInput(0),
+	}
+}
+
+// Parses one side's turn in a MySQL conversation.
+type mysqlParser struct {
+	factory  *mysqlParserFactory
+	bidiID   akinet.TCPBidiID
+	seq, ack reassembly.Sequence
+
+	// The input, which is returned as unused if the turn can't be parsed.
+	input akinet.RetainedInput
+
+	// Input that has not been consumed yet, starting at pos. Consumed input is
+	// dropped as we go, so that large result sets aren't held in memory.
+	allInput memview.MemView
+	pos      int64
+
+	turn        turn
+	numPackets  int
+	lastSeqID   byte
+	result      akinet.ParsedNetworkContent
+	response    akinet.MySQLResponse
+	state       responseState
+	numExpected int // Number of column or parameter definitions left to read
+	numColumns  int // Number of column definitions after the parameter definitions
+}
+
+var _ akinet.TCPParser = (*mysqlParser)(nil)
+
+func (*mysqlParser) Name() string {
+	return "MySQL Parser"
+}
+
+func (p *mysqlParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	p.input.Append(input)
+	p.allInput.Append(input)
+
+	done, err := p.parse(isEnd)
+	if err != nil {
+		return nil, p.input.Unused(p.allInput), err
+	}
+
+	if !done {
+		if !isEnd {
+			return nil, memview.MemView{}, nil
+		}
+
+		// The flow ended in the middle of a response. Emit what we have, if
+		// anything.
+		if p.numPackets == 0 || p.turn != responseTurn {
+			return nil, p.input.Unused(p.allInput), io.ErrUnexpectedEOF
+		}
+		p.pos = p.allInput.Len()
+	}
+
+	return p.getResult(), p.allInput.SubView(p.pos, p.allInput.Len()), nil
+}
+
+// Consumes packets until the turn is complete or more input is needed.
+func (p *mysqlParser) parse(isEnd bool) (done bool, err error) {
+	for {
+		pkt, ok, err := readPacket(p.allInput, p.pos)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			if p.turn == responseTurn && isEnd {
+				// An optional EOF packet was not sent.
+				return p.endWithoutOptionalEOF(), nil
+			}
+			return false, nil
+		}
+
+		if p.turn == unknownTurn {
+			p.turn = p.identifyTurn(pkt)
+		}
+
+		var consume bool
+		switch p.turn {
+		case handshakeTurn:
+			consume, done, err = p.processHandshake(pkt)
+		case handshakeResponseTurn:
+			consume, done, err = p.processHandshakeResponse(pkt)
+		case authDataTurn:
+			// Don't look at the contents, which may include a password.
+			p.result = akinet.MySQLHandshakeResponse{AuthData: true}
+			p.factory.setAuthPending(p.bidiID, false, 0)
+			consume, done = true, true
+		case commandTurn:
+			consume, done, err = p.processCommand(pkt)
+		case responseTurn:
+			if p.numPackets > 0 && pkt.seqID != p.lastSeqID+1 {
+				// The packet belongs to the next response.
+				return p.endWithoutOptionalEOF(), nil
+			}
+			consume, done, err = p.processResponsePacket(pkt)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if consume {
+			p.pos += pkt.length
+			p.numPackets++
+			p.lastSeqID = pkt.seqID
+			p.allInput = p.allInput.SubView(p.pos, p.allInput.Len())
+			p.pos = 0
+		}
+		if done {
+			return true, nil
+		}
+	}
+}
+
+// Determines what kind of turn the input starts with, given its first packet.
+func (p *mysqlParser) identifyTurn(pkt packet) turn {
+	switch {
+	case pkt.seqID == 0 && pkt.header() == handshakeProtocolVersion10:
+		if _, err := parseHandshake(pkt.bytes()); err == nil {
+			return handshakeTurn
+		}
+		return commandTurn
+	case pkt.seqID == 0:
+		return commandTurn
+	case pkt.seqID == 1 && pkt.payload.Len() >= handshakeResponseFixedLength_bytes:
+		if _, err := parseHandshakeResponse(pkt.bytes()); err == nil {
+			return handshakeResponseTurn
+		}
+	case p.factory.isAuthData(p.bidiID, pkt.seqID):
+		return authDataTurn
+	}
+	return responseTurn
+}
+
+func (p *mysqlParser) processHandshake(pkt packet) (consume, done bool, err error) {
+	handshake, err := parseHandshake(pkt.bytes())
+	if err != nil {
+		return false, false, errors.Wrap(err, "failed to parse MySQL handshake")
+	}
+	p.result = handshake
+	return true, true, nil
+}
+
+func (p *mysqlParser) processHandshakeResponse(pkt packet) (consume, done bool, err error) {
+	resp, err := parseHandshakeResponse(pkt.bytes())
+	if err != nil {
+		return false, false, errors.Wrap(err, "failed to parse MySQL handshake response")
+	}
+	p.factory.setCapabilities(p.bidiID, resp.CapabilityFlags)
+	p.result = resp
+	return true, true, nil
+}
+
+func (p *mysqlParser) processCommand(pkt packet) (consume, done bool, err error) {
+	if pkt.seqID != 0 {
+		return false, false, errors.Errorf("unexpected MySQL packet with sequence ID %d", pkt.seqID)
+	}
+	q, err := parseCommand(pkt.bytes())
+	if err != nil {
+		return false, false, err
+	}
+
+	switch q.Command {
+	case akinet.MySQLComStmtPrepare:
+		p.factory.prepareCommand(p.bidiID, int(p.ack), q.Statement)
+	case akinet.MySQLComStmtExecute, akinet.MySQLComStmtFetch, akinet.MySQLComStmtReset, akinet.MySQLComStmtSendLongData:
+		q.Statement = p.factory.statement(p.bidiID, *q.StatementID)
+	case akinet.MySQLComStmtClose:
+		q.Statement = p.factory.statement(p.bidiID, *q.StatementID)
+		p.factory.closeStatement(p.bidiID, *q.StatementID)
+	}
+
+	p.result = q
+	return true, true, nil
+}
+
+func (p *mysqlParser) processResponsePacket(pkt packet) (consume, done bool, err error) {
+	r := &p.response
+
+	switch p.state {
+	case responseStart:
+		return p.processResponseStart(pkt)
+
+	case readingColumns, readingPrepareParams, readingPrepareColumns:
+		name, err := parseColumnDefinition(pkt.bytes())
+		if err != nil {
+			return false, false, errors.Wrap(err, "failed to parse MySQL column definition")
+		}
+		if p.state != readingPrepareParams {
+			r.Columns = append(r.Columns, name)
+		}
+
+		p.numExpected--
+		if p.numExpected > 0 {
+			return true, false, nil
+		}
+
+		p.state++
+		if !p.eofDeprecated() {
+			return true, false, nil
+		}
+		// No EOF packet follows the definitions.
+		if p.state == afterColumns {
+			p.state = readingRows
+			return true, false, nil
+		}
+		p.advancePrepare()
+		return true, p.state == responseStart, nil
+
+	case afterColumns:
+		p.state = readingRows
+		if isEOF(pkt) {
+			if err := parseEOF(pkt.bytes(), r); err != nil {
+				return false, false, err
+			}
+			if r.StatusFlags&serverStatusCursorExists != 0 {
+				// The rows will be fetched with COM_STMT_FETCH.
+				return true, true, nil
+			}
+			return true, false, nil
+		}
+		return p.processResponsePacket(pkt)
+
+	case readingRows:
+		switch {
+		case pkt.header() == errPacketHeader:
+			r.Type = akinet.MySQLErrorResponse
+			return true, true, parseErr(pkt.bytes(), r)
+
+		case isResultSetEnd(pkt):
+			var err error
+			if isEOF(pkt) {
+				err = parseEOF(pkt.bytes(), r)
+			} else {
+				err = parseOK(pkt.bytes(), r)
+			}
+			if err != nil {
+				return false, false, err
+			}
+			return true, !p.moreResults(), nil
+		}
+		r.RowCount++
+		return true, false, nil
+
+	case afterPrepareParams, afterPrepareColumns:
+		if isEOF(pkt) {
+			if err := parseEOF(pkt.bytes(), r); err != nil {
+				return false, false, err
+			}
+			p.advancePrepare()
+			return true, p.state == responseStart, nil
+		}
+		p.advancePrepare()
+		if p.state == responseStart {
+			// The response ended without an EOF packet. Leave the packet for the
+			// next parser.
+			return false, true, nil
+		}
+		return p.processResponsePacket(pkt)
+	}
+
+	return false, false, errors.Errorf("invalid MySQL response state %d", p.state)
+}
+
+// Processes the first packet of a response, or of a subsequent result set.
+func (p *mysqlParser) processResponseStart(pkt packet) (consume, done bool, err error) {
+	r := &p.response
+	payload := pkt.bytes()
+
+	switch pkt.header() {
+	case okPacketHeader:
+		if len(payload) == prepareOKLength_bytes && p.numPackets == 0 {
+			if numColumns, numParams, err := parsePrepareOK(payload, r); err == nil {
+				r.Type = akinet.MySQLPrepareOKResponse
+				p.factory.prepareResponse(p.bidiID, int(p.seq), r.StatementID)
+
+				p.numColumns = numColumns
+				p.numExpected = numParams
+				p.state = readingPrepareParams
+				if numParams == 0 {
+					p.state = afterPrepareParams
+					p.advancePrepare()
+				}
+				return true, p.state == responseStart, nil
+			}
+		}
+		if err := parseOK(payload, r); err != nil {
+			return false, false, err
+		}
+		if r.Type != akinet.MySQLResultSetResponse {
+			r.Type = akinet.MySQLOKResponse
+		}
+		return true, !p.moreResults(), nil
+
+	case errPacketHeader:
+		r.Type = akinet.MySQLErrorResponse
+		return true, true, parseErr(payload, r)
+
+	case eofPacketHeader:
+		if isEOF(pkt) {
+			// Ends the response to COM_FIELD_LIST.
+			return true, true, parseEOF(payload, r)
+		}
+		name, err := parseAuthSwitch(payload)
+		if err != nil {
+			return false, false, err
+		}
+		r.Type = akinet.MySQLAuthRequestResponse
+		r.AuthPluginName = name
+		p.factory.setAuthPending(p.bidiID, true, pkt.seqID+1)
+		return true, true, nil
+
+	case authMoreDataHeader:
+		if len(payload) >= 2 {
+			if len(payload) == 2 && payload[1] == fastAuthSuccess {
+				// An OK packet follows.
+				return true, false, nil
+			}
+			r.Type = akinet.MySQLAuthRequestResponse
+			p.factory.setAuthPending(p.bidiID, true, pkt.seqID+1)
+			return true, true, nil
+		}
+	}
+
+	numColumns, err := parseColumnCount(payload)
+	if err != nil {
+		return false, false, errors.Wrap(err, "failed to parse MySQL response")
+	}
+	r.Type = akinet.MySQLResultSetResponse
+	r.Columns = nil
+	p.numExpected = numColumns
+	p.state = readingColumns
+	return true, false, nil
+}
+
+// Whether the server has indicated that another result set follows. If so,
+// prepares to read it.
+func (p *mysqlParser) moreResults() bool {
+	if p.response.StatusFlags&serverMoreResultsExist == 0 {
+		return false
+	}
+	p.state = responseStart
+	return true
+}
+
+// Whether the client and server agreed to omit the EOF packets that follow
+// column and parameter definitions. If the handshake was not observed, this
+// is not known, and we look at the packets themselves.
+func (p *mysqlParser) eofDeprecated() bool {
+	capabilities, known := p.factory.capabilities(p.bidiID)
+	return known && capabilities&clientDeprecateEOF != 0
+}
+
+// Moves past the EOF packet that may follow the parameter or column
+// definitions in the response to COM_STMT_PREPARE.
+func (p *mysqlParser) advancePrepare() {
+	switch p.state {
+	case afterPrepareParams:
+		if p.numColumns > 0 {
+			p.numExpected = p.numColumns
+			p.state = readingPrepareColumns
+			return
+		}
+	}
+	p.state = responseStart
+}
+
+// Called when there is no packet after the current one, either because the
+// flow has ended, or because the next packet belongs to the next response. An
+// EOF packet may have been omitted because of CLIENT_DEPRECATE_EOF. Returns
+// whether the response is complete.
+func (p *mysqlParser) endWithoutOptionalEOF() bool {
+	switch p.state {
+	case afterPrepareParams, afterPrepareColumns:
+		p.advancePrepare()
+		return p.state == responseStart
+	}
+	return false
+}
+
+func (p *mysqlParser) getResult() akinet.ParsedNetworkContent {
+	// As with HTTP, the client's turn finishes before the server replies, so
+	// the ack number on the first segment of the client's turn is equal to the
+	// seq number on the first segment of the server's reply.
+	switch r := p.result.(type) {
+	case akinet.MySQLHandshake:
+		r.StreamID = uuid.UUID(p.bidiID)
+		r.Seq = int(p.seq)
+		return r
+	case akinet.MySQLHandshakeResponse:
+		r.StreamID = uuid.UUID(p.bidiID)
+		r.Seq = int(p.ack)
+		return r
+	case akinet.MySQLQuery:
+		r.StreamID = uuid.UUID(p.bidiID)
+		r.Seq = int(p.ack)
+		return r
+	}
+
+	result := p.response
+	result.StreamID = uuid.UUID(p.bidiID)
+	result.Seq = int(p.seq)
+	return result
+}
@@ -0,0 +1,268 @@
+package mysql
+
+import (
+	"sync"
+
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a parser factory for both halves of a MySQL connection, using the
+// client/server protocol 4.1.
+//
+// Parsing depends on state from both flows of a connection: the capability
+// flags agreed in the handshake, and the prepared statements created on it.
+// The returned factory keeps track of this state, so the same factory must be
+// used for both flows of a connection. Connections whose handshake was not
+// observed are parsed on a best-effort basis.
+func NewMySQLParserFactory() akinet.TCPParserFactory {
+	factory := &mysqlParserFactory{}
+	factory.connections = akinet.NewConnectionMap(connectionIdleTimeout, func(_ akinet.TCPBidiID, state interface{}) {
+		if state.(*connection).authPending {
+			factory.numAuthPending--
+		}
+	})
+	return factory
+}
+
+type mysqlParserFactory struct {
+	mu          sync.Mutex
+	connections *akinet.ConnectionMap // of *connection, protected by mu
+
+	// The number of connections with authPending set. Protected by mu.
+	numAuthPending int
+}
+
+// The state of a connection. Protected by mysqlParserFactory.mu.
+type connection struct {
+	// The capability flags from the client's handshake response.
+	capabilities      uint32
+	capabilitiesKnown bool
+
+	// Set when the server has asked the client for more authentication data,
+	// until the client sends it. The client's packet will have the given
+	// sequence ID.
+	authPending bool
+	authSeqID   byte
+
+	// The text of prepared statements, keyed by statement ID.
+	statements map[uint32]string
+
+	// Each COM_STMT_PREPARE command and its response are recorded here, keyed
+	// by their stream sequence number, until the other is seen. This way, the
+	// two flows of the connection can be parsed in any order.
+	pendingPrepareText map[int]string
+	pendingPrepareID   map[int]uint32
+}
+
+func (*mysqlParserFactory) Name() string {
+	return "MySQL Parser Factory"
+}
+
+func (factory *mysqlParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	decision, discardFront = factory.accepts(input)
+
+	if decision == akinet.NeedMoreData && isEnd {
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+
+	return decision, discardFront
+}
+
+func (factory *mysqlParserFactory) accepts(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	// MySQL packets can only be recognized by their contents, so the whole
+	// first packet is needed.
+	pkt, ok, err := readPacket(input, 0)
+	if err != nil {
+		return akinet.Reject, input.Len()
+	}
+	if !ok {
+		return akinet.NeedMoreData, 0
+	}
+	payload := pkt.bytes()
+
+	switch {
+	case pkt.seqID == 0 && pkt.header() == handshakeProtocolVersion10:
+		if _, err := parseHandshake(payload); err == nil {
+			return akinet.Accept, 0
+		}
+	case pkt.seqID == 1 && pkt.payload.Len() >= handshakeResponseFixedLength_bytes:
+		if _, err := parseHandshakeResponse(payload); err == nil {
+			return akinet.Accept, 0
+		}
+	case pkt.seqID == 0:
+		if _, err := parseCommand(payload); err == nil {
+			return akinet.Accept, 0
+		}
+		return akinet.Reject, input.Len()
+	}
+
+	if pkt.seqID == 0 {
+		return akinet.Reject, input.Len()
+	}
+	if pkt.seqID >= 2 && factory.anyAuthPending() {
+		return akinet.Accept, 0
+	}
+
+	return acceptResponse(input, pkt, payload)
+}
+
+// Accepts input that starts with the first packet of a response from the
+// server.
+func acceptResponse(input memview.MemView, pkt packet, payload []byte) (decision akinet.AcceptDecision, discardFront int64) {
+	var resp akinet.MySQLResponse
+	var err error
+	switch pkt.header() {
+	case okPacketHeader:
+		if len(payload) == prepareOKLength_bytes {
+			if _, _, err := parsePrepareOK(payload, &resp); err == nil {
+				return akinet.Accept, 0
+			}
+		}
+		err = parseOK(payload, &resp)
+	case errPacketHeader:
+		err = parseErr(payload, &resp)
+	case eofPacketHeader:
+		if isEOF(pkt) {
+			err = parseEOF(payload, &resp)
+		} else {
+			_, err = parseAuthSwitch(payload)
+		}
+	case authMoreDataHeader:
+		if len(payload) == 2 && (payload[1] == fastAuthSuccess || payload[1] == performFullAuth) {
+			return akinet.Accept, 0
+		}
+		fallthrough
+	default:
+		// The start of a result set: the number of columns, followed by the
+		// first column definition.
+		if _, err := parseColumnCount(payload); err != nil {
+			return akinet.Reject, input.Len()
+		}
+		next, ok, err := readPacket(input, pkt.length)
+		if err != nil {
+			return akinet.Reject, input.Len()
+		}
+		if !ok {
+			return akinet.NeedMoreData, 0
+		}
+		_, err = parseColumnDefinition(next.bytes())
+	}
+
+	if err != nil {
+		return akinet.Reject, input.Len()
+	}
+	return akinet.Accept, 0
+}
+
+func (factory *mysqlParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newMySQLParser(factory, id, seq, ack)
+}
+
+func (factory *mysqlParserFactory) anyAuthPending() bool {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+	return factory.numAuthPending > 0
+}
+
+// Calls f with the state of the given connection, creating it if necessary.
+func (factory *mysqlParserFactory) withConnection(id akinet.TCPBidiID, f func(*connection)) {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	conn := factory.connections.GetOrCreate(id, func() interface{} {
+		return &connection{
+			statements:         map[uint32]string{},
+			pendingPrepareText: map[int]string{},
+			pendingPrepareID:   map[int]uint32{},
+		}
+	}).(*connection)
+
+	wasAuthPending := conn.authPending
+	f(conn)
+	if conn.authPending != wasAuthPending {
+		if conn.authPending {
+			factory.numAuthPending++
+		} else {
+			factory.numAuthPending--
+		}
+	}
+}
+
+// Returns the capability flags of the given connection, if they are known.
+func (factory *mysqlParserFactory) capabilities(id akinet.TCPBidiID) (capabilities uint32, known bool) {
+	factory.withConnection(id, func(conn *connection) {
+		capabilities, known = conn.capabilities, conn.capabilitiesKnown
+	})
+	return capabilities, known
+}
+
+func (factory *mysqlParserFactory) setCapabilities(id akinet.TCPBidiID, capabilities uint32) {
+	factory.withConnection(id, func(conn *connection) {
+		conn.capabilities = capabilities
+		conn.capabilitiesKnown = true
+	})
+}
+
+// Determines whether a packet with the given sequence ID is additional
+// authentication data from the client.
+func (factory *mysqlParserFactory) isAuthData(id akinet.TCPBidiID, seqID byte) (result bool) {
+	factory.withConnection(id, func(conn *connection) {
+		result = conn.authPending && conn.authSeqID == seqID
+	})
+	return result
+}
+
+func (factory *mysqlParserFactory) setAuthPending(id akinet.TCPBidiID, pending bool, seqID byte) {
+	factory.withConnection(id, func(conn *connection) {
+		conn.authPending = pending
+		conn.authSeqID = seqID
+	})
+}
+
+// Records the text of a COM_STMT_PREPARE command.
+func (factory *mysqlParserFactory) prepareCommand(id akinet.TCPBidiID, streamSeq int, text string) {
+	factory.withConnection(id, func(conn *connection) {
+		if stmtID, ok := conn.pendingPrepareID[streamSeq]; ok {
+			delete(conn.pendingPrepareID, streamSeq)
+			conn.addStatement(stmtID, text)
+		} else if len(conn.pendingPrepareText) < maxStatementsPerConnection {
+			conn.pendingPrepareText[streamSeq] = text
+		}
+	})
+}
+
+// Records the statement ID from the response to a COM_STMT_PREPARE command.
+func (factory *mysqlParserFactory) prepareResponse(id akinet.TCPBidiID, streamSeq int, stmtID uint32) {
+	factory.withConnection(id, func(conn *connection) {
+		if text, ok := conn.pendingPrepareText[streamSeq]; ok {
+			delete(conn.pendingPrepareText, streamSeq)
+			conn.addStatement(stmtID, text)
+		} else if len(conn.pendingPrepareID) < maxStatementsPerConnection {
+			conn.pendingPrepareID[streamSeq] = stmtID
+		}
+	})
+}
+
+// Returns the text of the given prepared statement, if known.
+func (factory *mysqlParserFactory) statement(id akinet.TCPBidiID, stmtID uint32) (text string) {
+	factory.withConnection(id, func(conn *connection) {
+		text = conn.statements[stmtID]
+	})
+	return text
+}
+
+func (factory *mysqlParserFactory) closeStatement(id akinet.TCPBidiID, stmtID uint32) {
+	factory.withConnection(id, func(conn *connection) {
+		delete(conn.statements, stmtID)
+	})
+}
+
+func (conn *connection) addStatement(stmtID uint32, text string) {
+	if len(conn.statements) < maxStatementsPerConnection {
+		conn.statements[stmtID] = text
+	}
+}
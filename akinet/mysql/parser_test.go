@@ -0,0 +1,440 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/akinet/internal/parsertest"
+)
+
+var testBidiID = akinet.TCPBidiID(uuid.MustParse("5e0a4f8e-8d5c-4b0f-a2a4-3c6f1d2e9b17"))
+
+const (
+	clientSeq = reassembly.Sequence(1000)
+	serverSeq = reassembly.Sequence(5000)
+)
+
+type payloadBuilder struct {
+	bytes.Buffer
+}
+
+func (b *payloadBuilder) byte(c byte) *payloadBuilder {
+	b.WriteByte(c)
+	return b
+}
+
+func (b *payloadBuilder) uint16(v uint16) *payloadBuilder {
+	binary.Write(b, binary.LittleEndian, v)
+	return b
+}
+
+func (b *payloadBuilder) uint32(v uint32) *payloadBuilder {
+	binary.Write(b, binary.LittleEndian, v)
+	return b
+}
+
+func (b *payloadBuilder) zeros(n int) *payloadBuilder {
+	b.Write(make([]byte, n))
+	return b
+}
+
+func (b *payloadBuilder) str(s string) *payloadBuilder {
+	b.WriteString(s)
+	return b
+}
+
+func (b *payloadBuilder) cstring(s string) *payloadBuilder {
+	b.WriteString(s)
+	b.WriteByte(0)
+	return b
+}
+
+// Writes a length-encoded string shorter than 251 bytes.
+func (b *payloadBuilder) lenenc(s string) *payloadBuilder {
+	b.WriteByte(byte(len(s)))
+	b.WriteString(s)
+	return b
+}
+
+// Returns a packet with the given sequence ID and payload.
+func (b *payloadBuilder) packet(seqID byte) []byte {
+	n := b.Len()
+	result := []byte{byte(n), byte(n >> 8), byte(n >> 16), seqID}
+	return append(result, b.Bytes()...)
+}
+
+func payload() *payloadBuilder {
+	return &payloadBuilder{}
+}
+
+func concat(packets ...[]byte) []byte {
+	return bytes.Join(packets, nil)
+}
+
+func columnDefinition(seqID byte, name string) []byte {
+	return payload().lenenc("def").lenenc("shop").lenenc("users").lenenc("users").
+		lenenc(name).lenenc(name).
+		byte(0x0c).uint16(33).uint32(255).byte(0xfd).uint16(0).byte(0).uint16(0).
+		packet(seqID)
+}
+
+func row(seqID byte, values ...string) []byte {
+	b := payload()
+	for _, v := range values {
+		b.lenenc(v)
+	}
+	return b.packet(seqID)
+}
+
+func eof(seqID byte, status uint16) []byte {
+	return payload().byte(eofPacketHeader).uint16(0).uint16(status).packet(seqID)
+}
+
+func ok(seqID byte, header byte, affectedRows byte, status uint16) []byte {
+	return payload().byte(header).byte(affectedRows).byte(0).uint16(status).uint16(0).packet(seqID)
+}
+
+func TestHandshake(t *testing.T) {
+	factory := NewMySQLParserFactory()
+	streamID := uuid.UUID(testBidiID)
+
+	serverCapabilities := uint32(clientProtocol41 | clientSecureConnection | clientPluginAuth | clientConnectWithDB | clientSSL)
+	greeting := payload().byte(handshakeProtocolVersion10).cstring("8.0.32").uint32(42).
+		zeros(8).byte(0).
+		uint16(uint16(serverCapabilities)).byte(0xff).uint16(2).uint16(uint16(serverCapabilities >> 16)).
+		byte(21).zeros(10).zeros(13).
+		cstring("caching_sha2_password").
+		packet(0)
+
+	clientCapabilities := uint32(clientProtocol41 | clientSecureConnection | clientPluginAuth | clientConnectWithDB)
+	response := payload().uint32(clientCapabilities).uint32(1 << 24).byte(0xff).zeros(23).
+		cstring("alice").
+		byte(20).zeros(20).
+		cstring("shop").
+		cstring("caching_sha2_password").
+		packet(1)
+
+	// The server asks for the full authentication exchange, and the client
+	// sends the password.
+	moreData := payload().byte(authMoreDataHeader).byte(performFullAuth).packet(2)
+	password := payload().cstring("secret").packet(3)
+
+	results := []akinet.ParsedNetworkContent{}
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq, greeting)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq+100, response)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, serverSeq+100, clientSeq+100, moreData)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, clientSeq+100, serverSeq+200, password)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, serverSeq+200, clientSeq+200, ok(4, okPacketHeader, 0, 2))...)
+
+	expected := []akinet.ParsedNetworkContent{
+		akinet.MySQLHandshake{
+			StreamID:        streamID,
+			Seq:             int(serverSeq),
+			ProtocolVersion: 10,
+			ServerVersion:   "8.0.32",
+			ConnectionID:    42,
+			CapabilityFlags: serverCapabilities,
+			AuthPluginName:  "caching_sha2_password",
+		},
+		akinet.MySQLHandshakeResponse{
+			StreamID:        streamID,
+			Seq:             int(serverSeq + 100),
+			CapabilityFlags: clientCapabilities,
+			Username:        "alice",
+			Database:        "shop",
+			AuthPluginName:  "caching_sha2_password",
+		},
+		akinet.MySQLResponse{
+			StreamID: streamID,
+			Seq:      int(serverSeq + 100),
+			Type:     akinet.MySQLAuthRequestResponse,
+		},
+		akinet.MySQLHandshakeResponse{
+			StreamID: streamID,
+			Seq:      int(serverSeq + 200),
+			AuthData: true,
+		},
+		akinet.MySQLResponse{
+			StreamID:    streamID,
+			Seq:         int(serverSeq + 200),
+			Type:        akinet.MySQLOKResponse,
+			StatusFlags: 2,
+		},
+	}
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+}
+
+func TestSSLRequest(t *testing.T) {
+	factory := NewMySQLParserFactory()
+	capabilities := uint32(clientProtocol41 | clientSSL | clientSecureConnection)
+	request := payload().uint32(capabilities).uint32(1 << 24).byte(0xff).zeros(23).packet(1)
+
+	results := parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, request)
+	expected := []akinet.ParsedNetworkContent{
+		akinet.MySQLHandshakeResponse{
+			StreamID:        uuid.UUID(testBidiID),
+			Seq:             int(serverSeq),
+			SSLRequest:      true,
+			CapabilityFlags: capabilities,
+		},
+	}
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	streamID := uuid.UUID(testBidiID)
+
+	client := concat(
+		payload().byte(byte(akinet.MySQLComQuery)).str("SELECT id, name FROM users").packet(0),
+		payload().byte(byte(akinet.MySQLComQuery)).str("SELECT * FROM user").packet(0),
+		payload().byte(byte(akinet.MySQLComQuery)).str("UPDATE users SET name = 'bob' WHERE id = 7").packet(0),
+	)
+
+	testCases := []struct {
+		name         string
+		capabilities uint32
+		server       []byte
+	}{
+		{
+			name: "with EOF",
+			server: concat(
+				payload().byte(2).packet(1),
+				columnDefinition(2, "id"),
+				columnDefinition(3, "name"),
+				eof(4, 2),
+				row(5, "7", "alice"),
+				row(6, "8", "bob"),
+				eof(7, 2),
+
+				payload().byte(errPacketHeader).uint16(1146).str("#42S02").str("Table 'shop.user' doesn't exist").packet(1),
+
+				ok(1, okPacketHeader, 1, 2),
+			),
+		},
+		{
+			name:         "without EOF",
+			capabilities: clientProtocol41 | clientDeprecateEOF,
+			server: concat(
+				payload().byte(2).packet(1),
+				columnDefinition(2, "id"),
+				columnDefinition(3, "name"),
+				row(4, "7", "alice"),
+				row(5, "8", "bob"),
+				ok(6, eofPacketHeader, 0, 2),
+
+				payload().byte(errPacketHeader).uint16(1146).str("#42S02").str("Table 'shop.user' doesn't exist").packet(1),
+
+				ok(1, okPacketHeader, 1, 2),
+			),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			factory := NewMySQLParserFactory()
+			if tc.capabilities != 0 {
+				factory.(*mysqlParserFactory).setCapabilities(testBidiID, tc.capabilities)
+			}
+
+			clientResults := parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, client)
+			serverResults := parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq, tc.server)
+
+			expectedClient := []akinet.ParsedNetworkContent{
+				akinet.MySQLQuery{
+					StreamID:  streamID,
+					Seq:       int(serverSeq),
+					Command:   akinet.MySQLComQuery,
+					Statement: "SELECT id, name FROM users",
+				},
+				akinet.MySQLQuery{
+					StreamID:  streamID,
+					Seq:       int(serverSeq),
+					Command:   akinet.MySQLComQuery,
+					Statement: "SELECT * FROM user",
+				},
+				akinet.MySQLQuery{
+					StreamID:  streamID,
+					Seq:       int(serverSeq),
+					Command:   akinet.MySQLComQuery,
+					Statement: "UPDATE users SET name = 'bob' WHERE id = 7",
+				},
+			}
+			if diff := cmp.Diff(expectedClient, clientResults, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("found diff in queries: %s", diff)
+			}
+
+			expectedServer := []akinet.ParsedNetworkContent{
+				akinet.MySQLResponse{
+					StreamID:    streamID,
+					Seq:         int(serverSeq),
+					Type:        akinet.MySQLResultSetResponse,
+					Columns:     []string{"id", "name"},
+					RowCount:    2,
+					StatusFlags: 2,
+				},
+				akinet.MySQLResponse{
+					StreamID:     streamID,
+					Seq:          int(serverSeq),
+					Type:         akinet.MySQLErrorResponse,
+					ErrorCode:    1146,
+					SQLState:     "42S02",
+					ErrorMessage: "Table 'shop.user' doesn't exist",
+				},
+				akinet.MySQLResponse{
+					StreamID:     streamID,
+					Seq:          int(serverSeq),
+					Type:         akinet.MySQLOKResponse,
+					AffectedRows: 1,
+					StatusFlags:  2,
+				},
+			}
+			if diff := cmp.Diff(expectedServer, serverResults, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("found diff in responses: %s", diff)
+			}
+		})
+	}
+}
+
+func TestPreparedStatements(t *testing.T) {
+	factory := NewMySQLParserFactory()
+	streamID := uuid.UUID(testBidiID)
+	stmtID := uint32(1)
+
+	prepare := payload().byte(byte(akinet.MySQLComStmtPrepare)).str("SELECT name FROM users WHERE id = ?").packet(0)
+	execute := payload().byte(byte(akinet.MySQLComStmtExecute)).uint32(stmtID).byte(0).uint32(1).
+		byte(0).byte(1).uint16(3).uint32(7).
+		packet(0)
+	closeStmt := payload().byte(byte(akinet.MySQLComStmtClose)).uint32(stmtID).packet(0)
+
+	prepareOK := concat(
+		payload().byte(okPacketHeader).uint32(stmtID).uint16(1).uint16(1).byte(0).uint16(0).packet(1),
+		columnDefinition(2, "?"),
+		eof(3, 2),
+		columnDefinition(4, "name"),
+		eof(5, 2),
+	)
+	// A binary result set.
+	executeResult := concat(
+		payload().byte(1).packet(1),
+		columnDefinition(2, "name"),
+		eof(3, 2),
+		payload().byte(0).byte(0).lenenc("alice").packet(4),
+		eof(5, 2),
+	)
+
+	// Parse the server's response to the prepare command before the command
+	// itself, as can happen when the flows are reassembled independently.
+	results := []akinet.ParsedNetworkContent{}
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq, prepareOK)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, prepare)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, clientSeq+100, serverSeq+100, concat(execute, closeStmt))...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, serverSeq+100, clientSeq+100, executeResult)...)
+
+	expected := []akinet.ParsedNetworkContent{
+		akinet.MySQLResponse{
+			StreamID:       streamID,
+			Seq:            int(serverSeq),
+			Type:           akinet.MySQLPrepareOKResponse,
+			StatementID:    stmtID,
+			ParameterCount: 1,
+			Columns:        []string{"name"},
+			StatusFlags:    2,
+		},
+		akinet.MySQLQuery{
+			StreamID:  streamID,
+			Seq:       int(serverSeq),
+			Command:   akinet.MySQLComStmtPrepare,
+			Statement: "SELECT name FROM users WHERE id = ?",
+		},
+		akinet.MySQLQuery{
+			StreamID:    streamID,
+			Seq:         int(serverSeq + 100),
+			Command:     akinet.MySQLComStmtExecute,
+			Statement:   "SELECT name FROM users WHERE id = ?",
+			StatementID: &stmtID,
+		},
+		akinet.MySQLQuery{
+			StreamID:    streamID,
+			Seq:         int(serverSeq + 100),
+			Command:     akinet.MySQLComStmtClose,
+			Statement:   "SELECT name FROM users WHERE id = ?",
+			StatementID: &stmtID,
+		},
+		akinet.MySQLResponse{
+			StreamID:    streamID,
+			Seq:         int(serverSeq + 100),
+			Type:        akinet.MySQLResultSetResponse,
+			Columns:     []string{"name"},
+			RowCount:    1,
+			StatusFlags: 2,
+		},
+	}
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+
+	if text := factory.(*mysqlParserFactory).statement(testBidiID, stmtID); text != "" {
+		t.Errorf("expected statement to be closed, got %q", text)
+	}
+}
+
+func TestFactoryRejectsOtherProtocols(t *testing.T) {
+	parsertest.RunRejectTests(t, NewMySQLParserFactory(), parsertest.OtherProtocols("MySQL"))
+}
+
+func TestIncrementalParse(t *testing.T) {
+	query := payload().byte(byte(akinet.MySQLComQuery)).str("SELECT 1").packet(0)
+	testCases := []parsertest.IncrementalTestCase{
+		{
+			Name:  "query",
+			Seq:   clientSeq,
+			Ack:   serverSeq,
+			Input: query,
+			Next:  query,
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				q, ok := result.(akinet.MySQLQuery)
+				return ok && q.Command == akinet.MySQLComQuery && q.Statement == "SELECT 1"
+			},
+		},
+		{
+			Name: "result set",
+			Seq:  serverSeq,
+			Ack:  clientSeq,
+			Input: concat(
+				payload().byte(1).packet(1),
+				columnDefinition(2, "id"),
+				eof(3, 2),
+				row(4, "7"),
+				eof(5, 2),
+			),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				r, ok := result.(akinet.MySQLResponse)
+				return ok && r.RowCount == 1 && len(r.Columns) == 1
+			},
+		},
+	}
+	parsertest.RunIncrementalTests(t, NewMySQLParserFactory(), testBidiID, testCases)
+}
+
+func TestErrorReturnsAllInput(t *testing.T) {
+	testCases := []parsertest.ErrorTestCase{
+		{
+			Name:  "malformed column definition",
+			Seq:   serverSeq,
+			Ack:   clientSeq,
+			Input: [][]byte{payload().byte(1).packet(1), payload().packet(2)},
+		},
+	}
+	parsertest.RunErrorTests(t, NewMySQLParserFactory(), testBidiID, testCases)
+}
@@ -65,6 +65,15 @@ type TCPPacketMetadata struct {
 
 	// The size of the TCP payload.
 	PayloadLength_bytes int
+
+	// The sequence and acknowledgement numbers, and the advertised receive
+	// window, as they appeared in the packet. The window is not scaled. Only
+	// meaningful if SeqAckWindowKnown is set; producers that don't have them
+	// leave all four fields zero.
+	Seq               uint32
+	Ack               uint32
+	Window            uint16
+	SeqAckWindowKnown bool
 }
 
 func (TCPPacketMetadata) ImplParsedNetworkContent() {}
@@ -125,6 +134,26 @@ type HTTPRequest struct {
 	Body             []byte // nil means no body
 	BodyDecompressed bool   // true if the body is already decompressed
 	Cookies          []*http.Cookie
+
+	// Length of the body as it appeared on the wire, before its
+	// Content-Encoding was decoded. Only set when the body had a
+	// Content-Encoding other than identity.
+	RawBodyLength int
+
+	// Set if the body has a Content-Encoding that could not be decoded. In that
+	// case, Body holds the raw bytes.
+	BodyDecodeError string
+
+	// True if the body was longer than the parser's limit, in which case Body
+	// holds only part of it, or none of it.
+	Truncated bool
+
+	// Trailing headers sent after the body, if any (e.g. HTTP/2 trailers).
+	Trailer http.Header
+
+	// Set when the message was decrypted from a TLS connection. Identifies the
+	// connection, and so its TLSHandshakeMetadata.
+	TLSConnectionID *akid.ConnectionID
 }
 
 func (HTTPRequest) ImplParsedNetworkContent() {}
@@ -147,6 +176,27 @@ type HTTPResponse struct {
 	Body             []byte // nil means no body
 	BodyDecompressed bool   // true if the body is already decompressed
 	Cookies          []*http.Cookie
+
+	// Length of the body as it appeared on the wire, before its
+	// Content-Encoding was decoded. Only set when the body had a
+	// Content-Encoding other than identity.
+	RawBodyLength int
+
+	// Set if the body has a Content-Encoding that could not be decoded. In that
+	// case, Body holds the raw bytes.
+	BodyDecodeError string
+
+	// True if the body was longer than the parser's limit, in which case Body
+	// holds only part of it, or none of it.
+	Truncated bool
+
+	// Trailing headers sent after the body, if any (e.g. gRPC status in HTTP/2
+	// trailers).
+	Trailer http.Header
+
+	// Set when the message was decrypted from a TLS connection. Identifies the
+	// connection, and so its TLSHandshakeMetadata.
+	TLSConnectionID *akid.ConnectionID
 }
 
 func (HTTPResponse) ImplParsedNetworkContent() {}
@@ -168,6 +218,32 @@ type TLSClientHello struct {
 	// The list of protocols supported by the client, as seen in the ALPN
 	// extension.
 	SupportedProtocols []string
+
+	// The legacy protocol version field of the Client Hello. This is 0x0303
+	// (TLS 1.2) for TLS 1.3 clients, which list the versions they support in
+	// SupportedVersions instead.
+	LegacyVersion uint16
+
+	// The cipher suites offered by the client, in the order given.
+	CipherSuites []uint16
+
+	// The types of the extensions in the Client Hello, in the order in which
+	// they appear.
+	Extensions []uint16
+
+	// The contents of the supported_groups, ec_point_formats,
+	// signature_algorithms and supported_versions extensions, if present.
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+
+	// Fingerprints of the client's TLS library, computed from the fields
+	// above. JA3 is the full JA3 string, and JA3Hash is its MD5 hash, as
+	// commonly reported by other tools.
+	JA3     string
+	JA3Hash string
+	JA4     string
 }
 
 func (TLSClientHello) ImplParsedNetworkContent() {}
@@ -180,6 +256,13 @@ type TLSServerHello struct {
 	// The inferred TLS version.
 	Version TLSVersion
 
+	// The cipher suite selected by the server.
+	CipherSuite uint16
+
+	// Whether this is a TLS 1.3 HelloRetryRequest, which asks the client to send
+	// another Client Hello, rather than a real Server Hello.
+	HelloRetryRequest bool
+
 	// The selected application-layer protocol, as seen in the ALPN extension, if
 	// any.
 	SelectedProtocol *string
@@ -188,10 +271,43 @@ type TLSServerHello struct {
 	// certificate, if observed. The server's certificate is encrypted in TLS 1.3,
 	// so this is only populated for TLS 1.2 connections.
 	DNSNames []string
+	// The certificate chain sent by the server, starting with the server's own
+	// certificate. Like DNSNames, this is only populated for TLS 1.2
+	// connections.
+	Certificates []TLSCertificate
 }
 
 func (TLSServerHello) ImplParsedNetworkContent() {}
 
+// Represents an observed TLS Alert record.
+type TLSAlert struct {
+	// Identifies the TCP connection to which this alert belongs.
+	ConnectionID akid.ConnectionID
+
+	// Whether the alert was encrypted, in which case its level and description
+	// are unknown.
+	Encrypted bool
+
+	Level       TLSAlertLevel
+	Description TLSAlertDescription
+}
+
+func (TLSAlert) ImplParsedNetworkContent() {}
+
+// Indicates that one side of a TLS connection has started sending encrypted
+// records, which means that it has finished its part of the handshake. This
+// is reported at most once for each side of a connection.
+type TLSEncryptionStarted struct {
+	// Identifies the TCP connection to which this belongs.
+	ConnectionID akid.ConnectionID
+
+	// Whether a ChangeCipherSpec record preceded the encrypted records. This is
+	// always the case in TLS 1.2, and optional in TLS 1.3.
+	ChangeCipherSpec bool
+}
+
+func (TLSEncryptionStarted) ImplParsedNetworkContent() {}
+
 // Metadata from an observed TLS handshake.
 type TLSHandshakeMetadata struct {
 	// Uniquely identifies the underlying TCP connection.
@@ -200,6 +316,10 @@ type TLSHandshakeMetadata struct {
 	// The inferred TLS version. Only populated if the Server Hello was seen.
 	Version *TLSVersion
 
+	// The cipher suite selected by the server. Zero if the Server Hello was not
+	// seen.
+	CipherSuite uint16
+
 	// The DNS hostname extracted from the client's SNI extension, if any.
 	SNIHostname *string
 
@@ -215,8 +335,43 @@ type TLSHandshakeMetadata struct {
 	// encrypted in TLS 1.3, so this is only populated for TLS 1.2 connections.
 	SubjectAlternativeNames []string
 
-	clientHandshakeSeen bool
-	serverHandshakeSeen bool
+	// The certificate chain sent by the server, starting with the server's own
+	// certificate. Only populated for TLS 1.2 connections. See
+	// AnalyzeCertificates.
+	CertificateChain []TLSCertificate
+
+	// What the client offered in its Client Hello. See TLSClientHello.
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+
+	// Fingerprints of the client's TLS library. Only populated if the Client
+	// Hello was seen.
+	JA3     string
+	JA3Hash string
+	JA4     string
+
+	// Whether the server sent a TLS 1.3 HelloRetryRequest.
+	HelloRetryRequest bool
+
+	// The first alert that may have ended the handshake, if any: a fatal
+	// alert, or an encrypted alert, whose level is unknown, sent before the
+	// handshake succeeded. Warning alerts are ignored. See Outcome.
+	Alert *TLSAlert
+
+	clientHandshakeSeen      bool
+	serverHandshakeSeen      bool
+	retryClientHandshakeSeen bool
+
+	// The number of sides of the connection that have started sending encrypted
+	// records.
+	numEncryptedSides int
+
+	// Whether the underlying TCP connection was seen to close.
+	connectionEnded bool
 }
 
 func (TLSHandshakeMetadata) ImplParsedNetworkContent() {}
@@ -231,6 +386,12 @@ func (tls *TLSHandshakeMetadata) AddClientHello(hello *TLSClientHello) error {
 	}
 
 	if tls.clientHandshakeSeen {
+		// After a HelloRetryRequest, the client sends a second Client Hello. Keep
+		// what the client offered in the first.
+		if tls.HelloRetryRequest && !tls.retryClientHandshakeSeen {
+			tls.retryClientHandshakeSeen = true
+			return nil
+		}
 		return errors.Errorf("multiple client handshakes seen for connection %s", akid.String(tls.ConnectionID))
 	}
 	tls.clientHandshakeSeen = true
@@ -244,6 +405,16 @@ func (tls *TLSHandshakeMetadata) AddClientHello(hello *TLSClientHello) error {
 	}
 
 	tls.SupportedProtocols = append(tls.SupportedProtocols, hello.SupportedProtocols...)
+	tls.CipherSuites = append(tls.CipherSuites, hello.CipherSuites...)
+	tls.Extensions = append(tls.Extensions, hello.Extensions...)
+	tls.SupportedGroups = append(tls.SupportedGroups, hello.SupportedGroups...)
+	tls.ECPointFormats = append(tls.ECPointFormats, hello.ECPointFormats...)
+	tls.SignatureAlgorithms = append(tls.SignatureAlgorithms, hello.SignatureAlgorithms...)
+	tls.SupportedVersions = append(tls.SupportedVersions, hello.SupportedVersions...)
+
+	tls.JA3 = hello.JA3
+	tls.JA3Hash = hello.JA3Hash
+	tls.JA4 = hello.JA4
 
 	return nil
 }
@@ -253,6 +424,12 @@ func (tls *TLSHandshakeMetadata) AddServerHello(hello *TLSServerHello) error {
 		return errors.Errorf("mismatched connections: %s and %s", akid.String(tls.ConnectionID), akid.String(hello.ConnectionID))
 	}
 
+	if hello.HelloRetryRequest {
+		// The real Server Hello follows the client's second Client Hello.
+		tls.HelloRetryRequest = true
+		return nil
+	}
+
 	if tls.serverHandshakeSeen {
 		return errors.Errorf("multiple server handshakes seen for connection %s", akid.String(tls.ConnectionID))
 	}
@@ -263,6 +440,7 @@ func (tls *TLSHandshakeMetadata) AddServerHello(hello *TLSServerHello) error {
 
 	version := hello.Version
 	tls.Version = &version
+	tls.CipherSuite = hello.CipherSuite
 
 	if hello.SelectedProtocol != nil {
 		protocol := *hello.SelectedProtocol
@@ -270,10 +448,80 @@ func (tls *TLSHandshakeMetadata) AddServerHello(hello *TLSServerHello) error {
 	}
 
 	tls.SubjectAlternativeNames = append(tls.SubjectAlternativeNames, hello.DNSNames...)
+	tls.CertificateChain = append(tls.CertificateChain, hello.Certificates...)
+
+	return nil
+}
+
+// Records an alert sent on the connection. Only the first fatal or encrypted
+// alert sent before the handshake succeeded is kept; warning alerts, and later
+// alerts such as the close_notify at the end of a connection, don't affect the
+// outcome of the handshake.
+func (tls *TLSHandshakeMetadata) AddAlert(alert *TLSAlert) error {
+	if tls.ConnectionID != alert.ConnectionID {
+		return errors.Errorf("mismatched connections: %s and %s", akid.String(tls.ConnectionID), akid.String(alert.ConnectionID))
+	}
 
+	if !alert.Encrypted && alert.Level != TLSAlertFatal {
+		return nil
+	}
+	if tls.Alert == nil && !tls.handshakeSucceeded() {
+		a := *alert
+		tls.Alert = &a
+	}
 	return nil
 }
 
+// Records that one side of the connection has started sending encrypted
+// records.
+func (tls *TLSHandshakeMetadata) AddEncryptionStarted(started *TLSEncryptionStarted) error {
+	if tls.ConnectionID != started.ConnectionID {
+		return errors.Errorf("mismatched connections: %s and %s", akid.String(tls.ConnectionID), akid.String(started.ConnectionID))
+	}
+
+	if tls.numEncryptedSides >= 2 {
+		return errors.Errorf("encryption started more than twice on connection %s", akid.String(tls.ConnectionID))
+	}
+	tls.numEncryptedSides++
+	return nil
+}
+
+// Records the state of the underlying TCP connection, so that a handshake on
+// a closed connection can be reported as aborted.
+func (tls *TLSHandshakeMetadata) AddTCPConnectionMetadata(conn *TCPConnectionMetadata) error {
+	if tls.ConnectionID != conn.ConnectionID {
+		return errors.Errorf("mismatched connections: %s and %s", akid.String(tls.ConnectionID), akid.String(conn.ConnectionID))
+	}
+
+	if conn.EndState == ConnectionClosed || conn.EndState == ConnectionReset {
+		tls.connectionEnded = true
+	}
+	return nil
+}
+
+// Determines how the handshake ended. A handshake succeeds once both Hellos
+// have been seen and both sides have started sending encrypted records.
+//
+// Records on the two sides of a connection may be observed out of order, so
+// an encrypted alert can be recorded before the other side is seen to finish
+// its part of the handshake. Such an alert may well have been a close_notify,
+// so it is ignored if the handshake succeeded.
+func (tls *TLSHandshakeMetadata) Outcome() TLSHandshakeOutcome {
+	switch {
+	case tls.Alert != nil && !(tls.Alert.Encrypted && tls.handshakeSucceeded()):
+		return TLSHandshakeAlert
+	case tls.handshakeSucceeded():
+		return TLSHandshakeSuccess
+	case tls.connectionEnded && (tls.clientHandshakeSeen || tls.serverHandshakeSeen):
+		return TLSHandshakeAborted
+	}
+	return TLSHandshakeUnknown
+}
+
+func (tls *TLSHandshakeMetadata) handshakeSucceeded() bool {
+	return tls.HandshakeComplete() && tls.numEncryptedSides >= 2
+}
+
 // Determines whether the response latency in the application layer can be
 // measured.
 func (tls *TLSHandshakeMetadata) ApplicationLatencyMeasurable() bool {
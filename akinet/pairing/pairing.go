@@ -0,0 +1,236 @@
+// Package pairing matches HTTP requests to their responses.
+package pairing
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+const (
+	// The default for Options.Timeout.
+	DefaultTimeout = time.Minute
+
+	// The default for Options.MaxPending.
+	DefaultMaxPending = 10000
+)
+
+// A request and its response.
+type HTTPExchange struct {
+	Request  akinet.HTTPRequest
+	Response akinet.HTTPResponse
+
+	// The connection, from the point of view of the request.
+	ClientIP   net.IP
+	ClientPort int
+	ServerIP   net.IP
+	ServerPort int
+	Interface  string
+
+	// When the first and final packets of the request and the response were
+	// observed.
+	RequestStart  time.Time
+	RequestEnd    time.Time
+	ResponseStart time.Time
+	ResponseEnd   time.Time
+
+	// The time from the end of the request to the start of the response.
+	Latency time.Duration
+
+	// The time from the end of the request to the end of the response.
+	TimeToLastByte time.Duration
+}
+
+type Options struct {
+	// Requests and responses that have not been matched after this long are
+	// dropped, and counted as orphans. The age of a request or response is
+	// measured against the ObservationTime of the latest traffic, so that
+	// captures can be replayed faster than real time. Defaults to
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// The maximum number of unmatched requests and responses to hold. Once
+	// this is reached, the oldest is dropped, and counted as an orphan.
+	// Defaults to DefaultMaxPending.
+	MaxPending int
+}
+
+type Counters struct {
+	// The number of requests and responses seen.
+	Requests  int
+	Responses int
+
+	// The number of requests matched with responses.
+	Exchanges int
+
+	// The number of requests and responses that were dropped without being
+	// matched: because they timed out, because too many were pending, because
+	// another request or response had the same stream key, or because the
+	// input ended.
+	OrphanedRequests  int
+	OrphanedResponses int
+
+	// The number of requests and responses currently waiting to be matched.
+	Pending int
+}
+
+// Matches HTTP requests to responses using their stream keys.
+type Pairer struct {
+	opts Options
+
+	mu sync.Mutex
+
+	// Unmatched requests and responses, by stream key, and in the order in
+	// which they were seen. Protected by mu.
+	pending map[string]*list.Element
+	order   *list.List
+
+	counters Counters // protected by mu
+}
+
+// An unmatched request or response.
+type pendingTraffic struct {
+	key       string
+	isRequest bool
+	traffic   akinet.ParsedNetworkTraffic
+}
+
+func NewPairer(opts Options) *Pairer {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = DefaultMaxPending
+	}
+	return &Pairer{
+		opts:    opts,
+		pending: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// Reads traffic from in until it is closed, and sends each matched request
+// and response to out. Traffic other than HTTP requests and responses is
+// ignored; use akinet.Tee to process it elsewhere. Closes out when done.
+func (p *Pairer) Run(in <-chan akinet.ParsedNetworkTraffic, out chan<- HTTPExchange) {
+	defer close(out)
+	for t := range in {
+		if exchange, ok := p.Add(t); ok {
+			out <- exchange
+		}
+	}
+	p.Flush()
+}
+
+// Adds a request or response. If it completes an exchange, returns the
+// exchange and true. Any other traffic is ignored.
+func (p *Pairer) Add(t akinet.ParsedNetworkTraffic) (HTTPExchange, bool) {
+	var key string
+	isRequest := false
+	switch c := t.Content.(type) {
+	case akinet.HTTPRequest:
+		key, isRequest = c.GetStreamKey(), true
+	case akinet.HTTPResponse:
+		key = c.GetStreamKey()
+	default:
+		return HTTPExchange{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if isRequest {
+		p.counters.Requests++
+	} else {
+		p.counters.Responses++
+	}
+	p.expire(t.ObservationTime.Add(-p.opts.Timeout))
+
+	if e, ok := p.pending[key]; ok {
+		other := e.Value.(*pendingTraffic)
+		if other.isRequest != isRequest {
+			p.remove(e)
+			p.counters.Exchanges++
+			if isRequest {
+				return newHTTPExchange(t, other.traffic), true
+			}
+			return newHTTPExchange(other.traffic, t), true
+		}
+
+		// A duplicate. Keep the newer one.
+		p.drop(e)
+	}
+
+	if p.order.Len() >= p.opts.MaxPending {
+		p.drop(p.order.Front())
+	}
+	p.pending[key] = p.order.PushBack(&pendingTraffic{key: key, isRequest: isRequest, traffic: t})
+	p.counters.Pending = p.order.Len()
+	return HTTPExchange{}, false
+}
+
+// Drops all unmatched requests and responses, and counts them as orphans.
+func (p *Pairer) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.order.Len() > 0 {
+		p.drop(p.order.Front())
+	}
+}
+
+func (p *Pairer) Counters() Counters {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.counters
+}
+
+// Drops unmatched requests and responses observed before the cutoff. Must hold
+// mu when calling expire.
+func (p *Pairer) expire(cutoff time.Time) {
+	for p.order.Len() > 0 {
+		front := p.order.Front()
+		if !front.Value.(*pendingTraffic).traffic.ObservationTime.Before(cutoff) {
+			return
+		}
+		p.drop(front)
+	}
+}
+
+// Drops an unmatched request or response, and counts it as an orphan. Must
+// hold mu when calling drop.
+func (p *Pairer) drop(e *list.Element) {
+	if e.Value.(*pendingTraffic).isRequest {
+		p.counters.OrphanedRequests++
+	} else {
+		p.counters.OrphanedResponses++
+	}
+	p.remove(e)
+}
+
+// Must hold mu when calling remove.
+func (p *Pairer) remove(e *list.Element) {
+	delete(p.pending, e.Value.(*pendingTraffic).key)
+	p.order.Remove(e)
+	p.counters.Pending = p.order.Len()
+}
+
+func newHTTPExchange(req, resp akinet.ParsedNetworkTraffic) HTTPExchange {
+	return HTTPExchange{
+		Request:        req.Content.(akinet.HTTPRequest),
+		Response:       resp.Content.(akinet.HTTPResponse),
+		ClientIP:       req.SrcIP,
+		ClientPort:     req.SrcPort,
+		ServerIP:       req.DstIP,
+		ServerPort:     req.DstPort,
+		Interface:      req.Interface,
+		RequestStart:   req.ObservationTime,
+		RequestEnd:     req.FinalPacketTime,
+		ResponseStart:  resp.ObservationTime,
+		ResponseEnd:    resp.FinalPacketTime,
+		Latency:        resp.ObservationTime.Sub(req.FinalPacketTime),
+		TimeToLastByte: resp.FinalPacketTime.Sub(req.FinalPacketTime),
+	}
+}
@@ -0,0 +1,179 @@
+package pairing
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+var (
+	testStreamID = uuid.MustParse("3c2d1a0b-9e8f-4a7b-8c6d-5e4f3a2b1c0d")
+	testStart    = time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+)
+
+func ms(n int) time.Time {
+	return testStart.Add(time.Duration(n) * time.Millisecond)
+}
+
+func request(seq int, start, end time.Time) akinet.ParsedNetworkTraffic {
+	return akinet.ParsedNetworkTraffic{
+		SrcIP:           net.IP{10, 0, 0, 1},
+		SrcPort:         54321,
+		DstIP:           net.IP{10, 0, 0, 2},
+		DstPort:         80,
+		Interface:       "eth0",
+		Content:         akinet.HTTPRequest{StreamID: testStreamID, Seq: seq, Method: "GET"},
+		ObservationTime: start,
+		FinalPacketTime: end,
+	}
+}
+
+func response(seq int, start, end time.Time) akinet.ParsedNetworkTraffic {
+	return akinet.ParsedNetworkTraffic{
+		SrcIP:           net.IP{10, 0, 0, 2},
+		SrcPort:         80,
+		DstIP:           net.IP{10, 0, 0, 1},
+		DstPort:         54321,
+		Interface:       "eth0",
+		Content:         akinet.HTTPResponse{StreamID: testStreamID, Seq: seq, StatusCode: 200},
+		ObservationTime: start,
+		FinalPacketTime: end,
+	}
+}
+
+func run(p *Pairer, traffic ...akinet.ParsedNetworkTraffic) []HTTPExchange {
+	in := make(chan akinet.ParsedNetworkTraffic, len(traffic))
+	for _, t := range traffic {
+		in <- t
+	}
+	close(in)
+
+	out := make(chan HTTPExchange)
+	go p.Run(in, out)
+
+	var results []HTTPExchange
+	for e := range out {
+		results = append(results, e)
+	}
+	return results
+}
+
+func TestPairing(t *testing.T) {
+	p := NewPairer(Options{})
+	exchanges := run(p,
+		request(1, ms(0), ms(2)),
+		akinet.ParsedNetworkTraffic{Content: akinet.TCPPacketMetadata{}, ObservationTime: ms(3)},
+		// The response arrives before its request.
+		response(2, ms(5), ms(6)),
+		request(2, ms(3), ms(4)),
+		response(1, ms(12), ms(15)),
+	)
+
+	expected := []HTTPExchange{
+		{
+			Request:        request(2, ms(3), ms(4)).Content.(akinet.HTTPRequest),
+			Response:       response(2, ms(5), ms(6)).Content.(akinet.HTTPResponse),
+			ClientIP:       net.IP{10, 0, 0, 1},
+			ClientPort:     54321,
+			ServerIP:       net.IP{10, 0, 0, 2},
+			ServerPort:     80,
+			Interface:      "eth0",
+			RequestStart:   ms(3),
+			RequestEnd:     ms(4),
+			ResponseStart:  ms(5),
+			ResponseEnd:    ms(6),
+			Latency:        time.Millisecond,
+			TimeToLastByte: 2 * time.Millisecond,
+		},
+		{
+			Request:        request(1, ms(0), ms(2)).Content.(akinet.HTTPRequest),
+			Response:       response(1, ms(12), ms(15)).Content.(akinet.HTTPResponse),
+			ClientIP:       net.IP{10, 0, 0, 1},
+			ClientPort:     54321,
+			ServerIP:       net.IP{10, 0, 0, 2},
+			ServerPort:     80,
+			Interface:      "eth0",
+			RequestStart:   ms(0),
+			RequestEnd:     ms(2),
+			ResponseStart:  ms(12),
+			ResponseEnd:    ms(15),
+			Latency:        10 * time.Millisecond,
+			TimeToLastByte: 13 * time.Millisecond,
+		},
+	}
+	if diff := cmp.Diff(expected, exchanges); diff != "" {
+		t.Errorf("found unexpected diff in exchanges:\n%s", diff)
+	}
+
+	expectedCounters := Counters{Requests: 2, Responses: 2, Exchanges: 2}
+	if diff := cmp.Diff(expectedCounters, p.Counters()); diff != "" {
+		t.Errorf("found unexpected diff in counters:\n%s", diff)
+	}
+}
+
+func TestPairingOrphans(t *testing.T) {
+	testCases := []struct {
+		name             string
+		opts             Options
+		traffic          []akinet.ParsedNetworkTraffic
+		expectedSeqs     []int
+		expectedCounters Counters
+	}{
+		{
+			name: "timeout",
+			opts: Options{Timeout: time.Second},
+			traffic: []akinet.ParsedNetworkTraffic{
+				request(1, ms(0), ms(1)),
+				response(2, ms(10), ms(11)),
+				request(3, ms(1500), ms(1501)),
+				// Request 1 has timed out by the time its response arrives.
+				response(1, ms(1502), ms(1503)),
+				response(3, ms(1504), ms(1505)),
+			},
+			expectedSeqs:     []int{3},
+			expectedCounters: Counters{Requests: 2, Responses: 3, Exchanges: 1, OrphanedRequests: 1, OrphanedResponses: 2},
+		},
+		{
+			name: "memory bound",
+			opts: Options{MaxPending: 2},
+			traffic: []akinet.ParsedNetworkTraffic{
+				request(1, ms(0), ms(1)),
+				request(2, ms(2), ms(3)),
+				response(3, ms(4), ms(5)),
+				response(2, ms(6), ms(7)),
+				response(1, ms(8), ms(9)),
+			},
+			expectedSeqs:     []int{2},
+			expectedCounters: Counters{Requests: 2, Responses: 3, Exchanges: 1, OrphanedRequests: 1, OrphanedResponses: 2},
+		},
+		{
+			name: "duplicate request",
+			traffic: []akinet.ParsedNetworkTraffic{
+				request(1, ms(0), ms(1)),
+				request(1, ms(2), ms(3)),
+				response(1, ms(4), ms(5)),
+			},
+			expectedSeqs:     []int{1},
+			expectedCounters: Counters{Requests: 2, Responses: 1, Exchanges: 1, OrphanedRequests: 1},
+		},
+	}
+
+	for _, c := range testCases {
+		p := NewPairer(c.opts)
+		var seqs []int
+		for _, e := range run(p, c.traffic...) {
+			seqs = append(seqs, e.Request.Seq)
+		}
+		if diff := cmp.Diff(c.expectedSeqs, seqs); diff != "" {
+			t.Errorf("[%s] found unexpected diff in exchanges:\n%s", c.name, diff)
+		}
+		if diff := cmp.Diff(c.expectedCounters, p.Counters()); diff != "" {
+			t.Errorf("[%s] found unexpected diff in counters:\n%s", c.name, diff)
+		}
+	}
+}
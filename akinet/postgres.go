@@ -0,0 +1,201 @@
+package akinet
+
+import (
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Identifies the kind of message that a PostgreSQL client sends before it can
+// issue queries.
+type PostgresStartupType int
+
+const (
+	// A StartupMessage, carrying the protocol version and connection
+	// parameters.
+	PostgresStartup PostgresStartupType = iota
+
+	// An SSLRequest, asking to upgrade the connection to TLS.
+	PostgresSSLRequest
+
+	// A GSSENCRequest, asking to encrypt the connection with GSSAPI.
+	PostgresGSSENCRequest
+
+	// A CancelRequest, asking the server to cancel a query running on another
+	// connection.
+	PostgresCancelRequest
+
+	// A response to an authentication request from the server (e.g. a password
+	// or a SASL message). Its contents are not captured.
+	PostgresAuthenticationResponse
+)
+
+func (t PostgresStartupType) String() string {
+	switch t {
+	case PostgresStartup:
+		return "StartupMessage"
+	case PostgresSSLRequest:
+		return "SSLRequest"
+	case PostgresGSSENCRequest:
+		return "GSSENCRequest"
+	case PostgresCancelRequest:
+		return "CancelRequest"
+	case PostgresAuthenticationResponse:
+		return "AuthenticationResponse"
+	}
+	return "PostgresStartupType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Represents a message sent by a PostgreSQL client while setting up a
+// connection. The server's reply is a PostgresResponse, or a
+// PostgresEncryptionResponse for SSLRequest and GSSENCRequest, with the same
+// stream key.
+type PostgresStartupMessage struct {
+	// StreamID and Seq uniquely identify a pair of startup message and response.
+	StreamID uuid.UUID
+	Seq      int
+
+	Type PostgresStartupType
+
+	// The protocol version requested in a StartupMessage, e.g. 3 and 0.
+	ProtocolMajor int
+	ProtocolMinor int
+
+	// The connection parameters in a StartupMessage (e.g. user, database and
+	// application_name).
+	Parameters map[string]string
+}
+
+func (PostgresStartupMessage) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this message with its corresponding
+// response.
+func (m PostgresStartupMessage) GetStreamKey() string {
+	return m.StreamID.String() + ":" + strconv.Itoa(m.Seq)
+}
+
+// Represents the single-byte reply of a PostgreSQL server to an SSLRequest or
+// GSSENCRequest.
+type PostgresEncryptionResponse struct {
+	// StreamID and Seq uniquely identify a pair of request and response.
+	StreamID uuid.UUID
+	Seq      int
+
+	// Whether the server agreed to encrypt the connection.
+	Accepted bool
+}
+
+func (PostgresEncryptionResponse) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this response with its corresponding
+// request.
+func (r PostgresEncryptionResponse) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(r.Seq)
+}
+
+// Represents a query sent by a PostgreSQL client: either a simple Query
+// message, or a sequence of extended query messages (Parse, Bind, Execute,
+// etc.) up to a Sync. Parameter values are not captured.
+//
+// The corresponding PostgresResponse has the same stream key. The time taken
+// to run the query is the difference between the observation times of the two.
+type PostgresQuery struct {
+	// StreamID and Seq uniquely identify a pair of query and response.
+	StreamID uuid.UUID
+	Seq      int
+
+	// Whether the query used the extended query protocol.
+	Extended bool
+
+	// The SQL text of the query. For the extended query protocol, this holds the
+	// text of each Parse message, in order; it is empty if the client executed
+	// statements that were prepared earlier. A simple query has a single
+	// element, which may hold several SQL statements.
+	Statements []string
+
+	// For the extended query protocol, the name of the prepared statement given
+	// in each Bind message. The unnamed statement is represented by the empty
+	// string.
+	BoundStatements []string
+
+	// The total number of parameter values bound, and their total size.
+	ParameterCount      int
+	ParameterSize_bytes int
+
+	// The number of Execute messages.
+	Executions int
+}
+
+func (PostgresQuery) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this query with its corresponding
+// response.
+func (q PostgresQuery) GetStreamKey() string {
+	return q.StreamID.String() + ":" + strconv.Itoa(q.Seq)
+}
+
+// Describes a column in the result of a PostgreSQL query.
+type PostgresColumn struct {
+	Name string
+
+	// The OID of the table that the column comes from, or 0 if the column is
+	// not a plain table column.
+	TableOID uint32
+
+	// The OID of the column's data type.
+	TypeOID uint32
+}
+
+// Represents an ErrorResponse from a PostgreSQL server.
+type PostgresError struct {
+	// e.g. ERROR or FATAL.
+	Severity string
+
+	// The SQLSTATE code, e.g. 42P01.
+	Code string
+
+	Message string
+}
+
+// Represents the messages sent by a PostgreSQL server in reply to a query or
+// startup message, up to the point where the server waits for the client again
+// (ReadyForQuery, or an authentication request). Row values are not captured.
+type PostgresResponse struct {
+	// StreamID and Seq uniquely identify a pair of query and response.
+	StreamID uuid.UUID
+	Seq      int
+
+	// Columns from the RowDescription of the last statement that returned rows.
+	Columns []PostgresColumn
+
+	// The command tag of each CommandComplete message, e.g. "SELECT 3" or
+	// "INSERT 0 1".
+	CommandTags []string
+
+	// The number of DataRow messages.
+	RowCount int
+
+	// Set if the server sent an ErrorResponse.
+	Error *PostgresError
+
+	// The type code of the last authentication message from the server, if any.
+	// 0 indicates successful authentication.
+	AuthenticationType *int
+
+	// Run-time parameters reported by the server through ParameterStatus
+	// messages.
+	Parameters map[string]string
+
+	// The transaction status from ReadyForQuery: 'I' if idle, 'T' if in a
+	// transaction block, or 'E' if in a failed transaction block. 0 if the
+	// response did not end with ReadyForQuery.
+	TransactionStatus byte
+}
+
+func (PostgresResponse) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this response with its corresponding
+// query.
+func (r PostgresResponse) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(r.Seq)
+}
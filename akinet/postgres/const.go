@@ -0,0 +1,85 @@
+package postgres
+
+import "time"
+
+const (
+	// Length of the header of every message after the startup message: a type
+	// byte followed by a 4-byte length. The length includes itself, but not the
+	// type byte.
+	messageHeaderLength_bytes = 5
+
+	// Length of the length field at the start of a startup message, or after the
+	// type byte of any other message.
+	lengthFieldLength_bytes = 4
+
+	// Largest message that the protocol allows.
+	maxMessageLength_bytes = 1 << 30
+
+	// Largest startup message accepted by PostgreSQL (MAX_STARTUP_PACKET_LENGTH
+	// in the server source).
+	maxStartupMessageLength_bytes = 10000
+
+	// Request codes that take the place of the protocol version in a startup
+	// message.
+	cancelRequestCode = 80877102
+	sslRequestCode    = 80877103
+	gssencRequestCode = 80877104
+
+	// Major version of the only protocol version in use since PostgreSQL 7.4.
+	protocolMajorVersion3 = 3
+
+	// Connections that have not seen any traffic for this long are forgotten.
+	connectionIdleTimeout = 10 * time.Minute
+)
+
+// Types of messages sent by the client.
+const (
+	bindMessage     = 'B'
+	closeMessage    = 'C'
+	describeMessage = 'D'
+	executeMessage  = 'E'
+	flushMessage    = 'H'
+	parseMessage    = 'P'
+	passwordMessage = 'p' // Also used for GSSAPI and SASL responses.
+	queryMessage    = 'Q'
+	syncMessage     = 'S'
+)
+
+// Types of messages sent by the server.
+const (
+	authenticationMessage           = 'R'
+	backendKeyDataMessage           = 'K'
+	bindCompleteMessage             = '2'
+	closeCompleteMessage            = '3'
+	commandCompleteMessage          = 'C'
+	copyInResponseMessage           = 'G'
+	copyOutResponseMessage          = 'H'
+	copyDataMessage                 = 'd'
+	copyDoneMessage                 = 'c'
+	dataRowMessage                  = 'D'
+	emptyQueryResponseMessage       = 'I'
+	errorResponseMessage            = 'E'
+	negotiateProtocolVersionMessage = 'v'
+	noDataMessage                   = 'n'
+	noticeResponseMessage           = 'N'
+	notificationResponseMessage     = 'A'
+	parameterDescriptionMessage     = 't'
+	parameterStatusMessage          = 'S'
+	parseCompleteMessage            = '1'
+	portalSuspendedMessage          = 's'
+	readyForQueryMessage            = 'Z'
+	rowDescriptionMessage           = 'T'
+)
+
+// Authentication message codes.
+const (
+	authenticationOK        = 0
+	authenticationSASLFinal = 12
+)
+
+// Single-byte replies to SSLRequest and GSSENCRequest.
+const (
+	encryptionAccepted    = 'S'
+	gssEncryptionAccepted = 'G'
+	encryptionRejected    = 'N'
+)
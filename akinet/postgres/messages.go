@@ -0,0 +1,377 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var errMalformedMessage = errors.New("malformed PostgreSQL message")
+
+// Reads the header of the message starting at the given offset. Returns false
+// if the input does not contain the whole header. The returned length covers
+// the whole message, including the type byte.
+func readMessageHeader(input memview.MemView, offset int64) (typ byte, length int64, ok bool) {
+	if input.Len()-offset < messageHeaderLength_bytes {
+		return 0, 0, false
+	}
+	return input.GetByte(offset), 1 + int64(input.GetUint32(offset+1)), true
+}
+
+// Determines whether the length field of a message is plausible.
+func validMessageLength(length int64) bool {
+	return length >= messageHeaderLength_bytes && length <= maxMessageLength_bytes
+}
+
+// Returns the body of the message starting at the given offset, which must be
+// complete.
+func messageBody(input memview.MemView, offset, length int64) []byte {
+	return []byte(input.SubView(offset+messageHeaderLength_bytes, offset+length).String())
+}
+
+// Reads fields from the body of a message. Errors are sticky: once a read
+// fails, all later reads return zero values, and err is set.
+type bodyReader struct {
+	buf []byte
+	err error
+}
+
+func (r *bodyReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.buf, 0)
+	if i < 0 {
+		r.err = errMalformedMessage
+		return ""
+	}
+	result := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return result
+}
+
+func (r *bodyReader) byte() byte {
+	if r.err != nil || len(r.buf) < 1 {
+		r.err = errMalformedMessage
+		return 0
+	}
+	result := r.buf[0]
+	r.buf = r.buf[1:]
+	return result
+}
+
+func (r *bodyReader) int16() int16 {
+	if r.err != nil || len(r.buf) < 2 {
+		r.err = errMalformedMessage
+		return 0
+	}
+	result := int16(binary.BigEndian.Uint16(r.buf))
+	r.buf = r.buf[2:]
+	return result
+}
+
+func (r *bodyReader) int32() int32 {
+	if r.err != nil || len(r.buf) < 4 {
+		r.err = errMalformedMessage
+		return 0
+	}
+	result := int32(binary.BigEndian.Uint32(r.buf))
+	r.buf = r.buf[4:]
+	return result
+}
+
+func (r *bodyReader) skip(n int) {
+	if r.err != nil || n < 0 || len(r.buf) < n {
+		r.err = errMalformedMessage
+		return
+	}
+	r.buf = r.buf[n:]
+}
+
+// Returns an error if a read failed, or if there are unread bytes left.
+func (r *bodyReader) finish() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.buf) > 0 {
+		return errMalformedMessage
+	}
+	return nil
+}
+
+// Parses the body of a startup message, which starts right after its length
+// field.
+func parseStartupMessage(body []byte) (akinet.PostgresStartupMessage, error) {
+	r := bodyReader{buf: body}
+	code := r.int32()
+	if r.err != nil {
+		return akinet.PostgresStartupMessage{}, r.err
+	}
+
+	var result akinet.PostgresStartupMessage
+	switch code {
+	case sslRequestCode:
+		result.Type = akinet.PostgresSSLRequest
+	case gssencRequestCode:
+		result.Type = akinet.PostgresGSSENCRequest
+	case cancelRequestCode:
+		// The process ID and secret key of the connection to cancel.
+		result.Type = akinet.PostgresCancelRequest
+		r.skip(8)
+	default:
+		result.Type = akinet.PostgresStartup
+		result.ProtocolMajor = int(code >> 16)
+		result.ProtocolMinor = int(code & 0xffff)
+		if result.ProtocolMajor != protocolMajorVersion3 {
+			return akinet.PostgresStartupMessage{}, errors.Errorf("unsupported PostgreSQL protocol version %d.%d", result.ProtocolMajor, result.ProtocolMinor)
+		}
+
+		// A list of parameter names and values, terminated by an empty name.
+		result.Parameters = map[string]string{}
+		for {
+			name := r.cstring()
+			if name == "" || r.err != nil {
+				break
+			}
+			result.Parameters[name] = r.cstring()
+		}
+	}
+
+	return result, r.finish()
+}
+
+func parseQuery(body []byte) (string, error) {
+	r := bodyReader{buf: body}
+	query := r.cstring()
+	return query, r.finish()
+}
+
+// Parses a Parse message, returning the SQL text and the number of parameter
+// types given.
+func parseParse(body []byte) (query string, err error) {
+	r := bodyReader{buf: body}
+	r.cstring() // Statement name
+	query = r.cstring()
+	numTypes := r.int16()
+	r.skip(4 * int(numTypes))
+	return query, r.finish()
+}
+
+// Parses a Bind message, returning the name of the prepared statement, and the
+// number and total size of parameter values. NULL values have no size.
+func parseBind(body []byte) (statement string, numParams int, size int, err error) {
+	r := bodyReader{buf: body}
+	r.cstring() // Portal name
+	statement = r.cstring()
+
+	numFormats := r.int16()
+	r.skip(2 * int(numFormats))
+
+	numParams = int(r.int16())
+	for i := 0; i < numParams && r.err == nil; i++ {
+		length := r.int32()
+		if length < 0 {
+			// NULL
+			continue
+		}
+		r.skip(int(length))
+		size += int(length)
+	}
+
+	numResultFormats := r.int16()
+	r.skip(2 * int(numResultFormats))
+	return statement, numParams, size, r.finish()
+}
+
+func parseExecute(body []byte) error {
+	r := bodyReader{buf: body}
+	r.cstring() // Portal name
+	r.int32()   // Maximum number of rows
+	return r.finish()
+}
+
+// Parses a Describe or Close message.
+func parseDescribeOrClose(body []byte) error {
+	r := bodyReader{buf: body}
+	switch r.byte() {
+	case 'S', 'P':
+	default:
+		return errMalformedMessage
+	}
+	r.cstring()
+	return r.finish()
+}
+
+func parseAuthentication(body []byte) (int, error) {
+	r := bodyReader{buf: body}
+	code := r.int32()
+	if r.err != nil {
+		return 0, r.err
+	}
+	if code < authenticationOK || code > authenticationSASLFinal {
+		return 0, errors.Errorf("unknown PostgreSQL authentication type %d", code)
+	}
+	// The rest depends on the authentication type (e.g. a salt, or SASL data).
+	return int(code), nil
+}
+
+func parseParameterStatus(body []byte) (name, value string, err error) {
+	r := bodyReader{buf: body}
+	name = r.cstring()
+	value = r.cstring()
+	return name, value, r.finish()
+}
+
+func parseReadyForQuery(body []byte) (byte, error) {
+	r := bodyReader{buf: body}
+	status := r.byte()
+	if err := r.finish(); err != nil {
+		return 0, err
+	}
+	switch status {
+	case 'I', 'T', 'E':
+		return status, nil
+	}
+	return 0, errors.Errorf("unknown PostgreSQL transaction status %q", status)
+}
+
+func parseRowDescription(body []byte) ([]akinet.PostgresColumn, error) {
+	r := bodyReader{buf: body}
+	numFields := int(r.int16())
+	if numFields < 0 {
+		return nil, errMalformedMessage
+	}
+
+	result := make([]akinet.PostgresColumn, 0, numFields)
+	for i := 0; i < numFields && r.err == nil; i++ {
+		col := akinet.PostgresColumn{
+			Name:     r.cstring(),
+			TableOID: uint32(r.int32()),
+		}
+		r.int16() // Column attribute number
+		col.TypeOID = uint32(r.int32())
+		r.int16() // Type size
+		r.int32() // Type modifier
+		r.int16() // Format code
+		result = append(result, col)
+	}
+	return result, r.finish()
+}
+
+func parseDataRow(body []byte) error {
+	r := bodyReader{buf: body}
+	numColumns := int(r.int16())
+	for i := 0; i < numColumns && r.err == nil; i++ {
+		if length := r.int32(); length >= 0 {
+			r.skip(int(length))
+		}
+	}
+	return r.finish()
+}
+
+func parseCommandComplete(body []byte) (string, error) {
+	r := bodyReader{buf: body}
+	tag := r.cstring()
+	return tag, r.finish()
+}
+
+// Parses an ErrorResponse or NoticeResponse.
+func parseErrorResponse(body []byte) (*akinet.PostgresError, error) {
+	r := bodyReader{buf: body}
+	result := &akinet.PostgresError{}
+	for {
+		fieldType := r.byte()
+		if fieldType == 0 || r.err != nil {
+			break
+		}
+		value := r.cstring()
+		switch fieldType {
+		case 'V':
+			// Non-localized severity.
+			result.Severity = value
+		case 'S':
+			if result.Severity == "" {
+				result.Severity = value
+			}
+		case 'C':
+			result.Code = value
+		case 'M':
+			result.Message = value
+		}
+	}
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseBackendKeyData(body []byte) error {
+	r := bodyReader{buf: body}
+	r.int32() // Process ID
+	r.int32() // Secret key
+	return r.finish()
+}
+
+func parseParameterDescription(body []byte) error {
+	r := bodyReader{buf: body}
+	numParams := r.int16()
+	r.skip(4 * int(numParams))
+	return r.finish()
+}
+
+func parseEmpty(body []byte) error {
+	if len(body) != 0 {
+		return errMalformedMessage
+	}
+	return nil
+}
+
+// Validates the body of a message sent by the server.
+func validateBackendMessage(typ byte, body []byte) error {
+	var err error
+	switch typ {
+	case authenticationMessage:
+		_, err = parseAuthentication(body)
+	case backendKeyDataMessage:
+		err = parseBackendKeyData(body)
+	case parseCompleteMessage, bindCompleteMessage, closeCompleteMessage, noDataMessage, emptyQueryResponseMessage, portalSuspendedMessage:
+		err = parseEmpty(body)
+	case commandCompleteMessage:
+		_, err = parseCommandComplete(body)
+	case dataRowMessage:
+		err = parseDataRow(body)
+	case errorResponseMessage, noticeResponseMessage:
+		_, err = parseErrorResponse(body)
+	case parameterDescriptionMessage:
+		err = parseParameterDescription(body)
+	case parameterStatusMessage:
+		_, _, err = parseParameterStatus(body)
+	case readyForQueryMessage:
+		_, err = parseReadyForQuery(body)
+	case rowDescriptionMessage:
+		_, err = parseRowDescription(body)
+	default:
+		err = errors.Errorf("unexpected PostgreSQL message type %q", typ)
+	}
+	return err
+}
+
+// Validates the body of a message that can start a query from the client.
+func validateQueryMessage(typ byte, body []byte) error {
+	var err error
+	switch typ {
+	case queryMessage:
+		_, err = parseQuery(body)
+	case parseMessage:
+		_, err = parseParse(body)
+	case bindMessage:
+		_, _, _, err = parseBind(body)
+	default:
+		err = errors.Errorf("unexpected PostgreSQL message type %q", typ)
+	}
+	return err
+}
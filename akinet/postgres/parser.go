@@ -0,0 +1,391 @@
+package postgres
+
+import (
+	"io"
+
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Identifies what a parser is reading: one side's turn in the conversation,
+// which ends when the other side has to reply.
+type turn int
+
+const (
+	unknownTurn turn = iota
+
+	// A startup message from the client.
+	startupTurn
+
+	// A response to an authentication request from the client.
+	authenticationTurn
+
+	// A simple query, or a sequence of extended query messages ending with
+	// Sync, from the client.
+	queryTurn
+
+	// The server's single-byte reply to SSLRequest or GSSENCRequest.
+	encryptionResponseTurn
+
+	// Messages from the server, up to ReadyForQuery or an authentication
+	// request.
+	responseTurn
+)
+
+func newPostgresParser(factory *postgresParserFactory, bidiID akinet.TCPBidiID, seq, ack reassembly.Sequence) *postgresParser {
+	return &postgresParser{
+		factory: factory,
+		bidiID:  bidiID,
+		seq:     seq,
+		ack:     ack,
+		input:   akinet.NewRetainedInput(0),
+	}
+}
+
+// Parses one side's turn in a PostgreSQL conversation.
+type postgresParser struct {
+	factory  *postgresParserFactory
+	bidiID   akinet.TCPBidiID
+	seq, ack reassembly.Sequence
+
+	// The input, which is returned as unused if the turn can't be parsed.
+	input akinet.RetainedInput
+
+	// Input that has not been consumed yet, starting at pos. Consumed input is
+	// dropped as we go, so that large result sets aren't held in memory.
+	allInput memview.MemView
+	pos      int64
+
+	turn        turn
+	numMessages int
+
+	startup  akinet.PostgresStartupMessage
+	query    akinet.PostgresQuery
+	response akinet.PostgresResponse
+}
+
+var _ akinet.TCPParser = (*postgresParser)(nil)
+
+func (*postgresParser) Name() string {
+	return "PostgreSQL Parser"
+}
+
+func (p *postgresParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	p.input.Append(input)
+	p.allInput.Append(input)
+
+	result, err = p.parse()
+	if err != nil {
+		return nil, p.input.Unused(p.allInput), err
+	}
+
+	if result == nil {
+		if !isEnd {
+			return nil, memview.MemView{}, nil
+		}
+
+		// The flow ended in the middle of a turn. Emit what we have, if anything.
+		if p.numMessages == 0 {
+			return nil, p.input.Unused(p.allInput), io.ErrUnexpectedEOF
+		}
+		result = p.result()
+		p.pos = p.allInput.Len()
+	}
+
+	return result, p.allInput.SubView(p.pos, p.allInput.Len()), nil
+}
+
+// Consumes input until the turn is complete or more input is needed. Returns
+// a non-nil result if the turn is complete.
+func (p *postgresParser) parse() (akinet.ParsedNetworkContent, error) {
+	if p.turn == unknownTurn {
+		if p.allInput.Len() == 0 {
+			return nil, nil
+		}
+		p.turn = p.identifyTurn(p.allInput.GetByte(0))
+	}
+
+	switch p.turn {
+	case startupTurn:
+		return p.parseStartupMessage()
+	case encryptionResponseTurn:
+		return p.parseEncryptionResponse(), nil
+	}
+
+	for {
+		typ, length, ok := readMessageHeader(p.allInput, p.pos)
+		if !ok {
+			return nil, nil
+		}
+		if !validMessageLength(length) {
+			return nil, errors.Errorf("invalid length %d for PostgreSQL message %q", length, typ)
+		}
+		if p.allInput.Len()-p.pos < length {
+			return nil, nil
+		}
+
+		var consume, done bool
+		var err error
+		switch p.turn {
+		case authenticationTurn:
+			consume, done, err = p.processAuthenticationMessage(typ)
+		case queryTurn:
+			consume, done, err = p.processQueryMessage(typ, length)
+		case responseTurn:
+			consume, done, err = p.processResponseMessage(typ, length)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if consume {
+			p.pos += length
+			p.numMessages++
+			p.allInput = p.allInput.SubView(p.pos, p.allInput.Len())
+			p.pos = 0
+		}
+		if done {
+			return p.result(), nil
+		}
+	}
+}
+
+// Determines what kind of turn the input starts with, given its first byte.
+func (p *postgresParser) identifyTurn(b0 byte) turn {
+	switch {
+	case b0 == 0:
+		return startupTurn
+	case b0 == queryMessage || b0 == parseMessage || b0 == bindMessage:
+		return queryTurn
+	case b0 == passwordMessage:
+		return authenticationTurn
+	case isEncryptionResponse(b0) && p.factory.encryptionRequested(p.bidiID):
+		return encryptionResponseTurn
+	}
+	return responseTurn
+}
+
+func (p *postgresParser) parseStartupMessage() (akinet.ParsedNetworkContent, error) {
+	if p.allInput.Len() < lengthFieldLength_bytes {
+		return nil, nil
+	}
+	length := int64(p.allInput.GetUint32(0))
+	if length < 2*lengthFieldLength_bytes || length > maxStartupMessageLength_bytes {
+		return nil, errors.Errorf("invalid length %d for PostgreSQL startup message", length)
+	}
+	if p.allInput.Len() < length {
+		return nil, nil
+	}
+
+	msg, err := parseStartupMessage([]byte(p.allInput.SubView(lengthFieldLength_bytes, length).String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse PostgreSQL startup message")
+	}
+	p.startup = msg
+	p.pos = length
+	p.numMessages++
+
+	switch msg.Type {
+	case akinet.PostgresSSLRequest, akinet.PostgresGSSENCRequest:
+		p.factory.setEncryptionRequested(p.bidiID, true)
+	}
+	return p.result(), nil
+}
+
+func (p *postgresParser) parseEncryptionResponse() akinet.ParsedNetworkContent {
+	p.factory.setEncryptionRequested(p.bidiID, false)
+	p.pos = 1
+	p.numMessages++
+
+	b0 := p.allInput.GetByte(0)
+	return akinet.PostgresEncryptionResponse{
+		StreamID: uuid.UUID(p.bidiID),
+		Seq:      int(p.seq),
+		Accepted: b0 == encryptionAccepted || b0 == gssEncryptionAccepted,
+	}
+}
+
+func (p *postgresParser) processAuthenticationMessage(typ byte) (consume, done bool, err error) {
+	if typ != passwordMessage {
+		return false, false, errors.Errorf("unexpected PostgreSQL message type %q", typ)
+	}
+
+	// Don't look at the contents, which may include a password.
+	p.startup.Type = akinet.PostgresAuthenticationResponse
+	p.factory.setAuthenticationRequested(p.bidiID, false)
+	return true, true, nil
+}
+
+func (p *postgresParser) processQueryMessage(typ byte, length int64) (consume, done bool, err error) {
+	q := &p.query
+	if typ == queryMessage && p.numMessages > 0 {
+		// A simple query after an incomplete sequence of extended query messages.
+		return false, true, nil
+	}
+
+	body := messageBody(p.allInput, p.pos, length)
+	switch typ {
+	case queryMessage:
+		query, err := parseQuery(body)
+		if err != nil {
+			return false, false, err
+		}
+		q.Statements = append(q.Statements, query)
+		return true, true, nil
+
+	case parseMessage:
+		query, err := parseParse(body)
+		if err != nil {
+			return false, false, err
+		}
+		q.Extended = true
+		q.Statements = append(q.Statements, query)
+
+	case bindMessage:
+		statement, numParams, size, err := parseBind(body)
+		if err != nil {
+			return false, false, err
+		}
+		q.Extended = true
+		q.BoundStatements = append(q.BoundStatements, statement)
+		q.ParameterCount += numParams
+		q.ParameterSize_bytes += size
+
+	case executeMessage:
+		if err := parseExecute(body); err != nil {
+			return false, false, err
+		}
+		q.Executions++
+
+	case describeMessage, closeMessage:
+		if err := parseDescribeOrClose(body); err != nil {
+			return false, false, err
+		}
+
+	case flushMessage:
+		if err := parseEmpty(body); err != nil {
+			return false, false, err
+		}
+
+	case syncMessage:
+		if err := parseEmpty(body); err != nil {
+			return false, false, err
+		}
+		return true, true, nil
+
+	default:
+		return false, false, errors.Errorf("unexpected PostgreSQL message type %q in query", typ)
+	}
+	return true, false, nil
+}
+
+func (p *postgresParser) processResponseMessage(typ byte, length int64) (consume, done bool, err error) {
+	r := &p.response
+
+	// Skip row and COPY data without reading it.
+	switch typ {
+	case dataRowMessage:
+		r.RowCount++
+		return true, false, nil
+	case copyDataMessage:
+		return true, false, nil
+	}
+
+	body := messageBody(p.allInput, p.pos, length)
+	switch typ {
+	case authenticationMessage:
+		code, err := parseAuthentication(body)
+		if err != nil {
+			return false, false, err
+		}
+		r.AuthenticationType = &code
+		if code != authenticationOK && code != authenticationSASLFinal {
+			// The server is waiting for the client to authenticate.
+			p.factory.setAuthenticationRequested(p.bidiID, true)
+			return true, true, nil
+		}
+
+	case parameterStatusMessage:
+		name, value, err := parseParameterStatus(body)
+		if err != nil {
+			return false, false, err
+		}
+		if r.Parameters == nil {
+			r.Parameters = map[string]string{}
+		}
+		r.Parameters[name] = value
+
+	case rowDescriptionMessage:
+		columns, err := parseRowDescription(body)
+		if err != nil {
+			return false, false, err
+		}
+		r.Columns = columns
+
+	case commandCompleteMessage:
+		tag, err := parseCommandComplete(body)
+		if err != nil {
+			return false, false, err
+		}
+		r.CommandTags = append(r.CommandTags, tag)
+
+	case errorResponseMessage:
+		e, err := parseErrorResponse(body)
+		if err != nil {
+			return false, false, err
+		}
+		if r.Error == nil {
+			r.Error = e
+		}
+
+	case readyForQueryMessage:
+		status, err := parseReadyForQuery(body)
+		if err != nil {
+			return false, false, err
+		}
+		r.TransactionStatus = status
+		return true, true, nil
+
+	case copyInResponseMessage:
+		// The server is waiting for the client to send data.
+		return true, true, nil
+
+	case backendKeyDataMessage, noticeResponseMessage, notificationResponseMessage,
+		parseCompleteMessage, bindCompleteMessage, closeCompleteMessage,
+		noDataMessage, parameterDescriptionMessage, portalSuspendedMessage,
+		emptyQueryResponseMessage, copyOutResponseMessage, copyDoneMessage,
+		negotiateProtocolVersionMessage:
+		// Nothing to record.
+
+	default:
+		return false, false, errors.Errorf("unexpected PostgreSQL message type %q in response", typ)
+	}
+	return true, false, nil
+}
+
+func (p *postgresParser) result() akinet.ParsedNetworkContent {
+	// As with HTTP, the client's turn finishes before the server replies, so
+	// the ack number on the first segment of the client's turn is equal to the
+	// seq number on the first segment of the server's reply.
+	switch p.turn {
+	case startupTurn, authenticationTurn:
+		result := p.startup
+		result.StreamID = uuid.UUID(p.bidiID)
+		result.Seq = int(p.ack)
+		return result
+	case queryTurn:
+		result := p.query
+		result.StreamID = uuid.UUID(p.bidiID)
+		result.Seq = int(p.ack)
+		return result
+	}
+
+	result := p.response
+	result.StreamID = uuid.UUID(p.bidiID)
+	result.Seq = int(p.seq)
+	return result
+}
@@ -0,0 +1,209 @@
+package postgres
+
+import (
+	"sync"
+
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a parser factory for both halves of a PostgreSQL connection, using
+// the frontend/backend protocol version 3.0.
+//
+// Some replies from the server can only be recognized if the request that
+// prompted them was seen (e.g. the single-byte reply to an SSLRequest), so the
+// returned factory keeps track of connections in the middle of setup. The same
+// factory must be used for both flows of a connection.
+func NewPostgresParserFactory() akinet.TCPParserFactory {
+	factory := &postgresParserFactory{}
+	factory.connections = akinet.NewConnectionMap(connectionIdleTimeout, func(_ akinet.TCPBidiID, state interface{}) {
+		conn := state.(*connection)
+		if conn.encryptionRequested {
+			factory.numEncryptionRequested--
+		}
+		if conn.authenticationRequested {
+			factory.numAuthenticationRequested--
+		}
+	})
+	return factory
+}
+
+type postgresParserFactory struct {
+	mu          sync.Mutex
+	connections *akinet.ConnectionMap // of *connection, protected by mu
+
+	// The number of connections with encryptionRequested and
+	// authenticationRequested set. Protected by mu.
+	numEncryptionRequested     int
+	numAuthenticationRequested int
+}
+
+// The state of a connection that is being set up.
+type connection struct {
+	// Set when the client has asked to encrypt the connection, until the server
+	// replies.
+	encryptionRequested bool
+
+	// Set when the server has asked the client to authenticate, until the
+	// client responds.
+	authenticationRequested bool
+}
+
+func (*postgresParserFactory) Name() string {
+	return "PostgreSQL Parser Factory"
+}
+
+func (factory *postgresParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	decision, discardFront = factory.accepts(input)
+
+	if decision == akinet.NeedMoreData && isEnd {
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+
+	return decision, discardFront
+}
+
+func (factory *postgresParserFactory) accepts(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	if input.Len() == 0 {
+		return akinet.NeedMoreData, 0
+	}
+
+	b0 := input.GetByte(0)
+	switch {
+	case b0 == 0:
+		// Startup messages have no type byte, and are short enough that their
+		// length starts with a zero byte.
+		return acceptStartupMessage(input)
+
+	case b0 == queryMessage || b0 == parseMessage || b0 == bindMessage:
+		return acceptMessage(input, validateQueryMessage)
+
+	case b0 == passwordMessage && factory.anyAuthenticationRequested():
+		typ, length, ok := readMessageHeader(input, 0)
+		if !ok {
+			return akinet.NeedMoreData, 0
+		}
+		if typ == passwordMessage && validMessageLength(length) {
+			return akinet.Accept, 0
+		}
+		return akinet.Reject, input.Len()
+
+	case isEncryptionResponse(b0) && factory.anyEncryptionRequested():
+		return akinet.Accept, 0
+	}
+
+	return acceptMessage(input, validateBackendMessage)
+}
+
+// Accepts input that starts with a complete, valid startup message.
+func acceptStartupMessage(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	if input.Len() < lengthFieldLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+	length := int64(input.GetUint32(0))
+	if length < 2*lengthFieldLength_bytes || length > maxStartupMessageLength_bytes {
+		return akinet.Reject, input.Len()
+	}
+	if input.Len() < length {
+		return akinet.NeedMoreData, 0
+	}
+
+	body := []byte(input.SubView(lengthFieldLength_bytes, length).String())
+	if _, err := parseStartupMessage(body); err != nil {
+		return akinet.Reject, input.Len()
+	}
+	return akinet.Accept, 0
+}
+
+// Accepts input that starts with a complete message that passes the given
+// validation. Messages are recognized by their contents, so the whole message
+// is needed.
+func acceptMessage(input memview.MemView, validate func(typ byte, body []byte) error) (decision akinet.AcceptDecision, discardFront int64) {
+	typ, length, ok := readMessageHeader(input, 0)
+	if !ok {
+		return akinet.NeedMoreData, 0
+	}
+	if !validMessageLength(length) {
+		return akinet.Reject, input.Len()
+	}
+	if input.Len() < length {
+		return akinet.NeedMoreData, 0
+	}
+
+	if err := validate(typ, messageBody(input, 0, length)); err != nil {
+		return akinet.Reject, input.Len()
+	}
+	return akinet.Accept, 0
+}
+
+func isEncryptionResponse(b byte) bool {
+	return b == encryptionAccepted || b == gssEncryptionAccepted || b == encryptionRejected
+}
+
+func (factory *postgresParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newPostgresParser(factory, id, seq, ack)
+}
+
+func (factory *postgresParserFactory) anyEncryptionRequested() bool {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+	return factory.numEncryptionRequested > 0
+}
+
+func (factory *postgresParserFactory) anyAuthenticationRequested() bool {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+	return factory.numAuthenticationRequested > 0
+}
+
+// Determines whether the client on the given connection is waiting for a reply
+// to a request for encryption.
+func (factory *postgresParserFactory) encryptionRequested(id akinet.TCPBidiID) bool {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+	conn, ok := factory.connections.Get(id).(*connection)
+	return ok && conn.encryptionRequested
+}
+
+func (factory *postgresParserFactory) setEncryptionRequested(id akinet.TCPBidiID, requested bool) {
+	factory.update(id, func(conn *connection) {
+		if conn.encryptionRequested != requested {
+			conn.encryptionRequested = requested
+			if requested {
+				factory.numEncryptionRequested++
+			} else {
+				factory.numEncryptionRequested--
+			}
+		}
+	})
+}
+
+func (factory *postgresParserFactory) setAuthenticationRequested(id akinet.TCPBidiID, requested bool) {
+	factory.update(id, func(conn *connection) {
+		if conn.authenticationRequested != requested {
+			conn.authenticationRequested = requested
+			if requested {
+				factory.numAuthenticationRequested++
+			} else {
+				factory.numAuthenticationRequested--
+			}
+		}
+	})
+}
+
+// Applies the given update to the state of a connection, and forgets the
+// connection once it has nothing left to track.
+func (factory *postgresParserFactory) update(id akinet.TCPBidiID, f func(*connection)) {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	conn := factory.connections.GetOrCreate(id, func() interface{} { return &connection{} }).(*connection)
+	f(conn)
+
+	if !conn.encryptionRequested && !conn.authenticationRequested {
+		factory.connections.Delete(id)
+	}
+}
@@ -0,0 +1,302 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/akinet/internal/parsertest"
+)
+
+var testBidiID = akinet.TCPBidiID(uuid.MustParse("0c9f1c5c-3b7d-4b5c-9a53-5a1b9f5e3c11"))
+
+const (
+	clientSeq = reassembly.Sequence(1000)
+	serverSeq = reassembly.Sequence(5000)
+)
+
+type messageBuilder struct {
+	bytes.Buffer
+}
+
+func (b *messageBuilder) cstring(s string) *messageBuilder {
+	b.WriteString(s)
+	b.WriteByte(0)
+	return b
+}
+
+func (b *messageBuilder) byte(c byte) *messageBuilder {
+	b.WriteByte(c)
+	return b
+}
+
+func (b *messageBuilder) int16(v int16) *messageBuilder {
+	binary.Write(b, binary.BigEndian, v)
+	return b
+}
+
+func (b *messageBuilder) int32(v int32) *messageBuilder {
+	binary.Write(b, binary.BigEndian, v)
+	return b
+}
+
+// Returns a message with the given type and body.
+func (b *messageBuilder) message(typ byte) []byte {
+	result := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(result[1:], uint32(4+b.Len()))
+	return append(result, b.Bytes()...)
+}
+
+// Returns a startup message with the given body.
+func (b *messageBuilder) startupMessage() []byte {
+	result := make([]byte, 4)
+	binary.BigEndian.PutUint32(result, uint32(4+b.Len()))
+	return append(result, b.Bytes()...)
+}
+
+func msg() *messageBuilder {
+	return &messageBuilder{}
+}
+
+func concat(msgs ...[]byte) []byte {
+	return bytes.Join(msgs, nil)
+}
+
+func TestStartup(t *testing.T) {
+	factory := NewPostgresParserFactory()
+	streamID := uuid.UUID(testBidiID)
+
+	sslRequest := msg().int32(sslRequestCode).startupMessage()
+	startup := msg().int32(3 << 16).
+		cstring("user").cstring("alice").
+		cstring("database").cstring("orders").
+		cstring("").
+		startupMessage()
+	password := msg().cstring("md5deadbeef").message(passwordMessage)
+
+	authRequest := msg().int32(5).int32(0x01020304).message(authenticationMessage)
+	authOK := msg().int32(0).message(authenticationMessage)
+	serverVersion := msg().cstring("server_version").cstring("13.4").message(parameterStatusMessage)
+	keyData := msg().int32(1234).int32(5678).message(backendKeyDataMessage)
+	ready := msg().byte('I').message(readyForQueryMessage)
+
+	results := []akinet.ParsedNetworkContent{}
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, sslRequest)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq+8, []byte{'N'})...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, clientSeq+8, serverSeq+1, startup)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, serverSeq+1, clientSeq+100, authRequest)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, clientSeq+100, serverSeq+100, password)...)
+	results = append(results, parsertest.ParseAll(t, factory, testBidiID, serverSeq+100, clientSeq+200, concat(authOK, serverVersion, keyData, ready))...)
+
+	authMD5, authSuccess := 5, 0
+	expected := []akinet.ParsedNetworkContent{
+		akinet.PostgresStartupMessage{
+			StreamID: streamID,
+			Seq:      int(serverSeq),
+			Type:     akinet.PostgresSSLRequest,
+		},
+		akinet.PostgresEncryptionResponse{
+			StreamID: streamID,
+			Seq:      int(serverSeq),
+			Accepted: false,
+		},
+		akinet.PostgresStartupMessage{
+			StreamID:      streamID,
+			Seq:           int(serverSeq + 1),
+			Type:          akinet.PostgresStartup,
+			ProtocolMajor: 3,
+			ProtocolMinor: 0,
+			Parameters: map[string]string{
+				"user":     "alice",
+				"database": "orders",
+			},
+		},
+		akinet.PostgresResponse{
+			StreamID:           streamID,
+			Seq:                int(serverSeq + 1),
+			AuthenticationType: &authMD5,
+		},
+		akinet.PostgresStartupMessage{
+			StreamID: streamID,
+			Seq:      int(serverSeq + 100),
+			Type:     akinet.PostgresAuthenticationResponse,
+		},
+		akinet.PostgresResponse{
+			StreamID:           streamID,
+			Seq:                int(serverSeq + 100),
+			AuthenticationType: &authSuccess,
+			Parameters:         map[string]string{"server_version": "13.4"},
+			TransactionStatus:  'I',
+		},
+	}
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	factory := NewPostgresParserFactory()
+	streamID := uuid.UUID(testBidiID)
+
+	rowDescription := msg().int16(2).
+		cstring("id").int32(16384).int16(1).int32(23).int16(4).int32(-1).int16(0).
+		cstring("name").int32(16384).int16(2).int32(25).int16(-1).int32(-1).int16(0).
+		message(rowDescriptionMessage)
+	dataRow := msg().int16(2).int32(1).byte('7').int32(-1).message(dataRowMessage)
+	ready := msg().byte('I').message(readyForQueryMessage)
+	empty := msg()
+
+	// A simple query, followed by an extended query that is pipelined after it.
+	client := concat(
+		msg().cstring("SELECT id, name FROM users").message(queryMessage),
+		msg().cstring("").cstring("SELECT id, name FROM users WHERE id = $1").int16(1).int32(23).message(parseMessage),
+		msg().cstring("").cstring("").int16(0).int16(1).int32(1).byte('7').int16(0).message(bindMessage),
+		msg().byte('P').cstring("").message(describeMessage),
+		msg().cstring("").int32(0).message(executeMessage),
+		empty.message(syncMessage),
+	)
+
+	server := concat(
+		rowDescription,
+		dataRow,
+		dataRow,
+		msg().cstring("SELECT 2").message(commandCompleteMessage),
+		ready,
+		empty.message(parseCompleteMessage),
+		empty.message(bindCompleteMessage),
+		rowDescription,
+		dataRow,
+		msg().cstring("SELECT 1").message(commandCompleteMessage),
+		ready,
+		// A query that failed.
+		msg().byte('S').cstring("ERROR").
+			byte('V').cstring("ERROR").
+			byte('C').cstring("42P01").
+			byte('M').cstring(`relation "user" does not exist`).
+			byte(0).
+			message(errorResponseMessage),
+		msg().byte('E').message(readyForQueryMessage),
+	)
+
+	clientResults := parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, client)
+	serverResults := parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq, server)
+
+	expectedClient := []akinet.ParsedNetworkContent{
+		akinet.PostgresQuery{
+			StreamID:   streamID,
+			Seq:        int(serverSeq),
+			Statements: []string{"SELECT id, name FROM users"},
+		},
+		akinet.PostgresQuery{
+			StreamID:            streamID,
+			Seq:                 int(serverSeq),
+			Extended:            true,
+			Statements:          []string{"SELECT id, name FROM users WHERE id = $1"},
+			BoundStatements:     []string{""},
+			ParameterCount:      1,
+			ParameterSize_bytes: 1,
+			Executions:          1,
+		},
+	}
+	if diff := cmp.Diff(expectedClient, clientResults, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff in queries: %s", diff)
+	}
+
+	columns := []akinet.PostgresColumn{
+		{Name: "id", TableOID: 16384, TypeOID: 23},
+		{Name: "name", TableOID: 16384, TypeOID: 25},
+	}
+	expectedServer := []akinet.ParsedNetworkContent{
+		akinet.PostgresResponse{
+			StreamID:          streamID,
+			Seq:               int(serverSeq),
+			Columns:           columns,
+			CommandTags:       []string{"SELECT 2"},
+			RowCount:          2,
+			TransactionStatus: 'I',
+		},
+		akinet.PostgresResponse{
+			StreamID:          streamID,
+			Seq:               int(serverSeq),
+			Columns:           columns,
+			CommandTags:       []string{"SELECT 1"},
+			RowCount:          1,
+			TransactionStatus: 'I',
+		},
+		akinet.PostgresResponse{
+			StreamID: streamID,
+			Seq:      int(serverSeq),
+			Error: &akinet.PostgresError{
+				Severity: "ERROR",
+				Code:     "42P01",
+				Message:  `relation "user" does not exist`,
+			},
+			TransactionStatus: 'E',
+		},
+	}
+	if diff := cmp.Diff(expectedServer, serverResults, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff in responses: %s", diff)
+	}
+}
+
+func TestFactoryRejectsOtherProtocols(t *testing.T) {
+	parsertest.RunRejectTests(t, NewPostgresParserFactory(), parsertest.OtherProtocols("Postgres"))
+}
+
+func TestIncrementalParse(t *testing.T) {
+	query := msg().cstring("SELECT 1").message(queryMessage)
+	testCases := []parsertest.IncrementalTestCase{
+		{
+			Name:  "query",
+			Seq:   clientSeq,
+			Ack:   serverSeq,
+			Input: query,
+			Next:  query,
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				q, ok := result.(akinet.PostgresQuery)
+				return ok && len(q.Statements) == 1 && q.Statements[0] == "SELECT 1"
+			},
+		},
+		{
+			Name: "response",
+			Seq:  serverSeq,
+			Ack:  clientSeq,
+			Input: concat(
+				msg().cstring("SELECT 0").message(commandCompleteMessage),
+				msg().byte('I').message(readyForQueryMessage),
+			),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				r, ok := result.(akinet.PostgresResponse)
+				return ok && len(r.CommandTags) == 1 && r.CommandTags[0] == "SELECT 0"
+			},
+		},
+	}
+	parsertest.RunIncrementalTests(t, NewPostgresParserFactory(), testBidiID, testCases)
+}
+
+func TestErrorReturnsAllInput(t *testing.T) {
+	parse := msg().cstring("").cstring("SELECT 1").int16(0).message(parseMessage)
+	query := msg().cstring("SELECT 1").message(queryMessage)
+	testCases := []parsertest.ErrorTestCase{
+		{
+			Name:  "invalid length after a message",
+			Seq:   clientSeq,
+			Ack:   serverSeq,
+			Input: [][]byte{parse, {bindMessage, 0xff, 0xff, 0xff, 0xff}},
+		},
+		{
+			Name:  "end of input",
+			Seq:   clientSeq,
+			Ack:   serverSeq,
+			Input: [][]byte{query[:7], query[7:10]},
+			IsEnd: true,
+		},
+	}
+	parsertest.RunErrorTests(t, NewPostgresParserFactory(), testBidiID, testCases)
+}
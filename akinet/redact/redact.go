@@ -0,0 +1,489 @@
+// Package redact removes sensitive values from parsed HTTP traffic before it
+// is stored or exported.
+package redact
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Applies a rule set to HTTP requests and responses.
+type Redactor struct {
+	rules []*compiledRule
+	salt  string
+
+	mu sync.Mutex
+
+	// The number of values redacted by each rule. Protected by mu.
+	hits map[string]int
+}
+
+func NewRedactor(rs RuleSet) (*Redactor, error) {
+	rules, err := compileRules(rs)
+	if err != nil {
+		return nil, err
+	}
+	hits := make(map[string]int, len(rules))
+	for _, rule := range rules {
+		hits[rule.Name] = 0
+	}
+	return &Redactor{
+		rules: rules,
+		salt:  rs.Salt,
+		hits:  hits,
+	}, nil
+}
+
+// Reads traffic from in until it is closed, and sends it to out with HTTP
+// requests and responses redacted. Other traffic is passed through unchanged.
+// Closes out when done.
+func (r *Redactor) Run(in <-chan akinet.ParsedNetworkTraffic, out chan<- akinet.ParsedNetworkTraffic) {
+	defer close(out)
+	for t := range in {
+		out <- r.Redact(t)
+	}
+}
+
+// Returns a copy of the traffic with the rules applied. The original is not
+// modified. Traffic other than HTTP requests and responses is returned
+// unchanged.
+func (r *Redactor) Redact(t akinet.ParsedNetworkTraffic) akinet.ParsedNetworkTraffic {
+	p := pass{Redactor: r, hits: map[string]int{}}
+	switch c := t.Content.(type) {
+	case akinet.HTTPRequest:
+		t.Content = p.redactRequest(c)
+	case akinet.HTTPResponse:
+		t.Content = p.redactResponse(c)
+	default:
+		return t
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, n := range p.hits {
+		r.hits[name] += n
+	}
+	return t
+}
+
+// Returns the number of values redacted by each rule, by rule name.
+func (r *Redactor) Hits() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make(map[string]int, len(r.hits))
+	for name, n := range r.hits {
+		result[name] = n
+	}
+	return result
+}
+
+// The redaction of a single request or response.
+type pass struct {
+	*Redactor
+
+	hits map[string]int
+}
+
+func (p *pass) redactRequest(req akinet.HTTPRequest) akinet.HTTPRequest {
+	req.Header = p.redactHeader(req.Header)
+	req.Trailer = p.redactHeader(req.Trailer)
+	req.Cookies = p.redactCookies(req.Cookies)
+	if req.URL != nil {
+		u := *req.URL
+		u.RawQuery = p.redactQuery(u.RawQuery)
+		req.URL = &u
+	}
+	if body, changed := p.redactBody(req.Body, req.Header); changed {
+		req.Body = body
+		updateContentLength(req.Header, body, req.RawBodyLength)
+	}
+	return req
+}
+
+func (p *pass) redactResponse(resp akinet.HTTPResponse) akinet.HTTPResponse {
+	resp.Header = p.redactHeader(resp.Header)
+	resp.Trailer = p.redactHeader(resp.Trailer)
+	resp.Cookies = p.redactCookies(resp.Cookies)
+	if body, changed := p.redactBody(resp.Body, resp.Header); changed {
+		resp.Body = body
+		updateContentLength(resp.Header, body, resp.RawBodyLength)
+	}
+	return resp
+}
+
+// Returns a redacted copy of the header.
+func (p *pass) redactHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	result := make(http.Header, len(h))
+	for name, values := range h {
+		var kept []string
+		for _, v := range values {
+			if v, ok := p.redactHeaderValue(name, v); ok {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) > 0 {
+			result[name] = kept
+		}
+	}
+	return result
+}
+
+// Returns false if the header value should be removed.
+func (p *pass) redactHeaderValue(name, value string) (string, bool) {
+	canonical := http.CanonicalHeaderKey(name)
+	for _, rule := range p.rules {
+		ok := true
+		switch {
+		case rule.Header != "":
+			if http.CanonicalHeaderKey(rule.Header) == canonical {
+				value, ok = p.redactValue(rule, value)
+			}
+		case rule.Cookie != "":
+			if canonical == "Cookie" {
+				value, ok = p.redactCookieHeader(rule, value)
+			} else if canonical == "Set-Cookie" {
+				value, ok = p.redactSetCookieHeader(rule, value)
+			}
+		case rule.global():
+			value, ok = p.redactValue(rule, value)
+		}
+		if !ok {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// Redacts a cookie in a Cookie header, which holds "name=value" pairs
+// separated by semicolons. Returns false if no cookies are left.
+func (p *pass) redactCookieHeader(rule *compiledRule, value string) (string, bool) {
+	pairs := strings.Split(value, ";")
+	kept := make([]string, 0, len(pairs))
+	changed := false
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		name, v := splitPair(pair)
+		if name == rule.Cookie {
+			changed = true
+			var ok bool
+			if v, ok = p.redactValue(rule, v); !ok {
+				continue
+			}
+			pair = name + "=" + v
+		}
+		kept = append(kept, pair)
+	}
+	if !changed {
+		return value, true
+	}
+	return strings.Join(kept, "; "), len(kept) > 0
+}
+
+// Redacts the cookie set by a Set-Cookie header. Returns false if the cookie
+// is dropped.
+func (p *pass) redactSetCookieHeader(rule *compiledRule, value string) (string, bool) {
+	pair, attrs := value, ""
+	if i := strings.IndexByte(value, ';'); i >= 0 {
+		pair, attrs = value[:i], value[i:]
+	}
+	name, v := splitPair(strings.TrimSpace(pair))
+	if name != rule.Cookie {
+		return value, true
+	}
+	v, ok := p.redactValue(rule, v)
+	return name + "=" + v + attrs, ok
+}
+
+// Returns a redacted copy of the cookies.
+func (p *pass) redactCookies(cookies []*http.Cookie) []*http.Cookie {
+	if cookies == nil {
+		return nil
+	}
+	result := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		value, ok := c.Value, true
+		for _, rule := range p.rules {
+			if (rule.Cookie != "" && rule.Cookie == c.Name) || rule.global() {
+				if value, ok = p.redactValue(rule, value); !ok {
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		if value != c.Value {
+			copied := *c
+			copied.Value = value
+			c = &copied
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// Redacts the values of query parameters. Parameters that aren't redacted
+// keep their original encoding and order.
+func (p *pass) redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	kept := make([]string, 0, len(params))
+	changed := false
+	for _, param := range params {
+		rawName, rawValue := splitPair(param)
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			value = rawValue
+		}
+
+		original, ok := value, true
+		for _, rule := range p.rules {
+			if (rule.Query != "" && rule.Query == name) || rule.global() {
+				if value, ok = p.redactValue(rule, value); !ok {
+					break
+				}
+			}
+		}
+		switch {
+		case !ok:
+			changed = true
+		case value != original:
+			changed = true
+			kept = append(kept, rawName+"="+url.QueryEscape(value))
+		default:
+			kept = append(kept, param)
+		}
+	}
+	if !changed {
+		return rawQuery
+	}
+	return strings.Join(kept, "&")
+}
+
+// Applies the JSON path rules to JSON bodies, and then the global rules to
+// text bodies. Returns the body, and whether it changed.
+func (p *pass) redactBody(body []byte, h http.Header) ([]byte, bool) {
+	if len(body) == 0 {
+		return body, false
+	}
+	changed := false
+
+	if strings.Contains(h.Get("Content-Type"), "json") {
+		if redacted, ok := p.redactJSONBody(body); ok {
+			body, changed = redacted, true
+		}
+	}
+
+	if utf8.Valid(body) {
+		text := string(body)
+		for _, rule := range p.rules {
+			if rule.global() {
+				text, _ = p.redactValue(rule, text)
+			}
+		}
+		if text != string(body) {
+			body, changed = []byte(text), true
+		}
+	}
+	return body, changed
+}
+
+// Returns the redacted body, and whether any rule matched. Bodies that aren't
+// valid JSON, including truncated bodies, are left alone.
+func (p *pass) redactJSONBody(body []byte) ([]byte, bool) {
+	hasRules := false
+	for _, rule := range p.rules {
+		if rule.jsonPath != nil {
+			hasRules = true
+			break
+		}
+	}
+	if !hasRules {
+		return nil, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+
+	matched := false
+	for _, rule := range p.rules {
+		if rule.jsonPath != nil {
+			before := p.hits[rule.Name]
+			v = p.redactJSONPath(v, rule.jsonPath, rule)
+			matched = matched || p.hits[rule.Name] != before
+		}
+	}
+	if !matched {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, false
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), true
+}
+
+// Redacts the values at the path within v. Returns the new value of v.
+func (p *pass) redactJSONPath(v interface{}, path []string, rule *compiledRule) interface{} {
+	key, rest := path[0], path[1:]
+	switch tv := v.(type) {
+	case map[string]interface{}:
+		for k, child := range tv {
+			if key != "*" && key != k {
+				continue
+			}
+			if len(rest) > 0 {
+				tv[k] = p.redactJSONPath(child, rest, rule)
+			} else if redacted, ok := p.redactJSONValue(rule, child); ok {
+				tv[k] = redacted
+			} else {
+				delete(tv, k)
+			}
+		}
+		return tv
+	case []interface{}:
+		result := tv[:0]
+		for i, child := range tv {
+			if key != "*" && key != strconv.Itoa(i) {
+				result = append(result, child)
+				continue
+			}
+			if len(rest) > 0 {
+				result = append(result, p.redactJSONPath(child, rest, rule))
+			} else if redacted, ok := p.redactJSONValue(rule, child); ok {
+				result = append(result, redacted)
+			}
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// Redacts a value in a JSON body. Values other than strings are redacted as
+// their JSON encoding, and become strings if hashed or masked. Returns false
+// if the value should be removed.
+func (p *pass) redactJSONValue(rule *compiledRule, v interface{}) (interface{}, bool) {
+	var s string
+	switch tv := v.(type) {
+	case string:
+		s = tv
+	case map[string]interface{}, []interface{}:
+		if rule.regex != nil {
+			// Regexes only apply to scalars.
+			return v, true
+		}
+		encoded, err := json.Marshal(tv)
+		if err != nil {
+			return v, true
+		}
+		s = string(encoded)
+	default:
+		encoded, err := json.Marshal(tv)
+		if err != nil {
+			return v, true
+		}
+		s = string(encoded)
+	}
+
+	redacted, ok := p.redactValue(rule, s)
+	if !ok {
+		return nil, false
+	}
+	if redacted == s {
+		return v, true
+	}
+	return redacted, true
+}
+
+// Redacts a value matched by a rule. If the rule has a regex, only the matching
+// text is redacted. Returns false if the whole value should be removed.
+func (p *pass) redactValue(rule *compiledRule, value string) (string, bool) {
+	if rule.regex != nil {
+		return rule.regex.ReplaceAllStringFunc(value, func(match string) string {
+			if match == "" {
+				return match
+			}
+			p.hits[rule.Name]++
+			return p.transform(rule, match)
+		}), true
+	}
+
+	p.hits[rule.Name]++
+	if rule.Action == ActionDrop {
+		return "", false
+	}
+	return p.transform(rule, value), true
+}
+
+func (p *pass) transform(rule *compiledRule, value string) string {
+	switch rule.Action {
+	case ActionHash:
+		return hashValue(p.salt, value)
+	case ActionMask:
+		return maskValue(value, rule.Keep)
+	default:
+		return ""
+	}
+}
+
+// Returns a salted hash of the value. Equal values with the same salt have the
+// same hash.
+func hashValue(salt, value string) string {
+	h := sha256.New()
+	h.Write([]byte(salt))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return "hash:" + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Replaces all but the last keep characters of the value with MaskChar.
+func maskValue(value string, keep int) string {
+	runes := []rune(value)
+	for i := 0; i < len(runes)-keep; i++ {
+		runes[i] = MaskChar
+	}
+	return string(runes)
+}
+
+// Splits "name=value". The value is empty if there is no "=".
+func splitPair(s string) (string, string) {
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// Updates the Content-Length header, if any, after the body has changed. The
+// header is left alone if it describes an encoded body.
+func updateContentLength(h http.Header, body []byte, rawBodyLength int) {
+	if h.Get("Content-Length") != "" && rawBodyLength == 0 {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+}
@@ -0,0 +1,175 @@
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+const testRules = `
+salt: pepper
+rules:
+  - name: auth
+    header: authorization
+    action: drop
+  - name: session
+    cookie: session
+    action: hash
+  - name: api-key
+    query: api_key
+    action: mask
+    keep: 2
+  - name: password
+    json_path: user.password
+    action: drop
+  - name: card-number
+    json_path: cards.*.number
+    action: mask
+    keep: 4
+  - name: token
+    regex: 'tok_[a-z0-9]+'
+    action: hash
+`
+
+func TestRedact(t *testing.T) {
+	rs, err := ParseRules([]byte(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRedactor(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streamID := uuid.New()
+	req := akinet.HTTPRequest{
+		StreamID: streamID,
+		Method:   "POST",
+		URL:      &url.URL{Path: "/v1/pay", RawQuery: "api_key=secret1234&note=tok_abc&x=%2F"},
+		Host:     "example.com",
+		Header: http.Header{
+			"Authorization":  {"Bearer abc"},
+			"Cookie":         {"session=s1; theme=dark"},
+			"Content-Type":   {"application/json"},
+			"Content-Length": {"96"},
+		},
+		Body:    []byte(`{"user":{"name":"Rex","password":"hunter2"},"cards":[{"number":"4111111111111111"},{"number":4242424242424242}]}`),
+		Cookies: []*http.Cookie{{Name: "session", Value: "s1"}},
+	}
+	resp := akinet.HTTPResponse{
+		StreamID:   streamID,
+		StatusCode: 200,
+		Header: http.Header{
+			"Set-Cookie":   {"session=s2; Path=/; HttpOnly", "theme=light"},
+			"Content-Type": {"text/plain"},
+		},
+		Body: []byte("your token is tok_xyz"),
+	}
+
+	in := make(chan akinet.ParsedNetworkTraffic, 3)
+	in <- akinet.ParsedNetworkTraffic{Content: req}
+	in <- akinet.ParsedNetworkTraffic{Content: resp}
+	in <- akinet.ParsedNetworkTraffic{Content: akinet.TCPPacketMetadata{SYN: true}}
+	close(in)
+	out := make(chan akinet.ParsedNetworkTraffic)
+	go r.Run(in, out)
+	var results []akinet.ParsedNetworkTraffic
+	for t := range out {
+		results = append(results, t)
+	}
+
+	s1, s2 := hashValue("pepper", "s1"), hashValue("pepper", "s2")
+	expectedBody := `{"cards":[{"number":"************1111"},{"number":"************4242"}],"user":{"name":"Rex"}}`
+	expected := []akinet.ParsedNetworkTraffic{
+		{
+			Content: akinet.HTTPRequest{
+				StreamID: streamID,
+				Method:   "POST",
+				URL:      &url.URL{Path: "/v1/pay", RawQuery: "api_key=" + url.QueryEscape("********34") + "&note=" + url.QueryEscape(hashValue("pepper", "tok_abc")) + "&x=%2F"},
+				Host:     "example.com",
+				Header: http.Header{
+					"Cookie":         {"session=" + s1 + "; theme=dark"},
+					"Content-Type":   {"application/json"},
+					"Content-Length": {"93"},
+				},
+				Body:    []byte(expectedBody),
+				Cookies: []*http.Cookie{{Name: "session", Value: s1}},
+			},
+		},
+		{
+			Content: akinet.HTTPResponse{
+				StreamID:   streamID,
+				StatusCode: 200,
+				Header: http.Header{
+					"Set-Cookie":   {"session=" + s2 + "; Path=/; HttpOnly", "theme=light"},
+					"Content-Type": {"text/plain"},
+				},
+				Body: []byte("your token is " + hashValue("pepper", "tok_xyz")),
+			},
+		},
+		{Content: akinet.TCPPacketMetadata{SYN: true}},
+	}
+	connIDComparer := cmp.Comparer(func(a, b akid.ConnectionID) bool { return a == b })
+	if diff := cmp.Diff(expected, results, connIDComparer); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+
+	expectedHits := map[string]int{
+		"auth":        1,
+		"session":     3,
+		"api-key":     1,
+		"password":    1,
+		"card-number": 2,
+		"token":       2,
+	}
+	if diff := cmp.Diff(expectedHits, r.Hits()); diff != "" {
+		t.Errorf("found unexpected diff in hits:\n%s", diff)
+	}
+
+	// The original request is unchanged.
+	if req.Header.Get("Authorization") != "Bearer abc" || req.Cookies[0].Value != "s1" {
+		t.Errorf("original request was modified")
+	}
+}
+
+func TestRedactRegexWithinTarget(t *testing.T) {
+	rs, err := ParseRules([]byte(`{"rules": [{"name": "bearer", "header": "Authorization", "regex": "[A-Za-z0-9]{8,}", "action": "mask"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRedactor(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := r.Redact(akinet.ParsedNetworkTraffic{Content: akinet.HTTPRequest{
+		Header: http.Header{"Authorization": {"Bearer abcdefgh123"}, "X-Other": {"abcdefgh123"}},
+	}})
+	expected := http.Header{"Authorization": {"Bearer ***********"}, "X-Other": {"abcdefgh123"}}
+	if diff := cmp.Diff(expected, result.Content.(akinet.HTTPRequest).Header); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, rules := range []string{
+		`rules: [{header: a, action: drop}]`,
+		`rules: [{name: a, header: a, action: drop}, {name: a, header: b, action: drop}]`,
+		`rules: [{name: a, header: a, cookie: b, action: drop}]`,
+		`rules: [{name: a, action: drop}]`,
+		`rules: [{name: a, header: a, action: erase}]`,
+		`rules: [{name: a, regex: "(", action: drop}]`,
+		`rules: [{name: a, json_path: "a..b", action: drop}]`,
+		`rules: [{name: a, headr: a, action: drop}]`,
+	} {
+		if _, err := ParseRules([]byte(rules)); err == nil {
+			t.Errorf("expected error for %s", rules)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package redact
+
+import (
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// What to do with a value that matches a rule.
+type Action string
+
+const (
+	// Removes the value. Headers, cookies, query parameters and JSON object
+	// members are removed entirely; text matched by a regex is deleted.
+	ActionDrop Action = "drop"
+
+	// Replaces the value with a salted hash, so that equal values can still be
+	// correlated.
+	ActionHash Action = "hash"
+
+	// Replaces each character of the value with MaskChar, except for the last
+	// Rule.Keep characters.
+	ActionMask Action = "mask"
+)
+
+// Replaces masked characters.
+const MaskChar = '*'
+
+// A rule says which values to redact and how. A rule has at most one of
+// Header, Cookie, Query and JSONPath. If it also has a Regex, only the text
+// within those values that matches the regex is redacted. A rule with only a
+// Regex applies to the values of all headers, cookies and query parameters,
+// and to text bodies.
+type Rule struct {
+	// Identifies the rule in hit counts. Must be unique.
+	Name string `yaml:"name" json:"name"`
+
+	// The name of a header, matched case-insensitively.
+	Header string `yaml:"header,omitempty" json:"header,omitempty"`
+
+	// The name of a cookie, in either the Cookie or Set-Cookie headers.
+	Cookie string `yaml:"cookie,omitempty" json:"cookie,omitempty"`
+
+	// The name of a query parameter.
+	Query string `yaml:"query,omitempty" json:"query,omitempty"`
+
+	// A path to values in JSON bodies, as object keys separated by dots. A "*"
+	// matches any key or array element; for example, "cards.*.number".
+	JSONPath string `yaml:"json_path,omitempty" json:"json_path,omitempty"`
+
+	// A regular expression, in Go syntax, for the text to redact.
+	Regex string `yaml:"regex,omitempty" json:"regex,omitempty"`
+
+	Action Action `yaml:"action" json:"action"`
+
+	// For ActionMask, the number of trailing characters to leave unmasked.
+	Keep int `yaml:"keep,omitempty" json:"keep,omitempty"`
+}
+
+type RuleSet struct {
+	// Mixed into hashes, so that hashed values can't be recovered by hashing
+	// guesses without knowing the salt.
+	Salt string `yaml:"salt,omitempty" json:"salt,omitempty"`
+
+	Rules []Rule `yaml:"rules" json:"rules"`
+}
+
+// Parses a rule set from YAML or JSON. Unknown fields are an error, so that
+// misspelled rules don't silently match nothing.
+func ParseRules(data []byte) (RuleSet, error) {
+	var rs RuleSet
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rs); err != nil {
+		return RuleSet{}, errors.Wrap(err, "failed to parse redaction rules")
+	}
+	if _, err := compileRules(rs); err != nil {
+		return RuleSet{}, err
+	}
+	return rs, nil
+}
+
+// Reads a rule set from a YAML or JSON file.
+func LoadRules(path string) (RuleSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return RuleSet{}, errors.Wrap(err, "failed to read redaction rules")
+	}
+	return ParseRules(data)
+}
+
+type compiledRule struct {
+	Rule
+
+	// Set if Rule.Regex is set.
+	regex *regexp.Regexp
+
+	// Rule.JSONPath, split on dots.
+	jsonPath []string
+}
+
+// Whether the rule applies to everything, rather than to one kind of value.
+func (r *compiledRule) global() bool {
+	return r.Header == "" && r.Cookie == "" && r.Query == "" && r.JSONPath == ""
+}
+
+func compileRules(rs RuleSet) ([]*compiledRule, error) {
+	names := map[string]bool{}
+	results := make([]*compiledRule, 0, len(rs.Rules))
+	for i, rule := range rs.Rules {
+		if rule.Name == "" {
+			return nil, errors.Errorf("redaction rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return nil, errors.Errorf("duplicate redaction rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		targets := 0
+		for _, t := range []string{rule.Header, rule.Cookie, rule.Query, rule.JSONPath} {
+			if t != "" {
+				targets++
+			}
+		}
+		if targets > 1 {
+			return nil, errors.Errorf("redaction rule %q has more than one of header, cookie, query and json_path", rule.Name)
+		}
+		if targets == 0 && rule.Regex == "" {
+			return nil, errors.Errorf("redaction rule %q matches nothing", rule.Name)
+		}
+
+		switch rule.Action {
+		case ActionDrop, ActionHash, ActionMask:
+		default:
+			return nil, errors.Errorf("redaction rule %q has unknown action %q", rule.Name, rule.Action)
+		}
+		if rule.Keep < 0 {
+			return nil, errors.Errorf("redaction rule %q has negative keep", rule.Name)
+		}
+
+		c := &compiledRule{Rule: rule}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, errors.Wrapf(err, "redaction rule %q has a bad regex", rule.Name)
+			}
+			c.regex = re
+		}
+		if rule.JSONPath != "" {
+			c.jsonPath = strings.Split(rule.JSONPath, ".")
+			for _, key := range c.jsonPath {
+				if key == "" {
+					return nil, errors.Errorf("redaction rule %q has a bad json_path", rule.Name)
+				}
+			}
+		}
+		results = append(results, c)
+	}
+	return results, nil
+}
@@ -0,0 +1,141 @@
+package akinet
+
+import (
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Identifies the type of a Redis reply, as given by the RESP type byte.
+type RedisReplyType int
+
+const (
+	RedisSimpleString RedisReplyType = iota
+	RedisError
+	RedisInteger
+	RedisBulkString
+	RedisArray
+
+	// A null reply. In RESP2, this is a bulk string or array of length -1.
+	RedisNull
+
+	// RESP3 types.
+	RedisBoolean
+	RedisDouble
+	RedisBigNumber
+	RedisBlobError
+	RedisVerbatimString
+	RedisMap
+	RedisSet
+
+	// Out-of-band data sent by the server, such as pub/sub messages and client
+	// cache invalidations. Pushes are not replies to commands.
+	RedisPush
+)
+
+func (t RedisReplyType) String() string {
+	switch t {
+	case RedisSimpleString:
+		return "SimpleString"
+	case RedisError:
+		return "Error"
+	case RedisInteger:
+		return "Integer"
+	case RedisBulkString:
+		return "BulkString"
+	case RedisArray:
+		return "Array"
+	case RedisNull:
+		return "Null"
+	case RedisBoolean:
+		return "Boolean"
+	case RedisDouble:
+		return "Double"
+	case RedisBigNumber:
+		return "BigNumber"
+	case RedisBlobError:
+		return "BlobError"
+	case RedisVerbatimString:
+		return "VerbatimString"
+	case RedisMap:
+		return "Map"
+	case RedisSet:
+		return "Set"
+	case RedisPush:
+		return "Push"
+	}
+	return "RedisReplyType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Represents a command sent by a Redis client.
+//
+// Redis replies to commands in the order in which they were sent, so commands
+// and replies are paired by their position on the connection. The reply to
+// this command is the RedisReply with the same stream key. The time taken to
+// run the command is the difference between the observation times of the two.
+type RedisCommand struct {
+	// StreamID and Index uniquely identify a pair of command and reply. Index
+	// counts the commands observed on the connection, starting from 0.
+	StreamID uuid.UUID
+	Index    int
+
+	// The command name, in upper case.
+	Name string
+
+	// The subcommand, in upper case, for commands such as CONFIG and CLIENT
+	// that group several subcommands.
+	Subcommand string
+
+	// The number of keys that the command operates on. This is 0 for commands
+	// that the parser does not know about.
+	KeyCount int
+
+	// The sizes of the arguments that follow the command name.
+	ArgumentSizes_bytes []int
+
+	// The arguments that follow the command name, possibly truncated. Only set
+	// if the parser was configured to capture values.
+	Arguments []string
+}
+
+func (RedisCommand) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this command with its corresponding
+// reply.
+func (c RedisCommand) GetStreamKey() string {
+	return c.StreamID.String() + ":" + strconv.Itoa(c.Index)
+}
+
+// Represents a reply sent by a Redis server, or a push if Type is RedisPush.
+type RedisReply struct {
+	// StreamID and Index uniquely identify a pair of command and reply. Index
+	// counts the replies observed on the connection, starting from 0. Pushes
+	// are not counted, and have an Index of -1.
+	StreamID uuid.UUID
+	Index    int
+
+	Type RedisReplyType
+
+	// The size of a string or error reply.
+	Size_bytes int
+
+	// The number of elements in an array, set or push, or the number of
+	// entries in a map.
+	ElementCount int
+
+	// The first word of an error reply, which identifies the kind of error
+	// (e.g. "ERR", "WRONGTYPE" or "MOVED").
+	ErrorPrefix string
+
+	// The value of a reply that isn't an array, set, map or push, possibly
+	// truncated. Only set if the parser was configured to capture values.
+	Value string
+}
+
+func (RedisReply) ImplParsedNetworkContent() {}
+
+// Returns a string key that associates this reply with its corresponding
+// command.
+func (r RedisReply) GetStreamKey() string {
+	return r.StreamID.String() + ":" + strconv.Itoa(r.Index)
+}
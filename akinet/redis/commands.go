@@ -0,0 +1,388 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Describes where the keys are in a command's arguments, following the
+// conventions of Redis's COMMAND INFO. Argument 0 is the command name.
+type keySpec struct {
+	// The positions of the first and last keys, and the distance between
+	// keys. A negative last position counts from the end of the arguments: -1
+	// is the last argument. A first position of 0 means there are no keys at
+	// fixed positions.
+	first, last, step int
+
+	// If non-zero, the position of an argument giving the number of keys that
+	// immediately follow it.
+	numKeys int
+
+	// Whether the keys are given after a STREAMS keyword, followed by the same
+	// number of IDs.
+	streams bool
+}
+
+var (
+	noKeys     = keySpec{}
+	firstKey   = keySpec{first: 1, last: 1, step: 1}
+	firstTwo   = keySpec{first: 1, last: 2, step: 1}
+	allKeys    = keySpec{first: 1, last: -1, step: 1}
+	allPairs   = keySpec{first: 1, last: -1, step: 2}
+	allButLast = keySpec{first: 1, last: -2, step: 1}
+	secondKey  = keySpec{first: 2, last: 2, step: 1}
+)
+
+// Commands that group several subcommands. The subcommand is the first
+// argument.
+var containerCommands = map[string]map[string]keySpec{
+	"ACL":      nil,
+	"CLIENT":   nil,
+	"CLUSTER":  nil,
+	"COMMAND":  nil,
+	"CONFIG":   nil,
+	"DEBUG":    nil,
+	"FUNCTION": nil,
+	"LATENCY":  nil,
+	"MEMORY": {
+		"USAGE": secondKey,
+	},
+	"MODULE": nil,
+	"OBJECT": {
+		"ENCODING": secondKey,
+		"FREQ":     secondKey,
+		"IDLETIME": secondKey,
+		"REFCOUNT": secondKey,
+	},
+	"PUBSUB":  nil,
+	"SCRIPT":  nil,
+	"SLOWLOG": nil,
+	"XGROUP": {
+		"CREATE":         secondKey,
+		"CREATECONSUMER": secondKey,
+		"DELCONSUMER":    secondKey,
+		"DESTROY":        secondKey,
+		"SETID":          secondKey,
+	},
+	"XINFO": {
+		"CONSUMERS": secondKey,
+		"GROUPS":    secondKey,
+		"STREAM":    secondKey,
+	},
+}
+
+// The commands that we know about, other than container commands.
+var commands = map[string]keySpec{
+	// Strings
+	"APPEND":      firstKey,
+	"DECR":        firstKey,
+	"DECRBY":      firstKey,
+	"GET":         firstKey,
+	"GETDEL":      firstKey,
+	"GETEX":       firstKey,
+	"GETRANGE":    firstKey,
+	"GETSET":      firstKey,
+	"INCR":        firstKey,
+	"INCRBY":      firstKey,
+	"INCRBYFLOAT": firstKey,
+	"LCS":         firstTwo,
+	"MGET":        allKeys,
+	"MSET":        allPairs,
+	"MSETNX":      allPairs,
+	"PSETEX":      firstKey,
+	"SET":         firstKey,
+	"SETEX":       firstKey,
+	"SETNX":       firstKey,
+	"SETRANGE":    firstKey,
+	"STRLEN":      firstKey,
+	"SUBSTR":      firstKey,
+
+	// Generic
+	"COPY":        firstTwo,
+	"DEL":         allKeys,
+	"DUMP":        firstKey,
+	"EXISTS":      allKeys,
+	"EXPIRE":      firstKey,
+	"EXPIREAT":    firstKey,
+	"EXPIRETIME":  firstKey,
+	"KEYS":        noKeys,
+	"MOVE":        firstKey,
+	"PERSIST":     firstKey,
+	"PEXPIRE":     firstKey,
+	"PEXPIREAT":   firstKey,
+	"PEXPIRETIME": firstKey,
+	"PTTL":        firstKey,
+	"RANDOMKEY":   noKeys,
+	"RENAME":      firstTwo,
+	"RENAMENX":    firstTwo,
+	"RESTORE":     firstKey,
+	"SCAN":        noKeys,
+	"SORT":        firstKey,
+	"SORT_RO":     firstKey,
+	"TOUCH":       allKeys,
+	"TTL":         firstKey,
+	"TYPE":        firstKey,
+	"UNLINK":      allKeys,
+	"WAIT":        noKeys,
+
+	// Hashes
+	"HDEL":         firstKey,
+	"HEXISTS":      firstKey,
+	"HGET":         firstKey,
+	"HGETALL":      firstKey,
+	"HINCRBY":      firstKey,
+	"HINCRBYFLOAT": firstKey,
+	"HKEYS":        firstKey,
+	"HLEN":         firstKey,
+	"HMGET":        firstKey,
+	"HMSET":        firstKey,
+	"HRANDFIELD":   firstKey,
+	"HSCAN":        firstKey,
+	"HSET":         firstKey,
+	"HSETNX":       firstKey,
+	"HSTRLEN":      firstKey,
+	"HVALS":        firstKey,
+
+	// Lists
+	"BLMOVE":     firstTwo,
+	"BLMPOP":     {numKeys: 2},
+	"BLPOP":      allButLast,
+	"BRPOP":      allButLast,
+	"BRPOPLPUSH": firstTwo,
+	"LINDEX":     firstKey,
+	"LINSERT":    firstKey,
+	"LLEN":       firstKey,
+	"LMOVE":      firstTwo,
+	"LMPOP":      {numKeys: 1},
+	"LPOP":       firstKey,
+	"LPOS":       firstKey,
+	"LPUSH":      firstKey,
+	"LPUSHX":     firstKey,
+	"LRANGE":     firstKey,
+	"LREM":       firstKey,
+	"LSET":       firstKey,
+	"LTRIM":      firstKey,
+	"RPOP":       firstKey,
+	"RPOPLPUSH":  firstTwo,
+	"RPUSH":      firstKey,
+	"RPUSHX":     firstKey,
+
+	// Sets
+	"SADD":        firstKey,
+	"SCARD":       firstKey,
+	"SDIFF":       allKeys,
+	"SDIFFSTORE":  allKeys,
+	"SINTER":      allKeys,
+	"SINTERCARD":  {numKeys: 1},
+	"SINTERSTORE": allKeys,
+	"SISMEMBER":   firstKey,
+	"SMEMBERS":    firstKey,
+	"SMISMEMBER":  firstKey,
+	"SMOVE":       firstTwo,
+	"SPOP":        firstKey,
+	"SRANDMEMBER": firstKey,
+	"SREM":        firstKey,
+	"SSCAN":       firstKey,
+	"SUNION":      allKeys,
+	"SUNIONSTORE": allKeys,
+
+	// Sorted sets
+	"BZMPOP":           {numKeys: 2},
+	"BZPOPMAX":         allButLast,
+	"BZPOPMIN":         allButLast,
+	"ZADD":             firstKey,
+	"ZCARD":            firstKey,
+	"ZCOUNT":           firstKey,
+	"ZDIFF":            {numKeys: 1},
+	"ZDIFFSTORE":       {first: 1, last: 1, step: 1, numKeys: 2},
+	"ZINCRBY":          firstKey,
+	"ZINTER":           {numKeys: 1},
+	"ZINTERCARD":       {numKeys: 1},
+	"ZINTERSTORE":      {first: 1, last: 1, step: 1, numKeys: 2},
+	"ZLEXCOUNT":        firstKey,
+	"ZMPOP":            {numKeys: 1},
+	"ZMSCORE":          firstKey,
+	"ZPOPMAX":          firstKey,
+	"ZPOPMIN":          firstKey,
+	"ZRANDMEMBER":      firstKey,
+	"ZRANGE":           firstKey,
+	"ZRANGEBYLEX":      firstKey,
+	"ZRANGEBYSCORE":    firstKey,
+	"ZRANGESTORE":      firstTwo,
+	"ZRANK":            firstKey,
+	"ZREM":             firstKey,
+	"ZREMRANGEBYLEX":   firstKey,
+	"ZREMRANGEBYRANK":  firstKey,
+	"ZREMRANGEBYSCORE": firstKey,
+	"ZREVRANGE":        firstKey,
+	"ZREVRANGEBYLEX":   firstKey,
+	"ZREVRANGEBYSCORE": firstKey,
+	"ZREVRANK":         firstKey,
+	"ZSCAN":            firstKey,
+	"ZSCORE":           firstKey,
+	"ZUNION":           {numKeys: 1},
+	"ZUNIONSTORE":      {first: 1, last: 1, step: 1, numKeys: 2},
+
+	// HyperLogLog
+	"PFADD":   firstKey,
+	"PFCOUNT": allKeys,
+	"PFMERGE": allKeys,
+
+	// Geospatial
+	"GEOADD":            firstKey,
+	"GEODIST":           firstKey,
+	"GEOHASH":           firstKey,
+	"GEOPOS":            firstKey,
+	"GEORADIUS":         firstKey,
+	"GEORADIUSBYMEMBER": firstKey,
+	"GEOSEARCH":         firstKey,
+	"GEOSEARCHSTORE":    firstTwo,
+
+	// Bitmaps
+	"BITCOUNT":    firstKey,
+	"BITFIELD":    firstKey,
+	"BITFIELD_RO": firstKey,
+	"BITOP":       {first: 2, last: -1, step: 1},
+	"BITPOS":      firstKey,
+	"GETBIT":      firstKey,
+	"SETBIT":      firstKey,
+
+	// Streams
+	"XACK":       firstKey,
+	"XADD":       firstKey,
+	"XAUTOCLAIM": firstKey,
+	"XCLAIM":     firstKey,
+	"XDEL":       firstKey,
+	"XLEN":       firstKey,
+	"XPENDING":   firstKey,
+	"XRANGE":     firstKey,
+	"XREAD":      {streams: true},
+	"XREADGROUP": {streams: true},
+	"XREVRANGE":  firstKey,
+	"XSETID":     firstKey,
+	"XTRIM":      firstKey,
+
+	// Scripting and functions
+	"EVAL":       {numKeys: 2},
+	"EVALSHA":    {numKeys: 2},
+	"EVALSHA_RO": {numKeys: 2},
+	"EVAL_RO":    {numKeys: 2},
+	"FCALL":      {numKeys: 2},
+	"FCALL_RO":   {numKeys: 2},
+
+	// Pub/sub
+	"PSUBSCRIBE":   noKeys,
+	"PUBLISH":      noKeys,
+	"PUNSUBSCRIBE": noKeys,
+	"SPUBLISH":     noKeys,
+	"SSUBSCRIBE":   noKeys,
+	"SUBSCRIBE":    noKeys,
+	"SUNSUBSCRIBE": noKeys,
+	"UNSUBSCRIBE":  noKeys,
+
+	// Transactions
+	"DISCARD": noKeys,
+	"EXEC":    noKeys,
+	"MULTI":   noKeys,
+	"UNWATCH": noKeys,
+	"WATCH":   allKeys,
+
+	// Connection and server
+	"AUTH":         noKeys,
+	"BGREWRITEAOF": noKeys,
+	"BGSAVE":       noKeys,
+	"DBSIZE":       noKeys,
+	"ECHO":         noKeys,
+	"FAILOVER":     noKeys,
+	"FLUSHALL":     noKeys,
+	"FLUSHDB":      noKeys,
+	"HELLO":        noKeys,
+	"INFO":         noKeys,
+	"LASTSAVE":     noKeys,
+	"LOLWUT":       noKeys,
+	"MONITOR":      noKeys,
+	"PING":         noKeys,
+	"PSYNC":        noKeys,
+	"QUIT":         noKeys,
+	"READONLY":     noKeys,
+	"READWRITE":    noKeys,
+	"REPLICAOF":    noKeys,
+	"RESET":        noKeys,
+	"ROLE":         noKeys,
+	"SAVE":         noKeys,
+	"SELECT":       noKeys,
+	"SHUTDOWN":     noKeys,
+	"SLAVEOF":      noKeys,
+	"SWAPDB":       noKeys,
+	"SYNC":         noKeys,
+	"TIME":         noKeys,
+}
+
+// Determines whether the given string is the name of a command that we know
+// about.
+func isKnownCommand(name string) bool {
+	name = strings.ToUpper(name)
+	if _, ok := commands[name]; ok {
+		return true
+	}
+	_, ok := containerCommands[name]
+	return ok
+}
+
+// Returns the key spec of the command with the given name and subcommand, and
+// whether the command is known.
+func lookupCommand(name, subcommand string) (keySpec, bool) {
+	if spec, ok := commands[name]; ok {
+		return spec, true
+	}
+	if subcommands, ok := containerCommands[name]; ok {
+		return subcommands[subcommand], true
+	}
+	return noKeys, false
+}
+
+// Counts the keys in a command, given the number of arguments and the short
+// arguments that were kept. tokens[i] is argument i, or "" if it was too long
+// or was not kept.
+func (spec keySpec) countKeys(numArgs int, tokens []string) int {
+	token := func(i int) string {
+		if i < len(tokens) {
+			return tokens[i]
+		}
+		return ""
+	}
+
+	count := 0
+	if spec.first > 0 && spec.first < numArgs {
+		last := spec.last
+		if last < 0 {
+			last += numArgs
+		}
+		if last >= numArgs {
+			last = numArgs - 1
+		}
+		if last >= spec.first {
+			count += (last-spec.first)/spec.step + 1
+		}
+	}
+
+	if spec.numKeys > 0 {
+		if n, err := strconv.Atoi(token(spec.numKeys)); err == nil && n > 0 {
+			if max := numArgs - spec.numKeys - 1; n > max {
+				n = max
+			}
+			count += n
+		}
+	}
+
+	if spec.streams {
+		for i := 1; i < numArgs; i++ {
+			if strings.EqualFold(token(i), "STREAMS") {
+				count += (numArgs - i - 1) / 2
+				break
+			}
+		}
+	}
+
+	return count
+}
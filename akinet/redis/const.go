@@ -0,0 +1,53 @@
+package redis
+
+import "time"
+
+// RESP type bytes.
+const (
+	simpleStringType   = '+'
+	errorType          = '-'
+	integerType        = ':'
+	bulkStringType     = '$'
+	arrayType          = '*'
+	nullType           = '_'
+	booleanType        = '#'
+	doubleType         = ','
+	bigNumberType      = '('
+	blobErrorType      = '!'
+	verbatimStringType = '='
+	mapType            = '%'
+	setType            = '~'
+	attributeType      = '|'
+	pushType           = '>'
+)
+
+const (
+	// Longest line that we parse: a simple string, error, or the header of a
+	// bulk string or aggregate.
+	maxLineLength_bytes = 64 * 1024
+
+	// Largest bulk string allowed by Redis.
+	maxBulkLength_bytes = 512 * 1024 * 1024
+
+	// Largest number of elements in an aggregate that we parse.
+	maxAggregateLength = 1 << 24
+
+	// Deepest nesting of aggregates that we parse.
+	maxNestingDepth = 64
+
+	// Arguments up to this length are kept while parsing a command, to work out
+	// its name and keys.
+	maxTokenLength_bytes = 64
+
+	// Largest number of arguments kept while parsing a command.
+	maxTokens = 1024
+
+	// Connections that have not seen any traffic for this long are forgotten.
+	connectionIdleTimeout = 10 * time.Minute
+
+	// Sequence numbers within this distance of a flow's last known sequence
+	// number are assumed to belong to that flow.
+	maxSeqDistance = 1 << 28
+)
+
+var crlf = []byte("\r\n")
@@ -0,0 +1,22 @@
+package redis
+
+// Default maximum length of a captured value.
+const DefaultMaxValueSize = 256
+
+// Configures the Redis parser factory and the parsers it creates. Zero values
+// are replaced with their defaults.
+type ParserOptions struct {
+	// Whether to capture command arguments and reply values. By default, only
+	// their sizes are captured.
+	CaptureValues bool
+
+	// Maximum length of a captured value. Longer values are truncated.
+	MaxValueSize int
+}
+
+func (opts ParserOptions) withDefaults() ParserOptions {
+	if opts.MaxValueSize <= 0 {
+		opts.MaxValueSize = DefaultMaxValueSize
+	}
+	return opts
+}
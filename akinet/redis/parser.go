@@ -0,0 +1,389 @@
+package redis
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+func newRedisParser(factory *redisParserFactory, bidiID akinet.TCPBidiID, seq, ack reassembly.Sequence) *redisParser {
+	return &redisParser{
+		factory: factory,
+		bidiID:  bidiID,
+		seq:     seq,
+		ack:     ack,
+		opts:    factory.opts,
+		input:   akinet.NewRetainedInput(0),
+	}
+}
+
+// An aggregate whose elements are being parsed.
+type frame struct {
+	// The number of elements left to parse.
+	remaining int64
+
+	// Whether the aggregate is an attribute, which is not itself an element of
+	// the enclosing aggregate.
+	attribute bool
+}
+
+// Parses a single RESP value: either a command or a reply. Bulk strings are
+// skipped as they arrive, rather than buffered. The input is held on to so
+// that it can be returned if the value can't be parsed, but only up to a limit
+// (see akinet.RetainedInput), so that large values don't use up memory.
+type redisParser struct {
+	factory  *redisParserFactory
+	bidiID   akinet.TCPBidiID
+	seq, ack reassembly.Sequence
+	opts     ParserOptions
+
+	// The input, which is returned as unused if the input can't be parsed.
+	input akinet.RetainedInput
+
+	// Input that has not been consumed yet. Consumed input is dropped as we go.
+	allInput memview.MemView
+
+	// The aggregates enclosing the element being parsed.
+	stack []frame
+
+	// Whether a bulk string is being parsed, and the number of bytes left in
+	// its body, not including the trailing CRLF.
+	inBody        bool
+	bodyRemaining int64
+
+	// The context of the bulk string being parsed, and the first bytes of its
+	// body, up to captureLimit.
+	bodyIsTop, bodyIsArgument bool
+	capture                   []byte
+	captureLimit              int
+
+	// The top-level value. Attributes that precede it are skipped.
+	top       header
+	topParsed bool
+	topValue  []byte
+
+	// Whether the value so far could be a command: an array of bulk strings.
+	couldBeCommand bool
+
+	// The sizes of the elements of the top-level array, and the short ones
+	// among them.
+	argumentSizes []int
+	tokens        []string
+	arguments     []string
+}
+
+var _ akinet.TCPParser = (*redisParser)(nil)
+
+func (*redisParser) Name() string {
+	return "Redis Parser"
+}
+
+func (p *redisParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	p.input.Append(input)
+	p.allInput.Append(input)
+
+	done, err := p.parse()
+	if err != nil {
+		return nil, p.input.Unused(p.allInput), err
+	}
+	if !done {
+		if isEnd {
+			return nil, p.input.Unused(p.allInput), io.ErrUnexpectedEOF
+		}
+		return nil, memview.MemView{}, nil
+	}
+
+	return p.getResult(), p.allInput, nil
+}
+
+// Consumes input until the value is complete or more input is needed.
+func (p *redisParser) parse() (done bool, err error) {
+	for {
+		if p.inBody {
+			if done, err := p.consumeBody(); err != nil || !done {
+				return false, err
+			}
+			if p.completeElement() {
+				return true, nil
+			}
+			continue
+		}
+
+		line, next, ok, err := readLine(p.allInput, 0)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+		h, err := parseHeader(line)
+		if err != nil {
+			return false, err
+		}
+		p.allInput = p.allInput.SubView(next, p.allInput.Len())
+
+		complete, err := p.processHeader(h)
+		if err != nil {
+			return false, err
+		}
+		if complete && p.completeElement() {
+			return true, nil
+		}
+	}
+}
+
+// Processes the line that starts a value. Returns true if the value is
+// complete.
+func (p *redisParser) processHeader(h header) (complete bool, err error) {
+	depth := len(p.stack)
+	isTop := depth == 0 && h.typ != attributeType
+	isArgument := p.topParsed && p.top.typ == arrayType && depth == 1
+
+	if isTop {
+		p.top = h
+		p.topParsed = true
+		p.couldBeCommand = h.typ == arrayType && h.length > 0
+		if !isAggregate(h.typ) && p.opts.CaptureValues {
+			p.topValue = truncate(h.content, p.opts.MaxValueSize)
+		}
+		if h.typ == errorType {
+			// Keep the error prefix.
+			p.topValue = h.content
+		}
+	} else if depth > 1 || h.typ != bulkStringType || h.length < 0 {
+		p.couldBeCommand = false
+	}
+
+	switch {
+	case hasBody(h.typ) && h.length >= 0:
+		p.inBody = true
+		p.bodyRemaining = h.length
+		p.bodyIsTop = isTop
+		p.bodyIsArgument = isArgument && p.couldBeCommand
+		p.capture = nil
+		p.captureLimit = 0
+		if isTop && (p.opts.CaptureValues || h.typ == blobErrorType) {
+			p.captureLimit = p.opts.MaxValueSize
+		}
+		if p.bodyIsArgument {
+			if len(p.tokens) < maxTokens {
+				p.captureLimit = maxTokenLength_bytes
+			}
+			if p.opts.CaptureValues && p.captureLimit < p.opts.MaxValueSize {
+				p.captureLimit = p.opts.MaxValueSize
+			}
+			p.argumentSizes = append(p.argumentSizes, int(h.length))
+		}
+		return false, nil
+
+	case isAggregate(h.typ) && h.length > 0:
+		if depth >= maxNestingDepth {
+			return false, errors.New("RESP value nested too deeply")
+		}
+		if h.typ == mapType || h.typ == attributeType {
+			h.length *= 2
+		}
+		p.stack = append(p.stack, frame{remaining: h.length, attribute: h.typ == attributeType})
+		return false, nil
+
+	case h.typ == attributeType:
+		// An empty attribute.
+		return false, nil
+	}
+
+	if isArgument {
+		// A null or a simple type in the top-level array.
+		p.couldBeCommand = false
+	}
+	return true, nil
+}
+
+// Consumes the body of a bulk string and its trailing CRLF, capturing the
+// first bytes of the body. Returns true if the whole body was consumed.
+func (p *redisParser) consumeBody() (done bool, err error) {
+	if p.bodyRemaining > 0 {
+		n := p.allInput.Len()
+		if n > p.bodyRemaining {
+			n = p.bodyRemaining
+		}
+		if want := int64(p.captureLimit - len(p.capture)); want > 0 {
+			if want > n {
+				want = n
+			}
+			p.capture = append(p.capture, p.allInput.SubView(0, want).String()...)
+		}
+		p.allInput = p.allInput.SubView(n, p.allInput.Len())
+		p.bodyRemaining -= n
+		if p.bodyRemaining > 0 {
+			return false, nil
+		}
+	}
+
+	if p.allInput.Len() < int64(len(crlf)) {
+		return false, nil
+	}
+	if p.allInput.Index(0, crlf) != 0 {
+		return false, errMalformedValue
+	}
+	p.allInput = p.allInput.SubView(int64(len(crlf)), p.allInput.Len())
+	p.inBody = false
+
+	switch {
+	case p.bodyIsTop:
+		p.topValue = p.capture
+	case p.bodyIsArgument:
+		if len(p.tokens) < maxTokens {
+			token := ""
+			if len(p.capture) == p.argumentSizes[len(p.argumentSizes)-1] && len(p.capture) <= maxTokenLength_bytes {
+				token = string(p.capture)
+			}
+			p.tokens = append(p.tokens, token)
+		}
+		if p.opts.CaptureValues {
+			p.arguments = append(p.arguments, string(truncate(p.capture, p.opts.MaxValueSize)))
+		}
+	}
+	p.capture = nil
+	return true, nil
+}
+
+// Records the completion of an element. Returns true if the top-level value is
+// complete.
+func (p *redisParser) completeElement() bool {
+	for len(p.stack) > 0 {
+		top := &p.stack[len(p.stack)-1]
+		top.remaining--
+		if top.remaining > 0 {
+			return false
+		}
+
+		p.stack = p.stack[:len(p.stack)-1]
+		if top.attribute {
+			// The value that the attribute describes follows.
+			return false
+		}
+	}
+	return p.topParsed
+}
+
+func (p *redisParser) getResult() akinet.ParsedNetworkContent {
+	isCommand := false
+	name := ""
+	if p.couldBeCommand && len(p.tokens) > 0 {
+		name = strings.ToUpper(p.tokens[0])
+	}
+	if isClient, known := p.factory.isClientFlow(p.bidiID, p.seq); known {
+		isCommand = isClient && p.couldBeCommand
+	} else {
+		isCommand = p.couldBeCommand && isKnownCommand(name)
+	}
+
+	if isCommand {
+		return p.getCommand(name)
+	}
+	return p.getReply()
+}
+
+func (p *redisParser) getCommand(name string) akinet.RedisCommand {
+	result := akinet.RedisCommand{
+		StreamID:            uuid.UUID(p.bidiID),
+		Index:               p.factory.record(p.bidiID, p.seq, true, true),
+		Name:                name,
+		ArgumentSizes_bytes: p.argumentSizes[1:],
+	}
+	if p.opts.CaptureValues {
+		result.Arguments = p.arguments[1:]
+	}
+
+	if _, ok := containerCommands[name]; ok && len(p.tokens) > 1 {
+		result.Subcommand = strings.ToUpper(p.tokens[1])
+	}
+	if spec, ok := lookupCommand(name, result.Subcommand); ok {
+		result.KeyCount = spec.countKeys(len(p.argumentSizes), p.tokens)
+	}
+	return result
+}
+
+func (p *redisParser) getReply() akinet.RedisReply {
+	isPush := p.top.typ == pushType
+	result := akinet.RedisReply{
+		StreamID: uuid.UUID(p.bidiID),
+		Index:    p.factory.record(p.bidiID, p.seq, false, !isPush),
+		Type:     replyType(p.top),
+	}
+
+	switch {
+	case isAggregate(p.top.typ):
+		if p.top.length > 0 {
+			result.ElementCount = int(p.top.length)
+		}
+	case hasBody(p.top.typ):
+		if p.top.length > 0 {
+			result.Size_bytes = int(p.top.length)
+		}
+	default:
+		result.Size_bytes = len(p.top.content)
+	}
+
+	if result.Type == akinet.RedisError || result.Type == akinet.RedisBlobError {
+		prefix := p.topValue
+		if i := bytes.IndexByte(prefix, ' '); i >= 0 {
+			prefix = prefix[:i]
+		}
+		result.ErrorPrefix = string(prefix)
+	}
+	if p.opts.CaptureValues && !isAggregate(p.top.typ) {
+		result.Value = string(truncate(p.topValue, p.opts.MaxValueSize))
+	}
+	return result
+}
+
+func replyType(h header) akinet.RedisReplyType {
+	if h.length < 0 {
+		return akinet.RedisNull
+	}
+	switch h.typ {
+	case simpleStringType:
+		return akinet.RedisSimpleString
+	case errorType:
+		return akinet.RedisError
+	case integerType:
+		return akinet.RedisInteger
+	case bulkStringType:
+		return akinet.RedisBulkString
+	case arrayType:
+		return akinet.RedisArray
+	case nullType:
+		return akinet.RedisNull
+	case booleanType:
+		return akinet.RedisBoolean
+	case doubleType:
+		return akinet.RedisDouble
+	case bigNumberType:
+		return akinet.RedisBigNumber
+	case blobErrorType:
+		return akinet.RedisBlobError
+	case verbatimStringType:
+		return akinet.RedisVerbatimString
+	case mapType:
+		return akinet.RedisMap
+	case setType:
+		return akinet.RedisSet
+	default:
+		return akinet.RedisPush
+	}
+}
+
+func truncate(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
+}
@@ -0,0 +1,146 @@
+package redis
+
+import (
+	"sync"
+
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a parser factory for both halves of a Redis connection, using RESP2
+// or RESP3. Inline commands are not supported.
+//
+// Commands and replies are paired by their position on the connection, and
+// both look alike on the wire, so the returned factory keeps track of the
+// connections it has seen. The same factory must be used for both flows of a
+// connection.
+func NewRedisParserFactory(opts ParserOptions) akinet.TCPParserFactory {
+	return &redisParserFactory{
+		opts:        opts.withDefaults(),
+		connections: akinet.NewConnectionMap(connectionIdleTimeout, nil),
+	}
+}
+
+type redisParserFactory struct {
+	opts ParserOptions
+
+	mu          sync.Mutex
+	connections *akinet.ConnectionMap // of *connection, protected by mu
+}
+
+// The state of a connection. Protected by redisParserFactory.mu.
+type connection struct {
+	// The last sequence numbers seen on the client and server flows, used to
+	// tell which flow a parser is on.
+	clientSeq, serverSeq     reassembly.Sequence
+	clientKnown, serverKnown bool
+
+	// The number of commands and replies parsed so far.
+	numCommands, numReplies int
+}
+
+func (*redisParserFactory) Name() string {
+	return "Redis Parser Factory"
+}
+
+func (factory *redisParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	decision, discardFront = factory.accepts(input)
+
+	if decision == akinet.NeedMoreData && isEnd {
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+
+	return decision, discardFront
+}
+
+func (factory *redisParserFactory) accepts(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	line, next, ok, err := readLine(input, 0)
+	if err != nil {
+		return akinet.Reject, input.Len()
+	}
+	if !ok {
+		// Check the type byte early to avoid waiting for a line that will never
+		// come.
+		if input.Len() > 0 && !isTypeByte(input.GetByte(0)) {
+			return akinet.Reject, input.Len()
+		}
+		return akinet.NeedMoreData, 0
+	}
+
+	h, err := parseHeader(line)
+	if err != nil {
+		return akinet.Reject, input.Len()
+	}
+
+	// An aggregate must be followed by a valid element.
+	if isAggregate(h.typ) && h.length > 0 {
+		line, _, ok, err := readLine(input, next)
+		if err != nil {
+			return akinet.Reject, input.Len()
+		}
+		if !ok {
+			return akinet.NeedMoreData, 0
+		}
+		if _, err := parseHeader(line); err != nil {
+			return akinet.Reject, input.Len()
+		}
+	}
+
+	return akinet.Accept, 0
+}
+
+func (factory *redisParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newRedisParser(factory, id, seq, ack)
+}
+
+// Calls f with the state of the given connection, creating it if necessary.
+func (factory *redisParserFactory) withConnection(id akinet.TCPBidiID, f func(*connection)) {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	f(factory.connections.GetOrCreate(id, func() interface{} { return &connection{} }).(*connection))
+}
+
+// Determines whether the flow with the given sequence number is the client's,
+// if known.
+func (factory *redisParserFactory) isClientFlow(id akinet.TCPBidiID, seq reassembly.Sequence) (isClient, known bool) {
+	factory.withConnection(id, func(conn *connection) {
+		switch {
+		case conn.clientKnown && isNear(seq, conn.clientSeq):
+			isClient, known = true, true
+		case conn.serverKnown && isNear(seq, conn.serverSeq):
+			isClient, known = false, true
+		}
+	})
+	return isClient, known
+}
+
+// Records that the flow with the given sequence number is the client's or the
+// server's, and returns the index of the command or reply parsed on it.
+func (factory *redisParserFactory) record(id akinet.TCPBidiID, seq reassembly.Sequence, isClient, count bool) (index int) {
+	index = -1
+	factory.withConnection(id, func(conn *connection) {
+		if isClient {
+			conn.clientSeq, conn.clientKnown = seq, true
+			if count {
+				index = conn.numCommands
+				conn.numCommands++
+			}
+		} else {
+			conn.serverSeq, conn.serverKnown = seq, true
+			if count {
+				index = conn.numReplies
+				conn.numReplies++
+			}
+		}
+	})
+	return index
+}
+
+func isNear(a, b reassembly.Sequence) bool {
+	d := a.Difference(b)
+	return -maxSeqDistance < d && d < maxSeqDistance
+}
@@ -0,0 +1,275 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/akinet/internal/parsertest"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var testBidiID = akinet.TCPBidiID(uuid.MustParse("8b1f3c2e-6a0d-4e7f-9c5b-2d4a6e8f0b13"))
+
+const (
+	clientSeq = reassembly.Sequence(1000)
+	serverSeq = reassembly.Sequence(2000000000)
+)
+
+func TestPipelinedCommands(t *testing.T) {
+	factory := NewRedisParserFactory(ParserOptions{})
+	streamID := uuid.UUID(testBidiID)
+
+	client := strings.Join([]string{
+		"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$5\r\nhello\r\n",
+		"*2\r\n$3\r\nget\r\n$3\r\nfoo\r\n",
+		"*3\r\n$4\r\nMGET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n",
+		"*2\r\n$6\r\nLRANGE\r\n$4\r\nlist\r\n",
+		"*5\r\n$4\r\nEVAL\r\n$8\r\nreturn 1\r\n$1\r\n2\r\n$1\r\na\r\n$1\r\nb\r\n",
+		"*3\r\n$6\r\nCONFIG\r\n$3\r\nGET\r\n$10\r\nmaxclients\r\n",
+	}, "")
+	server := strings.Join([]string{
+		"+OK\r\n",
+		"$5\r\nhello\r\n",
+		"*2\r\n$5\r\nhello\r\n$-1\r\n",
+		// A list whose first element looks like a command.
+		"*2\r\n$3\r\nGET\r\n$3\r\nSET\r\n",
+		"-ERR unknown script\r\n",
+		"*2\r\n$10\r\nmaxclients\r\n$5\r\n10000\r\n",
+	}, "")
+
+	// The server's flow is parsed first, so the array replies can only be told
+	// apart from commands once a reply that isn't an array has been seen.
+	serverResults := parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq, []byte(server))
+	clientResults := parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, []byte(client))
+
+	expectedClient := []akinet.ParsedNetworkContent{
+		akinet.RedisCommand{
+			StreamID:            streamID,
+			Index:               0,
+			Name:                "SET",
+			KeyCount:            1,
+			ArgumentSizes_bytes: []int{3, 5},
+		},
+		akinet.RedisCommand{
+			StreamID:            streamID,
+			Index:               1,
+			Name:                "GET",
+			KeyCount:            1,
+			ArgumentSizes_bytes: []int{3},
+		},
+		akinet.RedisCommand{
+			StreamID:            streamID,
+			Index:               2,
+			Name:                "MGET",
+			KeyCount:            2,
+			ArgumentSizes_bytes: []int{3, 3},
+		},
+		akinet.RedisCommand{
+			StreamID:            streamID,
+			Index:               3,
+			Name:                "LRANGE",
+			KeyCount:            1,
+			ArgumentSizes_bytes: []int{4},
+		},
+		akinet.RedisCommand{
+			StreamID:            streamID,
+			Index:               4,
+			Name:                "EVAL",
+			KeyCount:            2,
+			ArgumentSizes_bytes: []int{8, 1, 1, 1},
+		},
+		akinet.RedisCommand{
+			StreamID:            streamID,
+			Index:               5,
+			Name:                "CONFIG",
+			Subcommand:          "GET",
+			ArgumentSizes_bytes: []int{3, 10},
+		},
+	}
+	if diff := cmp.Diff(expectedClient, clientResults, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff in commands: %s", diff)
+	}
+
+	expectedServer := []akinet.ParsedNetworkContent{
+		akinet.RedisReply{
+			StreamID:   streamID,
+			Index:      0,
+			Type:       akinet.RedisSimpleString,
+			Size_bytes: 2,
+		},
+		akinet.RedisReply{
+			StreamID:   streamID,
+			Index:      1,
+			Type:       akinet.RedisBulkString,
+			Size_bytes: 5,
+		},
+		akinet.RedisReply{
+			StreamID:     streamID,
+			Index:        2,
+			Type:         akinet.RedisArray,
+			ElementCount: 2,
+		},
+		akinet.RedisReply{
+			StreamID:     streamID,
+			Index:        3,
+			Type:         akinet.RedisArray,
+			ElementCount: 2,
+		},
+		akinet.RedisReply{
+			StreamID:    streamID,
+			Index:       4,
+			Type:        akinet.RedisError,
+			Size_bytes:  18,
+			ErrorPrefix: "ERR",
+		},
+		akinet.RedisReply{
+			StreamID:     streamID,
+			Index:        5,
+			Type:         akinet.RedisArray,
+			ElementCount: 2,
+		},
+	}
+	if diff := cmp.Diff(expectedServer, serverResults, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff in replies: %s", diff)
+	}
+}
+
+func TestRESP3Replies(t *testing.T) {
+	factory := NewRedisParserFactory(ParserOptions{})
+	streamID := uuid.UUID(testBidiID)
+
+	server := strings.Join([]string{
+		"%2\r\n+server\r\n+redis\r\n+proto\r\n:3\r\n",
+		"_\r\n",
+		"#t\r\n",
+		",3.14\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"!21\r\nSYNTAX invalid syntax\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"~2\r\n:1\r\n:2\r\n",
+		// An attribute, followed by the reply that it describes.
+		"|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.1923\r\n*1\r\n:2039123\r\n",
+		// A push, which is not a reply to a command.
+		">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+	}, "")
+
+	results := parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq, []byte(server))
+	expected := []akinet.ParsedNetworkContent{
+		akinet.RedisReply{StreamID: streamID, Index: 0, Type: akinet.RedisMap, ElementCount: 2},
+		akinet.RedisReply{StreamID: streamID, Index: 1, Type: akinet.RedisNull},
+		akinet.RedisReply{StreamID: streamID, Index: 2, Type: akinet.RedisBoolean, Size_bytes: 1},
+		akinet.RedisReply{StreamID: streamID, Index: 3, Type: akinet.RedisDouble, Size_bytes: 4},
+		akinet.RedisReply{StreamID: streamID, Index: 4, Type: akinet.RedisBigNumber, Size_bytes: 43},
+		akinet.RedisReply{StreamID: streamID, Index: 5, Type: akinet.RedisBlobError, Size_bytes: 21, ErrorPrefix: "SYNTAX"},
+		akinet.RedisReply{StreamID: streamID, Index: 6, Type: akinet.RedisVerbatimString, Size_bytes: 15},
+		akinet.RedisReply{StreamID: streamID, Index: 7, Type: akinet.RedisSet, ElementCount: 2},
+		akinet.RedisReply{StreamID: streamID, Index: 8, Type: akinet.RedisArray, ElementCount: 1},
+		akinet.RedisReply{StreamID: streamID, Index: -1, Type: akinet.RedisPush, ElementCount: 3},
+	}
+	if diff := cmp.Diff(expected, results, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+}
+
+func TestCaptureValues(t *testing.T) {
+	factory := NewRedisParserFactory(ParserOptions{CaptureValues: true, MaxValueSize: 4})
+	streamID := uuid.UUID(testBidiID)
+
+	commands := parsertest.ParseAll(t, factory, testBidiID, clientSeq, serverSeq, []byte("*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$5\r\nhello\r\n"))
+	replies := parsertest.ParseAll(t, factory, testBidiID, serverSeq, clientSeq, []byte("$5\r\nhello\r\n"))
+
+	expected := []akinet.ParsedNetworkContent{
+		akinet.RedisCommand{
+			StreamID:            streamID,
+			Name:                "SET",
+			KeyCount:            1,
+			ArgumentSizes_bytes: []int{3, 5},
+			Arguments:           []string{"foo", "hell"},
+		},
+		akinet.RedisReply{
+			StreamID:   streamID,
+			Type:       akinet.RedisBulkString,
+			Size_bytes: 5,
+			Value:      "hell",
+		},
+	}
+	if diff := cmp.Diff(expected, append(commands, replies...), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff: %s", diff)
+	}
+}
+
+func TestFactoryRejectsOtherProtocols(t *testing.T) {
+	testCases := append(parsertest.OtherProtocols("Redis"),
+		parsertest.RejectTestCase{Name: "MySQL query", Input: "+\x00\x00\x00\x03SELECT 1"},
+		parsertest.RejectTestCase{Name: "malformed array", Input: "*2\r\nfoo\r\n"},
+	)
+	parsertest.RunRejectTests(t, NewRedisParserFactory(ParserOptions{}), testCases)
+}
+
+func TestIncrementalParse(t *testing.T) {
+	testCases := []parsertest.IncrementalTestCase{
+		{
+			Name:  "command",
+			Seq:   clientSeq,
+			Ack:   serverSeq,
+			Input: []byte("*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"),
+			Next:  []byte("*1\r\n$4\r\nPING\r\n"),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				c, ok := result.(akinet.RedisCommand)
+				return ok && c.Name == "GET" && c.KeyCount == 1
+			},
+		},
+		{
+			Name:  "reply",
+			Seq:   serverSeq,
+			Ack:   clientSeq,
+			Input: []byte("$5\r\nhello\r\n"),
+			Next:  []byte("+PONG\r\n"),
+			Check: func(result akinet.ParsedNetworkContent) bool {
+				r, ok := result.(akinet.RedisReply)
+				return ok && r.Type == akinet.RedisBulkString && r.Size_bytes == 5
+			},
+		},
+	}
+	parsertest.RunIncrementalTests(t, NewRedisParserFactory(ParserOptions{}), testBidiID, testCases)
+}
+
+func TestErrorReturnsAllInput(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input []string
+		isEnd bool
+	}{
+		{
+			name:  "malformed element",
+			input: []string{"*2\r\n$3\r\nGET\r\n", "?foo\r\n"},
+		},
+		{
+			name:  "end of input",
+			input: []string{"*2\r\n$3\r\nGET\r\n", "$3\r\nfo"},
+			isEnd: true,
+		},
+	}
+
+	for _, c := range testCases {
+		p := NewRedisParserFactory(ParserOptions{}).CreateParser(testBidiID, clientSeq, serverSeq)
+		var unused memview.MemView
+		var err error
+		for i, input := range c.input {
+			_, unused, err = p.Parse(memview.New([]byte(input)), c.isEnd && i == len(c.input)-1)
+		}
+		if err == nil {
+			t.Errorf("[%s] expected an error", c.name)
+			continue
+		}
+		if expected := strings.Join(c.input, ""); unused.String() != expected {
+			t.Errorf("[%s] expected all input to be unused, got %q", c.name, unused.String())
+		}
+	}
+}
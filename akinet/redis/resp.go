@@ -0,0 +1,173 @@
+package redis
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var errMalformedValue = errors.New("malformed RESP value")
+
+// The line that starts a RESP value: a type byte, followed by the value of a
+// simple type, or the length of a bulk string or aggregate.
+type header struct {
+	typ byte
+
+	// The line without the type byte, for simple types.
+	content []byte
+
+	// The length of a bulk string or aggregate, or -1 for a RESP2 null.
+	length int64
+}
+
+// Reads the line starting at the given offset, up to CRLF. Returns the line
+// without the CRLF, and the offset after the CRLF. Returns false if the input
+// does not contain the whole line.
+func readLine(input memview.MemView, offset int64) (line []byte, next int64, ok bool, err error) {
+	end := input.Index(offset, crlf)
+	if end < 0 {
+		if input.Len()-offset > maxLineLength_bytes {
+			return nil, 0, false, errors.New("RESP line too long")
+		}
+		return nil, 0, false, nil
+	}
+	if end-offset > maxLineLength_bytes {
+		return nil, 0, false, errors.New("RESP line too long")
+	}
+	return []byte(input.SubView(offset, end).String()), end + int64(len(crlf)), true, nil
+}
+
+// Parses and validates the line that starts a RESP value.
+func parseHeader(line []byte) (header, error) {
+	if len(line) == 0 {
+		return header{}, errMalformedValue
+	}
+	h := header{typ: line[0], content: line[1:]}
+
+	switch h.typ {
+	case simpleStringType:
+		if !isPrintable(h.content) {
+			return header{}, errMalformedValue
+		}
+
+	case errorType:
+		// Errors start with an upper-case word that identifies the kind of
+		// error.
+		if len(h.content) == 0 || h.content[0] < 'A' || h.content[0] > 'Z' || !isPrintable(h.content) {
+			return header{}, errMalformedValue
+		}
+
+	case integerType, bigNumberType:
+		if !isInteger(h.content) {
+			return header{}, errMalformedValue
+		}
+
+	case nullType:
+		if len(h.content) != 0 {
+			return header{}, errMalformedValue
+		}
+
+	case booleanType:
+		if len(h.content) != 1 || (h.content[0] != 't' && h.content[0] != 'f') {
+			return header{}, errMalformedValue
+		}
+
+	case doubleType:
+		switch string(h.content) {
+		case "inf", "-inf", "nan":
+		default:
+			if _, err := strconv.ParseFloat(string(h.content), 64); err != nil {
+				return header{}, errMalformedValue
+			}
+		}
+
+	case bulkStringType, blobErrorType, verbatimStringType:
+		length, err := parseLength(h.content, h.typ == bulkStringType, maxBulkLength_bytes)
+		if err != nil {
+			return header{}, err
+		}
+		h.length = length
+
+	case arrayType, mapType, setType, attributeType, pushType:
+		length, err := parseLength(h.content, h.typ == arrayType, maxAggregateLength)
+		if err != nil {
+			return header{}, err
+		}
+		h.length = length
+
+	default:
+		return header{}, errors.Errorf("unknown RESP type byte 0x%x", h.typ)
+	}
+
+	return h, nil
+}
+
+// Parses the length of a bulk string or aggregate. RESP2 uses a length of -1
+// for nulls. Streamed RESP3 types, which have a length of "?", are not
+// supported.
+func parseLength(b []byte, allowNull bool, max int64) (int64, error) {
+	if !isInteger(b) {
+		return 0, errMalformedValue
+	}
+	length, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, errMalformedValue
+	}
+	if length == -1 && allowNull {
+		return length, nil
+	}
+	if length < 0 || length > max {
+		return 0, errMalformedValue
+	}
+	return length, nil
+}
+
+func isInteger(b []byte) bool {
+	if len(b) > 0 && (b[0] == '-' || b[0] == '+') {
+		b = b[1:]
+	}
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if (c < 0x20 && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func isTypeByte(typ byte) bool {
+	switch typ {
+	case simpleStringType, errorType, integerType, nullType, booleanType, doubleType, bigNumberType:
+		return true
+	}
+	return hasBody(typ) || isAggregate(typ)
+}
+
+func isAggregate(typ byte) bool {
+	switch typ {
+	case arrayType, mapType, setType, attributeType, pushType:
+		return true
+	}
+	return false
+}
+
+func hasBody(typ byte) bool {
+	switch typ {
+	case bulkStringType, blobErrorType, verbatimStringType:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+// Package replay parses the TCP traffic in pcap and pcapng files, without
+// needing a live network interface or root access.
+package replay
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/reassembly"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+const (
+	// The default for Options.StreamTimeout.
+	DefaultStreamTimeout = 90 * time.Second
+
+	// How often, in capture time, to close streams that have timed out.
+	flushInterval = 10 * time.Second
+)
+
+// The magic number at the start of a pcapng file, which is the type of its
+// first Section Header Block.
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+type Options struct {
+	// Selects the parsers for each direction of each TCP connection. Data that
+	// no factory accepts is discarded.
+	Factories akinet.TCPParserFactorySelector
+
+	// The interface name reported for packets in pcap files, and in pcapng
+	// files that don't name the interface that a packet was captured on.
+	InterfaceName string
+
+	// Connections that see no packets for this long, in capture time, are
+	// closed, and any partially parsed data is given to the parsers as the end
+	// of the flow. Defaults to DefaultStreamTimeout.
+	StreamTimeout time.Duration
+}
+
+// Reads a pcap or pcapng file, reassembles its TCP connections, and sends the
+// traffic parsed from them to out. See Read.
+func ReadFile(path string, opts Options, out chan<- akinet.ParsedNetworkTraffic) error {
+	f, err := os.Open(path)
+	if err != nil {
+		defer close(out)
+		return errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+	return Read(f, opts, out)
+}
+
+// Reads a pcap or pcapng capture, reassembles its TCP connections, and sends
+// the traffic parsed from them to out. Closes out once the whole capture has
+// been read, or on error.
+//
+// Each direction of each connection is parsed with the factories in
+// opts.Factories. In addition to the parsed content, out receives an
+// akinet.TCPPacketMetadata for every TCP packet, and an
+// akinet.TCPConnectionMetadata for every connection once it is closed, or once
+// the capture ends. Packets that aren't TCP are ignored.
+func Read(r io.Reader, opts Options, out chan<- akinet.ParsedNetworkTraffic) error {
+	defer close(out)
+
+	if opts.StreamTimeout <= 0 {
+		opts.StreamTimeout = DefaultStreamTimeout
+	}
+
+	source, err := newPacketSource(r, opts.InterfaceName)
+	if err != nil {
+		return err
+	}
+
+	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(&streamFactory{
+		factories: opts.Factories,
+		out:       out,
+	}))
+
+	var lastFlush time.Time
+	for packetNum := 1; ; packetNum++ {
+		data, ci, err := source.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to read packet %d", packetNum)
+		}
+
+		packet := gopacket.NewPacket(data, source.linkType(ci), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		network := packet.NetworkLayer()
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if network == nil || !ok {
+			continue
+		}
+
+		assembler.AssembleWithContext(network.NetworkFlow(), tcp, &packetContext{
+			ci:    ci,
+			iface: source.interfaceName(ci),
+			seq:   reassembly.Sequence(tcp.Seq),
+			ack:   reassembly.Sequence(tcp.Ack),
+		})
+
+		if lastFlush.IsZero() {
+			lastFlush = ci.Timestamp
+		} else if ci.Timestamp.Sub(lastFlush) >= flushInterval {
+			assembler.FlushCloseOlderThan(ci.Timestamp.Add(-opts.StreamTimeout))
+			lastFlush = ci.Timestamp
+		}
+	}
+
+	assembler.FlushAll()
+	return nil
+}
+
+// Reads packets from either a pcap or a pcapng capture.
+type packetSource struct {
+	gopacket.PacketDataSource
+
+	// Set for pcap captures.
+	pcap *pcapgo.Reader
+
+	// Set for pcapng captures.
+	pcapng *pcapgo.NgReader
+
+	defaultInterface string
+}
+
+func newPacketSource(r io.Reader, defaultInterface string) (*packetSource, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read capture header")
+	}
+
+	source := &packetSource{defaultInterface: defaultInterface}
+	if string(magic) == string(pcapngMagic) {
+		options := pcapgo.DefaultNgReaderOptions
+		options.WantMixedLinkType = true
+		source.pcapng, err = pcapgo.NewNgReader(br, options)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read pcapng header")
+		}
+		source.PacketDataSource = source.pcapng
+	} else {
+		source.pcap, err = pcapgo.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read pcap header")
+		}
+		source.PacketDataSource = source.pcap
+	}
+	return source, nil
+}
+
+// Returns the link type of a packet.
+func (s *packetSource) linkType(ci gopacket.CaptureInfo) layers.LinkType {
+	if s.pcap != nil {
+		return s.pcap.LinkType()
+	}
+	// WantMixedLinkType puts each packet's link type in its ancillary data.
+	if len(ci.AncillaryData) > 0 {
+		if linkType, ok := ci.AncillaryData[0].(layers.LinkType); ok {
+			return linkType
+		}
+	}
+	return s.pcapng.LinkType()
+}
+
+// Returns the name of the interface a packet was captured on.
+func (s *packetSource) interfaceName(ci gopacket.CaptureInfo) string {
+	if s.pcapng != nil {
+		if intf, err := s.pcapng.Interface(ci.InterfaceIndex); err == nil && intf.Name != "" {
+			return intf.Name
+		}
+	}
+	return s.defaultInterface
+}
+
+// Carries the details of a packet through reassembly.
+type packetContext struct {
+	ci       gopacket.CaptureInfo
+	iface    string
+	seq, ack reassembly.Sequence
+}
+
+var _ reassembly.AssemblerContext = (*packetContext)(nil)
+
+func (c *packetContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return c.ci
+}
@@ -0,0 +1,324 @@
+package replay
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	akihttp "github.com/akitasoftware/akita-libs/akinet/http"
+)
+
+var (
+	clientIP = net.IP{10, 0, 0, 1}
+	serverIP = net.IP{10, 0, 0, 2}
+
+	startTime = time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+)
+
+type testPacket struct {
+	fromClient bool
+	flags      string // Any of "S", "A", "F", "R".
+	payload    string
+}
+
+// A connection on which the client sends a request in two packets, the server
+// responds, and both sides close the connection.
+var testConnection = []testPacket{
+	{true, "S", ""},
+	{false, "SA", ""},
+	{true, "A", ""},
+	{true, "A", "POST /v1/pets HTTP/1.1\r\nHost: example.com\r\n"},
+	{true, "A", "Content-Length: 14\r\n\r\n{\"name\":\"Rex\"}"},
+	{false, "A", "HTTP/1.1 201 Created\r\nContent-Length: 11\r\n\r\n{\"id\":\"p1\"}"},
+	{true, "FA", ""},
+	{false, "FA", ""},
+	{true, "A", ""},
+}
+
+// Returns the capture time of the i-th test packet.
+func packetTime(i int) time.Time {
+	return startTime.Add(time.Duration(i) * time.Millisecond)
+}
+
+// Serializes the test packets as Ethernet frames, tracking the sequence
+// numbers of each side.
+func serializePackets(t *testing.T, packets []testPacket) [][]byte {
+	clientSeq, serverSeq := uint32(1000), uint32(500000000)
+	var result [][]byte
+	for _, p := range packets {
+		tcp := &layers.TCP{
+			SrcPort: 54321,
+			DstPort: 80,
+			Seq:     clientSeq,
+			Ack:     serverSeq,
+			Window:  65535,
+		}
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: clientIP, DstIP: serverIP}
+		if !p.fromClient {
+			tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+			tcp.Seq, tcp.Ack = serverSeq, clientSeq
+			ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+		}
+		tcp.SYN = bytes.ContainsRune([]byte(p.flags), 'S')
+		tcp.ACK = bytes.ContainsRune([]byte(p.flags), 'A')
+		tcp.FIN = bytes.ContainsRune([]byte(p.flags), 'F')
+		tcp.RST = bytes.ContainsRune([]byte(p.flags), 'R')
+		if !tcp.ACK {
+			tcp.Ack = 0
+		}
+		tcp.SetNetworkLayerForChecksum(ip)
+
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(p.payload)); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, buf.Bytes())
+
+		advance := uint32(len(p.payload))
+		if tcp.SYN || tcp.FIN {
+			advance++
+		}
+		if p.fromClient {
+			clientSeq += advance
+		} else {
+			serverSeq += advance
+		}
+	}
+	return result
+}
+
+func writePcap(t *testing.T, packets []testPacket) []byte {
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range serializePackets(t, packets) {
+		ci := gopacket.CaptureInfo{Timestamp: packetTime(i), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func writePcapng(t *testing.T, packets []testPacket, iface string) []byte {
+	var buf bytes.Buffer
+	w, err := pcapgo.NewNgWriterInterface(&buf, pcapgo.NgInterface{
+		Name:                iface,
+		LinkType:            layers.LinkTypeEthernet,
+		SnapLength:          65536,
+		TimestampResolution: 9,
+	}, pcapgo.DefaultNgWriterOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range serializePackets(t, packets) {
+		ci := gopacket.CaptureInfo{Timestamp: packetTime(i), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func replay(t *testing.T, capture []byte, opts Options) []akinet.ParsedNetworkTraffic {
+	out := make(chan akinet.ParsedNetworkTraffic)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Read(bytes.NewReader(capture), opts, out)
+	}()
+
+	var results []akinet.ParsedNetworkTraffic
+	for r := range out {
+		results = append(results, r)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return results
+}
+
+func TestReplay(t *testing.T) {
+	testCases := []struct {
+		name          string
+		capture       []byte
+		expectedIface string
+	}{
+		{
+			name:          "pcap",
+			capture:       writePcap(t, testConnection),
+			expectedIface: "default0",
+		},
+		{
+			name:          "pcapng",
+			capture:       writePcapng(t, testConnection, "eth7"),
+			expectedIface: "eth7",
+		},
+	}
+
+	for _, c := range testCases {
+		results := replay(t, c.capture, Options{
+			Factories: akinet.TCPParserFactorySelector{
+				akihttp.NewHTTPRequestParserFactory(akihttp.ParserOptions{}),
+				akihttp.NewHTTPResponseParserFactory(akihttp.ParserOptions{}),
+			},
+			InterfaceName: "default0",
+		})
+
+		var req *akinet.HTTPRequest
+		var resp *akinet.HTTPResponse
+		var conn *akinet.TCPConnectionMetadata
+		var reqTraffic, respTraffic, connTraffic akinet.ParsedNetworkTraffic
+		numPackets := 0
+		for _, r := range results {
+			if r.Interface != c.expectedIface {
+				t.Errorf("[%s] expected interface %q, got %q", c.name, c.expectedIface, r.Interface)
+			}
+			switch content := r.Content.(type) {
+			case akinet.HTTPRequest:
+				req, reqTraffic = &content, r
+			case akinet.HTTPResponse:
+				resp, respTraffic = &content, r
+			case akinet.TCPConnectionMetadata:
+				conn, connTraffic = &content, r
+			case akinet.TCPPacketMetadata:
+				numPackets++
+				if !content.SeqAckWindowKnown {
+					t.Errorf("[%s] expected the sequence number and window of each packet to be known", c.name)
+				}
+			default:
+				t.Errorf("[%s] unexpected result %T", c.name, content)
+			}
+		}
+		if req == nil || resp == nil || conn == nil {
+			t.Fatalf("[%s] missing results: request %v, response %v, connection %v", c.name, req != nil, resp != nil, conn != nil)
+		}
+
+		if numPackets != len(testConnection) {
+			t.Errorf("[%s] expected %d packets, got %d", c.name, len(testConnection), numPackets)
+		}
+		if req.Method != "POST" || req.URL.Path != "/v1/pets" || string(req.Body) != `{"name":"Rex"}` {
+			t.Errorf("[%s] unexpected request: %s %s %q", c.name, req.Method, req.URL, req.Body)
+		}
+		if resp.StatusCode != 201 || string(resp.Body) != `{"id":"p1"}` {
+			t.Errorf("[%s] unexpected response: %d %q", c.name, resp.StatusCode, resp.Body)
+		}
+		if req.GetStreamKey() != resp.GetStreamKey() {
+			t.Errorf("[%s] request and response don't pair: %s and %s", c.name, req.GetStreamKey(), resp.GetStreamKey())
+		}
+
+		type endpoints struct {
+			SrcIP, DstIP     net.IP
+			SrcPort, DstPort int
+			ObservationTime  time.Time
+			FinalPacketTime  time.Time
+		}
+		toEndpoints := func(r akinet.ParsedNetworkTraffic) endpoints {
+			return endpoints{r.SrcIP, r.DstIP, r.SrcPort, r.DstPort, r.ObservationTime, r.FinalPacketTime}
+		}
+		expected := []endpoints{
+			{clientIP, serverIP, 54321, 80, packetTime(3), packetTime(4)},
+			{serverIP, clientIP, 80, 54321, packetTime(5), packetTime(5)},
+			// The connection is reported once both sides have sent FIN, before the
+			// final ACK.
+			{clientIP, serverIP, 54321, 80, packetTime(0), packetTime(7)},
+		}
+		actual := []endpoints{toEndpoints(reqTraffic), toEndpoints(respTraffic), toEndpoints(connTraffic)}
+		if diff := cmp.Diff(expected, actual, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("[%s] found unexpected diff in request, response, and connection:\n%s", c.name, diff)
+		}
+
+		if conn.Initiator != akinet.SourceInitiator || conn.EndState != akinet.ConnectionClosed {
+			t.Errorf("[%s] unexpected connection metadata: %+v", c.name, conn)
+		}
+	}
+}
+
+func TestReplayMidConnection(t *testing.T) {
+	// The capture starts after the handshake, and the connection is reset.
+	packets := append([]testPacket{}, testConnection[3:6]...)
+	packets = append(packets, testPacket{false, "R", ""})
+
+	results := replay(t, writePcap(t, packets), Options{
+		Factories: akinet.TCPParserFactorySelector{
+			akihttp.NewHTTPRequestParserFactory(akihttp.ParserOptions{}),
+			akihttp.NewHTTPResponseParserFactory(akihttp.ParserOptions{}),
+		},
+	})
+
+	var contentTypes []string
+	var conn akinet.TCPConnectionMetadata
+	for _, r := range results {
+		switch content := r.Content.(type) {
+		case akinet.HTTPRequest:
+			contentTypes = append(contentTypes, "request")
+		case akinet.HTTPResponse:
+			contentTypes = append(contentTypes, "response")
+		case akinet.TCPConnectionMetadata:
+			contentTypes = append(contentTypes, "connection")
+			conn = content
+		}
+	}
+	if diff := cmp.Diff([]string{"request", "response", "connection"}, contentTypes); diff != "" {
+		t.Errorf("found unexpected diff in results:\n%s", diff)
+	}
+	if conn.Initiator != akinet.UnknownTCPConnectionInitiator || conn.EndState != akinet.ConnectionReset {
+		t.Errorf("unexpected connection metadata: %+v", conn)
+	}
+}
+
+func TestReplayAfterParseError(t *testing.T) {
+	// A malformed request is followed by a good one on the same flow.
+	packets := []testPacket{
+		{true, "S", ""},
+		{false, "SA", ""},
+		{true, "A", ""},
+		{true, "A", "GET / HTTP/1.1\r\nnot a header\r\n\r\nGET /ok HTTP/1.1\r\nHost: example.com\r\n\r\n"},
+		{false, "A", "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"},
+	}
+
+	results := replay(t, writePcap(t, packets), Options{
+		Factories: akinet.TCPParserFactorySelector{
+			akihttp.NewHTTPRequestParserFactory(akihttp.ParserOptions{}),
+			akihttp.NewHTTPResponseParserFactory(akihttp.ParserOptions{}),
+		},
+	})
+
+	var paths []string
+	for _, r := range results {
+		if req, ok := r.Content.(akinet.HTTPRequest); ok {
+			paths = append(paths, req.URL.Path)
+		}
+	}
+	if diff := cmp.Diff([]string{"/ok"}, paths); diff != "" {
+		t.Errorf("found unexpected diff in requests:\n%s", diff)
+	}
+}
+
+func TestReplayInvalidCapture(t *testing.T) {
+	out := make(chan akinet.ParsedNetworkTraffic, 1)
+	if err := Read(bytes.NewReader([]byte("not a capture")), Options{}, out); err == nil {
+		t.Error("expected an error")
+	}
+	if _, ok := <-out; ok {
+		t.Error("expected the output channel to be closed")
+	}
+}
@@ -0,0 +1,343 @@
+package replay
+
+import (
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Creates a tcpStream for each TCP connection seen by the assembler.
+type streamFactory struct {
+	factories akinet.TCPParserFactorySelector
+	out       chan<- akinet.ParsedNetworkTraffic
+}
+
+var _ reassembly.StreamFactory = (*streamFactory)(nil)
+
+func (sf *streamFactory) New(netFlow, _ gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	bidiID := akinet.TCPBidiID(uuid.New())
+	s := &tcpStream{
+		factories:    sf.factories,
+		out:          sf.out,
+		bidiID:       bidiID,
+		connectionID: akid.NewConnectionID(uuid.UUID(bidiID)),
+		iface:        ac.(*packetContext).iface,
+		initiator:    akinet.UnknownTCPConnectionInitiator,
+	}
+
+	// The assembler takes the first packet of a connection to be from client to
+	// server. Work out whether the "client" really initiated the connection.
+	if tcp.SYN {
+		if tcp.ACK {
+			s.initiator = akinet.DestInitiator
+		} else {
+			s.initiator = akinet.SourceInitiator
+		}
+	}
+
+	srcIP, dstIP := net.IP(netFlow.Src().Raw()), net.IP(netFlow.Dst().Raw())
+	srcPort, dstPort := int(tcp.SrcPort), int(tcp.DstPort)
+	s.clientToServer = tcpFlow{stream: s, srcIP: srcIP, srcPort: srcPort, dstIP: dstIP, dstPort: dstPort}
+	s.serverToClient = tcpFlow{stream: s, srcIP: dstIP, srcPort: dstPort, dstIP: srcIP, dstPort: srcPort}
+	return s
+}
+
+// The state of a TCP connection.
+type tcpStream struct {
+	factories    akinet.TCPParserFactorySelector
+	out          chan<- akinet.ParsedNetworkTraffic
+	bidiID       akinet.TCPBidiID
+	connectionID akid.ConnectionID
+	iface        string
+
+	initiator akinet.TCPConnectionInitiator
+	finSeen   bool
+	rstSeen   bool
+	complete  bool
+
+	firstPacketTime, lastPacketTime time.Time
+
+	clientToServer, serverToClient tcpFlow
+}
+
+var _ reassembly.Stream = (*tcpStream)(nil)
+
+func (s *tcpStream) flow(dir reassembly.TCPFlowDirection) *tcpFlow {
+	if dir == reassembly.TCPDirClientToServer {
+		return &s.clientToServer
+	}
+	return &s.serverToClient
+}
+
+func (s *tcpStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	// Captures may start in the middle of a connection, so don't wait for a
+	// SYN.
+	*start = true
+
+	if s.firstPacketTime.IsZero() {
+		s.firstPacketTime = ci.Timestamp
+	}
+	s.lastPacketTime = ci.Timestamp
+	s.finSeen = s.finSeen || tcp.FIN
+	s.rstSeen = s.rstSeen || tcp.RST
+
+	s.flow(dir).emit(akinet.TCPPacketMetadata{
+		ConnectionID:        s.connectionID,
+		SYN:                 tcp.SYN,
+		ACK:                 tcp.ACK,
+		FIN:                 tcp.FIN,
+		RST:                 tcp.RST,
+		PayloadLength_bytes: len(tcp.Payload),
+		Seq:                 tcp.Seq,
+		Ack:                 tcp.Ack,
+		Window:              tcp.Window,
+		SeqAckWindowKnown:   true,
+	}, ci.Timestamp, ci.Timestamp)
+	return true
+}
+
+func (s *tcpStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, end, skip := sg.Info()
+	length, _ := sg.Lengths()
+
+	var data []byte
+	if length > 0 {
+		// The ScatterGather is reused, so copy the data out of it.
+		data = append([]byte{}, sg.Fetch(length)...)
+	}
+
+	f := s.flow(dir)
+	if skip != 0 {
+		// Some data is missing, so whatever was being parsed can't be completed.
+		f.reset()
+	}
+	if length == 0 {
+		f.reassembled(memview.MemView{}, segment{}, end)
+		return
+	}
+
+	ctx, ok := sg.AssemblerContext(0).(*packetContext)
+	if !ok {
+		ctx, _ = ac.(*packetContext)
+	}
+	if ctx == nil {
+		// Shouldn't happen, since we supply a packetContext for every packet.
+		f.reset()
+		return
+	}
+	if !f.seqKnown {
+		f.seq, f.seqKnown = ctx.seq, true
+	}
+	f.reassembled(memview.New(data), segment{
+		length:    int64(length),
+		ack:       ctx.ack,
+		firstTime: ctx.ci.Timestamp,
+		lastTime:  sg.CaptureInfo(length - 1).Timestamp,
+	}, end)
+}
+
+func (s *tcpStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	if s.complete {
+		return false
+	}
+	s.complete = true
+
+	s.clientToServer.end()
+	s.serverToClient.end()
+
+	endState := akinet.ConnectionOpen
+	if s.rstSeen {
+		endState = akinet.ConnectionReset
+	} else if s.finSeen {
+		endState = akinet.ConnectionClosed
+	}
+	s.clientToServer.emit(akinet.TCPConnectionMetadata{
+		ConnectionID: s.connectionID,
+		Initiator:    s.initiator,
+		EndState:     endState,
+	}, s.firstPacketTime, s.lastPacketTime)
+
+	// Keep the connection in the pool, so that packets that follow the end of
+	// the connection (e.g., the final ACK) don't start a new one. The connection
+	// is removed once it times out.
+	return false
+}
+
+// A contiguous run of data on a flow, reassembled from one or more packets.
+type segment struct {
+	length int64
+
+	// The acknowledgement number on the first packet.
+	ack reassembly.Sequence
+
+	// The capture times of the first and last packets.
+	firstTime, lastTime time.Time
+}
+
+// The parsing state of one direction of a TCP connection.
+type tcpFlow struct {
+	stream *tcpStream
+
+	srcIP, dstIP     net.IP
+	srcPort, dstPort int
+
+	// The sequence number of the first byte that hasn't yet produced a result
+	// or been discarded.
+	seq      reassembly.Sequence
+	seqKnown bool
+
+	// The current parser, and the number of bytes given to it so far.
+	parser akinet.TCPParser
+	fed    int64
+
+	// Data that has not yet been given to a parser.
+	pending memview.MemView
+
+	// Describes the data starting at seq: the data given to the current parser,
+	// followed by pending.
+	segments []segment
+
+	// The time of the last packet with data on the flow.
+	lastTime time.Time
+
+	ended bool
+}
+
+func (f *tcpFlow) emit(content akinet.ParsedNetworkContent, observationTime, finalPacketTime time.Time) {
+	f.stream.out <- akinet.ParsedNetworkTraffic{
+		SrcIP:           f.srcIP,
+		SrcPort:         f.srcPort,
+		DstIP:           f.dstIP,
+		DstPort:         f.dstPort,
+		Content:         content,
+		Interface:       f.stream.iface,
+		ObservationTime: observationTime,
+		FinalPacketTime: finalPacketTime,
+	}
+}
+
+// Abandons the current parser and any pending data.
+func (f *tcpFlow) reset() {
+	f.parser = nil
+	f.fed = 0
+	f.pending = memview.MemView{}
+	f.segments = nil
+	f.seqKnown = false
+}
+
+// Handles data reassembled from the flow.
+func (f *tcpFlow) reassembled(data memview.MemView, seg segment, isEnd bool) {
+	if f.ended {
+		return
+	}
+	if seg.length > 0 {
+		f.pending.Append(data)
+		f.segments = append(f.segments, seg)
+		f.lastTime = seg.lastTime
+	}
+	f.parse(isEnd)
+	if isEnd {
+		f.ended = true
+	}
+}
+
+// Signals the end of the flow to the current parser, if it hasn't already
+// been signalled.
+func (f *tcpFlow) end() {
+	f.reassembled(memview.MemView{}, segment{}, true)
+}
+
+// Drives the parsers over the pending data.
+func (f *tcpFlow) parse(isEnd bool) {
+	for f.parser != nil || f.pending.Len() > 0 {
+		if f.parser == nil {
+			factory, decision, discardFront := f.stream.factories.Select(f.pending, isEnd)
+			f.pending = f.pending.SubView(discardFront, f.pending.Len())
+			f.advance(discardFront)
+			if decision != akinet.Accept {
+				return
+			}
+
+			var ack reassembly.Sequence
+			if len(f.segments) > 0 {
+				ack = f.segments[0].ack
+			}
+			f.parser = factory.CreateParser(f.stream.bidiID, f.seq, ack)
+			f.fed = 0
+		}
+
+		input := f.pending
+		f.pending = memview.MemView{}
+		f.fed += input.Len()
+		result, unused, err := f.parser.Parse(input, isEnd)
+		if err != nil {
+			// Discard what the parser consumed and the first byte of what it left
+			// unused, and look for the start of another message in the rest.
+			discard := f.fed - unused.Len()
+			if unused.Len() > 0 {
+				unused = unused.SubView(1, unused.Len())
+				discard++
+			}
+			f.advance(discard)
+			f.parser = nil
+			f.pending = unused
+			continue
+		}
+		if result == nil {
+			return
+		}
+
+		consumed := f.fed - unused.Len()
+		f.emit(result, f.firstTime(), f.lastTimeAt(consumed-1))
+		f.advance(consumed)
+		f.parser = nil
+		f.pending = unused
+
+		if consumed == 0 && isEnd {
+			// Guard against parsers that produce results without consuming
+			// anything.
+			return
+		}
+	}
+}
+
+// Discards the first n bytes of the data described by segments.
+func (f *tcpFlow) advance(n int64) {
+	f.seq = f.seq.Add(int(n))
+	for n > 0 && len(f.segments) > 0 {
+		if n < f.segments[0].length {
+			f.segments[0].length -= n
+			return
+		}
+		n -= f.segments[0].length
+		f.segments = f.segments[1:]
+	}
+}
+
+// Returns the time of the first packet holding the data described by segments.
+func (f *tcpFlow) firstTime() time.Time {
+	if len(f.segments) == 0 {
+		return f.lastTime
+	}
+	return f.segments[0].firstTime
+}
+
+// Returns the time of the last packet holding data up to the given offset of
+// the data described by segments.
+func (f *tcpFlow) lastTimeAt(offset int64) time.Time {
+	for _, seg := range f.segments {
+		if offset < seg.length {
+			return seg.lastTime
+		}
+		offset -= seg.length
+	}
+	return f.lastTime
+}
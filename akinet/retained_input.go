@@ -0,0 +1,53 @@
+package akinet
+
+import (
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Default limit on the input held on to by a RetainedInput.
+const DefaultMaxRetainedInput_bytes int64 = 1024 * 1024
+
+// Holds on to the input supplied to a TCPParser, so that all of it can be
+// returned as unused if the parser fails.
+//
+// Parsers that consume their input as they go, rather than buffering whole
+// messages, use this so that they don't hold on to an unbounded amount of
+// input. Once more than the limit has been supplied, the input is let go of,
+// and only the input that the parser hasn't consumed can be returned. The
+// input that was consumed is lost, along with the message it belonged to.
+type RetainedInput struct {
+	limit    int64
+	input    memview.MemView
+	overflow bool
+}
+
+// Returns a RetainedInput that holds on to at most limit bytes. If limit is
+// not positive, DefaultMaxRetainedInput_bytes is used.
+func NewRetainedInput(limit int64) RetainedInput {
+	if limit <= 0 {
+		limit = DefaultMaxRetainedInput_bytes
+	}
+	return RetainedInput{limit: limit}
+}
+
+// Records input supplied to the parser.
+func (r *RetainedInput) Append(input memview.MemView) {
+	if r.overflow {
+		return
+	}
+	r.input.Append(input)
+	if r.input.Len() > r.limit {
+		r.input = memview.MemView{}
+		r.overflow = true
+	}
+}
+
+// Returns the input to return as unused when the parser fails: all of the
+// input, if it has been held on to, and otherwise the given input that the
+// parser hasn't consumed.
+func (r *RetainedInput) Unused(unconsumed memview.MemView) memview.MemView {
+	if r.overflow {
+		return unconsumed
+	}
+	return r.input
+}
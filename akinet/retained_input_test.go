@@ -0,0 +1,23 @@
+package akinet
+
+import (
+	"testing"
+
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+func TestRetainedInput(t *testing.T) {
+	r := NewRetainedInput(10)
+	r.Append(memview.New([]byte("hello ")))
+	r.Append(memview.New([]byte("wor")))
+	if unused := r.Unused(memview.New([]byte("or"))); unused.String() != "hello wor" {
+		t.Errorf("expected all input to be retained, got %q", unused.String())
+	}
+
+	// Once the limit is exceeded, only the unconsumed input is returned.
+	r.Append(memview.New([]byte("ld")))
+	r.Append(memview.New([]byte("!")))
+	if unused := r.Unused(memview.New([]byte("d!"))); unused.String() != "d!" {
+		t.Errorf("expected only the unconsumed input, got %q", unused.String())
+	}
+}
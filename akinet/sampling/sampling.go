@@ -0,0 +1,319 @@
+// Package sampling deterministically samples parsed network traffic, keeping
+// the halves of each exchange, and the metadata of each connection, together.
+package sampling
+
+import (
+	"bytes"
+	"container/list"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/OneOfOne/xxhash"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/daemon"
+)
+
+const (
+	// The name under which traffic that matches no stratum is counted.
+	DefaultStratum = "default"
+
+	// The default for Options.MaxPending.
+	DefaultMaxPending = 10000
+)
+
+// A subset of traffic that is sampled at its own rate; for example, errors
+// that should always be kept.
+type Stratum struct {
+	// Identifies the stratum in Counts. Must not be DefaultStratum.
+	Name string
+
+	// Returns true if the traffic belongs to the stratum.
+	Matches func(akinet.ParsedNetworkTraffic) bool
+
+	// The fraction of matching traffic to keep, in [0,1].
+	Rate float64
+}
+
+type Options struct {
+	// The fraction of traffic to keep, in [0,1], for traffic that matches no
+	// stratum.
+	Rate float64
+
+	// Traffic is sampled at the rate of the first stratum that it matches.
+	Strata []Stratum
+
+	// When there are strata, the first half of each exchange is remembered
+	// until the second half arrives, so that both halves get the same decision
+	// even if only one matches a stratum. This bounds the number of exchanges
+	// remembered. Defaults to DefaultMaxPending.
+	MaxPending int
+}
+
+// The amount of traffic that was kept and dropped.
+type Counts struct {
+	In  int
+	Out int
+}
+
+// Samples traffic by hashing its stream key, which is made of the StreamID and
+// Seq (or the protocol's equivalent), so that the request and response of an
+// exchange are sampled together. TCP and TLS metadata are sampled by their
+// ConnectionID, so that all of a connection's metadata is sampled together.
+// Any other traffic is sampled by its endpoints.
+//
+// Because the decision for each key is a threshold on its hash, raising the
+// rate keeps everything that was kept at the lower rate.
+type Sampler struct {
+	strata     []Stratum
+	maxPending int
+
+	mu sync.Mutex
+
+	rate float64 // protected by mu
+
+	// The first halves of exchanges, by stream key, and in the order in which
+	// they were seen. Only used if there are strata. Protected by mu.
+	pending map[string]*list.Element
+	order   *list.List
+
+	counts map[string]*Counts // protected by mu
+}
+
+// The first half of an exchange.
+type pendingHalf struct {
+	key     string
+	stratum string
+	kept    bool
+
+	// Set if the first half was dropped.
+	traffic akinet.ParsedNetworkTraffic
+}
+
+type streamKeyer interface {
+	GetStreamKey() string
+}
+
+func NewSampler(opts Options) *Sampler {
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = DefaultMaxPending
+	}
+	counts := map[string]*Counts{DefaultStratum: {}}
+	strata := make([]Stratum, len(opts.Strata))
+	for i, stratum := range opts.Strata {
+		stratum.Rate = clampRate(stratum.Rate)
+		strata[i] = stratum
+		counts[stratum.Name] = &Counts{}
+	}
+	return &Sampler{
+		strata:     strata,
+		maxPending: opts.MaxPending,
+		rate:       clampRate(opts.Rate),
+		pending:    map[string]*list.Element{},
+		order:      list.New(),
+		counts:     counts,
+	}
+}
+
+// Reads traffic from in until it is closed, and sends the traffic that is
+// sampled in to out. Closes out when done.
+func (s *Sampler) Run(in <-chan akinet.ParsedNetworkTraffic, out chan<- akinet.ParsedNetworkTraffic) {
+	defer close(out)
+	for t := range in {
+		for _, kept := range s.Add(t) {
+			out <- kept
+		}
+	}
+	s.Flush()
+}
+
+// Samples the traffic. Returns the traffic to keep, which is usually either
+// nothing or t. If t completes an exchange whose first half was dropped, but
+// t is kept because of its stratum, the first half is returned before t.
+func (s *Sampler) Add(t akinet.ParsedNetworkTraffic) []akinet.ParsedNetworkTraffic {
+	key, isExchange := sampleKey(t)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stratum, rate := s.stratum(t)
+	kept := keep(key, rate)
+
+	if !isExchange || len(s.strata) == 0 {
+		s.count(stratum, kept, 1)
+		if kept {
+			return []akinet.ParsedNetworkTraffic{t}
+		}
+		return nil
+	}
+
+	e, ok := s.pending[key]
+	if !ok {
+		// The first half. Remember it, and if it's dropped, hold on to it in case
+		// the second half is kept.
+		if s.order.Len() >= s.maxPending {
+			s.drop(s.order.Front())
+		}
+		half := &pendingHalf{key: key, stratum: stratum, kept: kept}
+		if kept {
+			s.count(stratum, true, 1)
+		} else {
+			half.traffic = t
+		}
+		s.pending[key] = s.order.PushBack(half)
+		if kept {
+			return []akinet.ParsedNetworkTraffic{t}
+		}
+		return nil
+	}
+
+	// The second half. Keep both halves if either is kept.
+	first := e.Value.(*pendingHalf)
+	delete(s.pending, key)
+	s.order.Remove(e)
+	switch {
+	case first.kept:
+		s.count(stratum, true, 1)
+		return []akinet.ParsedNetworkTraffic{t}
+	case kept:
+		s.count(stratum, true, 2)
+		return []akinet.ParsedNetworkTraffic{first.traffic, t}
+	default:
+		s.count(first.stratum, false, 1)
+		s.count(stratum, false, 1)
+		return nil
+	}
+}
+
+// Forgets the first halves of all exchanges. Those that were held because
+// they were dropped are counted as sampled out.
+func (s *Sampler) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.order.Len() > 0 {
+		s.drop(s.order.Front())
+	}
+}
+
+// Changes the sampling rate for traffic that matches no stratum.
+func (s *Sampler) SetRate(rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rate = clampRate(rate)
+}
+
+func (s *Sampler) Rate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate
+}
+
+// Sets the sampling rate from the logging options of a trace.
+func (s *Sampler) ApplyLoggingOptions(opts daemon.LoggingOptions) {
+	s.SetRate(float64(opts.SamplingRate))
+}
+
+// Returns the amount of traffic kept and dropped, by the name of the stratum
+// that decided it, or DefaultStratum.
+func (s *Sampler) Counts() map[string]Counts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]Counts, len(s.counts))
+	for name, c := range s.counts {
+		result[name] = *c
+	}
+	return result
+}
+
+// Returns the name and rate of the stratum that the traffic belongs to. Must
+// hold mu when calling stratum.
+func (s *Sampler) stratum(t akinet.ParsedNetworkTraffic) (string, float64) {
+	for _, stratum := range s.strata {
+		if stratum.Matches != nil && stratum.Matches(t) {
+			return stratum.Name, stratum.Rate
+		}
+	}
+	return DefaultStratum, s.rate
+}
+
+// Must hold mu when calling count.
+func (s *Sampler) count(stratum string, kept bool, n int) {
+	if kept {
+		s.counts[stratum].In += n
+	} else {
+		s.counts[stratum].Out += n
+	}
+}
+
+// Forgets the first half of an exchange. Must hold mu when calling drop.
+func (s *Sampler) drop(e *list.Element) {
+	half := e.Value.(*pendingHalf)
+	if !half.kept {
+		s.count(half.stratum, false, 1)
+	}
+	delete(s.pending, half.key)
+	s.order.Remove(e)
+}
+
+// Returns the key by which the traffic is sampled, and whether the traffic is
+// half of an exchange.
+func sampleKey(t akinet.ParsedNetworkTraffic) (string, bool) {
+	switch c := t.Content.(type) {
+	case akinet.TCPPacketMetadata:
+		return connectionKey(c.ConnectionID), false
+	case akinet.TCPConnectionMetadata:
+		return connectionKey(c.ConnectionID), false
+	case akinet.TLSClientHello:
+		return connectionKey(c.ConnectionID), false
+	case akinet.TLSServerHello:
+		return connectionKey(c.ConnectionID), false
+	case akinet.TLSAlert:
+		return connectionKey(c.ConnectionID), false
+	case akinet.TLSEncryptionStarted:
+		return connectionKey(c.ConnectionID), false
+	case akinet.TLSHandshakeMetadata:
+		return connectionKey(c.ConnectionID), false
+	case streamKeyer:
+		return c.GetStreamKey(), true
+	}
+
+	// Order the endpoints so that both directions get the same key.
+	a := net.JoinHostPort(t.SrcIP.String(), strconv.Itoa(t.SrcPort))
+	b := net.JoinHostPort(t.DstIP.String(), strconv.Itoa(t.DstPort))
+	if bytes.Compare([]byte(a), []byte(b)) > 0 {
+		a, b = b, a
+	}
+	return a + "-" + b, false
+}
+
+func connectionKey(id akid.ConnectionID) string {
+	return akid.String(id)
+}
+
+// Whether to keep traffic with the given key at the given rate.
+func keep(key string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	return float64(xxhash.ChecksumString64(key))/math.Exp2(64) < rate
+}
+
+func clampRate(rate float64) float64 {
+	switch {
+	case math.IsNaN(rate) || rate < 0:
+		return 0
+	case rate > 1:
+		return 1
+	default:
+		return rate
+	}
+}
+
+// Matches HTTP responses with a 4xx or 5xx status, for use in a Stratum.
+func HTTPErrorResponse(t akinet.ParsedNetworkTraffic) bool {
+	resp, ok := t.Content.(akinet.HTTPResponse)
+	return ok && resp.StatusCode >= 400
+}
@@ -0,0 +1,156 @@
+package sampling
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/daemon"
+)
+
+func request(streamID uuid.UUID, seq int, path string) akinet.ParsedNetworkTraffic {
+	return akinet.ParsedNetworkTraffic{Content: akinet.HTTPRequest{StreamID: streamID, Seq: seq, Method: path}}
+}
+
+func response(streamID uuid.UUID, seq int, status int) akinet.ParsedNetworkTraffic {
+	return akinet.ParsedNetworkTraffic{Content: akinet.HTTPResponse{StreamID: streamID, Seq: seq, StatusCode: status}}
+}
+
+func run(s *Sampler, traffic ...akinet.ParsedNetworkTraffic) []akinet.ParsedNetworkTraffic {
+	in := make(chan akinet.ParsedNetworkTraffic, len(traffic))
+	for _, t := range traffic {
+		in <- t
+	}
+	close(in)
+
+	out := make(chan akinet.ParsedNetworkTraffic)
+	go s.Run(in, out)
+
+	var results []akinet.ParsedNetworkTraffic
+	for t := range out {
+		results = append(results, t)
+	}
+	return results
+}
+
+// Returns the stream keys of the kept requests and responses.
+func keptKeys(traffic []akinet.ParsedNetworkTraffic) (requests, responses map[string]bool) {
+	requests, responses = map[string]bool{}, map[string]bool{}
+	for _, t := range traffic {
+		switch c := t.Content.(type) {
+		case akinet.HTTPRequest:
+			requests[c.GetStreamKey()] = true
+		case akinet.HTTPResponse:
+			responses[c.GetStreamKey()] = true
+		}
+	}
+	return requests, responses
+}
+
+func TestSampler(t *testing.T) {
+	streamID := uuid.New()
+	var traffic []akinet.ParsedNetworkTraffic
+	for i := 0; i < 1000; i++ {
+		traffic = append(traffic, request(streamID, i, "GET"), response(streamID, i, 200))
+	}
+
+	s := NewSampler(Options{Rate: 0.3})
+	requests, responses := keptKeys(run(s, traffic...))
+	if diff := cmp.Diff(requests, responses); diff != "" {
+		t.Errorf("requests and responses sampled differently:\n%s", diff)
+	}
+	if n := len(requests); n < 250 || n > 350 {
+		t.Errorf("expected about 300 exchanges to be kept, got %d", n)
+	}
+	expected := map[string]Counts{DefaultStratum: {In: 2 * len(requests), Out: 2 * (1000 - len(requests))}}
+	if diff := cmp.Diff(expected, s.Counts()); diff != "" {
+		t.Errorf("found unexpected diff in counts:\n%s", diff)
+	}
+
+	// Raising the rate keeps everything kept at the lower rate.
+	s = NewSampler(Options{Rate: 0.3})
+	s.ApplyLoggingOptions(daemon.LoggingOptions{SamplingRate: 0.6})
+	if s.Rate() < 0.59 || s.Rate() > 0.61 {
+		t.Errorf("expected rate 0.6, got %v", s.Rate())
+	}
+	moreRequests, _ := keptKeys(run(s, traffic...))
+	for key := range requests {
+		if !moreRequests[key] {
+			t.Errorf("%s was kept at rate 0.3 but not at rate 0.6", key)
+		}
+	}
+
+	s.SetRate(2)
+	if s.Rate() != 1 {
+		t.Errorf("expected rate to be clamped to 1, got %v", s.Rate())
+	}
+}
+
+func TestSamplerConnectionMetadata(t *testing.T) {
+	s := NewSampler(Options{Rate: 0.5})
+	for i := 0; i < 100; i++ {
+		id := akid.GenerateConnectionID()
+		kept := s.Add(akinet.ParsedNetworkTraffic{Content: akinet.TCPPacketMetadata{ConnectionID: id, SYN: true}})
+		for _, c := range []akinet.ParsedNetworkContent{
+			akinet.TCPPacketMetadata{ConnectionID: id, ACK: true},
+			akinet.TLSClientHello{ConnectionID: id},
+			akinet.TCPConnectionMetadata{ConnectionID: id},
+		} {
+			if len(s.Add(akinet.ParsedNetworkTraffic{Content: c})) != len(kept) {
+				t.Fatalf("metadata of connection %s sampled differently", akid.String(id))
+			}
+		}
+	}
+}
+
+func TestSamplerStrata(t *testing.T) {
+	streamID := uuid.New()
+	s := NewSampler(Options{
+		Rate: 0,
+		Strata: []Stratum{
+			{Name: "errors", Matches: HTTPErrorResponse, Rate: 1},
+			{
+				Name: "rare",
+				Matches: func(t akinet.ParsedNetworkTraffic) bool {
+					req, ok := t.Content.(akinet.HTTPRequest)
+					return ok && req.Method == "DELETE"
+				},
+				Rate: 1,
+			},
+		},
+	})
+
+	kept := run(s,
+		request(streamID, 1, "GET"),
+		request(streamID, 2, "GET"),
+		request(streamID, 3, "DELETE"),
+		response(streamID, 1, 200),
+		// The dropped request is released along with the error.
+		response(streamID, 2, 500),
+		response(streamID, 3, 204),
+		// Never answered.
+		request(streamID, 4, "GET"),
+	)
+
+	expected := []akinet.ParsedNetworkTraffic{
+		request(streamID, 3, "DELETE"),
+		request(streamID, 2, "GET"),
+		response(streamID, 2, 500),
+		response(streamID, 3, 204),
+	}
+	if diff := cmp.Diff(expected, kept); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+
+	expectedCounts := map[string]Counts{
+		DefaultStratum: {In: 1, Out: 3},
+		"errors":       {In: 2},
+		"rare":         {In: 1},
+	}
+	if diff := cmp.Diff(expectedCounts, s.Counts()); diff != "" {
+		t.Errorf("found unexpected diff in counts:\n%s", diff)
+	}
+}
@@ -71,7 +71,9 @@ type TCPParser interface {
 	// result or an error is returned. If result != nil, unused is the portion of
 	// all the input ever supplied to Parse that were not used to generate the
 	// result (e.g. trailing bytes after HTTP resonse body). If err != nil, unused
-	// equals to everything supplied.
+	// equals to everything supplied, unless the parser has let go of input that
+	// it skipped over without buffering (see RetainedInput); in that case,
+	// unused is the input that the parser hasn't consumed.
 	// If no more data is forthcoming, the caller should specify isEnd=true to let
 	// the parser know. In this case, the parser implementation must return a
 	// non-nil result or an error.
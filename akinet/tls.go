@@ -1,8 +1,131 @@
 package akinet
 
+import "strconv"
+
 type TLSVersion string
 
 const (
 	TLS_v1_2 TLSVersion = "1.2"
 	TLS_v1_3 TLSVersion = "1.3"
 )
+
+// The level of a TLS alert.
+type TLSAlertLevel uint8
+
+const (
+	TLSAlertWarning TLSAlertLevel = 1
+	TLSAlertFatal   TLSAlertLevel = 2
+)
+
+func (l TLSAlertLevel) String() string {
+	switch l {
+	case TLSAlertWarning:
+		return "warning"
+	case TLSAlertFatal:
+		return "fatal"
+	}
+	return "level" + strconv.Itoa(int(l))
+}
+
+// The description of a TLS alert, as registered with IANA.
+type TLSAlertDescription uint8
+
+const (
+	TLSAlertCloseNotify                  TLSAlertDescription = 0
+	TLSAlertUnexpectedMessage            TLSAlertDescription = 10
+	TLSAlertBadRecordMAC                 TLSAlertDescription = 20
+	TLSAlertDecryptionFailed             TLSAlertDescription = 21
+	TLSAlertRecordOverflow               TLSAlertDescription = 22
+	TLSAlertDecompressionFailure         TLSAlertDescription = 30
+	TLSAlertHandshakeFailure             TLSAlertDescription = 40
+	TLSAlertNoCertificate                TLSAlertDescription = 41
+	TLSAlertBadCertificate               TLSAlertDescription = 42
+	TLSAlertUnsupportedCertificate       TLSAlertDescription = 43
+	TLSAlertCertificateRevoked           TLSAlertDescription = 44
+	TLSAlertCertificateExpired           TLSAlertDescription = 45
+	TLSAlertCertificateUnknown           TLSAlertDescription = 46
+	TLSAlertIllegalParameter             TLSAlertDescription = 47
+	TLSAlertUnknownCA                    TLSAlertDescription = 48
+	TLSAlertAccessDenied                 TLSAlertDescription = 49
+	TLSAlertDecodeError                  TLSAlertDescription = 50
+	TLSAlertDecryptError                 TLSAlertDescription = 51
+	TLSAlertExportRestriction            TLSAlertDescription = 60
+	TLSAlertProtocolVersion              TLSAlertDescription = 70
+	TLSAlertInsufficientSecurity         TLSAlertDescription = 71
+	TLSAlertInternalError                TLSAlertDescription = 80
+	TLSAlertInappropriateFallback        TLSAlertDescription = 86
+	TLSAlertUserCanceled                 TLSAlertDescription = 90
+	TLSAlertNoRenegotiation              TLSAlertDescription = 100
+	TLSAlertMissingExtension             TLSAlertDescription = 109
+	TLSAlertUnsupportedExtension         TLSAlertDescription = 110
+	TLSAlertCertificateUnobtainable      TLSAlertDescription = 111
+	TLSAlertUnrecognizedName             TLSAlertDescription = 112
+	TLSAlertBadCertificateStatusResponse TLSAlertDescription = 113
+	TLSAlertBadCertificateHashValue      TLSAlertDescription = 114
+	TLSAlertUnknownPSKIdentity           TLSAlertDescription = 115
+	TLSAlertCertificateRequired          TLSAlertDescription = 116
+	TLSAlertNoApplicationProtocol        TLSAlertDescription = 120
+)
+
+var tlsAlertDescriptionNames = map[TLSAlertDescription]string{
+	TLSAlertCloseNotify:                  "close_notify",
+	TLSAlertUnexpectedMessage:            "unexpected_message",
+	TLSAlertBadRecordMAC:                 "bad_record_mac",
+	TLSAlertDecryptionFailed:             "decryption_failed",
+	TLSAlertRecordOverflow:               "record_overflow",
+	TLSAlertDecompressionFailure:         "decompression_failure",
+	TLSAlertHandshakeFailure:             "handshake_failure",
+	TLSAlertNoCertificate:                "no_certificate",
+	TLSAlertBadCertificate:               "bad_certificate",
+	TLSAlertUnsupportedCertificate:       "unsupported_certificate",
+	TLSAlertCertificateRevoked:           "certificate_revoked",
+	TLSAlertCertificateExpired:           "certificate_expired",
+	TLSAlertCertificateUnknown:           "certificate_unknown",
+	TLSAlertIllegalParameter:             "illegal_parameter",
+	TLSAlertUnknownCA:                    "unknown_ca",
+	TLSAlertAccessDenied:                 "access_denied",
+	TLSAlertDecodeError:                  "decode_error",
+	TLSAlertDecryptError:                 "decrypt_error",
+	TLSAlertExportRestriction:            "export_restriction",
+	TLSAlertProtocolVersion:              "protocol_version",
+	TLSAlertInsufficientSecurity:         "insufficient_security",
+	TLSAlertInternalError:                "internal_error",
+	TLSAlertInappropriateFallback:        "inappropriate_fallback",
+	TLSAlertUserCanceled:                 "user_canceled",
+	TLSAlertNoRenegotiation:              "no_renegotiation",
+	TLSAlertMissingExtension:             "missing_extension",
+	TLSAlertUnsupportedExtension:         "unsupported_extension",
+	TLSAlertCertificateUnobtainable:      "certificate_unobtainable",
+	TLSAlertUnrecognizedName:             "unrecognized_name",
+	TLSAlertBadCertificateStatusResponse: "bad_certificate_status_response",
+	TLSAlertBadCertificateHashValue:      "bad_certificate_hash_value",
+	TLSAlertUnknownPSKIdentity:           "unknown_psk_identity",
+	TLSAlertCertificateRequired:          "certificate_required",
+	TLSAlertNoApplicationProtocol:        "no_application_protocol",
+}
+
+func (d TLSAlertDescription) String() string {
+	if name, ok := tlsAlertDescriptionNames[d]; ok {
+		return name
+	}
+	return "alert" + strconv.Itoa(int(d))
+}
+
+// How a TLS handshake ended, as far as can be told from the traffic observed.
+type TLSHandshakeOutcome string
+
+const (
+	// Neither side has finished the handshake, and the connection is still
+	// open, or the handshake was not seen.
+	TLSHandshakeUnknown TLSHandshakeOutcome = "unknown"
+
+	// Both sides finished the handshake and started sending encrypted records.
+	TLSHandshakeSuccess TLSHandshakeOutcome = "success"
+
+	// One side sent an alert before the handshake finished.
+	TLSHandshakeAlert TLSHandshakeOutcome = "alert"
+
+	// The connection was closed before the handshake finished, without an
+	// alert.
+	TLSHandshakeAborted TLSHandshakeOutcome = "aborted"
+)
@@ -0,0 +1,225 @@
+package tls
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// An AEAD cipher suite that we can decrypt.
+type cipherSuite struct {
+	keyLen int
+
+	// The hash used by the TLS 1.2 PRF or the TLS 1.3 key schedule.
+	hash crypto.Hash
+
+	// Whether the suite uses ChaCha20-Poly1305, rather than AES-GCM. In TLS 1.2,
+	// this determines how the nonce is formed.
+	chacha bool
+}
+
+// Returns the AEAD for the suite with the given key.
+func (suite *cipherSuite) aead(key []byte) (cipher.AEAD, error) {
+	if suite.chacha {
+		return chacha20poly1305.New(key)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var (
+	aes128GCMSHA256        = &cipherSuite{keyLen: 16, hash: crypto.SHA256}
+	aes256GCMSHA384        = &cipherSuite{keyLen: 32, hash: crypto.SHA384}
+	chacha20Poly1305SHA256 = &cipherSuite{keyLen: chacha20poly1305.KeySize, hash: crypto.SHA256, chacha: true}
+)
+
+// The cipher suites that we can decrypt, by ID.
+var cipherSuites = map[uint16]*cipherSuite{
+	// TLS 1.3.
+	0x13_01: aes128GCMSHA256,
+	0x13_02: aes256GCMSHA384,
+	0x13_03: chacha20Poly1305SHA256,
+
+	// TLS 1.2.
+	0x00_9c: aes128GCMSHA256,        // TLS_RSA_WITH_AES_128_GCM_SHA256
+	0x00_9d: aes256GCMSHA384,        // TLS_RSA_WITH_AES_256_GCM_SHA384
+	0x00_9e: aes128GCMSHA256,        // TLS_DHE_RSA_WITH_AES_128_GCM_SHA256
+	0x00_9f: aes256GCMSHA384,        // TLS_DHE_RSA_WITH_AES_256_GCM_SHA384
+	0xc0_2b: aes128GCMSHA256,        // TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+	0xc0_2c: aes256GCMSHA384,        // TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384
+	0xc0_2f: aes128GCMSHA256,        // TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	0xc0_30: aes256GCMSHA384,        // TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384
+	0xcc_a8: chacha20Poly1305SHA256, // TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256
+	0xcc_a9: chacha20Poly1305SHA256, // TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256
+	0xcc_aa: chacha20Poly1305SHA256, // TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256
+}
+
+const (
+	// The length of the explicit part of a TLS 1.2 AES-GCM nonce, which is sent
+	// at the start of each record.
+	explicitNonceLength_bytes = 8
+
+	// The length of the implicit part of a TLS 1.2 AES-GCM nonce, which is
+	// derived from the master secret.
+	gcmImplicitNonceLength_bytes = 4
+
+	// The length of the IV for ChaCha20-Poly1305 and for TLS 1.3.
+	ivLength_bytes = 12
+)
+
+// Decrypts the records sent by one side of a connection.
+type recordDecrypter struct {
+	aead cipher.AEAD
+	iv   []byte
+
+	tls13 bool
+
+	// The sequence number of the next record.
+	seq uint64
+}
+
+// Returns a decrypter for TLS 1.3 records protected with the given traffic
+// secret.
+func newTLS13Decrypter(suite *cipherSuite, trafficSecret []byte) (*recordDecrypter, error) {
+	key := hkdfExpandLabel(suite.hash, trafficSecret, "key", suite.keyLen)
+	aead, err := suite.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	return &recordDecrypter{
+		aead:  aead,
+		iv:    hkdfExpandLabel(suite.hash, trafficSecret, "iv", ivLength_bytes),
+		tls13: true,
+	}, nil
+}
+
+// Returns decrypters for the TLS 1.2 records sent by the client and by the
+// server.
+func newTLS12Decrypters(suite *cipherSuite, masterSecret, clientRandom, serverRandom []byte) (client, server *recordDecrypter, err error) {
+	ivLen := gcmImplicitNonceLength_bytes
+	if suite.chacha {
+		ivLen = ivLength_bytes
+	}
+
+	// AEAD suites have no MAC keys, so the key block is just the two write keys
+	// followed by the two IVs.
+	seed := append(append([]byte{}, serverRandom...), clientRandom...)
+	keyBlock := prf12(suite.hash, masterSecret, "key expansion", seed, 2*suite.keyLen+2*ivLen)
+	clientKey, keyBlock := keyBlock[:suite.keyLen], keyBlock[suite.keyLen:]
+	serverKey, keyBlock := keyBlock[:suite.keyLen], keyBlock[suite.keyLen:]
+	clientIV, serverIV := keyBlock[:ivLen], keyBlock[ivLen:]
+
+	clientAEAD, err := suite.aead(clientKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverAEAD, err := suite.aead(serverKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &recordDecrypter{aead: clientAEAD, iv: clientIV}, &recordDecrypter{aead: serverAEAD, iv: serverIV}, nil
+}
+
+// Decrypts the given record, including its header. Returns the type of the
+// content, which for TLS 1.3 is the inner content type, and the plaintext.
+func (d *recordDecrypter) decrypt(record []byte) (recordType, []byte, error) {
+	header, payload := record[:tlsRecordHeaderLength_bytes], record[tlsRecordHeaderLength_bytes:]
+	typ := recordType(header[0])
+
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], d.seq)
+
+	var nonce, additionalData []byte
+	if d.tls13 {
+		nonce = xorNonce(d.iv, seq)
+		additionalData = header
+	} else {
+		if len(d.iv) == gcmImplicitNonceLength_bytes {
+			// AES-GCM records start with the explicit part of the nonce.
+			if len(payload) < explicitNonceLength_bytes {
+				return 0, nil, errors.New("TLS record too short for its explicit nonce")
+			}
+			nonce = append(append([]byte{}, d.iv...), payload[:explicitNonceLength_bytes]...)
+			payload = payload[explicitNonceLength_bytes:]
+		} else {
+			nonce = xorNonce(d.iv, seq)
+		}
+
+		if len(payload) < d.aead.Overhead() {
+			return 0, nil, errors.New("TLS record too short for its authentication tag")
+		}
+		plaintextLen := len(payload) - d.aead.Overhead()
+		additionalData = append(seq[:], header[0], header[1], header[2], byte(plaintextLen>>8), byte(plaintextLen))
+	}
+
+	plaintext, err := d.aead.Open(nil, nonce, payload, additionalData)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to decrypt TLS record")
+	}
+	d.seq++
+
+	if d.tls13 {
+		// The plaintext is followed by the real content type and any padding.
+		i := len(plaintext) - 1
+		for i >= 0 && plaintext[i] == 0 {
+			i--
+		}
+		if i < 0 {
+			return 0, nil, errors.New("decrypted TLS 1.3 record has no content type")
+		}
+		typ, plaintext = recordType(plaintext[i]), plaintext[:i]
+	}
+	return typ, plaintext, nil
+}
+
+// XORs the big-endian sequence number into the end of a copy of the IV.
+func xorNonce(iv []byte, seq [8]byte) []byte {
+	nonce := append([]byte{}, iv...)
+	for i, b := range seq {
+		nonce[len(nonce)-len(seq)+i] ^= b
+	}
+	return nonce
+}
+
+// The TLS 1.2 pseudorandom function, P_hash from RFC 5246 section 5.
+func prf12(hash crypto.Hash, secret []byte, label string, seed []byte, length int) []byte {
+	labelAndSeed := append([]byte(label), seed...)
+	mac := hmac.New(hash.New, secret)
+
+	result := make([]byte, 0, length+hash.Size())
+	a := labelAndSeed
+	for len(result) < length {
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(labelAndSeed)
+		result = mac.Sum(result)
+	}
+	return result[:length]
+}
+
+// HKDF-Expand-Label from RFC 8446 section 7.1, with an empty context.
+func hkdfExpandLabel(hash crypto.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := []byte{byte(length >> 8), byte(length), byte(len(label))}
+	info = append(info, label...)
+	info = append(info, 0)
+
+	result := make([]byte, length)
+	hkdf.Expand(hash.New, secret, info).Read(result)
+	return result
+}
@@ -19,6 +19,10 @@ func newTLSClientHelloParser(bidiID akinet.TCPBidiID) *tlsClientHelloParser {
 type tlsClientHelloParser struct {
 	connectionID akid.ConnectionID
 	allInput     memview.MemView
+
+	// The client random, once the Client Hello has been parsed. Used to look up
+	// the connection's secrets when decrypting.
+	random []byte
 }
 
 var _ akinet.TCPParser = (*tlsClientHelloParser)(nil)
@@ -68,11 +72,21 @@ func (parser *tlsClientHelloParser) parse(input memview.MemView, isEnd bool) (re
 	buf := parser.allInput.SubView(tlsRecordHeaderLength_bytes, handshakeMsgEndPos)
 	reader := buf.CreateReader()
 
-	// Seek past some headers.
-	_, err = reader.Seek(handshakeHeaderLength_bytes+clientVersionLength_bytes+clientRandomLength_bytes, io.SeekCurrent)
+	// Seek past the handshake header.
+	_, err = reader.Seek(handshakeHeaderLength_bytes, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Read the client version and the client random.
+	legacyVersion, err := reader.ReadUint16()
 	if err != nil {
 		return nil, 0, err
 	}
+	random := make([]byte, clientRandomLength_bytes)
+	if n, err := reader.Read(random); n != clientRandomLength_bytes || err != nil {
+		return nil, 0, errors.New("malformed TLS message")
+	}
 
 	// Now at the session ID, which is a variable-length vector. Seek past this.
 	// The first byte indicates the vector's length in bytes.
@@ -81,9 +95,9 @@ func (parser *tlsClientHelloParser) parse(input memview.MemView, isEnd bool) (re
 		return nil, 0, err
 	}
 
-	// Now at the cipher suites. Seek past this. The first two bytes gives the
-	// length of this header in bytes.
-	err = reader.ReadUint16AndSeek()
+	// Now at the cipher suites. The first two bytes gives the length of this
+	// header in bytes.
+	cipherSuites, err := readUint16List(reader)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -104,6 +118,11 @@ func (parser *tlsClientHelloParser) parse(input memview.MemView, isEnd bool) (re
 
 	dnsHostname := (*string)(nil)
 	protocols := []string{}
+	hello := akinet.TLSClientHello{
+		ConnectionID:  parser.connectionID,
+		LegacyVersion: legacyVersion,
+		CipherSuites:  cipherSuites,
+	}
 
 	for {
 		// The first two bytes of the extension give the extension type.
@@ -118,6 +137,7 @@ func (parser *tlsClientHelloParser) parse(input memview.MemView, isEnd bool) (re
 			}
 			extensionType = tlsExtensionID(val)
 		}
+		hello.Extensions = append(hello.Extensions, uint16(extensionType))
 
 		// The following two bytes give the extension's content length in bytes.
 		// Isolate the extension in its own reader.
@@ -141,15 +161,29 @@ func (parser *tlsClientHelloParser) parse(input memview.MemView, isEnd bool) (re
 
 		case alpnTLSExtensionID:
 			protocols = parser.parseALPNExtension(extensionReader)
+
+		// The remaining extensions are only used for fingerprinting, so
+		// malformed ones are ignored.
+		case supportedGroupsTLSExtensionID:
+			hello.SupportedGroups, _ = readUint16List(extensionReader)
+
+		case ecPointFormatsTLSExtensionID:
+			hello.ECPointFormats, _ = readUint8List(extensionReader)
+
+		case signatureAlgorithmsTLSExtensionID:
+			hello.SignatureAlgorithms, _ = readUint16List(extensionReader)
+
+		case supportedVersionsTLSExtensionID:
+			hello.SupportedVersions, _ = parser.parseSupportedVersionsExtension(extensionReader)
 		}
 	}
 
-	hello := akinet.TLSClientHello{
-		ConnectionID:       parser.connectionID,
-		Hostname:           dnsHostname,
-		SupportedProtocols: protocols,
-	}
+	hello.Hostname = dnsHostname
+	hello.SupportedProtocols = protocols
+	hello.JA3, hello.JA3Hash = ja3(&hello)
+	hello.JA4 = ja4(&hello)
 
+	parser.random = random
 	return hello, handshakeMsgEndPos, nil
 }
 
@@ -224,3 +258,55 @@ func (*tlsClientHelloParser) parseALPNExtension(reader *memview.MemViewReader) [
 		result = append(result, string(protocol))
 	}
 }
+
+// Extracts the list of versions from a buffer containing the client's TLS
+// Supported Versions extension.
+func (*tlsClientHelloParser) parseSupportedVersionsExtension(reader *memview.MemViewReader) ([]uint16, error) {
+	// The first byte gives the length of the list in bytes. Each version is two
+	// bytes.
+	length, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	reader, err = reader.Truncate(int64(length))
+	if err != nil {
+		return nil, err
+	}
+	return readUint16s(reader)
+}
+
+// Reads a list of uint16 values, preceded by the length of the list in bytes
+// as a uint16. On return, the reader is positioned after the list.
+func readUint16List(reader *memview.MemViewReader) ([]uint16, error) {
+	length, listReader, err := reader.ReadUint16AndTruncate()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := reader.Seek(int64(length), io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	return readUint16s(listReader)
+}
+
+// Reads uint16 values until the end of the reader.
+func readUint16s(reader *memview.MemViewReader) ([]uint16, error) {
+	result := []uint16{}
+	for {
+		val, err := reader.ReadUint16()
+		if err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, val)
+	}
+}
+
+// Reads a list of byte values, preceded by the length of the list as a byte.
+func readUint8List(reader *memview.MemViewReader) ([]uint8, error) {
+	list, err := reader.ReadString_byte()
+	if err != nil {
+		return nil, err
+	}
+	return []uint8(list), nil
+}
@@ -18,7 +18,7 @@ func (*tlsClientParserFactory) Name() string {
 }
 
 func (factory *tlsClientParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
-	decision, discardFront = factory.accepts(input)
+	decision, discardFront = acceptsAfterChangeCipherSpec(input, factory.accepts)
 
 	if decision == akinet.NeedMoreData && isEnd {
 		decision = akinet.Reject
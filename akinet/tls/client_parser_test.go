@@ -0,0 +1,158 @@
+package tls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var testBidiID = akinet.TCPBidiID(uuid.MustParse("7a3e5c1d-9b2f-4e6a-8c4d-1f3b5e7a9c20"))
+
+func uint16s(values ...uint16) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+// Prefixes data with its length as a uint16.
+func vec16(data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	return append(uint16s(uint16(len(body))), body...)
+}
+
+// Prefixes data with its length as a byte.
+func vec8(data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	return append([]byte{byte(len(body))}, body...)
+}
+
+func extension(typ uint16, data ...[]byte) []byte {
+	return append(uint16s(typ), vec16(data...)...)
+}
+
+// Wraps a handshake message body in handshake and record headers.
+func handshakeRecord(msgType byte, body []byte) []byte {
+	msg := append([]byte{msgType, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{0x16, 0x03, 0x01, byte(len(msg) >> 8), byte(len(msg))}, msg...)
+}
+
+func TestClientHelloFingerprints(t *testing.T) {
+	hello := handshakeRecord(0x01, bytes.Join([][]byte{
+		uint16s(0x0303),
+		make([]byte, clientRandomLength_bytes),
+		vec8(make([]byte, 32)),
+		vec16(uint16s(0x0a0a, 0x1301, 0x1302, 0xc02b)),
+		vec8([]byte{0}),
+		vec16(
+			extension(0x1a1a),
+			extension(0x0000, vec16([]byte{0}, vec16([]byte("example.com")))),
+			extension(0x000a, vec16(uint16s(0x2a2a, 0x001d, 0x0017))),
+			extension(0x000b, vec8([]byte{0})),
+			extension(0x000d, vec16(uint16s(0x0403, 0x0804))),
+			extension(0x0010, vec16(vec8([]byte("h2")), vec8([]byte("http/1.1")))),
+			extension(0x002b, vec8(uint16s(0x3a3a, 0x0304, 0x0303))),
+		),
+	}, nil))
+
+	factory := NewTLSClientParserFactory()
+	if decision, _ := factory.Accepts(memview.New(hello), true); decision != akinet.Accept {
+		t.Fatalf("expected factory to accept, got %s", decision)
+	}
+	result, unused, err := factory.CreateParser(testBidiID, 0, 0).Parse(memview.New(hello), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if unused.Len() != 0 {
+		t.Errorf("expected all input to be consumed, %d bytes left", unused.Len())
+	}
+
+	hostname := "example.com"
+	expected := akinet.TLSClientHello{
+		ConnectionID:        akid.NewConnectionID(uuid.UUID(testBidiID)),
+		Hostname:            &hostname,
+		SupportedProtocols:  []string{"h2", "http/1.1"},
+		LegacyVersion:       0x0303,
+		CipherSuites:        []uint16{0x0a0a, 0x1301, 0x1302, 0xc02b},
+		Extensions:          []uint16{0x1a1a, 0x0000, 0x000a, 0x000b, 0x000d, 0x0010, 0x002b},
+		SupportedGroups:     []uint16{0x2a2a, 0x001d, 0x0017},
+		ECPointFormats:      []uint8{0},
+		SignatureAlgorithms: []uint16{0x0403, 0x0804},
+		SupportedVersions:   []uint16{0x3a3a, 0x0304, 0x0303},
+		JA3:                 "771,4865-4866-49195,0-10-11-13-16-43,29-23,0",
+		JA3Hash:             "11138d9933242c3a03b6aad35a296476",
+		JA4:                 "t13d0306h2_5559582ccdc4_fb71836bce29",
+	}
+	connectionIDs := cmp.Comparer(func(a, b akid.ConnectionID) bool { return a == b })
+	if diff := cmp.Diff(expected, result, cmpopts.EquateEmpty(), connectionIDs); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+}
+
+// Checks JA4 against the example in the JA4 specification.
+func TestJA4(t *testing.T) {
+	hostname := "example.com"
+	hello := akinet.TLSClientHello{
+		Hostname:           &hostname,
+		SupportedProtocols: []string{"h2", "http/1.1"},
+		LegacyVersion:      0x0303,
+		CipherSuites: []uint16{
+			0x8a8a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9,
+			0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+		},
+		Extensions: []uint16{
+			0x9a9a, 0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0023, 0x0010, 0x0005,
+			0x000d, 0x0012, 0x0033, 0x002d, 0x002b, 0x001b, 0x4469, 0x0015, 0xaaaa,
+		},
+		SignatureAlgorithms: []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601},
+		SupportedVersions:   []uint16{0x5a5a, 0x0304, 0x0303},
+	}
+
+	testCases := []struct {
+		name     string
+		modify   func(*akinet.TLSClientHello)
+		expected string
+	}{
+		{
+			name:     "Chrome",
+			modify:   func(*akinet.TLSClientHello) {},
+			expected: "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+		{
+			name: "no SNI or ALPN",
+			modify: func(h *akinet.TLSClientHello) {
+				h.Hostname = nil
+				h.SupportedProtocols = nil
+			},
+			expected: "t13i151600_8daaf6152771_e5627efa2ab1",
+		},
+		{
+			name: "TLS 1.2 without extensions",
+			modify: func(h *akinet.TLSClientHello) {
+				h.Hostname = nil
+				h.SupportedProtocols = nil
+				h.Extensions = nil
+				h.SignatureAlgorithms = nil
+				h.SupportedVersions = nil
+			},
+			expected: "t12i150000_8daaf6152771_000000000000",
+		},
+	}
+
+	for _, c := range testCases {
+		h := hello
+		c.modify(&h)
+		if actual := ja4(&h); actual != c.expected {
+			t.Errorf("[%s] expected %s, got %s", c.name, c.expected, actual)
+		}
+	}
+}
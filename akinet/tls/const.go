@@ -51,14 +51,46 @@ const (
 	serverRandomLength_bytes            = 32
 	serverCiphersuiteLength_bytes       = 2
 	serverCompressionMethodLength_bytes = 1
+
+	// The length of a TLS 1.2 master secret.
+	masterSecretLength_bytes = 48
+
+	// The largest encrypted record allowed by TLS 1.2. TLS 1.3 records are
+	// smaller.
+	maxCiphertextLength_bytes = 1<<14 + 2048
+)
+
+// The server random of a HelloRetryRequest, which is otherwise sent as a
+// Server Hello. This is the SHA-256 hash of "HelloRetryRequest".
+var helloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+type recordType byte
+
+const (
+	changeCipherSpecRecordType recordType = 20
+	alertRecordType            recordType = 21
+	handshakeRecordType        recordType = 22
+	applicationDataRecordType  recordType = 23
+)
+
+type handshakeType byte
+
+const (
+	finishedHandshakeType handshakeType = 20
 )
 
 type tlsExtensionID uint16
 
 const (
-	serverNameTLSExtensionID        tlsExtensionID = 0x00_00
-	alpnTLSExtensionID              tlsExtensionID = 0x00_10
-	supportedVersionsTLSExtensionID tlsExtensionID = 0x00_2b
+	serverNameTLSExtensionID          tlsExtensionID = 0x00_00
+	supportedGroupsTLSExtensionID     tlsExtensionID = 0x00_0a
+	ecPointFormatsTLSExtensionID      tlsExtensionID = 0x00_0b
+	signatureAlgorithmsTLSExtensionID tlsExtensionID = 0x00_0d
+	alpnTLSExtensionID                tlsExtensionID = 0x00_10
+	supportedVersionsTLSExtensionID   tlsExtensionID = 0x00_2b
 )
 
 type sniType byte
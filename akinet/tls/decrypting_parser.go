@@ -0,0 +1,374 @@
+package tls
+
+import (
+	"io"
+
+	"github.com/golang/glog"
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+func newTLSDecryptingParser(factory *tlsDecryptingParserFactory, bidiID akinet.TCPBidiID, seq reassembly.Sequence) *tlsDecryptingParser {
+	return &tlsDecryptingParser{
+		factory: factory,
+		bidiID:  bidiID,
+		seq:     seq,
+	}
+}
+
+// Parses a Client or Server Hello, or decrypts records until the decrypted
+// data yields a result.
+type tlsDecryptingParser struct {
+	factory *tlsDecryptingParserFactory
+	bidiID  akinet.TCPBidiID
+	seq     reassembly.Sequence
+
+	// All of the input to the record decrypter, which is returned as unused if
+	// the input can't be parsed.
+	input memview.MemView
+
+	// The input to the record decrypter that hasn't been consumed yet.
+	allInput memview.MemView
+
+	// Set once we know what the parser is parsing. If neither Hello parser is
+	// set, the parser decrypts records.
+	started     bool
+	clientHello *tlsClientHelloParser
+	serverHello *tlsServerHelloParser
+}
+
+var _ akinet.TCPParser = (*tlsDecryptingParser)(nil)
+
+func (*tlsDecryptingParser) Name() string {
+	return "TLS Decrypting Parser"
+}
+
+func (p *tlsDecryptingParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	if !p.started {
+		p.allInput.Append(input)
+		if !p.start(isEnd) {
+			return nil, memview.MemView{}, nil
+		}
+		input, p.allInput = p.allInput, memview.MemView{}
+	}
+
+	switch {
+	case p.clientHello != nil:
+		result, unused, err = p.clientHello.Parse(input, isEnd)
+		if result != nil {
+			p.factory.addClientHello(p.bidiID, p.seq, p.clientHello.random)
+		}
+		return result, unused, err
+
+	case p.serverHello != nil:
+		result, unused, err = p.serverHello.Parse(input, isEnd)
+		if hello, ok := result.(akinet.TLSServerHello); ok && !hello.HelloRetryRequest {
+			p.factory.addServerHello(p.bidiID, p.seq, p.serverHello.random, &hello)
+		}
+		return result, unused, err
+	}
+
+	p.input.Append(input)
+	p.allInput.Append(input)
+	var numBytesConsumed int64
+	p.factory.withConnection(p.bidiID, func(conn *tlsConnection) {
+		result, numBytesConsumed, err = conn.parse(p.factory, p.bidiID, p.seq, p.allInput, isEnd)
+	})
+	unused = p.allInput.SubView(numBytesConsumed, p.allInput.Len())
+
+	if err != nil {
+		return nil, p.input, err
+	}
+	if result == nil {
+		if isEnd {
+			return nil, p.input, io.ErrUnexpectedEOF
+		}
+		p.allInput = unused
+		return nil, memview.MemView{}, nil
+	}
+	return result, unused, nil
+}
+
+// Determines whether the input starts with a Hello, and sets up the parser
+// accordingly. Returns false if more data is needed to tell.
+func (p *tlsDecryptingParser) start(isEnd bool) bool {
+	if p.allInput.Len() == 0 {
+		return false
+	}
+	if recordType(p.allInput.GetByte(0)) == handshakeRecordType && p.allInput.Len() < minTLSClientHelloLength_bytes && !isEnd {
+		return false
+	}
+
+	p.started = true
+	switch {
+	case isClientHello(p.allInput):
+		p.clientHello = newTLSClientHelloParser(p.bidiID)
+	case isServerHello(p.allInput):
+		p.serverHello = newTLSServerHelloParser(p.bidiID)
+	}
+	return true
+}
+
+// Decrypts the complete records at the start of input, which was sent on the
+// flow with the given sequence number, until the decrypted data yields a
+// result. Returns the number of bytes consumed.
+func (conn *tlsConnection) parse(factory *tlsDecryptingParserFactory, id akinet.TCPBidiID, seq reassembly.Sequence, input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, numBytesConsumed int64, err error) {
+	flow, isClient, err := conn.flow(seq)
+	if err != nil {
+		return nil, 0, err
+	}
+	connectionID := akid.NewConnectionID(uuid.UUID(id))
+
+	for {
+		if result := flow.nextResult(factory.inner, id, isClient); result != nil {
+			return withTLSConnectionID(result, connectionID), numBytesConsumed, nil
+		}
+
+		remaining := input.SubView(numBytesConsumed, input.Len())
+		if remaining.Len() < tlsRecordHeaderLength_bytes {
+			break
+		}
+		if !isPlausibleRecordHeader(remaining) {
+			return nil, numBytesConsumed, errors.New("malformed TLS record header")
+		}
+		recordEnd := tlsRecordHeaderLength_bytes + int64(remaining.GetUint16(3))
+		if remaining.Len() < recordEnd {
+			break
+		}
+
+		waiting, err := conn.processRecord(factory.keyLog, flow, isClient, []byte(remaining.SubView(0, recordEnd).String()))
+		if err != nil {
+			return nil, numBytesConsumed, err
+		}
+		if waiting {
+			break
+		}
+		numBytesConsumed += recordEnd
+	}
+
+	if isEnd && flow.parser != nil {
+		result, _, err := flow.parser.Parse(memview.MemView{}, true)
+		flow.parser = nil
+		if err == nil {
+			return withTLSConnectionID(result, connectionID), numBytesConsumed, nil
+		}
+		glog.V(4).Infof("failed to parse decrypted TLS data: %v", err)
+	}
+	return nil, numBytesConsumed, nil
+}
+
+// Returns the state of the flow with the given sequence number.
+func (conn *tlsConnection) flow(seq reassembly.Sequence) (flow *tlsFlow, isClient bool, err error) {
+	isNear := func(f *tlsFlow) bool {
+		d := seq.Difference(f.seq)
+		return f.known && -maxSeqDistance < d && d < maxSeqDistance
+	}
+
+	switch {
+	case isNear(&conn.client):
+		flow, isClient = &conn.client, true
+	case isNear(&conn.server):
+		flow, isClient = &conn.server, false
+	default:
+		return nil, false, errors.New("TLS record on a connection whose handshake was not seen")
+	}
+	flow.seq = seq
+	return flow, isClient, nil
+}
+
+// Decrypts a single record on the given flow. Returns true if the record
+// can't be processed until the Server Hello has been seen.
+func (conn *tlsConnection) processRecord(keyLog *KeyLog, flow *tlsFlow, isClient bool, record []byte) (waiting bool, err error) {
+	typ := recordType(record[0])
+
+	// Until the Server Hello has been seen, we don't know whether records are
+	// encrypted.
+	if !conn.serverHelloSeen {
+		return typ == applicationDataRecordType || typ == changeCipherSpecRecordType, nil
+	}
+
+	tls13 := conn.version == akinet.TLS_v1_3
+	if tls13 {
+		// Everything after the Server Hello is sent as application data. Other
+		// records are either unencrypted or, for ChangeCipherSpec, only there for
+		// compatibility with middleboxes.
+		if typ != applicationDataRecordType {
+			return false, nil
+		}
+	} else {
+		if typ == changeCipherSpecRecordType {
+			flow.changeCipherSpecSeen = true
+			return false, nil
+		}
+		if !flow.changeCipherSpecSeen {
+			return false, nil
+		}
+	}
+
+	if flow.decrypter == nil {
+		if err := conn.initDecrypters(keyLog); err != nil {
+			return false, err
+		}
+	}
+
+	typ, plaintext, err := flow.decrypter.decrypt(record)
+	if err != nil && tls13 && !flow.applicationKeys {
+		// We may have missed the end of the handshake. Try the application traffic
+		// keys instead.
+		if conn.useApplicationKeys(keyLog, flow, isClient) == nil {
+			typ, plaintext, err = flow.decrypter.decrypt(record)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch typ {
+	case applicationDataRecordType:
+		flow.plaintext.Append(memview.New(plaintext))
+
+	case handshakeRecordType:
+		if tls13 && !flow.applicationKeys {
+			return false, conn.readHandshake(keyLog, flow, isClient, plaintext)
+		}
+	}
+	return false, nil
+}
+
+// Sets up the decrypters for both flows of the connection. For TLS 1.3, these
+// use the handshake traffic keys.
+func (conn *tlsConnection) initDecrypters(keyLog *KeyLog) (err error) {
+	if conn.suite == nil {
+		return errors.Errorf("unsupported TLS cipher suite 0x%04x", conn.cipherSuite)
+	}
+	secrets, ok := keyLog.lookup(conn.clientRandom)
+	if !ok {
+		return errors.New("no secrets in the key log for TLS connection")
+	}
+
+	if conn.version == akinet.TLS_v1_3 {
+		if secrets.clientHandshakeTrafficSecret == nil || secrets.serverHandshakeTrafficSecret == nil {
+			return errors.New("no handshake traffic secrets in the key log for TLS 1.3 connection")
+		}
+		if conn.client.decrypter, err = newTLS13Decrypter(conn.suite, secrets.clientHandshakeTrafficSecret); err != nil {
+			return err
+		}
+		conn.server.decrypter, err = newTLS13Decrypter(conn.suite, secrets.serverHandshakeTrafficSecret)
+		return err
+	}
+
+	if secrets.masterSecret == nil {
+		return errors.New("no master secret in the key log for TLS 1.2 connection")
+	}
+	conn.client.decrypter, conn.server.decrypter, err = newTLS12Decrypters(conn.suite, secrets.masterSecret, conn.clientRandom, conn.serverRandom)
+	return err
+}
+
+// Switches a TLS 1.3 flow to its application traffic keys.
+func (conn *tlsConnection) useApplicationKeys(keyLog *KeyLog, flow *tlsFlow, isClient bool) error {
+	secrets, _ := keyLog.lookup(conn.clientRandom)
+	secret := secrets.serverTrafficSecret
+	if isClient {
+		secret = secrets.clientTrafficSecret
+	}
+	if secret == nil {
+		return errors.New("no application traffic secret in the key log for TLS 1.3 connection")
+	}
+
+	decrypter, err := newTLS13Decrypter(conn.suite, secret)
+	if err != nil {
+		return err
+	}
+	flow.decrypter = decrypter
+	flow.applicationKeys = true
+	flow.handshake = nil
+	return nil
+}
+
+// Reads the handshake messages decrypted from a TLS 1.3 flow, and switches to
+// the application traffic keys after the flow's Finished message.
+func (conn *tlsConnection) readHandshake(keyLog *KeyLog, flow *tlsFlow, isClient bool, data []byte) error {
+	flow.handshake = append(flow.handshake, data...)
+	for len(flow.handshake) >= handshakeHeaderLength_bytes {
+		msgLen := int(flow.handshake[1])<<16 | int(flow.handshake[2])<<8 | int(flow.handshake[3])
+		if len(flow.handshake) < handshakeHeaderLength_bytes+msgLen {
+			break
+		}
+		if handshakeType(flow.handshake[0]) == finishedHandshakeType {
+			return conn.useApplicationKeys(keyLog, flow, isClient)
+		}
+		flow.handshake = flow.handshake[handshakeHeaderLength_bytes+msgLen:]
+	}
+	return nil
+}
+
+// Parses the flow's decrypted data until a result is available. Returns nil if
+// more data is needed.
+func (flow *tlsFlow) nextResult(inner akinet.TCPParserFactorySelector, id akinet.TCPBidiID, isClient bool) akinet.ParsedNetworkContent {
+	for flow.plaintext.Len() > 0 {
+		if flow.parser == nil {
+			factory, decision, discardFront := inner.Select(flow.plaintext, false)
+			flow.plaintext = flow.plaintext.SubView(discardFront, flow.plaintext.Len())
+			if decision != akinet.Accept {
+				return nil
+			}
+			seq, ack := plaintextSeq(isClient, flow.numResults), plaintextSeq(!isClient, flow.numResults)
+			flow.parser = factory.CreateParser(id, seq, ack)
+		}
+
+		input := flow.plaintext
+		flow.plaintext = memview.MemView{}
+		result, unused, err := flow.parser.Parse(input, false)
+		if err != nil {
+			// Drop the first byte of the data that the parser was given, and look
+			// for the start of another message in the rest.
+			glog.V(4).Infof("failed to parse decrypted TLS data with %s: %v", flow.parser.Name(), err)
+			flow.parser = nil
+			if unused.Len() > 0 {
+				flow.plaintext = unused.SubView(1, unused.Len())
+			}
+			continue
+		}
+		if result == nil {
+			return nil
+		}
+
+		flow.parser = nil
+		flow.plaintext = unused
+		flow.numResults++
+		return result
+	}
+	return nil
+}
+
+// Returns the number that stands in for the TCP sequence number of the given
+// result parsed from a flow's decrypted data. How much data has been decrypted
+// from each flow depends on the order in which the flows are processed, so
+// results are numbered instead: the nth result on one flow is given the nth
+// number of the other flow as its acknowledgement number, which pairs HTTP
+// requests with their responses. The two flows are numbered from opposite
+// halves of the sequence space, so that they can be told apart.
+func plaintextSeq(isClient bool, n int) reassembly.Sequence {
+	if isClient {
+		return reassembly.Sequence(n)
+	}
+	return reassembly.Sequence(1 << 31).Add(n)
+}
+
+// Links HTTP messages to the TLS connection from which they were decrypted.
+func withTLSConnectionID(result akinet.ParsedNetworkContent, connectionID akid.ConnectionID) akinet.ParsedNetworkContent {
+	switch r := result.(type) {
+	case akinet.HTTPRequest:
+		r.TLSConnectionID = &connectionID
+		return r
+	case akinet.HTTPResponse:
+		r.TLSConnectionID = &connectionID
+		return r
+	}
+	return result
+}
@@ -0,0 +1,210 @@
+package tls
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+const (
+	// Sequence numbers on the same flow are assumed to be closer than this.
+	maxSeqDistance = 1 << 28
+
+	// Connections that have not seen any traffic for this long are forgotten.
+	connectionIdleTimeout = 10 * time.Minute
+)
+
+// Returns a parser factory that decrypts TLS connections using the secrets in
+// keyLog, and parses the decrypted data with the factories in inner.
+//
+// The returned factory parses Client and Server Hellos in place of the
+// factories returned by NewTLSClientParserFactory and
+// NewTLSServerParserFactory, because the randoms and cipher suite in the
+// Hellos are needed to decrypt the connection. It keeps track of each
+// connection's state, so the same factory must be used for both flows of a
+// connection.
+//
+// Only AEAD cipher suites (AES-GCM and ChaCha20-Poly1305) are supported, and
+// TLS 1.3 key updates and early data are not. HTTP messages decrypted from a
+// connection have their TLSConnectionID set to the connection's ID.
+func NewTLSDecryptingParserFactory(keyLog *KeyLog, inner akinet.TCPParserFactorySelector) akinet.TCPParserFactory {
+	factory := &tlsDecryptingParserFactory{
+		keyLog: keyLog,
+		inner:  inner,
+	}
+	factory.connections = akinet.NewConnectionMap(connectionIdleTimeout, func(_ akinet.TCPBidiID, state interface{}) {
+		if state.(*tlsConnection).suite != nil {
+			factory.numDecryptable--
+		}
+	})
+	return factory
+}
+
+type tlsDecryptingParserFactory struct {
+	keyLog *KeyLog
+	inner  akinet.TCPParserFactorySelector
+
+	mu          sync.Mutex
+	connections *akinet.ConnectionMap // of *tlsConnection, protected by mu
+
+	// The number of connections whose Server Hello selected a cipher suite that
+	// we can decrypt. Records other than Hellos are only accepted while this is
+	// non-zero. Protected by mu.
+	numDecryptable int
+}
+
+// The state of a connection. Protected by tlsDecryptingParserFactory.mu.
+type tlsConnection struct {
+	clientRandom []byte
+	serverRandom []byte
+
+	// Set once the Server Hello is seen. suite is nil if the selected cipher
+	// suite is not supported.
+	serverHelloSeen bool
+	version         akinet.TLSVersion
+	cipherSuite     uint16
+	suite           *cipherSuite
+
+	client, server tlsFlow
+}
+
+// The state of one flow of a connection.
+type tlsFlow struct {
+	// The last sequence number seen on the flow, used to tell which flow a
+	// parser is on.
+	seq   reassembly.Sequence
+	known bool
+
+	// Decrypts the flow's records, once they are encrypted.
+	decrypter *recordDecrypter
+
+	// TLS 1.2: whether the flow has sent ChangeCipherSpec, after which its
+	// records are encrypted.
+	changeCipherSpecSeen bool
+
+	// TLS 1.3: whether the flow has finished the handshake, and switched to its
+	// application traffic keys. Until then, handshake holds any partial
+	// handshake message.
+	applicationKeys bool
+	handshake       []byte
+
+	// Decrypted application data that has not yet been given to a parser.
+	plaintext memview.MemView
+
+	// The number of results parsed from the decrypted data. See plaintextSeq.
+	numResults int
+
+	// The parser for the decrypted data, once one has been selected.
+	parser akinet.TCPParser
+}
+
+func (*tlsDecryptingParserFactory) Name() string {
+	return "TLS Decrypting Parser Factory"
+}
+
+func (factory *tlsDecryptingParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	decision, discardFront = factory.accepts(input)
+
+	if decision == akinet.NeedMoreData && isEnd {
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+
+	return decision, discardFront
+}
+
+func (factory *tlsDecryptingParserFactory) accepts(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	if input.Len() < tlsRecordHeaderLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+
+	// Hellos are always accepted, along with a ChangeCipherSpec that precedes
+	// them.
+	if decision, discardFront := acceptsAfterChangeCipherSpec(input, acceptsHello); decision != akinet.Reject {
+		return decision, discardFront
+	}
+
+	if !isPlausibleRecordHeader(input) || !factory.haveDecryptableConnections() {
+		return akinet.Reject, input.Len()
+	}
+	return akinet.Accept, 0
+}
+
+func (factory *tlsDecryptingParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newTLSDecryptingParser(factory, id, seq)
+}
+
+// Determines whether the input starts with a Client Hello.
+func isClientHello(input memview.MemView) bool {
+	decision, _ := (*tlsClientParserFactory)(nil).accepts(input)
+	return decision == akinet.Accept
+}
+
+// Determines whether the input starts with a Server Hello.
+func isServerHello(input memview.MemView) bool {
+	decision, _ := (*tlsServerParserFactory)(nil).accepts(input)
+	return decision == akinet.Accept
+}
+
+// Determines whether the input starts with the header of a TLS 1.0-1.2 record,
+// which includes all TLS 1.3 records.
+func isPlausibleRecordHeader(input memview.MemView) bool {
+	switch recordType(input.GetByte(0)) {
+	case changeCipherSpecRecordType, alertRecordType, handshakeRecordType, applicationDataRecordType:
+	default:
+		return false
+	}
+
+	if input.GetByte(1) != 0x03 || input.GetByte(2) < 0x01 || input.GetByte(2) > 0x03 {
+		return false
+	}
+
+	return input.GetUint16(3) <= maxCiphertextLength_bytes
+}
+
+func (factory *tlsDecryptingParserFactory) haveDecryptableConnections() bool {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+	return factory.numDecryptable > 0
+}
+
+// Calls f with the state of the given connection, creating it if necessary.
+func (factory *tlsDecryptingParserFactory) withConnection(id akinet.TCPBidiID, f func(*tlsConnection)) {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	f(factory.connections.GetOrCreate(id, func() interface{} { return &tlsConnection{} }).(*tlsConnection))
+}
+
+// Records the Client Hello of a connection, seen on the flow with the given
+// sequence number.
+func (factory *tlsDecryptingParserFactory) addClientHello(id akinet.TCPBidiID, seq reassembly.Sequence, random []byte) {
+	factory.withConnection(id, func(conn *tlsConnection) {
+		conn.client.seq, conn.client.known = seq, true
+		conn.clientRandom = random
+	})
+}
+
+// Records the Server Hello of a connection, seen on the flow with the given
+// sequence number.
+func (factory *tlsDecryptingParserFactory) addServerHello(id akinet.TCPBidiID, seq reassembly.Sequence, random []byte, hello *akinet.TLSServerHello) {
+	factory.withConnection(id, func(conn *tlsConnection) {
+		conn.server.seq, conn.server.known = seq, true
+		if conn.serverHelloSeen {
+			return
+		}
+
+		conn.serverRandom = random
+		conn.serverHelloSeen = true
+		conn.version = hello.Version
+		conn.cipherSuite = hello.CipherSuite
+		conn.suite = cipherSuites[hello.CipherSuite]
+		if conn.suite != nil {
+			factory.numDecryptable++
+		}
+	})
+}
@@ -0,0 +1,406 @@
+package tls
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	akihttp "github.com/akitasoftware/akita-libs/akinet/http"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Records the data written to a connection, in the order written by either
+// side.
+type capture struct {
+	mu     sync.Mutex
+	chunks []capturedChunk
+}
+
+type capturedChunk struct {
+	fromClient bool
+	data       []byte
+}
+
+type capturingConn struct {
+	net.Conn
+	capture    *capture
+	fromClient bool
+}
+
+func (c *capturingConn) Write(b []byte) (int, error) {
+	c.capture.mu.Lock()
+	c.capture.chunks = append(c.capture.chunks, capturedChunk{c.fromClient, append([]byte{}, b...)})
+	c.capture.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// Runs an HTTP request and response over a real TLS connection, and returns
+// the captured traffic and the client's key log.
+func captureHTTPSExchange(t *testing.T, clientConfig *gotls.Config) (*capture, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"example.com"},
+	}
+	cert := gotls.Certificate{
+		Certificate: [][]byte{makeCertificate(t, template, key, nil, nil)},
+		PrivateKey:  key,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	c := &capture{}
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+
+		server := gotls.Server(&capturingConn{conn, c, false}, &gotls.Config{Certificates: []gotls.Certificate{cert}})
+		req, err := http.ReadRequest(bufio.NewReader(server))
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		ioutil.ReadAll(req.Body)
+		_, err = server.Write([]byte("HTTP/1.1 201 Created\r\nContent-Length: 11\r\n\r\n{\"id\":\"p1\"}"))
+		serverErr <- err
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var keyLog bytes.Buffer
+	clientConfig.ServerName = "example.com"
+	clientConfig.InsecureSkipVerify = true
+	clientConfig.KeyLogWriter = &keyLog
+	client := gotls.Client(&capturingConn{conn, c, true}, clientConfig)
+
+	body := `{"name":"Rex"}`
+	_, err = client.Write([]byte("POST /v1/pets HTTP/1.1\r\nHost: example.com\r\nContent-Length: 14\r\n\r\n" + body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	if err := <-serverErr; err != nil {
+		t.Fatal(err)
+	}
+
+	return c, keyLog.Bytes()
+}
+
+// Feeds a flow to parsers created by a selector, in the same way as a packet
+// capture would.
+type flowParser struct {
+	selector akinet.TCPParserFactorySelector
+	seq      reassembly.Sequence
+	buf      memview.MemView
+	parser   akinet.TCPParser
+	results  []akinet.ParsedNetworkContent
+	errs     []error
+}
+
+func (f *flowParser) feed(data []byte, isEnd bool) {
+	f.buf.Append(memview.New(data))
+	for f.buf.Len() > 0 || (isEnd && f.parser != nil) {
+		if f.parser == nil {
+			factory, decision, discardFront := f.selector.Select(f.buf, isEnd)
+			f.buf = f.buf.SubView(discardFront, f.buf.Len())
+			f.seq = f.seq.Add(int(discardFront))
+			if decision != akinet.Accept {
+				return
+			}
+			f.parser = factory.CreateParser(testBidiID, f.seq, 0)
+		}
+
+		input := f.buf
+		f.buf = memview.MemView{}
+		result, unused, err := f.parser.Parse(input, isEnd)
+		if err != nil {
+			f.errs = append(f.errs, errors.Wrap(err, f.parser.Name()))
+			f.parser = nil
+			return
+		}
+		if result == nil {
+			return
+		}
+		f.results = append(f.results, result)
+		f.parser = nil
+		f.buf = unused
+	}
+}
+
+func TestDecryptHTTPS(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      *gotls.Config
+		cipherSuite uint16
+	}{
+		{
+			name:        "TLS 1.2 AES-128-GCM",
+			config:      &gotls.Config{MaxVersion: gotls.VersionTLS12, CipherSuites: []uint16{0xc02b}},
+			cipherSuite: 0xc02b,
+		},
+		{
+			name:        "TLS 1.2 AES-256-GCM",
+			config:      &gotls.Config{MaxVersion: gotls.VersionTLS12, CipherSuites: []uint16{0xc02c}},
+			cipherSuite: 0xc02c,
+		},
+		{
+			name:        "TLS 1.2 ChaCha20-Poly1305",
+			config:      &gotls.Config{MaxVersion: gotls.VersionTLS12, CipherSuites: []uint16{0xcca9}},
+			cipherSuite: 0xcca9,
+		},
+		{
+			name:   "TLS 1.3",
+			config: &gotls.Config{MinVersion: gotls.VersionTLS13},
+		},
+	}
+
+	for _, c := range testCases {
+		capture, keyLogData := captureHTTPSExchange(t, c.config)
+
+		keyLog := NewKeyLog()
+		if err := keyLog.Read(bytes.NewReader(keyLogData)); err != nil {
+			t.Fatalf("[%s] failed to read key log: %v", c.name, err)
+		}
+		selector := akinet.TCPParserFactorySelector{
+			NewTLSDecryptingParserFactory(keyLog, akinet.TCPParserFactorySelector{
+				akihttp.NewHTTPRequestParserFactory(akihttp.ParserOptions{}),
+				akihttp.NewHTTPResponseParserFactory(akihttp.ParserOptions{}),
+			}),
+		}
+		client := &flowParser{selector: selector, seq: 1000}
+		server := &flowParser{selector: selector, seq: 500000000}
+		for _, chunk := range capture.chunks {
+			if chunk.fromClient {
+				client.feed(chunk.data, false)
+			} else {
+				server.feed(chunk.data, false)
+			}
+		}
+		client.feed(nil, true)
+		server.feed(nil, true)
+		for _, err := range append(client.errs, server.errs...) {
+			t.Errorf("[%s] unexpected error: %v", c.name, err)
+		}
+
+		if len(client.results) != 2 || len(server.results) != 2 {
+			t.Fatalf("[%s] expected 2 results on each flow, got %d from the client and %d from the server", c.name, len(client.results), len(server.results))
+		}
+		if _, ok := client.results[0].(akinet.TLSClientHello); !ok {
+			t.Errorf("[%s] expected a TLSClientHello, got %T", c.name, client.results[0])
+		}
+		hello, ok := server.results[0].(akinet.TLSServerHello)
+		if !ok {
+			t.Fatalf("[%s] expected a TLSServerHello, got %T", c.name, server.results[0])
+		}
+		if c.cipherSuite != 0 && hello.CipherSuite != c.cipherSuite {
+			t.Errorf("[%s] expected cipher suite 0x%04x, got 0x%04x", c.name, c.cipherSuite, hello.CipherSuite)
+		}
+
+		req, ok := client.results[1].(akinet.HTTPRequest)
+		if !ok {
+			t.Fatalf("[%s] expected an HTTPRequest, got %T", c.name, client.results[1])
+		}
+		resp, ok := server.results[1].(akinet.HTTPResponse)
+		if !ok {
+			t.Fatalf("[%s] expected an HTTPResponse, got %T", c.name, server.results[1])
+		}
+
+		if req.Method != "POST" || req.URL.Path != "/v1/pets" || string(req.Body) != `{"name":"Rex"}` {
+			t.Errorf("[%s] unexpected request: %s %s %q", c.name, req.Method, req.URL, req.Body)
+		}
+		if resp.StatusCode != 201 || string(resp.Body) != `{"id":"p1"}` {
+			t.Errorf("[%s] unexpected response: %d %q", c.name, resp.StatusCode, resp.Body)
+		}
+		if req.GetStreamKey() != resp.GetStreamKey() {
+			t.Errorf("[%s] request and response don't pair: %s and %s", c.name, req.GetStreamKey(), resp.GetStreamKey())
+		}
+
+		connectionID := akid.NewConnectionID(uuid.UUID(testBidiID))
+		for _, id := range []*akid.ConnectionID{req.TLSConnectionID, resp.TLSConnectionID} {
+			if id == nil || *id != connectionID {
+				t.Errorf("[%s] expected decrypted HTTP messages to be linked to connection %s", c.name, akid.String(connectionID))
+			}
+		}
+	}
+}
+
+func TestDecryptHTTPSPairsRegardlessOfOrder(t *testing.T) {
+	configs := map[string]*gotls.Config{
+		"TLS 1.2": {MaxVersion: gotls.VersionTLS12},
+		"TLS 1.3": {MinVersion: gotls.VersionTLS13},
+	}
+
+	for name, config := range configs {
+		capture, keyLogData := captureHTTPSExchange(t, config)
+		keyLog := NewKeyLog()
+		if err := keyLog.Read(bytes.NewReader(keyLogData)); err != nil {
+			t.Fatalf("[%s] failed to read key log: %v", name, err)
+		}
+		selector := akinet.TCPParserFactorySelector{
+			NewTLSDecryptingParserFactory(keyLog, akinet.TCPParserFactorySelector{
+				akihttp.NewHTTPRequestParserFactory(akihttp.ParserOptions{}),
+				akihttp.NewHTTPResponseParserFactory(akihttp.ParserOptions{}),
+			}),
+		}
+		client := &flowParser{selector: selector, seq: 1000}
+		server := &flowParser{selector: selector, seq: 500000000}
+
+		// The whole server flow, including the response, is decrypted before the
+		// client's request.
+		client.feed(capture.chunks[0].data, false)
+		var rest []capturedChunk
+		for _, chunk := range capture.chunks[1:] {
+			if chunk.fromClient {
+				rest = append(rest, chunk)
+			} else {
+				server.feed(chunk.data, false)
+			}
+		}
+		for _, chunk := range rest {
+			client.feed(chunk.data, false)
+		}
+		client.feed(nil, true)
+		server.feed(nil, true)
+
+		var req akinet.HTTPRequest
+		var resp akinet.HTTPResponse
+		for _, r := range append(client.results, server.results...) {
+			switch r := r.(type) {
+			case akinet.HTTPRequest:
+				req = r
+			case akinet.HTTPResponse:
+				resp = r
+			}
+		}
+		if req.Method == "" || resp.StatusCode == 0 {
+			t.Fatalf("[%s] expected a request and a response, got %v and %v", name, client.results, server.results)
+		}
+		if req.GetStreamKey() != resp.GetStreamKey() {
+			t.Errorf("[%s] request and response don't pair: %s and %s", name, req.GetStreamKey(), resp.GetStreamKey())
+		}
+	}
+}
+
+func TestDecryptedDataReselectedAfterError(t *testing.T) {
+	inner := akinet.TCPParserFactorySelector{
+		akihttp.NewHTTPRequestParserFactory(akihttp.ParserOptions{}),
+	}
+	flow := &tlsFlow{
+		plaintext: memview.New([]byte("GET / HTTP/1.1\r\nnot a header\r\n\r\nGET /ok HTTP/1.1\r\nHost: example.com\r\n\r\n")),
+	}
+
+	// The malformed request is skipped, and the request that follows it is
+	// parsed.
+	result := flow.nextResult(inner, testBidiID, true)
+	req, ok := result.(akinet.HTTPRequest)
+	if !ok {
+		t.Fatalf("expected an HTTPRequest, got %v", result)
+	}
+	if req.URL.Path != "/ok" {
+		t.Errorf("expected request for /ok, got %s", req.URL)
+	}
+}
+
+func TestDecryptingParserErrorReturnsAllInput(t *testing.T) {
+	factory := NewTLSDecryptingParserFactory(NewKeyLog(), akinet.TCPParserFactorySelector{})
+	hello := clientHelloRecord()
+	if _, _, err := factory.CreateParser(testBidiID, 1000, 0).Parse(memview.New(hello), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A handshake record is consumed before the malformed record is seen.
+	inputs := [][]byte{
+		record(handshakeRecordType, make([]byte, 10)),
+		{0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	p := factory.CreateParser(testBidiID, reassembly.Sequence(1000).Add(len(hello)), 0)
+	if result, _, err := p.Parse(memview.New(inputs[0]), false); result != nil || err != nil {
+		t.Fatalf("expected parser to need more data, got %v, %v", result, err)
+	}
+	_, unused, err := p.Parse(memview.New(inputs[1]), false)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if expected := string(bytes.Join(inputs, nil)); unused.String() != expected {
+		t.Errorf("expected all input to be unused, got %q", unused.String())
+	}
+}
+
+func TestKeyLog(t *testing.T) {
+	random := strings.Repeat("ab", clientRandomLength_bytes)
+	keyLog := NewKeyLog()
+	err := keyLog.Read(strings.NewReader(strings.Join([]string{
+		"# comment",
+		"",
+		"CLIENT_RANDOM " + random + " " + strings.Repeat("01", masterSecretLength_bytes),
+		"CLIENT_TRAFFIC_SECRET_0 " + random + " " + strings.Repeat("02", 32),
+		"EXPORTER_SECRET " + random + " " + strings.Repeat("03", 32),
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secrets, ok := keyLog.lookup(bytes.Repeat([]byte{0xab}, clientRandomLength_bytes))
+	if !ok {
+		t.Fatal("expected secrets for client random")
+	}
+	if !bytes.Equal(secrets.masterSecret, bytes.Repeat([]byte{1}, masterSecretLength_bytes)) {
+		t.Errorf("unexpected master secret %x", secrets.masterSecret)
+	}
+	if !bytes.Equal(secrets.clientTrafficSecret, bytes.Repeat([]byte{2}, 32)) {
+		t.Errorf("unexpected client traffic secret %x", secrets.clientTrafficSecret)
+	}
+
+	for _, line := range []string{
+		"CLIENT_RANDOM " + random,
+		"CLIENT_RANDOM abcd " + strings.Repeat("01", masterSecretLength_bytes),
+		"CLIENT_RANDOM " + random + " 0102",
+		"SERVER_TRAFFIC_SECRET_0 " + random + " xyz",
+	} {
+		if err := keyLog.AddLine(line); err == nil {
+			t.Errorf("expected an error for %q", line)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package tls
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Determines whether a cipher suite, extension type, group or version is one of
+// the GREASE values reserved by RFC 8701, which clients send at random to
+// keep servers tolerant of unknown values. Fingerprints ignore them.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	result := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// Returns the JA3 string of a Client Hello and its MD5 hash. See
+// https://github.com/salesforce/ja3.
+func ja3(hello *akinet.TLSClientHello) (ja3 string, hash string) {
+	decimal := func(values []uint16) string {
+		strs := make([]string, 0, len(values))
+		for _, v := range withoutGREASE(values) {
+			strs = append(strs, strconv.Itoa(int(v)))
+		}
+		return strings.Join(strs, "-")
+	}
+
+	pointFormats := make([]uint16, 0, len(hello.ECPointFormats))
+	for _, f := range hello.ECPointFormats {
+		pointFormats = append(pointFormats, uint16(f))
+	}
+
+	ja3 = strings.Join([]string{
+		strconv.Itoa(int(hello.LegacyVersion)),
+		decimal(hello.CipherSuites),
+		decimal(hello.Extensions),
+		decimal(hello.SupportedGroups),
+		decimal(pointFormats),
+	}, ",")
+	sum := md5.Sum([]byte(ja3))
+	return ja3, hex.EncodeToString(sum[:])
+}
+
+// Returns the JA4 fingerprint of a Client Hello received over TCP. See
+// https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md.
+func ja4(hello *akinet.TLSClientHello) string {
+	ciphers := withoutGREASE(hello.CipherSuites)
+	extensions := withoutGREASE(hello.Extensions)
+
+	// The first part describes the protocol, the TLS version, whether SNI was
+	// given, the number of cipher suites and extensions, and the first ALPN
+	// value.
+	version := hello.LegacyVersion
+	for _, v := range withoutGREASE(hello.SupportedVersions) {
+		if v > version {
+			version = v
+		}
+	}
+	sni := "i"
+	if hello.Hostname != nil {
+		sni = "d"
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s",
+		ja4Version(version), sni, min99(len(ciphers)), min99(len(extensions)), ja4ALPN(hello.SupportedProtocols))
+
+	// The second part is a hash of the sorted cipher suites.
+	b := ja4Hash(sortedHex(ciphers))
+
+	// The third part is a hash of the sorted extension types, excluding SNI and
+	// ALPN, followed by the signature algorithms in the order given.
+	var hashedExtensions []uint16
+	for _, e := range extensions {
+		if tlsExtensionID(e) != serverNameTLSExtensionID && tlsExtensionID(e) != alpnTLSExtensionID {
+			hashedExtensions = append(hashedExtensions, e)
+		}
+	}
+	c := ""
+	if len(hashedExtensions) > 0 {
+		c = sortedHex(hashedExtensions)
+		if sigAlgs := withoutGREASE(hello.SignatureAlgorithms); len(sigAlgs) > 0 {
+			c += "_" + joinHex(sigAlgs)
+		}
+	}
+
+	return a + "_" + b + "_" + ja4Hash(c)
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	}
+	return "00"
+}
+
+// Returns the first and last characters of the first ALPN value, or "00" if
+// there is none. Values that don't start and end with alphanumeric characters
+// are represented by the first and last characters of their hex encoding.
+func ja4ALPN(protocols []string) string {
+	if len(protocols) == 0 || protocols[0] == "" {
+		return "00"
+	}
+	p := protocols[0]
+	first, last := p[0], p[len(p)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+	h := hex.EncodeToString([]byte(p))
+	return string([]byte{h[0], h[len(h)-1]})
+}
+
+func isAlphanumeric(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func min99(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
+
+func joinHex(values []uint16) string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%04x", v))
+	}
+	return strings.Join(strs, ",")
+}
+
+func sortedHex(values []uint16) string {
+	sorted := append([]uint16(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return joinHex(sorted)
+}
+
+// Returns the first 12 hex characters of the SHA-256 hash of s, or zeros if s
+// is empty.
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
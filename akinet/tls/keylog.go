@@ -0,0 +1,135 @@
+package tls
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Secrets for TLS connections, as written to the file named by the
+// SSLKEYLOGFILE environment variable by browsers, curl, and other programs
+// that use NSS, OpenSSL, BoringSSL or Go's crypto/tls. The format is described
+// at https://developer.mozilla.org/en-US/docs/Mozilla/Projects/NSS/Key_Log_Format.
+//
+// Secrets can be added while connections are being decrypted, e.g. as new
+// lines are written to the file.
+type KeyLog struct {
+	mu      sync.RWMutex
+	secrets map[string]*connectionSecrets // protected by mu; keyed by client random
+}
+
+// The secrets for a single connection, keyed by their key log labels.
+type connectionSecrets struct {
+	// The TLS 1.2 master secret.
+	masterSecret []byte
+
+	// TLS 1.3 traffic secrets.
+	clientHandshakeTrafficSecret []byte
+	serverHandshakeTrafficSecret []byte
+	clientTrafficSecret          []byte
+	serverTrafficSecret          []byte
+}
+
+func NewKeyLog() *KeyLog {
+	return &KeyLog{
+		secrets: map[string]*connectionSecrets{},
+	}
+}
+
+// Reads the key log file at the given path.
+func ReadKeyLogFile(path string) (*KeyLog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open key log file %s", path)
+	}
+	defer f.Close()
+
+	keyLog := NewKeyLog()
+	if err := keyLog.Read(f); err != nil {
+		return nil, errors.Wrapf(err, "failed to read key log file %s", path)
+	}
+	return keyLog, nil
+}
+
+// Adds the secrets in r, which has the key log format.
+func (kl *KeyLog) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if err := kl.AddLine(scanner.Text()); err != nil {
+			return errors.Wrapf(err, "line %d", lineNum)
+		}
+	}
+	return scanner.Err()
+}
+
+// Adds the secret in a single line of a key log file. Blank lines, comments,
+// and secrets that aren't needed for decryption (e.g. EXPORTER_SECRET) are
+// ignored.
+func (kl *KeyLog) AddLine(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return errors.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	label := fields[0]
+
+	clientRandom, err := hex.DecodeString(fields[1])
+	if err != nil || len(clientRandom) != clientRandomLength_bytes {
+		return errors.Errorf("malformed client random for %s", label)
+	}
+	secret, err := hex.DecodeString(fields[2])
+	if err != nil || len(secret) == 0 {
+		return errors.Errorf("malformed secret for %s", label)
+	}
+
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	secrets, ok := kl.secrets[string(clientRandom)]
+	if !ok {
+		secrets = &connectionSecrets{}
+	}
+
+	switch label {
+	case "CLIENT_RANDOM":
+		if len(secret) != masterSecretLength_bytes {
+			return errors.Errorf("expected a %d-byte master secret, got %d bytes", masterSecretLength_bytes, len(secret))
+		}
+		secrets.masterSecret = secret
+	case "CLIENT_HANDSHAKE_TRAFFIC_SECRET":
+		secrets.clientHandshakeTrafficSecret = secret
+	case "SERVER_HANDSHAKE_TRAFFIC_SECRET":
+		secrets.serverHandshakeTrafficSecret = secret
+	case "CLIENT_TRAFFIC_SECRET_0":
+		secrets.clientTrafficSecret = secret
+	case "SERVER_TRAFFIC_SECRET_0":
+		secrets.serverTrafficSecret = secret
+	default:
+		return nil
+	}
+
+	kl.secrets[string(clientRandom)] = secrets
+	return nil
+}
+
+// Returns a copy of the secrets for the connection with the given client
+// random.
+func (kl *KeyLog) lookup(clientRandom []byte) (connectionSecrets, bool) {
+	kl.mu.RLock()
+	defer kl.mu.RUnlock()
+
+	secrets, ok := kl.secrets[string(clientRandom)]
+	if !ok {
+		return connectionSecrets{}, false
+	}
+	return *secrets, true
+}
@@ -0,0 +1,154 @@
+package tls
+
+import (
+	"io"
+
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+const (
+	// The length of an unencrypted alert: a level and a description.
+	alertLength_bytes = 2
+
+	// The length of an encrypted TLS 1.3 alert: the alert, the inner content
+	// type, and a 16-byte authentication tag. An alert ends the connection, so
+	// an application data record of this length is only taken to be an alert if
+	// nothing follows it.
+	encryptedTLS13AlertLength_bytes = alertLength_bytes + 1 + 16
+)
+
+func newTLSRecordParser(factory *tlsRecordParserFactory, bidiID akinet.TCPBidiID, seq reassembly.Sequence) *tlsRecordParser {
+	return &tlsRecordParser{
+		factory:      factory,
+		bidiID:       bidiID,
+		seq:          seq,
+		connectionID: akid.NewConnectionID(uuid.UUID(bidiID)),
+		encrypted:    factory.isEncryptedFlow(bidiID, seq),
+		input:        akinet.NewRetainedInput(0),
+	}
+}
+
+// Parses TLS records until an alert, or until the flow starts sending
+// encrypted records. After that, the flow's records are skipped until the next
+// alert.
+type tlsRecordParser struct {
+	factory      *tlsRecordParserFactory
+	bidiID       akinet.TCPBidiID
+	seq          reassembly.Sequence
+	connectionID akid.ConnectionID
+
+	// The input, which is returned as unused if the records can't be parsed.
+	// On an encrypted flow, where records are skipped until the next alert,
+	// input beyond its limit is let go of.
+	input akinet.RetainedInput
+
+	// Input that has not been consumed yet. Each record is dropped once it has
+	// been read.
+	allInput memview.MemView
+
+	changeCipherSpecSeen bool
+
+	// Whether the flow has already started sending encrypted records.
+	encrypted bool
+}
+
+var _ akinet.TCPParser = (*tlsRecordParser)(nil)
+
+func (*tlsRecordParser) Name() string {
+	return "TLS Record Parser"
+}
+
+func (p *tlsRecordParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	p.input.Append(input)
+	p.allInput.Append(input)
+
+	result, err = p.parse(isEnd)
+	if err != nil {
+		return nil, p.input.Unused(p.allInput), err
+	}
+	if result == nil {
+		if isEnd {
+			return nil, p.input.Unused(p.allInput), io.ErrUnexpectedEOF
+		}
+		return nil, memview.MemView{}, nil
+	}
+	return result, p.allInput, nil
+}
+
+func (p *tlsRecordParser) parse(isEnd bool) (akinet.ParsedNetworkContent, error) {
+	for p.allInput.Len() >= tlsRecordHeaderLength_bytes {
+		if !isPlausibleRecordHeader(p.allInput) {
+			return nil, errors.New("malformed TLS record header")
+		}
+		recordLen := int64(p.allInput.GetUint16(tlsRecordHeaderLength_bytes - 2))
+		recordEnd := tlsRecordHeaderLength_bytes + recordLen
+		if p.allInput.Len() < recordEnd {
+			return nil, nil
+		}
+
+		typ := recordType(p.allInput.GetByte(0))
+		if typ == handshakeRecordType && (isClientHello(p.allInput) || isServerHello(p.allInput)) {
+			// Leave the Hello for its own parser.
+			return nil, errors.New("unexpected Hello after other TLS records")
+		}
+
+		// In TLS 1.3, everything after the Hellos is sent as application data,
+		// including alerts. Wait to see whether anything follows a record that
+		// could be an alert.
+		maybeAlert := typ == applicationDataRecordType && !p.encrypted && recordLen == encryptedTLS13AlertLength_bytes
+		if maybeAlert && p.allInput.Len() == recordEnd && !isEnd {
+			return nil, nil
+		}
+
+		record := p.allInput.SubView(tlsRecordHeaderLength_bytes, recordEnd)
+		p.allInput = p.allInput.SubView(recordEnd, p.allInput.Len())
+
+		switch typ {
+		case alertRecordType:
+			// Alerts sent after ChangeCipherSpec in TLS 1.2 are encrypted.
+			if recordLen != alertLength_bytes {
+				return akinet.TLSAlert{ConnectionID: p.connectionID, Encrypted: true}, nil
+			}
+			return akinet.TLSAlert{
+				ConnectionID: p.connectionID,
+				Level:        akinet.TLSAlertLevel(record.GetByte(0)),
+				Description:  akinet.TLSAlertDescription(record.GetByte(1)),
+			}, nil
+
+		case changeCipherSpecRecordType:
+			p.changeCipherSpecSeen = true
+
+		case handshakeRecordType:
+			// In TLS 1.2, the Finished message that follows ChangeCipherSpec is the
+			// first encrypted record. Other handshake records are unencrypted.
+			if p.changeCipherSpecSeen && !p.encrypted {
+				return p.encryptionStarted(), nil
+			}
+
+		case applicationDataRecordType:
+			if p.encrypted {
+				continue
+			}
+			if maybeAlert && p.allInput.Len() == 0 {
+				return akinet.TLSAlert{ConnectionID: p.connectionID, Encrypted: true}, nil
+			}
+			return p.encryptionStarted(), nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *tlsRecordParser) encryptionStarted() akinet.ParsedNetworkContent {
+	p.encrypted = true
+	p.factory.addEncryptedFlow(p.bidiID, p.seq)
+	return akinet.TLSEncryptionStarted{
+		ConnectionID:     p.connectionID,
+		ChangeCipherSpec: p.changeCipherSpecSeen,
+	}
+}
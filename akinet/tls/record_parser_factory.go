@@ -0,0 +1,160 @@
+package tls
+
+import (
+	"sync"
+
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a parser factory for the TLS records that follow the Client and
+// Server Hellos on either half of a connection. The parsers report alerts, and
+// report when each side starts sending encrypted records. See
+// akinet.TLSHandshakeMetadata.Outcome.
+//
+// The returned factory doesn't accept Hellos, so it should be used alongside
+// the factories returned by NewTLSClientParserFactory and
+// NewTLSServerParserFactory. It remembers which flows have started sending
+// encrypted records, so the same factory must be used for both flows of a
+// connection.
+func NewTLSRecordParserFactory() akinet.TCPParserFactory {
+	return &tlsRecordParserFactory{
+		connections: akinet.NewConnectionMap(connectionIdleTimeout, nil),
+	}
+}
+
+type tlsRecordParserFactory struct {
+	mu          sync.Mutex
+	connections *akinet.ConnectionMap // of *recordConnection, protected by mu
+}
+
+// The state of a connection. Protected by tlsRecordParserFactory.mu.
+type recordConnection struct {
+	// The last sequence numbers seen on the flows that have started sending
+	// encrypted records.
+	encryptedFlows []reassembly.Sequence
+}
+
+func (*tlsRecordParserFactory) Name() string {
+	return "TLS Record Parser Factory"
+}
+
+func (factory *tlsRecordParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	decision, discardFront = factory.accepts(input)
+
+	if decision == akinet.NeedMoreData && isEnd {
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+
+	return decision, discardFront
+}
+
+func (*tlsRecordParserFactory) accepts(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	if input.Len() < tlsRecordHeaderLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+	if !isPlausibleRecordHeader(input) {
+		return akinet.Reject, input.Len()
+	}
+
+	switch recordType(input.GetByte(0)) {
+	case handshakeRecordType:
+		// Leave Hellos to their own parsers.
+		if input.Len() < minTLSClientHelloLength_bytes {
+			return akinet.NeedMoreData, 0
+		}
+		if isClientHello(input) || isServerHello(input) {
+			return akinet.Reject, input.Len()
+		}
+
+	case changeCipherSpecRecordType:
+		// Leave a ChangeCipherSpec that precedes a Hello to the Hello's parser. See
+		// acceptsAfterChangeCipherSpec.
+		decision, _ := acceptsAfterChangeCipherSpec(input, acceptsHello)
+		switch decision {
+		case akinet.NeedMoreData:
+			return akinet.NeedMoreData, 0
+		case akinet.Accept:
+			return akinet.Reject, input.Len()
+		}
+	}
+
+	return akinet.Accept, 0
+}
+
+func (factory *tlsRecordParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newTLSRecordParser(factory, id, seq)
+}
+
+// Determines whether the input starts with a Client or Server Hello.
+func acceptsHello(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	if input.Len() > 0 && recordType(input.GetByte(0)) != handshakeRecordType {
+		return akinet.Reject, input.Len()
+	}
+	if input.Len() < minTLSClientHelloLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+	if isClientHello(input) || isServerHello(input) {
+		return akinet.Accept, 0
+	}
+	return akinet.Reject, input.Len()
+}
+
+// Applies accepts to the input, after skipping a ChangeCipherSpec record at
+// the start of the input. In TLS 1.3, either side may send a ChangeCipherSpec
+// after a HelloRetryRequest, immediately followed by its Hello.
+func acceptsAfterChangeCipherSpec(input memview.MemView, accepts func(memview.MemView) (akinet.AcceptDecision, int64)) (decision akinet.AcceptDecision, discardFront int64) {
+	if input.Len() < tlsRecordHeaderLength_bytes || recordType(input.GetByte(0)) != changeCipherSpecRecordType {
+		return accepts(input)
+	}
+
+	recordEnd := tlsRecordHeaderLength_bytes + int64(input.GetUint16(tlsRecordHeaderLength_bytes-2))
+	if input.Len() <= recordEnd {
+		return akinet.NeedMoreData, 0
+	}
+
+	decision, discardFront = accepts(input.SubView(recordEnd, input.Len()))
+	switch decision {
+	case akinet.Accept:
+		return akinet.Accept, recordEnd + discardFront
+	case akinet.NeedMoreData:
+		return akinet.NeedMoreData, 0
+	}
+	return akinet.Reject, input.Len()
+}
+
+// Calls f with the state of the given connection, creating it if necessary.
+func (factory *tlsRecordParserFactory) withConnection(id akinet.TCPBidiID, f func(*recordConnection)) {
+	factory.mu.Lock()
+	defer factory.mu.Unlock()
+
+	f(factory.connections.GetOrCreate(id, func() interface{} { return &recordConnection{} }).(*recordConnection))
+}
+
+// Determines whether the flow with the given sequence number has already
+// started sending encrypted records. If so, its sequence number is updated.
+func (factory *tlsRecordParserFactory) isEncryptedFlow(id akinet.TCPBidiID, seq reassembly.Sequence) (encrypted bool) {
+	factory.withConnection(id, func(conn *recordConnection) {
+		for i, flowSeq := range conn.encryptedFlows {
+			if d := seq.Difference(flowSeq); -maxSeqDistance < d && d < maxSeqDistance {
+				conn.encryptedFlows[i] = seq
+				encrypted = true
+				return
+			}
+		}
+	})
+	return encrypted
+}
+
+// Records that the flow with the given sequence number has started sending
+// encrypted records.
+func (factory *tlsRecordParserFactory) addEncryptedFlow(id akinet.TCPBidiID, seq reassembly.Sequence) {
+	factory.withConnection(id, func(conn *recordConnection) {
+		if len(conn.encryptedFlows) < 2 {
+			conn.encryptedFlows = append(conn.encryptedFlows, seq)
+		}
+	})
+}
@@ -0,0 +1,314 @@
+package tls
+
+import (
+	"bytes"
+	gotls "crypto/tls"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/akinet/internal/parsertest"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var testConnectionID = akid.NewConnectionID(uuid.UUID(testBidiID))
+
+func newHandshakeSelector() akinet.TCPParserFactorySelector {
+	return akinet.TCPParserFactorySelector{
+		NewTLSClientParserFactory(),
+		NewTLSServerParserFactory(),
+		NewTLSRecordParserFactory(),
+	}
+}
+
+// Builds the handshake metadata for a connection from the parsed results on
+// both of its flows.
+func handshakeMetadata(t *testing.T, results ...akinet.ParsedNetworkContent) *akinet.TLSHandshakeMetadata {
+	metadata := &akinet.TLSHandshakeMetadata{ConnectionID: testConnectionID}
+	for _, r := range results {
+		var err error
+		switch r := r.(type) {
+		case akinet.TLSClientHello:
+			err = metadata.AddClientHello(&r)
+		case akinet.TLSServerHello:
+			err = metadata.AddServerHello(&r)
+		case akinet.TLSAlert:
+			err = metadata.AddAlert(&r)
+		case akinet.TLSEncryptionStarted:
+			err = metadata.AddEncryptionStarted(&r)
+		default:
+			t.Fatalf("unexpected result %T", r)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return metadata
+}
+
+func serverHelloRecord(random []byte, extensions ...[]byte) []byte {
+	record := handshakeRecord(0x02, bytes.Join([][]byte{
+		uint16s(0x0303),
+		random,
+		vec8(),
+		uint16s(0x1301),
+		{0},
+		vec16(extensions...),
+	}, nil))
+	record[1], record[2] = 0x03, 0x03
+	return record
+}
+
+func clientHelloRecord() []byte {
+	return handshakeRecord(0x01, bytes.Join([][]byte{
+		uint16s(0x0303),
+		make([]byte, clientRandomLength_bytes),
+		vec8(),
+		vec16(uint16s(0x1301)),
+		vec8([]byte{0}),
+		vec16(extension(0x002b, vec8(uint16s(0x0304)))),
+	}, nil))
+}
+
+func record(typ recordType, body []byte) []byte {
+	return append([]byte{byte(typ), 0x03, 0x03, byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+func TestHandshakeSuccess(t *testing.T) {
+	configs := map[string]*gotls.Config{
+		"TLS 1.2": {MaxVersion: gotls.VersionTLS12},
+		"TLS 1.3": {MinVersion: gotls.VersionTLS13},
+	}
+
+	for name, config := range configs {
+		capture, _ := captureHTTPSExchange(t, config)
+
+		selector := newHandshakeSelector()
+		client := &flowParser{selector: selector, seq: 1000}
+		server := &flowParser{selector: selector, seq: 500000000}
+		for _, chunk := range capture.chunks {
+			if chunk.fromClient {
+				client.feed(chunk.data, false)
+			} else {
+				server.feed(chunk.data, false)
+			}
+		}
+
+		metadata := handshakeMetadata(t, append(client.results, server.results...)...)
+		if outcome := metadata.Outcome(); outcome != akinet.TLSHandshakeSuccess {
+			t.Errorf("[%s] expected a successful handshake, got %s", name, outcome)
+		}
+		if metadata.Alert != nil {
+			t.Errorf("[%s] unexpected alert: %+v", name, metadata.Alert)
+		}
+	}
+}
+
+func TestHandshakeFailure(t *testing.T) {
+	serverHello := serverHelloRecord(make([]byte, serverRandomLength_bytes), extension(0x002b, uint16s(0x0304)))
+	connectionClosed := &akinet.TCPConnectionMetadata{ConnectionID: testConnectionID, EndState: akinet.ConnectionClosed}
+
+	testCases := []struct {
+		name            string
+		client, server  [][]byte
+		closed          bool
+		expected        akinet.TLSHandshakeOutcome
+		expectedAlert   *akinet.TLSAlert
+		expectedResults []akinet.ParsedNetworkContent
+	}{
+		{
+			name:     "protocol version alert",
+			client:   [][]byte{clientHelloRecord()},
+			server:   [][]byte{record(alertRecordType, []byte{2, 70})},
+			expected: akinet.TLSHandshakeAlert,
+			expectedAlert: &akinet.TLSAlert{
+				ConnectionID: testConnectionID,
+				Level:        akinet.TLSAlertFatal,
+				Description:  akinet.TLSAlertProtocolVersion,
+			},
+		},
+		{
+			name:     "encrypted alert from client",
+			client:   [][]byte{clientHelloRecord(), record(changeCipherSpecRecordType, []byte{1}), record(applicationDataRecordType, make([]byte, 19))},
+			server:   [][]byte{serverHello, record(changeCipherSpecRecordType, []byte{1}), record(applicationDataRecordType, make([]byte, 100))},
+			closed:   true,
+			expected: akinet.TLSHandshakeAlert,
+			expectedAlert: &akinet.TLSAlert{
+				ConnectionID: testConnectionID,
+				Encrypted:    true,
+			},
+		},
+		{
+			name:   "short application data record",
+			client: [][]byte{clientHelloRecord(), record(applicationDataRecordType, make([]byte, 100))},
+			server: [][]byte{
+				serverHello,
+				record(applicationDataRecordType, make([]byte, 19)),
+				record(applicationDataRecordType, make([]byte, 100)),
+			},
+			closed:   true,
+			expected: akinet.TLSHandshakeSuccess,
+		},
+		{
+			name:   "warning alert during handshake",
+			client: [][]byte{clientHelloRecord(), record(changeCipherSpecRecordType, []byte{1}), record(handshakeRecordType, make([]byte, 40))},
+			server: [][]byte{
+				serverHello,
+				record(alertRecordType, []byte{1, 100}),
+				record(changeCipherSpecRecordType, []byte{1}),
+				record(handshakeRecordType, make([]byte, 40)),
+			},
+			expected: akinet.TLSHandshakeSuccess,
+		},
+		{
+			// The client's close_notify is seen before the server finishes the
+			// handshake.
+			name: "encrypted close_notify during handshake",
+			client: [][]byte{
+				clientHelloRecord(),
+				record(changeCipherSpecRecordType, []byte{1}),
+				record(handshakeRecordType, make([]byte, 40)),
+				record(alertRecordType, make([]byte, 26)),
+			},
+			server: [][]byte{
+				serverHello,
+				record(changeCipherSpecRecordType, []byte{1}),
+				record(handshakeRecordType, make([]byte, 40)),
+			},
+			expected: akinet.TLSHandshakeSuccess,
+			expectedAlert: &akinet.TLSAlert{
+				ConnectionID: testConnectionID,
+				Encrypted:    true,
+			},
+		},
+		{
+			name:     "connection closed after Client Hello",
+			client:   [][]byte{clientHelloRecord()},
+			closed:   true,
+			expected: akinet.TLSHandshakeAborted,
+		},
+		{
+			name:     "connection open after Client Hello",
+			client:   [][]byte{clientHelloRecord()},
+			expected: akinet.TLSHandshakeUnknown,
+		},
+		{
+			name: "close_notify after success",
+			client: [][]byte{
+				clientHelloRecord(),
+				record(applicationDataRecordType, make([]byte, 100)),
+				record(applicationDataRecordType, make([]byte, 200)),
+			},
+			server: [][]byte{
+				serverHello,
+				record(applicationDataRecordType, make([]byte, 100)),
+				record(alertRecordType, []byte{1, 0}),
+			},
+			expected: akinet.TLSHandshakeSuccess,
+		},
+	}
+
+	for _, c := range testCases {
+		selector := newHandshakeSelector()
+		client := &flowParser{selector: selector, seq: 1000}
+		server := &flowParser{selector: selector, seq: 500000000}
+		for _, r := range c.client {
+			client.feed(r, false)
+		}
+		for _, r := range c.server {
+			server.feed(r, false)
+		}
+		if c.closed {
+			client.feed(nil, true)
+			server.feed(nil, true)
+		}
+
+		metadata := handshakeMetadata(t, append(client.results, server.results...)...)
+		if c.closed {
+			if err := metadata.AddTCPConnectionMetadata(connectionClosed); err != nil {
+				t.Fatalf("[%s] unexpected error: %v", c.name, err)
+			}
+		}
+
+		if outcome := metadata.Outcome(); outcome != c.expected {
+			t.Errorf("[%s] expected outcome %s, got %s", c.name, c.expected, outcome)
+		}
+		connectionIDs := cmp.Comparer(func(a, b akid.ConnectionID) bool { return a == b })
+		if diff := cmp.Diff(c.expectedAlert, metadata.Alert, connectionIDs); diff != "" {
+			t.Errorf("[%s] found unexpected diff in alert:\n%s", c.name, diff)
+		}
+	}
+}
+
+func TestHelloRetryRequest(t *testing.T) {
+	changeCipherSpec := record(changeCipherSpecRecordType, []byte{1})
+	encrypted := record(applicationDataRecordType, make([]byte, 64))
+
+	selector := newHandshakeSelector()
+	client := &flowParser{selector: selector, seq: 1000}
+	server := &flowParser{selector: selector, seq: 500000000}
+
+	client.feed(clientHelloRecord(), false)
+	server.feed(serverHelloRecord(helloRetryRequestRandom, extension(0x002b, uint16s(0x0304)), extension(0x0033, uint16s(0x0017))), false)
+	client.feed(append(changeCipherSpec, clientHelloRecord()...), false)
+	server.feed(bytes.Join([][]byte{
+		changeCipherSpec,
+		serverHelloRecord(make([]byte, serverRandomLength_bytes), extension(0x002b, uint16s(0x0304))),
+		encrypted,
+	}, nil), false)
+	client.feed(encrypted, false)
+
+	for _, err := range append(client.errs, server.errs...) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	var hellos []bool
+	for _, r := range server.results {
+		if hello, ok := r.(akinet.TLSServerHello); ok {
+			hellos = append(hellos, hello.HelloRetryRequest)
+		}
+	}
+	if diff := cmp.Diff([]bool{true, false}, hellos, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found unexpected diff in Server Hellos:\n%s", diff)
+	}
+
+	// The HelloRetryRequest must be seen before the second Client Hello.
+	metadata := handshakeMetadata(t, append(server.results, client.results...)...)
+	if !metadata.HelloRetryRequest {
+		t.Error("expected HelloRetryRequest to be recorded")
+	}
+	if outcome := metadata.Outcome(); outcome != akinet.TLSHandshakeSuccess {
+		t.Errorf("expected a successful handshake, got %s", outcome)
+	}
+}
+
+func TestRecordErrorReturnsAllInput(t *testing.T) {
+	changeCipherSpec := record(changeCipherSpecRecordType, []byte{1})
+	encrypted := record(applicationDataRecordType, make([]byte, 64))
+
+	// Once the client has sent an encrypted record, its records are skipped.
+	factory := NewTLSRecordParserFactory()
+	if _, _, err := factory.CreateParser(testBidiID, 1000, 0).Parse(memview.New(encrypted), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testCases := []parsertest.ErrorTestCase{
+		{
+			Name:  "malformed record header",
+			Seq:   500000000,
+			Input: [][]byte{changeCipherSpec, {0xff, 0x03, 0x03, 0x00, 0x01}},
+		},
+		{
+			Name:  "end of input on encrypted flow",
+			Seq:   1000 + reassembly.Sequence(len(encrypted)),
+			Input: [][]byte{encrypted, encrypted[:10]},
+			IsEnd: true,
+		},
+	}
+	parsertest.RunErrorTests(t, factory, testBidiID, testCases)
+}
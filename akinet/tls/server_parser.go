@@ -1,6 +1,7 @@
 package tls
 
 import (
+	"bytes"
 	"crypto/x509"
 	"io"
 
@@ -20,6 +21,10 @@ func newTLSServerHelloParser(bidiID akinet.TCPBidiID) *tlsServerHelloParser {
 type tlsServerHelloParser struct {
 	connectionID akid.ConnectionID
 	allInput     memview.MemView
+
+	// The server random, once the Server Hello has been parsed. Used to derive
+	// the connection's keys when decrypting.
+	random []byte
 }
 
 var _ akinet.TCPParser = (*tlsServerHelloParser)(nil)
@@ -69,11 +74,15 @@ func (parser *tlsServerHelloParser) parse(input memview.MemView, isEnd bool) (re
 	buf := parser.allInput.SubView(tlsRecordHeaderLength_bytes, handshakeMsgEndPos)
 	reader := buf.CreateReader()
 
-	// Seek past some headers.
-	_, err = reader.Seek(handshakeHeaderLength_bytes+serverVersionLength_bytes+serverRandomLength_bytes, io.SeekCurrent)
+	// Seek past some headers, and read the server random.
+	_, err = reader.Seek(handshakeHeaderLength_bytes+serverVersionLength_bytes, io.SeekCurrent)
 	if err != nil {
 		return nil, 0, err
 	}
+	random := make([]byte, serverRandomLength_bytes)
+	if n, err := reader.Read(random); n != serverRandomLength_bytes || err != nil {
+		return nil, 0, errors.New("malformed TLS message")
+	}
 
 	// Now at the session ID, which is a variable-length vector. Seek past this.
 	// The first byte indicates the vector's length in bytes.
@@ -82,8 +91,12 @@ func (parser *tlsServerHelloParser) parse(input memview.MemView, isEnd bool) (re
 		return nil, 0, err
 	}
 
-	// Seek past more headers.
-	_, err = reader.Seek(serverCiphersuiteLength_bytes+serverCompressionMethodLength_bytes, io.SeekCurrent)
+	// Read the selected cipher suite, and seek past the compression method.
+	cipherSuite, err := reader.ReadUint16()
+	if err != nil {
+		return nil, 0, err
+	}
+	_, err = reader.Seek(serverCompressionMethodLength_bytes, io.SeekCurrent)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -95,9 +108,14 @@ func (parser *tlsServerHelloParser) parse(input memview.MemView, isEnd bool) (re
 		return nil, 0, errors.New("malformed TLS message")
 	}
 
+	// The number of bytes taken up by the Server Hello and, for TLS 1.2, the
+	// Certificate message.
+	numBytesConsumed = handshakeMsgEndPos
+
 	selectedVersion := akinet.TLS_v1_2
 	selectedProtocol := (*string)(nil)
 	dnsNames := ([]string)(nil)
+	certificates := ([]akinet.TLSCertificate)(nil)
 
 	for {
 		// The first two bytes of the extension give the extension type.
@@ -174,6 +192,7 @@ func (parser *tlsServerHelloParser) parse(input memview.MemView, isEnd bool) (re
 		}
 
 		// Get a Memview of the handshake record.
+		numBytesConsumed += handshakeMsgEndPos
 		buf = buf.SubView(tlsRecordHeaderLength_bytes, handshakeMsgEndPos)
 		reader := buf.CreateReader()
 
@@ -201,43 +220,57 @@ func (parser *tlsServerHelloParser) parse(input memview.MemView, isEnd bool) (re
 			return nil, 0, errors.New("expected a TLS message containing the server's certificate, but found a malformed certificate handshake message")
 		}
 
-		// The first certificate is the one that was issued to the server, so we
-		// only need to look at that.
+		// The certificates follow, starting with the one that was issued to the
+		// server. Each is preceded by its length in three bytes.
+		for {
+			var certLen_bytes int64
+			{
+				val, err := reader.ReadUint24()
+				if err == io.EOF {
+					// Out of certificates.
+					break
+				} else if err != nil {
+					return nil, 0, errors.New("expected a TLS message containing the server's certificate, but found a malformed certificate handshake message")
+				}
+				certLen_bytes = int64(val)
+			}
 
-		// The next three bytes gives the length of the first certificate.
-		var certLen_bytes int64
-		{
-			val, err := reader.ReadUint24()
-			if err != nil {
+			// Extract the certificate.
+			certBytes := make([]byte, certLen_bytes)
+			read, err := reader.Read(certBytes)
+			if read != int(certLen_bytes) || err != nil {
 				return nil, 0, errors.New("expected a TLS message containing the server's certificate, but found a malformed certificate handshake message")
 			}
-			certLen_bytes = int64(val)
-		}
 
-		// Extract the first certificate.
-		certBytes := make([]byte, certLen_bytes)
-		read, err := reader.Read(certBytes)
-		if read != int(certLen_bytes) || err != nil {
-			return nil, 0, errors.New("expected a TLS message containing the server's certificate, but found a malformed certificate handshake message")
-		}
+			cert, err := x509.ParseCertificate(certBytes)
+			if err != nil {
+				if len(certificates) == 0 {
+					return nil, 0, errors.Wrap(err, "error parsing server certificate")
+				}
+				// Intermediate certificates are only informational, so skip any that we
+				// can't parse.
+				continue
+			}
 
-		var cert *x509.Certificate
-		cert, err = x509.ParseCertificate(certBytes)
-		if err != nil {
-			return nil, 0, errors.Wrap(err, "error parsing server certificate")
+			if len(certificates) == 0 {
+				dnsNames = cert.DNSNames
+			}
+			certificates = append(certificates, akinet.NewTLSCertificate(cert))
 		}
-
-		dnsNames = cert.DNSNames
 	}
 
 	hello := akinet.TLSServerHello{
-		ConnectionID:     parser.connectionID,
-		Version:          selectedVersion,
-		SelectedProtocol: selectedProtocol,
-		DNSNames:         dnsNames,
+		ConnectionID:      parser.connectionID,
+		Version:           selectedVersion,
+		CipherSuite:       cipherSuite,
+		HelloRetryRequest: bytes.Equal(random, helloRetryRequestRandom),
+		SelectedProtocol:  selectedProtocol,
+		DNSNames:          dnsNames,
+		Certificates:      certificates,
 	}
 
-	return hello, handshakeMsgEndPos, nil
+	parser.random = random
+	return hello, numBytesConsumed, nil
 }
 
 // Extracts the server-selected TLS version from a buffer containing a TLS
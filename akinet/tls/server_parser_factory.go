@@ -18,7 +18,7 @@ func (*tlsServerParserFactory) Name() string {
 }
 
 func (factory *tlsServerParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
-	decision, discardFront = factory.accepts(input)
+	decision, discardFront = acceptsAfterChangeCipherSpec(input, factory.accepts)
 
 	if decision == akinet.NeedMoreData && isEnd {
 		decision = akinet.Reject
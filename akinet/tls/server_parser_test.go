@@ -0,0 +1,129 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns a DER-encoded certificate for the given template, signed by parent,
+// or self-signed if parent is nil.
+func makeCertificate(t *testing.T, template *x509.Certificate, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) []byte {
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func uint24(n int) []byte {
+	return []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+}
+
+func TestServerCertificateChain(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER := makeCertificate(t, caTemplate, caKey, nil, nil)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(0xabcdef),
+		Subject:      pkix.Name{CommonName: "api.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		DNSNames:     []string{"api.example.com", "*.api.example.com"},
+	}
+	leafDER := makeCertificate(t, leafTemplate, leafKey, caTemplate, caKey)
+
+	serverHello := handshakeRecord(0x02, bytes.Join([][]byte{
+		uint16s(0x0303),
+		make([]byte, serverRandomLength_bytes),
+		vec8(),
+		uint16s(0xc02b),
+		{0},
+		vec16(),
+	}, nil))
+	serverHello[1], serverHello[2] = 0x03, 0x03
+
+	chain := bytes.Join([][]byte{uint24(len(leafDER)), leafDER, uint24(len(caDER)), caDER}, nil)
+	certificate := handshakeRecord(0x0b, append(uint24(len(chain)), chain...))
+	certificate[1], certificate[2] = 0x03, 0x03
+
+	input := append(serverHello, certificate...)
+	factory := NewTLSServerParserFactory()
+	if decision, _ := factory.Accepts(memview.New(input), true); decision != akinet.Accept {
+		t.Fatalf("expected factory to accept, got %s", decision)
+	}
+	result, _, err := factory.CreateParser(testBidiID, 0, 0).Parse(memview.New(input), true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hello, ok := result.(akinet.TLSServerHello)
+	if !ok {
+		t.Fatalf("expected a TLSServerHello, got %T", result)
+	}
+
+	expected := []akinet.TLSCertificate{
+		{
+			Subject:            "CN=api.example.com",
+			Issuer:             "CN=Test CA",
+			SerialNumber:       "abcdef",
+			NotBefore:          notBefore,
+			NotAfter:           notAfter,
+			KeyType:            "ECDSA",
+			KeySize_bits:       256,
+			SignatureAlgorithm: "ECDSA-SHA384",
+			DNSNames:           []string{"api.example.com", "*.api.example.com"},
+		},
+		{
+			Subject:            "CN=Test CA",
+			Issuer:             "CN=Test CA",
+			SerialNumber:       "1",
+			NotBefore:          notBefore,
+			NotAfter:           notAfter,
+			KeyType:            "ECDSA",
+			KeySize_bits:       384,
+			SignatureAlgorithm: "ECDSA-SHA384",
+			IsCA:               true,
+			SelfSigned:         true,
+		},
+	}
+	if diff := cmp.Diff(expected, hello.Certificates, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found unexpected diff:\n%s", diff)
+	}
+	if diff := cmp.Diff(expected[0].DNSNames, hello.DNSNames); diff != "" {
+		t.Errorf("found unexpected diff in DNS names:\n%s", diff)
+	}
+}
@@ -0,0 +1,243 @@
+package akinet
+
+import (
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Describes a certificate in the chain sent by a TLS server.
+type TLSCertificate struct {
+	Subject string
+	Issuer  string
+
+	// The serial number, in lowercase hex.
+	SerialNumber string
+
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// The type of the public key: "RSA", "ECDSA", "Ed25519" or "DSA". Empty if
+	// the key type is not known.
+	KeyType string
+
+	// The size of the public key. For RSA and DSA keys, this is the size of the
+	// modulus; for ECDSA keys, the size of the curve.
+	KeySize_bits int
+
+	// The algorithm used by the issuer to sign the certificate, e.g.
+	// "SHA256-RSA".
+	SignatureAlgorithm string
+
+	// The DNS names in the certificate's SAN extension.
+	DNSNames []string
+
+	IsCA bool
+
+	// Whether the certificate is signed by its own key.
+	SelfSigned bool
+}
+
+// Extracts the metadata of a parsed X.509 certificate.
+func NewTLSCertificate(cert *x509.Certificate) TLSCertificate {
+	result := TLSCertificate{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		DNSNames:           append([]string(nil), cert.DNSNames...),
+		IsCA:               cert.IsCA,
+	}
+	if cert.SerialNumber != nil {
+		result.SerialNumber = cert.SerialNumber.Text(16)
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		result.KeyType, result.KeySize_bits = "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		result.KeyType, result.KeySize_bits = "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		result.KeyType, result.KeySize_bits = "Ed25519", 256
+	case *dsa.PublicKey:
+		result.KeyType, result.KeySize_bits = "DSA", key.P.BitLen()
+	}
+
+	result.SelfSigned = cert.Subject.String() == cert.Issuer.String() && checkOwnSignature(cert) == nil
+	return result
+}
+
+// Checks that the certificate is signed by its own key. Go's CheckSignature
+// refuses MD5 signatures as insecure, but those are still found on old
+// self-signed certificates, so they are checked here instead.
+func checkOwnSignature(cert *x509.Certificate) error {
+	if cert.SignatureAlgorithm != x509.MD5WithRSA {
+		return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+	}
+
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.Errorf("signature algorithm %s doesn't match the public key", cert.SignatureAlgorithm)
+	}
+	digest := md5.Sum(cert.RawTBSCertificate)
+	return rsa.VerifyPKCS1v15(key, crypto.MD5, digest[:], cert.Signature)
+}
+
+// A problem found with a server's certificate chain.
+type TLSCertificateIssue string
+
+const (
+	TLSCertificateExpired          TLSCertificateIssue = "expired"
+	TLSCertificateExpiringSoon     TLSCertificateIssue = "expiring_soon"
+	TLSCertificateNotYetValid      TLSCertificateIssue = "not_yet_valid"
+	TLSCertificateSelfSigned       TLSCertificateIssue = "self_signed"
+	TLSCertificateWeakKey          TLSCertificateIssue = "weak_key"
+	TLSCertificateWeakSignature    TLSCertificateIssue = "weak_signature"
+	TLSCertificateHostnameMismatch TLSCertificateIssue = "hostname_mismatch"
+)
+
+type TLSCertificateFinding struct {
+	Issue TLSCertificateIssue
+
+	// The position of the affected certificate in the chain. The server's own
+	// certificate is at index 0.
+	CertificateIndex int
+
+	// A human-readable description of the problem.
+	Detail string
+}
+
+// Configures AnalyzeCertificates.
+type TLSCertificateAnalysisOptions struct {
+	// The time at which validity is checked. Defaults to the current time.
+	Now time.Time
+
+	// Certificates that expire within this long are flagged as expiring soon.
+	// Defaults to 30 days.
+	ExpiryWarning time.Duration
+
+	// The smallest RSA or DSA key that is not considered weak. Defaults to 2048
+	// bits.
+	MinRSAKeySize_bits int
+
+	// The smallest ECDSA key that is not considered weak. Defaults to 256
+	// bits.
+	MinECDSAKeySize_bits int
+}
+
+const (
+	defaultExpiryWarning        = 30 * 24 * time.Hour
+	defaultMinRSAKeySize_bits   = 2048
+	defaultMinECDSAKeySize_bits = 256
+)
+
+// Signature algorithms that rely on broken hash functions.
+var weakSignatureAlgorithms = map[string]struct{}{
+	x509.MD2WithRSA.String():    {},
+	x509.MD5WithRSA.String():    {},
+	x509.SHA1WithRSA.String():   {},
+	x509.DSAWithSHA1.String():   {},
+	x509.ECDSAWithSHA1.String(): {},
+}
+
+// Checks the certificate chain of the handshake for expired, soon-to-expire
+// and not-yet-valid certificates, weak keys and signatures, and a self-signed
+// server certificate. Also checks that the SNI hostname matches the SANs of
+// the server's certificate.
+//
+// Returns nil if no certificates were observed, as is always the case for
+// TLS 1.3.
+func (tls *TLSHandshakeMetadata) AnalyzeCertificates(opts TLSCertificateAnalysisOptions) []TLSCertificateFinding {
+	if len(tls.CertificateChain) == 0 {
+		return nil
+	}
+
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	if opts.ExpiryWarning == 0 {
+		opts.ExpiryWarning = defaultExpiryWarning
+	}
+	if opts.MinRSAKeySize_bits == 0 {
+		opts.MinRSAKeySize_bits = defaultMinRSAKeySize_bits
+	}
+	if opts.MinECDSAKeySize_bits == 0 {
+		opts.MinECDSAKeySize_bits = defaultMinECDSAKeySize_bits
+	}
+
+	var findings []TLSCertificateFinding
+	add := func(issue TLSCertificateIssue, idx int, format string, args ...interface{}) {
+		findings = append(findings, TLSCertificateFinding{
+			Issue:            issue,
+			CertificateIndex: idx,
+			Detail:           fmt.Sprintf(format, args...),
+		})
+	}
+
+	for idx, cert := range tls.CertificateChain {
+		switch {
+		case opts.Now.After(cert.NotAfter):
+			add(TLSCertificateExpired, idx, "certificate for %q expired at %s", cert.Subject, cert.NotAfter.Format(time.RFC3339))
+		case opts.Now.Before(cert.NotBefore):
+			add(TLSCertificateNotYetValid, idx, "certificate for %q is not valid until %s", cert.Subject, cert.NotBefore.Format(time.RFC3339))
+		case cert.NotAfter.Sub(opts.Now) < opts.ExpiryWarning:
+			add(TLSCertificateExpiringSoon, idx, "certificate for %q expires at %s", cert.Subject, cert.NotAfter.Format(time.RFC3339))
+		}
+
+		minSize := 0
+		switch cert.KeyType {
+		case "RSA", "DSA":
+			minSize = opts.MinRSAKeySize_bits
+		case "ECDSA":
+			minSize = opts.MinECDSAKeySize_bits
+		}
+		if cert.KeySize_bits < minSize {
+			add(TLSCertificateWeakKey, idx, "certificate for %q has a %d-bit %s key", cert.Subject, cert.KeySize_bits, cert.KeyType)
+		}
+
+		// The signature on a self-signed root is never checked, so its algorithm
+		// doesn't matter.
+		if _, weak := weakSignatureAlgorithms[cert.SignatureAlgorithm]; weak && !cert.SelfSigned {
+			add(TLSCertificateWeakSignature, idx, "certificate for %q is signed with %s", cert.Subject, cert.SignatureAlgorithm)
+		}
+	}
+
+	leaf := tls.CertificateChain[0]
+	if leaf.SelfSigned {
+		add(TLSCertificateSelfSigned, 0, "certificate for %q is self-signed", leaf.Subject)
+	}
+	if tls.SNIHostname != nil && !MatchesCertificateHostname(*tls.SNIHostname, leaf.DNSNames) {
+		add(TLSCertificateHostnameMismatch, 0, "SNI hostname %q does not match the certificate's names %v", *tls.SNIHostname, leaf.DNSNames)
+	}
+
+	return findings
+}
+
+// Determines whether hostname matches one of the given DNS names from a
+// certificate. A wildcard may only be the whole of the leftmost label, and
+// matches exactly one label.
+func MatchesCertificateHostname(hostname string, dnsNames []string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	for _, name := range dnsNames {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name == hostname {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") {
+			if i := strings.IndexByte(hostname, '.'); i > 0 && hostname[i:] == name[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+package akinet
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestAnalyzeCertificates(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	hostname := "api.example.com"
+
+	goodLeaf := TLSCertificate{
+		Subject:            "CN=api.example.com",
+		Issuer:             "CN=Test CA",
+		NotBefore:          now.AddDate(0, -1, 0),
+		NotAfter:           now.AddDate(1, 0, 0),
+		KeyType:            "ECDSA",
+		KeySize_bits:       256,
+		SignatureAlgorithm: "SHA256-RSA",
+		DNSNames:           []string{"*.example.com"},
+	}
+	root := TLSCertificate{
+		Subject:            "CN=Test CA",
+		Issuer:             "CN=Test CA",
+		NotBefore:          now.AddDate(-5, 0, 0),
+		NotAfter:           now.AddDate(5, 0, 0),
+		KeyType:            "RSA",
+		KeySize_bits:       4096,
+		SignatureAlgorithm: "SHA1-RSA",
+		IsCA:               true,
+		SelfSigned:         true,
+	}
+
+	testCases := []struct {
+		name     string
+		chain    []TLSCertificate
+		sni      *string
+		expected []TLSCertificateIssue
+	}{
+		{
+			name:  "no problems",
+			chain: []TLSCertificate{goodLeaf, root},
+			sni:   &hostname,
+		},
+		{
+			name:  "no certificates",
+			chain: nil,
+			sni:   &hostname,
+		},
+		{
+			name: "expired leaf with a weak key",
+			chain: []TLSCertificate{
+				func(c TLSCertificate) TLSCertificate {
+					c.NotAfter = now.AddDate(0, 0, -1)
+					c.KeyType = "RSA"
+					c.KeySize_bits = 1024
+					return c
+				}(goodLeaf),
+				root,
+			},
+			expected: []TLSCertificateIssue{TLSCertificateExpired, TLSCertificateWeakKey},
+		},
+		{
+			name: "expiring intermediate with a weak signature",
+			chain: []TLSCertificate{
+				goodLeaf,
+				{
+					Subject:            "CN=Intermediate",
+					Issuer:             "CN=Test CA",
+					NotBefore:          now.AddDate(-1, 0, 0),
+					NotAfter:           now.AddDate(0, 0, 10),
+					KeyType:            "RSA",
+					KeySize_bits:       2048,
+					SignatureAlgorithm: "SHA1-RSA",
+					IsCA:               true,
+				},
+			},
+			sni:      &hostname,
+			expected: []TLSCertificateIssue{TLSCertificateExpiringSoon, TLSCertificateWeakSignature},
+		},
+		{
+			name: "self-signed leaf for another host",
+			chain: []TLSCertificate{
+				func(c TLSCertificate) TLSCertificate {
+					c.Issuer = c.Subject
+					c.SelfSigned = true
+					c.DNSNames = []string{"other.example.org"}
+					return c
+				}(goodLeaf),
+			},
+			sni:      &hostname,
+			expected: []TLSCertificateIssue{TLSCertificateSelfSigned, TLSCertificateHostnameMismatch},
+		},
+	}
+
+	for _, c := range testCases {
+		metadata := TLSHandshakeMetadata{
+			SNIHostname:      c.sni,
+			CertificateChain: c.chain,
+		}
+		findings := metadata.AnalyzeCertificates(TLSCertificateAnalysisOptions{Now: now})
+
+		issues := make([]TLSCertificateIssue, 0, len(findings))
+		for _, f := range findings {
+			issues = append(issues, f.Issue)
+		}
+		if diff := cmp.Diff(c.expected, issues, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("[%s] found unexpected diff:\n%s", c.name, diff)
+		}
+	}
+}
+
+// Generates a certificate for the given subject, signed by the given parent.
+// The certificate is self-signed if parent is nil.
+func generateCertificate(t *testing.T, subject string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: subject},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		DNSNames:     []string{subject},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestNewTLSCertificateSelfSigned(t *testing.T) {
+	root, rootKey := generateCertificate(t, "Test CA", true, nil, nil)
+	leaf, _ := generateCertificate(t, "api.example.com", false, root, rootKey)
+	selfSignedLeaf, _ := generateCertificate(t, "api.example.com", false, nil, nil)
+
+	// Has the same name as the root, but is signed by another key.
+	impostor, _ := generateCertificate(t, "Test CA", true, root, rootKey)
+
+	testCases := []struct {
+		name     string
+		cert     *x509.Certificate
+		expected bool
+	}{
+		{"self-signed CA", root, true},
+		{"leaf signed by CA", leaf, false},
+		{"self-signed leaf", selfSignedLeaf, true},
+		{"issuer matches subject but signed by another key", impostor, false},
+	}
+
+	for _, c := range testCases {
+		if actual := NewTLSCertificate(c.cert).SelfSigned; actual != c.expected {
+			t.Errorf("[%s] expected SelfSigned = %v, got %v", c.name, c.expected, actual)
+		}
+	}
+}
+
+// Generates an RSA certificate that names itself as its issuer, with the
+// given signature algorithm, signed by the given key. Go won't sign with MD5,
+// so MD5 certificates are signed with SHA-256 and then re-signed.
+func generateRSACertificate(t *testing.T, algorithm x509.SignatureAlgorithm, key, signer *rsa.PrivateKey) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:       big.NewInt(1),
+		Subject:            pkix.Name{CommonName: "legacy.example.com"},
+		NotBefore:          time.Now().Add(-time.Hour),
+		NotAfter:           time.Now().Add(time.Hour),
+		SignatureAlgorithm: algorithm,
+	}
+	if algorithm == x509.MD5WithRSA {
+		template.SignatureAlgorithm = x509.SHA256WithRSA
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if algorithm == x509.MD5WithRSA {
+		// The algorithm identifiers are the same length, and the signature is at
+		// the end of the certificate.
+		sha256WithRSA := []byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x0b}
+		md5WithRSA := []byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x04}
+		der = bytes.ReplaceAll(der, sha256WithRSA, md5WithRSA)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := md5.Sum(cert.RawTBSCertificate)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, signer, crypto.MD5, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(der[len(der)-len(signature):], signature)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestNewTLSCertificateSelfSignedWithInsecureAlgorithms(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algorithm := range []x509.SignatureAlgorithm{x509.SHA1WithRSA, x509.MD5WithRSA} {
+		testCases := []struct {
+			name     string
+			signer   *rsa.PrivateKey
+			expected bool
+		}{
+			{"signed by own key", key, true},
+			{"signed by another key", otherKey, false},
+		}
+
+		for _, c := range testCases {
+			cert := generateRSACertificate(t, algorithm, key, c.signer)
+			if cert.SignatureAlgorithm != algorithm {
+				t.Fatalf("[%s, %s] expected signature algorithm %s, got %s", algorithm, c.name, algorithm, cert.SignatureAlgorithm)
+			}
+			if actual := NewTLSCertificate(cert).SelfSigned; actual != c.expected {
+				t.Errorf("[%s, %s] expected SelfSigned = %v, got %v", algorithm, c.name, c.expected, actual)
+			}
+		}
+	}
+}
+
+func TestMatchesCertificateHostname(t *testing.T) {
+	testCases := []struct {
+		hostname string
+		dnsNames []string
+		expected bool
+	}{
+		{"api.example.com", []string{"api.example.com"}, true},
+		{"API.Example.com.", []string{"api.example.com"}, true},
+		{"api.example.com", []string{"*.example.com"}, true},
+		{"a.b.example.com", []string{"*.example.com"}, false},
+		{"example.com", []string{"*.example.com"}, false},
+		{"api.example.com", []string{"www.example.com", "example.com"}, false},
+		{"api.example.com", nil, false},
+	}
+
+	for _, c := range testCases {
+		if actual := MatchesCertificateHostname(c.hostname, c.dnsNames); actual != c.expected {
+			t.Errorf("%q against %v: expected %v, got %v", c.hostname, c.dnsNames, c.expected, actual)
+		}
+	}
+}
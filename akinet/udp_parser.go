@@ -0,0 +1,55 @@
+package akinet
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// An ID that uniquely identifies the pair of uni-directional UDP flows between
+// two endpoints (IP and port) at a particular time. The caller should assign
+// the same ID to datagrams travelling in either direction, so that parsed
+// results from the two directions (e.g. a DNS query and its response) can be
+// paired up.
+type UDPBidiID uuid.UUID
+
+// UDPParserFactory is responsible for creating UDPParsers and deciding whether
+// a UDP datagram can be parsed with the parser type created by this factory.
+// Implementations must be thread-safe.
+//
+// Unlike TCP flows, UDP datagrams are parsed one at a time, so the factory
+// sees each datagram in its entirety.
+type UDPParserFactory interface {
+	Name() string
+
+	// Checks whether the datagram can be parsed by parsers created by this
+	// factory. Implementations must return either Accept or Reject.
+	Accepts(datagram memview.MemView) AcceptDecision
+
+	// Creates a new UDPParser, supplying the bidirectional ID of the flows that
+	// the datagram belongs to.
+	CreateParser(id UDPBidiID) UDPParser
+}
+
+// UDPParser converts a single UDP datagram into ParsedNetworkContent.
+type UDPParser interface {
+	Name() string
+
+	// Parses the whole datagram. Returns a non-nil result or an error.
+	Parse(datagram memview.MemView) (result ParsedNetworkContent, err error)
+}
+
+// UDPParserFactorySelector helps to select a UDPParserFactory from a list of
+// factories.
+type UDPParserFactorySelector []UDPParserFactory
+
+// Select returns the first UDPParserFactory that accepts the datagram, or nil
+// if no factory is suitable.
+func (s UDPParserFactorySelector) Select(datagram memview.MemView) UDPParserFactory {
+	for _, f := range s {
+		if f.Accepts(datagram) == Accept {
+			return f
+		}
+	}
+	return nil
+}
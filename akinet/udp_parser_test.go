@@ -0,0 +1,67 @@
+package akinet
+
+import (
+	"testing"
+
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+type testUDPFactory struct {
+	name     string
+	decision AcceptDecision
+}
+
+func (f testUDPFactory) Name() string {
+	return f.name
+}
+
+func (f testUDPFactory) Accepts(memview.MemView) AcceptDecision {
+	return f.decision
+}
+
+func (testUDPFactory) CreateParser(UDPBidiID) UDPParser {
+	return nil
+}
+
+func TestUDPParserFactorySelector(t *testing.T) {
+	testInput := memview.New([]byte("hello I'm test input"))
+
+	testCases := []struct {
+		name     string
+		facts    UDPParserFactorySelector
+		expected string
+	}{
+		{
+			name:     "no factories",
+			facts:    UDPParserFactorySelector{},
+			expected: "",
+		},
+		{
+			name: "first accepting factory",
+			facts: UDPParserFactorySelector{
+				testUDPFactory{"a", Reject},
+				testUDPFactory{"b", Accept},
+				testUDPFactory{"c", Accept},
+			},
+			expected: "b",
+		},
+		{
+			name: "all reject",
+			facts: UDPParserFactorySelector{
+				testUDPFactory{"a", Reject},
+				testUDPFactory{"b", Reject},
+			},
+			expected: "",
+		},
+	}
+
+	for _, c := range testCases {
+		name := ""
+		if f := c.facts.Select(testInput); f != nil {
+			name = f.Name()
+		}
+		if name != c.expected {
+			t.Errorf("[%s] expected factory %q, got %q", c.name, c.expected, name)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package akinet
+
+import (
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Identifies the type of a WebSocket message (RFC 6455 Section 5.2).
+type WebSocketOpcode byte
+
+const (
+	WebSocketContinuation WebSocketOpcode = 0x0
+	WebSocketText         WebSocketOpcode = 0x1
+	WebSocketBinary       WebSocketOpcode = 0x2
+	WebSocketClose        WebSocketOpcode = 0x8
+	WebSocketPing         WebSocketOpcode = 0x9
+	WebSocketPong         WebSocketOpcode = 0xa
+)
+
+func (op WebSocketOpcode) String() string {
+	switch op {
+	case WebSocketContinuation:
+		return "CONTINUATION"
+	case WebSocketText:
+		return "TEXT"
+	case WebSocketBinary:
+		return "BINARY"
+	case WebSocketClose:
+		return "CLOSE"
+	case WebSocketPing:
+		return "PING"
+	case WebSocketPong:
+		return "PONG"
+	}
+	return "UNKNOWN"
+}
+
+// Whether the opcode is for a control frame (close, ping or pong).
+func (op WebSocketOpcode) IsControl() bool {
+	return op&0x8 != 0
+}
+
+// A WebSocket message, reassembled from one or more frames. Control frames
+// (close, ping and pong) are also represented as messages.
+type WebSocketMessage struct {
+	// StreamID and Seq identify the HTTP upgrade request (and its 101 Switching
+	// Protocols response) that established the WebSocket connection.
+	StreamID uuid.UUID
+	Seq      int
+
+	// Whether the message was sent by the client. Otherwise, it was sent by the
+	// server.
+	FromClient bool
+
+	// Position of this message among the messages sent in the same direction on
+	// the WebSocket connection, starting at 0.
+	MessageSeq int
+
+	Opcode WebSocketOpcode
+
+	// The message payload, unmasked and, if the message was compressed with
+	// permessage-deflate, decompressed.
+	Payload []byte
+
+	// Whether the message was compressed with permessage-deflate.
+	Compressed bool
+
+	// Whether Payload was cut short because the message was too large.
+	Truncated bool
+
+	// For close messages, the status code and reason given, if any.
+	CloseCode   *int
+	CloseReason string
+}
+
+func (WebSocketMessage) ImplParsedNetworkContent() {}
+
+// Returns the stream key of the HTTP upgrade request that established the
+// WebSocket connection carrying this message. See HTTPRequest.GetStreamKey.
+func (m WebSocketMessage) GetUpgradeStreamKey() string {
+	return m.StreamID.String() + ":" + strconv.Itoa(m.Seq)
+}
@@ -0,0 +1,42 @@
+package websocket
+
+import "time"
+
+const (
+	// Length of the smallest WebSocket frame header: FIN/RSV/opcode (1 byte) and
+	// MASK/payload length (1 byte).
+	minFrameHeaderLength_bytes = 2
+
+	// Length of the masking key on frames sent by the client.
+	maskingKeyLength_bytes = 4
+
+	// Control frames must have a payload of at most this many bytes (RFC 6455
+	// Section 5.5).
+	maxControlPayloadLength_bytes = 125
+
+	// Messages larger than this are truncated.
+	maxMessageLength_bytes = 1024 * 1024
+
+	// Size of the LZ77 sliding window used by permessage-deflate when context
+	// takeover is in effect.
+	deflateWindowLength_bytes = 32 * 1024
+
+	// Connections that have not seen any traffic for this long are forgotten.
+	connectionIdleTimeout = 10 * time.Minute
+)
+
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+	maskBit = 0x80
+
+	opcodeMask        = 0x0f
+	payloadLengthMask = 0x7f
+)
+
+// Appended to the payload of a compressed message before inflating it (RFC
+// 7692 Section 7.2.2), followed by an empty final block so that the inflater
+// terminates cleanly.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
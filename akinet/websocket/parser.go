@@ -0,0 +1,321 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+func newWebSocketParser(t *tracker, bidiID akinet.TCPBidiID) *webSocketParser {
+	return &webSocketParser{
+		tracker: t,
+		bidiID:  bidiID,
+	}
+}
+
+// Parses frames from one flow of a WebSocket connection until a message is
+// complete.
+type webSocketParser struct {
+	tracker *tracker
+	bidiID  akinet.TCPBidiID
+
+	allInput memview.MemView
+
+	// Offset into allInput of the next byte to process.
+	pos int64
+
+	// The frame whose payload is being read, if any, and the half of the
+	// connection that it belongs to.
+	frame *frame
+	half  *halfConnection
+}
+
+var _ akinet.TCPParser = (*webSocketParser)(nil)
+
+func (*webSocketParser) Name() string {
+	return "WebSocket Parser"
+}
+
+func (p *webSocketParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	p.allInput.Append(input)
+
+	conn := p.tracker.getConnection(p.bidiID)
+	if conn == nil {
+		return nil, p.allInput, errors.New("no WebSocket upgrade seen for connection")
+	}
+
+	var half *halfConnection
+	result, half, err = p.parse(conn)
+	if err == nil && result == nil && isEnd {
+		err = errors.New("WebSocket flow ended without a complete message")
+	}
+
+	// Once the flow has ended and all of its input is used, nothing more will
+	// be parsed in this direction.
+	if isEnd && half != nil && (err != nil || p.pos == p.allInput.Len()) {
+		conn.mu.Lock()
+		half.done = true
+		conn.mu.Unlock()
+		p.tracker.release(conn)
+	}
+
+	if err != nil {
+		return nil, p.allInput, err
+	}
+	return result, p.allInput.SubView(p.pos, p.allInput.Len()), nil
+}
+
+func (p *webSocketParser) parse(conn *connection) (result akinet.ParsedNetworkContent, half *halfConnection, err error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	for {
+		if p.frame == nil {
+			remaining := p.allInput.SubView(p.pos, p.allInput.Len())
+			f, headerLen, ok := readFrameHeader(remaining)
+			if !ok {
+				return nil, p.half, nil
+			}
+			p.pos += headerLen
+
+			// Frames from the client are always masked, and frames from the server
+			// never are (RFC 6455 Section 5.1).
+			if f.masked {
+				p.half = &conn.client
+			} else {
+				p.half = &conn.server
+			}
+
+			if err := p.startFrame(conn, p.half, f); err != nil {
+				return nil, p.half, err
+			}
+			p.frame = f
+		}
+
+		if !p.readPayload() {
+			return nil, p.half, nil
+		}
+		f := p.frame
+		p.frame = nil
+
+		if msg := p.finishFrame(p.half, f); msg != nil {
+			result, err := p.toWebSocketMessage(conn, p.half, f.masked, msg)
+			return result, p.half, err
+		}
+	}
+}
+
+type frame struct {
+	fin        bool
+	rsv1       bool
+	opcode     akinet.WebSocketOpcode
+	masked     bool
+	maskingKey []byte
+
+	// The length of the payload declared in the header, and the number of
+	// payload bytes still to be read.
+	payloadLen       uint64
+	payloadRemaining uint64
+
+	// The unmasked start of the payload, up to captureLimit bytes. The rest of
+	// the payload is skipped.
+	payload      []byte
+	captureLimit int
+}
+
+// Reads a frame header from the start of the input. Returns false if the input
+// does not contain a complete header.
+func readFrameHeader(input memview.MemView) (f *frame, headerLen int64, ok bool) {
+	if input.Len() < minFrameHeaderLength_bytes {
+		return nil, 0, false
+	}
+
+	b0, b1 := input.GetByte(0), input.GetByte(1)
+	f = &frame{
+		fin:    b0&finBit != 0,
+		rsv1:   b0&rsv1Bit != 0,
+		opcode: akinet.WebSocketOpcode(b0 & opcodeMask),
+		masked: b1&maskBit != 0,
+	}
+
+	pos := int64(minFrameHeaderLength_bytes)
+	f.payloadLen = uint64(b1 & payloadLengthMask)
+	switch f.payloadLen {
+	case 126:
+		if input.Len() < pos+2 {
+			return nil, 0, false
+		}
+		f.payloadLen = uint64(input.GetUint16(pos))
+		pos += 2
+	case 127:
+		if input.Len() < pos+8 {
+			return nil, 0, false
+		}
+		f.payloadLen = uint64(input.GetUint32(pos))<<32 | uint64(input.GetUint32(pos+4))
+		pos += 8
+	}
+	f.payloadRemaining = f.payloadLen
+
+	if f.masked {
+		if input.Len() < pos+maskingKeyLength_bytes {
+			return nil, 0, false
+		}
+		f.maskingKey = []byte(input.SubView(pos, pos+maskingKeyLength_bytes).String())
+		pos += maskingKeyLength_bytes
+	}
+
+	return f, pos, true
+}
+
+// Checks a frame whose header has been read, and adds it to the connection
+// state. Sets the number of payload bytes to capture.
+func (p *webSocketParser) startFrame(conn *connection, half *halfConnection, f *frame) error {
+	if f.opcode.IsControl() {
+		// Control frames may be interleaved with the fragments of a data message.
+		if !f.fin || f.payloadLen > maxControlPayloadLength_bytes {
+			return errors.Errorf("malformed WebSocket %s frame", f.opcode)
+		}
+		f.captureLimit = int(f.payloadLen)
+		return nil
+	}
+
+	if f.opcode == akinet.WebSocketContinuation {
+		if half.fragmented == nil {
+			return errors.New("WebSocket continuation frame without a preceding fragment")
+		}
+	} else {
+		if half.fragmented != nil {
+			return errors.Errorf("WebSocket %s frame in the middle of a fragmented message", f.opcode)
+		}
+		if f.rsv1 && !conn.deflateAccepted && !conn.deflateOffered {
+			return errors.New("compressed WebSocket message without permessage-deflate")
+		}
+		half.fragmented = &message{opcode: f.opcode, compressed: f.rsv1}
+	}
+
+	f.captureLimit = maxMessageLength_bytes - len(half.fragmented.payload)
+	if uint64(f.captureLimit) > f.payloadLen {
+		f.captureLimit = int(f.payloadLen)
+	}
+	return nil
+}
+
+// Reads as much of the current frame's payload as is available, keeping the
+// start of it and skipping the rest. Returns true if the payload is complete.
+func (p *webSocketParser) readPayload() bool {
+	f := p.frame
+	n := p.allInput.Len() - p.pos
+	if uint64(n) > f.payloadRemaining {
+		n = int64(f.payloadRemaining)
+	}
+
+	if want := int64(f.captureLimit - len(f.payload)); want > 0 {
+		if want > n {
+			want = n
+		}
+		start := len(f.payload)
+		f.payload = append(f.payload, p.allInput.SubView(p.pos, p.pos+want).String()...)
+		if f.maskingKey != nil {
+			for i := start; i < len(f.payload); i++ {
+				f.payload[i] ^= f.maskingKey[i%maskingKeyLength_bytes]
+			}
+		}
+	}
+
+	p.pos += n
+	f.payloadRemaining -= uint64(n)
+	return f.payloadRemaining == 0
+}
+
+// Adds a complete frame to the connection state. Returns a non-nil message if
+// the frame completed one.
+func (p *webSocketParser) finishFrame(half *halfConnection, f *frame) *message {
+	if f.opcode.IsControl() {
+		return &message{opcode: f.opcode, payload: f.payload}
+	}
+
+	msg := half.fragmented
+	msg.payload = append(msg.payload, f.payload...)
+	if uint64(len(f.payload)) < f.payloadLen {
+		msg.truncated = true
+	}
+
+	if !f.fin {
+		return nil
+	}
+	half.fragmented = nil
+	return msg
+}
+
+func (p *webSocketParser) toWebSocketMessage(conn *connection, half *halfConnection, fromClient bool, msg *message) (akinet.WebSocketMessage, error) {
+	result := akinet.WebSocketMessage{
+		StreamID:   conn.streamID(),
+		Seq:        conn.upgradeSeq,
+		FromClient: fromClient,
+		MessageSeq: half.numMessages,
+		Opcode:     msg.opcode,
+		Payload:    msg.payload,
+		Compressed: msg.compressed,
+		Truncated:  msg.truncated,
+	}
+	half.numMessages++
+
+	if msg.compressed {
+		if msg.truncated {
+			// We can't inflate a partial message, and the sliding window is lost.
+			half.deflateWindow = nil
+		} else {
+			payload, err := half.inflate(msg.payload)
+			if err != nil {
+				return akinet.WebSocketMessage{}, errors.Wrap(err, "failed to decompress WebSocket message")
+			}
+			result.Payload = payload
+		}
+	}
+
+	if msg.opcode == akinet.WebSocketClose && len(result.Payload) >= 2 {
+		code := int(binary.BigEndian.Uint16(result.Payload))
+		result.CloseCode = &code
+		result.CloseReason = string(result.Payload[2:])
+	}
+
+	return result, nil
+}
+
+// Decompresses a message compressed with permessage-deflate (RFC 7692).
+func (half *halfConnection) inflate(payload []byte) ([]byte, error) {
+	compressed := make([]byte, 0, len(payload)+len(deflateTail))
+	compressed = append(compressed, payload...)
+	compressed = append(compressed, deflateTail...)
+	var dict []byte
+	if !half.noContextTakeover {
+		dict = half.deflateWindow
+	}
+
+	r := flate.NewReaderDict(bytes.NewReader(compressed), dict)
+	defer r.Close()
+	result, err := ioutil.ReadAll(io.LimitReader(r, maxMessageLength_bytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > maxMessageLength_bytes {
+		return nil, errors.Errorf("decompressed WebSocket message is longer than %d bytes", maxMessageLength_bytes)
+	}
+
+	if !half.noContextTakeover {
+		window := append(half.deflateWindow, result...)
+		if len(window) > deflateWindowLength_bytes {
+			window = window[len(window)-deflateWindowLength_bytes:]
+		}
+		half.deflateWindow = append([]byte{}, window...)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"github.com/google/gopacket/reassembly"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+// Returns parser factories that follow HTTP/1.1 connections as they are
+// upgraded to WebSocket. The given HTTP parser factories (usually the ones
+// from akinet/http) are wrapped to watch for the upgrade handshake; they are
+// returned in the same order, followed by a factory for WebSocket frames.
+//
+// The returned factories share state about upgraded connections, so they
+// must be used together, for both flows of each connection.
+func NewWebSocketParserFactories(httpFactories ...akinet.TCPParserFactory) akinet.TCPParserFactorySelector {
+	t := newTracker()
+
+	result := make(akinet.TCPParserFactorySelector, 0, len(httpFactories)+1)
+	for _, f := range httpFactories {
+		result = append(result, &upgradeWatchingParserFactory{
+			httpFactory: f,
+			tracker:     t,
+		})
+	}
+	return append(result, &webSocketParserFactory{tracker: t})
+}
+
+type webSocketParserFactory struct {
+	tracker *tracker
+}
+
+func (*webSocketParserFactory) Name() string {
+	return "WebSocket Parser Factory"
+}
+
+func (factory *webSocketParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	decision, discardFront = factory.accepts(input)
+
+	if decision == akinet.NeedMoreData && isEnd {
+		decision = akinet.Reject
+		discardFront = input.Len()
+	}
+
+	return decision, discardFront
+}
+
+func (factory *webSocketParserFactory) accepts(input memview.MemView) (decision akinet.AcceptDecision, discardFront int64) {
+	// WebSocket frames can only be told apart from other binary data by their
+	// header, which isn't very distinctive. Only accept them if a connection has
+	// been upgraded.
+	if !factory.tracker.hasConnections() {
+		return akinet.Reject, input.Len()
+	}
+
+	if input.Len() < minFrameHeaderLength_bytes {
+		return akinet.NeedMoreData, 0
+	}
+
+	if !plausibleFrameHeader(input.GetByte(0), input.GetByte(1)) {
+		return akinet.Reject, input.Len()
+	}
+	return akinet.Accept, 0
+}
+
+func (factory *webSocketParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return newWebSocketParser(factory.tracker, id)
+}
+
+// Determines whether the first two bytes of a frame are plausible for a
+// WebSocket frame.
+func plausibleFrameHeader(b0, b1 byte) bool {
+	// RSV2 and RSV3 are not used by any extension we support.
+	if b0&(rsv2Bit|rsv3Bit) != 0 {
+		return false
+	}
+
+	opcode := akinet.WebSocketOpcode(b0 & opcodeMask)
+	switch opcode {
+	case akinet.WebSocketContinuation, akinet.WebSocketText, akinet.WebSocketBinary:
+		return true
+	case akinet.WebSocketClose, akinet.WebSocketPing, akinet.WebSocketPong:
+		// Control frames cannot be fragmented or compressed, and have short
+		// payloads.
+		return b0&finBit != 0 && b0&rsv1Bit == 0 && int(b1&payloadLengthMask) <= maxControlPayloadLength_bytes
+	}
+	return false
+}
+
+// Wraps an HTTP parser factory to watch for WebSocket upgrades.
+type upgradeWatchingParserFactory struct {
+	httpFactory akinet.TCPParserFactory
+	tracker     *tracker
+}
+
+func (factory *upgradeWatchingParserFactory) Name() string {
+	return factory.httpFactory.Name()
+}
+
+func (factory *upgradeWatchingParserFactory) Accepts(input memview.MemView, isEnd bool) (decision akinet.AcceptDecision, discardFront int64) {
+	return factory.httpFactory.Accepts(input, isEnd)
+}
+
+func (factory *upgradeWatchingParserFactory) CreateParser(id akinet.TCPBidiID, seq, ack reassembly.Sequence) akinet.TCPParser {
+	return &upgradeWatchingParser{
+		httpParser: factory.httpFactory.CreateParser(id, seq, ack),
+		tracker:    factory.tracker,
+	}
+}
+
+type upgradeWatchingParser struct {
+	httpParser akinet.TCPParser
+	tracker    *tracker
+}
+
+func (p *upgradeWatchingParser) Name() string {
+	return p.httpParser.Name()
+}
+
+func (p *upgradeWatchingParser) Parse(input memview.MemView, isEnd bool) (result akinet.ParsedNetworkContent, unused memview.MemView, err error) {
+	result, unused, err = p.httpParser.Parse(input, isEnd)
+	switch r := result.(type) {
+	case akinet.HTTPRequest:
+		p.tracker.observeRequest(r)
+	case akinet.HTTPResponse:
+		p.tracker.observeResponse(r)
+	}
+	return result, unused, err
+}
@@ -0,0 +1,284 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/gopacket/reassembly"
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	akihttp "github.com/akitasoftware/akita-libs/akinet/http"
+	"github.com/akitasoftware/akita-libs/memview"
+)
+
+var testBidiID = akinet.TCPBidiID(uuid.MustParse("3744e3d7-2c08-4cd2-9ee9-2306dfba6727"))
+
+const (
+	// The HTTP/1.x parsers pair the request and response using the ack number
+	// on the request and the seq number on the response.
+	clientSeq = reassembly.Sequence(1000)
+	serverSeq = reassembly.Sequence(5000)
+)
+
+// Builds a WebSocket frame.
+func wsFrame(fin, rsv1 bool, opcode akinet.WebSocketOpcode, masked bool, payload []byte) []byte {
+	var buf bytes.Buffer
+	b0 := byte(opcode)
+	if fin {
+		b0 |= finBit
+	}
+	if rsv1 {
+		b0 |= rsv1Bit
+	}
+	buf.WriteByte(b0)
+
+	var b1 byte
+	if masked {
+		b1 = maskBit
+	}
+	switch {
+	case len(payload) < 126:
+		buf.WriteByte(b1 | byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf.WriteByte(b1 | 126)
+		binary.Write(&buf, binary.BigEndian, uint16(len(payload)))
+	default:
+		buf.WriteByte(b1 | 127)
+		binary.Write(&buf, binary.BigEndian, uint64(len(payload)))
+	}
+
+	if masked {
+		key := []byte{0x12, 0x34, 0x56, 0x78}
+		buf.Write(key)
+		for i, b := range payload {
+			buf.WriteByte(b ^ key[i%4])
+		}
+	} else {
+		buf.Write(payload)
+	}
+	return buf.Bytes()
+}
+
+// Compresses messages with permessage-deflate, using context takeover.
+type deflater struct {
+	buf bytes.Buffer
+	w   *flate.Writer
+}
+
+func newDeflater() *deflater {
+	d := &deflater{}
+	d.w, _ = flate.NewWriter(&d.buf, flate.BestCompression)
+	return d
+}
+
+func (d *deflater) compress(msg string) []byte {
+	d.buf.Reset()
+	d.w.Write([]byte(msg))
+	d.w.Flush()
+	return bytes.TrimSuffix(d.buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+}
+
+func closePayload(code uint16, reason string) []byte {
+	result := make([]byte, 2)
+	binary.BigEndian.PutUint16(result, code)
+	return append(result, reason...)
+}
+
+// Parses a flow with the given selector, the way a caller would.
+func parseFlow(t *testing.T, selector akinet.TCPParserFactorySelector, seq, ack reassembly.Sequence, flow []byte) []akinet.ParsedNetworkContent {
+	results := []akinet.ParsedNetworkContent{}
+	input := memview.New(flow)
+	for input.Len() > 0 {
+		factory, decision, discardFront := selector.Select(input, true)
+		if decision != akinet.Accept {
+			t.Fatalf("expected a factory to accept, got %s after %d results: %q", decision, len(results), input.String())
+		}
+		input = input.SubView(discardFront, input.Len())
+
+		p := factory.CreateParser(testBidiID, seq, ack)
+		result, unused, err := p.Parse(input, true)
+		if err != nil {
+			t.Fatalf("unexpected error from %s: %v", p.Name(), err)
+		}
+		results = append(results, result)
+		input = unused
+	}
+	return results
+}
+
+func TestWebSocketUpgrade(t *testing.T) {
+	var client bytes.Buffer
+	client.WriteString("GET /chat HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	client.Write(wsFrame(false, false, akinet.WebSocketText, true, []byte("hel")))
+	client.Write(wsFrame(true, false, akinet.WebSocketPing, true, []byte("are you there")))
+	client.Write(wsFrame(true, false, akinet.WebSocketContinuation, true, []byte("lo")))
+	client.Write(wsFrame(true, false, akinet.WebSocketClose, true, closePayload(1000, "bye")))
+
+	d := newDeflater()
+	var server bytes.Buffer
+	server.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n\r\n")
+	server.Write(wsFrame(true, true, akinet.WebSocketText, false, d.compress("hello hello hello")))
+	// Relies on the previous message through context takeover.
+	server.Write(wsFrame(true, true, akinet.WebSocketText, false, d.compress("hello hello hello")))
+	server.Write(wsFrame(true, false, akinet.WebSocketPong, false, []byte("are you there")))
+
+	selector := NewWebSocketParserFactories(
+		akihttp.NewHTTPRequestParserFactory(akihttp.ParserOptions{}),
+		akihttp.NewHTTPResponseParserFactory(akihttp.ParserOptions{}),
+	)
+
+	// Parse the server flow first: the flows of a connection may be parsed in any
+	// order.
+	serverResults := parseFlow(t, selector, serverSeq, clientSeq, server.Bytes())
+	clientResults := parseFlow(t, selector, clientSeq, serverSeq, client.Bytes())
+
+	if len(clientResults) != 4 {
+		t.Fatalf("expected 4 results from client, got %d", len(clientResults))
+	}
+	upgrade := clientResults[0].(akinet.HTTPRequest)
+	closeCode := 1000
+
+	expectedClient := []akinet.ParsedNetworkContent{
+		akinet.WebSocketMessage{
+			StreamID:   uuid.UUID(testBidiID),
+			Seq:        upgrade.Seq,
+			FromClient: true,
+			MessageSeq: 0,
+			Opcode:     akinet.WebSocketPing,
+			Payload:    []byte("are you there"),
+		},
+		akinet.WebSocketMessage{
+			StreamID:   uuid.UUID(testBidiID),
+			Seq:        upgrade.Seq,
+			FromClient: true,
+			MessageSeq: 1,
+			Opcode:     akinet.WebSocketText,
+			Payload:    []byte("hello"),
+		},
+		akinet.WebSocketMessage{
+			StreamID:    uuid.UUID(testBidiID),
+			Seq:         upgrade.Seq,
+			FromClient:  true,
+			MessageSeq:  2,
+			Opcode:      akinet.WebSocketClose,
+			Payload:     closePayload(1000, "bye"),
+			CloseCode:   &closeCode,
+			CloseReason: "bye",
+		},
+	}
+	if diff := cmp.Diff(expectedClient, clientResults[1:], cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("found diff in client messages: %s", diff)
+	}
+
+	if len(serverResults) != 4 {
+		t.Fatalf("expected 4 results from server, got %d", len(serverResults))
+	}
+	for i, r := range serverResults[1:] {
+		msg := r.(akinet.WebSocketMessage)
+		if msg.GetUpgradeStreamKey() != upgrade.GetStreamKey() {
+			t.Errorf("server message %d is not tied to the upgrade request", i)
+		}
+		if msg.FromClient {
+			t.Errorf("server message %d marked as from client", i)
+		}
+	}
+	for i := 1; i <= 2; i++ {
+		msg := serverResults[i].(akinet.WebSocketMessage)
+		if !msg.Compressed || string(msg.Payload) != "hello hello hello" {
+			t.Errorf("unexpected compressed server message %d: %+v", i, msg)
+		}
+	}
+}
+
+func TestWebSocketFactoryRejectsWithoutUpgrade(t *testing.T) {
+	selector := NewWebSocketParserFactories()
+	frame := wsFrame(true, false, akinet.WebSocketText, true, []byte("hi"))
+	if _, decision, _ := selector.Select(memview.New(frame), false); decision != akinet.Reject {
+		t.Errorf("expected WebSocket frame to be rejected without an upgrade, got %s", decision)
+	}
+}
+
+func TestWebSocketTruncatesLargeMessages(t *testing.T) {
+	var client bytes.Buffer
+	client.WriteString("GET /chat HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	large := bytes.Repeat([]byte("abcdefg"), maxMessageLength_bytes/7+100)
+	client.Write(wsFrame(true, false, akinet.WebSocketBinary, true, large))
+	client.Write(wsFrame(true, false, akinet.WebSocketPing, true, []byte("ping")))
+
+	selector := NewWebSocketParserFactories(
+		akihttp.NewHTTPRequestParserFactory(akihttp.ParserOptions{}),
+		akihttp.NewHTTPResponseParserFactory(akihttp.ParserOptions{}),
+	)
+	results := parseFlow(t, selector, clientSeq, serverSeq, client.Bytes())
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	msg := results[1].(akinet.WebSocketMessage)
+	if !msg.Truncated || !bytes.Equal(msg.Payload, large[:maxMessageLength_bytes]) {
+		t.Errorf("expected payload truncated to %d bytes, got %d bytes, truncated=%v", maxMessageLength_bytes, len(msg.Payload), msg.Truncated)
+	}
+	if ping := results[2].(akinet.WebSocketMessage); string(ping.Payload) != "ping" {
+		t.Errorf("unexpected message after truncated message: %+v", ping)
+	}
+}
+
+func TestWebSocketParsesPayloadIncrementally(t *testing.T) {
+	tr := newTracker()
+	tr.connections.GetOrCreate(testBidiID, func() interface{} { return &connection{bidiID: testBidiID} })
+
+	// Declares a payload far larger than anything that is buffered.
+	header := []byte{finBit | byte(akinet.WebSocketBinary), 127, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	p := newWebSocketParser(tr, testBidiID)
+	result, _, err := p.Parse(memview.New(header), false)
+	if result != nil || err != nil {
+		t.Fatalf("expected parser to need more input, got %v, %v", result, err)
+	}
+	chunk := bytes.Repeat([]byte{'x'}, 64*1024)
+	for i := 0; i < 20; i++ {
+		if result, _, err := p.Parse(memview.New(chunk), false); result != nil || err != nil {
+			t.Fatalf("expected parser to need more input, got %v, %v", result, err)
+		}
+	}
+	if len(p.frame.payload) != p.frame.captureLimit || p.frame.captureLimit != maxMessageLength_bytes {
+		t.Errorf("expected %d bytes of payload to be kept, got %d", maxMessageLength_bytes, len(p.frame.payload))
+	}
+}
+
+func TestInflateDoesNotModifyPayload(t *testing.T) {
+	compressed := newDeflater().compress("hello hello hello")
+	buf := make([]byte, len(compressed), len(compressed)+len(deflateTail))
+	copy(buf, compressed)
+	spare := buf[len(buf):cap(buf)]
+
+	half := &halfConnection{}
+	result, err := half.inflate(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "hello hello hello" {
+		t.Errorf("unexpected inflated payload %q", result)
+	}
+	if !bytes.Equal(spare, make([]byte, len(spare))) {
+		t.Errorf("inflate wrote past the end of the payload: %v", spare)
+	}
+}
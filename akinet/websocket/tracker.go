@@ -0,0 +1,198 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+)
+
+// Keeps track of TCP connections that have been upgraded to WebSocket. It is
+// shared between the HTTP parsers that watch for the upgrade handshake and the
+// WebSocket parsers that take over afterwards.
+type tracker struct {
+	mu          sync.Mutex
+	connections *akinet.ConnectionMap // of *connection, protected by mu
+}
+
+// The state of a WebSocket connection.
+type connection struct {
+	bidiID akinet.TCPBidiID
+
+	// The Seq of the HTTP upgrade request and its response.
+	upgradeSeq int
+
+	// Whether the server has accepted the upgrade. We also follow connections
+	// whose upgrade request was seen without a response, since the flows of a
+	// connection may be parsed in any order.
+	accepted bool
+
+	// Whether permessage-deflate was offered by the client, and whether it was
+	// accepted by the server.
+	deflateOffered  bool
+	deflateAccepted bool
+
+	mu     sync.Mutex
+	client halfConnection // protected by mu
+	server halfConnection // protected by mu
+}
+
+// The state for one direction of a WebSocket connection.
+type halfConnection struct {
+	// The number of messages emitted so far.
+	numMessages int
+
+	// A fragmented message that is being reassembled.
+	fragmented *message
+
+	// Whether permessage-deflate must reset its sliding window between
+	// messages.
+	noContextTakeover bool
+
+	// The most recent decompressed output, used as the sliding window for the
+	// next compressed message.
+	deflateWindow []byte
+
+	// Set when the flow has ended.
+	done bool
+}
+
+type message struct {
+	opcode     akinet.WebSocketOpcode
+	compressed bool
+	truncated  bool
+	payload    []byte
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		connections: akinet.NewConnectionMap(connectionIdleTimeout, nil),
+	}
+}
+
+func (t *tracker) hasConnections() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.connections.Len() > 0
+}
+
+// Returns the given connection, or nil if it hasn't been upgraded to
+// WebSocket.
+func (t *tracker) getConnection(id akinet.TCPBidiID) *connection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conn, _ := t.connections.Get(id).(*connection)
+	return conn
+}
+
+// Records an HTTP request asking to upgrade to WebSocket.
+func (t *tracker) observeRequest(req akinet.HTTPRequest) {
+	if !isWebSocketUpgrade(req.Header) || req.Header.Get("Sec-WebSocket-Key") == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := akinet.TCPBidiID(req.StreamID)
+	conn := t.connections.GetOrCreate(id, func() interface{} {
+		return &connection{bidiID: id, upgradeSeq: req.Seq}
+	}).(*connection)
+
+	if _, ok := parseDeflateExtension(req.Header); ok {
+		conn.deflateOffered = true
+	}
+}
+
+// Records an HTTP response. If it accepts an upgrade to WebSocket, the
+// connection is followed from here on.
+func (t *tracker) observeResponse(resp akinet.HTTPResponse) {
+	if resp.StatusCode != http.StatusSwitchingProtocols || !isWebSocketUpgrade(resp.Header) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := akinet.TCPBidiID(resp.StreamID)
+	conn := t.connections.GetOrCreate(id, func() interface{} {
+		return &connection{bidiID: id}
+	}).(*connection)
+	conn.upgradeSeq = resp.Seq
+	conn.accepted = true
+
+	if params, ok := parseDeflateExtension(resp.Header); ok {
+		conn.deflateAccepted = true
+
+		conn.mu.Lock()
+		conn.client.noContextTakeover = params["client_no_context_takeover"]
+		conn.server.noContextTakeover = params["server_no_context_takeover"]
+		conn.mu.Unlock()
+	}
+}
+
+// Forgets about the given connection once both of its flows have ended.
+func (t *tracker) release(conn *connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Lock ordering: tracker.mu before conn.mu.
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.client.done && conn.server.done {
+		t.connections.Delete(conn.bidiID)
+	}
+}
+
+// Returns the StreamID for messages on this connection.
+func (conn *connection) streamID() uuid.UUID {
+	return uuid.UUID(conn.bidiID)
+}
+
+// Determines whether the headers ask for (or agree to) an upgrade to
+// WebSocket.
+func isWebSocketUpgrade(header http.Header) bool {
+	if !headerContainsToken(header, "Upgrade", "websocket") {
+		return false
+	}
+	return headerContainsToken(header, "Connection", "upgrade")
+}
+
+// Determines whether the comma-separated values of the given header contain
+// the given token, ignoring case.
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Looks for the permessage-deflate extension in the Sec-WebSocket-Extensions
+// header. Returns the names of its parameters.
+func parseDeflateExtension(header http.Header) (map[string]bool, bool) {
+	for _, v := range header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(v, ",") {
+			parts := strings.Split(ext, ";")
+			if !strings.EqualFold(strings.TrimSpace(parts[0]), "permessage-deflate") {
+				continue
+			}
+
+			params := map[string]bool{}
+			for _, p := range parts[1:] {
+				name := strings.SplitN(strings.TrimSpace(p), "=", 2)[0]
+				params[strings.ToLower(name)] = true
+			}
+			return params, true
+		}
+	}
+	return nil, false
+}
@@ -440,6 +440,21 @@ type TLSGraphEdge struct {
 	Values map[TimelineValue]float32 `json:"values"`
 }
 
+// Represents Kafka requests from a client to a broker in a graph, for one
+// topic.
+type KafkaGraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+
+	Topic string `json:"topic"`
+
+	// The kind of request, e.g. "Produce" or "Fetch".
+	APIKey akinet.KafkaAPIKey `json:"api_key"`
+
+	// Aggregate values attached to the edge, e.g., "count"
+	Values map[TimelineValue]float32 `json:"values"`
+}
+
 type GraphResponse struct {
 	// Graph edges representing HTTP requests and responses.
 	HTTPEdges []HTTPGraphEdge `json:"edges"`
@@ -450,6 +465,9 @@ type GraphResponse struct {
 	// Graph edges representing TLS connections.
 	TLSEdges []TLSGraphEdge `json:"tls_edges"`
 
+	// Graph edges representing Kafka requests.
+	KafkaEdges []KafkaGraphEdge `json:"kafka_edges"`
+
 	// TODO: vertex list? vertex or edge count?
 	// TODO: pagination
 }
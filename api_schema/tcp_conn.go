@@ -26,6 +26,28 @@ type TCPConnectionReport struct {
 
 	// Whether and how the connection was closed.
 	EndState akinet.TCPConnectionEndState `json:"end_state"`
+
+	// The traffic sent by the source and by the destination.
+	SrcToDest TCPDirectionStats `json:"src_to_dest"`
+	DestToSrc TCPDirectionStats `json:"dest_to_src"`
+
+	// The round-trip time between the endpoints, estimated from the time
+	// between the SYN and the ACK that completed the handshake. Nil if the
+	// handshake was not observed.
+	HandshakeRTT *time.Duration `json:"handshake_rtt,omitempty"`
+}
+
+// Counts the packets sent in one direction of a TCP connection.
+type TCPDirectionStats struct {
+	Packets      int   `json:"packets"`
+	PayloadBytes int64 `json:"payload_bytes"`
+
+	// The number of packets that repeated a SYN, or that carried only data
+	// that had already been sent.
+	Retransmissions int `json:"retransmissions"`
+
+	// The number of packets that advertised a zero receive window.
+	ZeroWindows int `json:"zero_windows"`
 }
 
 func (report TCPConnectionReport) GetID() akid.ID {
@@ -12,6 +12,10 @@ type TLSHandshakeReport struct {
 	// The inferred TLS version. Only populated if the Server Hello was seen.
 	Version *akinet.TLSVersion
 
+	// The cipher suite selected by the server. Zero if the Server Hello was not
+	// seen.
+	CipherSuite uint16
+
 	// The DNS hostname extracted from the client's SNI extension, if any.
 	SNIHostname *string
 
@@ -26,4 +30,35 @@ type TLSHandshakeReport struct {
 	// The SANs seen in the server's certificate. The server's certificate is
 	// encrypted in TLS 1.3, so this is only populated for TLS 1.2 connections.
 	SubjectAlternativeNames []string
+
+	// The certificate chain sent by the server, starting with the server's own
+	// certificate, and any problems found with it. Only populated for TLS 1.2
+	// connections.
+	CertificateChain    []akinet.TLSCertificate
+	CertificateFindings []akinet.TLSCertificateFinding
+
+	// The cipher suites, extension types, supported groups, EC point formats,
+	// signature algorithms and supported versions offered in the Client Hello,
+	// in the order given.
+	CipherSuites        []uint16
+	Extensions          []uint16
+	SupportedGroups     []uint16
+	ECPointFormats      []uint8
+	SignatureAlgorithms []uint16
+	SupportedVersions   []uint16
+
+	// Fingerprints of the client's TLS library. JA3 is the full JA3 string,
+	// and JA3Hash is its MD5 hash.
+	JA3     string
+	JA3Hash string
+	JA4     string
+
+	// How the handshake ended. If the outcome is an alert, AlertLevel and
+	// AlertDescription describe the alert, unless it was encrypted.
+	Outcome          akinet.TLSHandshakeOutcome
+	AlertLevel       *akinet.TLSAlertLevel
+	AlertDescription *akinet.TLSAlertDescription
+
+	// Whether the server sent a TLS 1.3 HelloRetryRequest.
+	HelloRetryRequest bool
 }
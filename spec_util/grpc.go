@@ -0,0 +1,69 @@
+package spec_util
+
+import (
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/spec_util/ir_hash"
+)
+
+func GRPCMetaFromData(d *pb.Data) *pb.GRPCMeta {
+	if m, ok := d.GetMeta().GetMeta().(*pb.DataMeta_Grpc); ok {
+		return m.Grpc
+	}
+	return nil
+}
+
+func GRPCMetaFromMethod(m *pb.Method) *pb.GRPCMethodMeta {
+	if m, ok := m.GetMeta().GetMeta().(*pb.MethodMeta_Grpc); ok {
+		return m.Grpc
+	}
+	return nil
+}
+
+// Creates a witness method for a gRPC call. The response may be nil if it was
+// not observed. Each message is recorded as an opaque bytes value, since we
+// don't have the protobuf descriptors needed to decode them.
+func GRPCMethodFromCall(req akinet.GRPCRequest, resp *akinet.GRPCResponse) *pb.Method {
+	method := &pb.Method{
+		Id: &pb.MethodID{
+			Name:    req.FullMethod(),
+			ApiType: pb.ApiType_GRPC,
+		},
+		Args:      grpcMessagesToData(req.Messages),
+		Responses: map[string]*pb.Data{},
+		Meta: &pb.MethodMeta{
+			Meta: &pb.MethodMeta_Grpc{
+				Grpc: &pb.GRPCMethodMeta{},
+			},
+		},
+	}
+
+	if resp != nil {
+		method.Responses = grpcMessagesToData(resp.Messages)
+	}
+
+	return method
+}
+
+func grpcMessagesToData(messages []akinet.GRPCMessage) map[string]*pb.Data {
+	result := make(map[string]*pb.Data, len(messages))
+	for _, msg := range messages {
+		d := &pb.Data{
+			Value: &pb.Data_Primitive{
+				Primitive: &pb.Primitive{
+					Value: &pb.Primitive_BytesValue{
+						BytesValue: &pb.Bytes{Value: msg.Data},
+					},
+				},
+			},
+			Meta: &pb.DataMeta{
+				Meta: &pb.DataMeta_Grpc{
+					Grpc: &pb.GRPCMeta{},
+				},
+			},
+		}
+		result[ir_hash.HashDataToString(d)] = d
+	}
+	return result
+}
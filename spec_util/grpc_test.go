@@ -0,0 +1,77 @@
+package spec_util
+
+import (
+	"testing"
+
+	pb "github.com/akitasoftware/akita-ir/go/api_spec"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/spec_util/ir_hash"
+)
+
+func grpcBytesData(value string) *pb.Data {
+	return &pb.Data{
+		Value: &pb.Data_Primitive{
+			Primitive: &pb.Primitive{
+				Value: &pb.Primitive_BytesValue{
+					BytesValue: &pb.Bytes{Value: []byte(value)},
+				},
+			},
+		},
+		Meta: &pb.DataMeta{
+			Meta: &pb.DataMeta_Grpc{
+				Grpc: &pb.GRPCMeta{},
+			},
+		},
+	}
+}
+
+func TestGRPCMessagesToData(t *testing.T) {
+	messages := []akinet.GRPCMessage{
+		{Decompressed: true, Length: 5, Data: []byte("hello")},
+		{Decompressed: true, Length: 5, Data: []byte("world")},
+		// Identical messages are recorded once.
+		{Decompressed: true, Length: 5, Data: []byte("hello")},
+	}
+
+	result := grpcMessagesToData(messages)
+	assert.Equal(t, 2, len(result))
+	for _, value := range []string{"hello", "world"} {
+		expected := grpcBytesData(value)
+		actual, ok := result[ir_hash.HashDataToString(expected)]
+		if assert.True(t, ok, "missing message %q", value) {
+			assert.True(t, proto.Equal(expected, actual), "unexpected data for %q: %s", value, proto.MarshalTextString(actual))
+		}
+		assert.NotNil(t, GRPCMetaFromData(actual))
+	}
+
+	assert.Empty(t, grpcMessagesToData(nil))
+}
+
+func TestGRPCMethodFromCall(t *testing.T) {
+	req := akinet.GRPCRequest{
+		Service:  "helloworld.Greeter",
+		Method:   "SayHello",
+		Messages: []akinet.GRPCMessage{{Decompressed: true, Length: 3, Data: []byte("Rex")}},
+	}
+	resp := akinet.GRPCResponse{
+		Messages: []akinet.GRPCMessage{{Decompressed: true, Length: 9, Data: []byte("Hello Rex")}},
+	}
+
+	method := GRPCMethodFromCall(req, &resp)
+	assert.Equal(t, "/helloworld.Greeter/SayHello", method.GetId().GetName())
+	assert.Equal(t, pb.ApiType_GRPC, method.GetId().GetApiType())
+	assert.NotNil(t, GRPCMetaFromMethod(method))
+
+	expectedArgs := grpcMessagesToData(req.Messages)
+	expectedResponses := grpcMessagesToData(resp.Messages)
+	assert.True(t, proto.Equal(&pb.Method{Args: expectedArgs}, &pb.Method{Args: method.Args}), "unexpected args")
+	assert.True(t, proto.Equal(&pb.Method{Responses: expectedResponses}, &pb.Method{Responses: method.Responses}), "unexpected responses")
+
+	// The response may not have been observed.
+	method = GRPCMethodFromCall(req, nil)
+	assert.Empty(t, method.Responses)
+	assert.Equal(t, 1, len(method.Args))
+}
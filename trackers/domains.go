@@ -1,16 +1,17 @@
 package trackers
 
-var domains = map[string]struct{}{
-	"amazon-adsystem.com":   {},
-	"appdynamics.com":       {},
-	"doubleclick.net":       {},
-	"google-analytics.com":  {},
-	"googleadservices.com":  {},
-	"googlesyndication.com": {},
-	"googletagmanager.com":  {},
-	"googletagservices.com": {},
-	"gstatic.com":           {},
-	"openx.net":             {},
-	"segment.io":            {},
-	"zendesk.com":           {},
+// The built-in tracker domains. Subdomains of these domains are also trackers.
+var domains = map[string]Category{
+	"amazon-adsystem.com":   Advertising,
+	"appdynamics.com":       Analytics,
+	"doubleclick.net":       Advertising,
+	"google-analytics.com":  Analytics,
+	"googleadservices.com":  Advertising,
+	"googlesyndication.com": Advertising,
+	"googletagmanager.com":  Analytics,
+	"googletagservices.com": Advertising,
+	"gstatic.com":           Other,
+	"openx.net":             Advertising,
+	"segment.io":            Analytics,
+	"zendesk.com":           Other,
 }
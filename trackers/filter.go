@@ -0,0 +1,240 @@
+package trackers
+
+import (
+	"container/list"
+	"net/url"
+	"sync"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/daemon"
+)
+
+// The default for FilterOptions.MaxTracked.
+const DefaultMaxTracked = 10000
+
+// How a tracker was identified.
+type MatchSource string
+
+const (
+	MatchHost    MatchSource = "host"
+	MatchSNI     MatchSource = "sni"
+	MatchReferer MatchSource = "referer"
+)
+
+type FilterOptions struct {
+	// The tracker domains to filter. Defaults to DefaultList.
+	List *List
+
+	// The maximum number of dropped requests and TLS connections to remember,
+	// so that their responses and remaining traffic can also be dropped.
+	// Defaults to DefaultMaxTracked.
+	MaxTracked int
+}
+
+type FilterCounters struct {
+	// The number of items of traffic kept and dropped.
+	Kept    int
+	Dropped int
+
+	// The number of requests and TLS connections found to be trackers, by
+	// category and by how they were identified.
+	ByCategory map[Category]int
+	BySource   map[MatchSource]int
+}
+
+// Drops traffic to and from third-party trackers. A request is a tracker
+// request if its Host, or the host of its Referer, is a tracker. A TLS
+// connection is a tracker connection if the hostname in its SNI is a tracker,
+// in which case all of its metadata, and any traffic decrypted from it, is
+// dropped. Responses to dropped requests are also dropped.
+//
+// Filtering is on when the filter is created, and can be switched with
+// SetEnabled or ApplyLoggingOptions.
+type Filter struct {
+	list       *List
+	maxTracked int
+
+	mu sync.Mutex
+
+	enabled bool // protected by mu
+
+	// The stream keys of dropped requests, and the IDs of tracker connections,
+	// in the order in which they were found. Protected by mu.
+	tracked map[string]*list.Element
+	order   *list.List
+
+	counters FilterCounters // protected by mu
+}
+
+func NewFilter(opts FilterOptions) *Filter {
+	if opts.List == nil {
+		opts.List = DefaultList
+	}
+	if opts.MaxTracked <= 0 {
+		opts.MaxTracked = DefaultMaxTracked
+	}
+	return &Filter{
+		list:       opts.List,
+		maxTracked: opts.MaxTracked,
+		enabled:    true,
+		tracked:    map[string]*list.Element{},
+		order:      list.New(),
+		counters: FilterCounters{
+			ByCategory: map[Category]int{},
+			BySource:   map[MatchSource]int{},
+		},
+	}
+}
+
+// Reads traffic from in until it is closed, and sends the traffic that isn't
+// dropped to out. Closes out when done.
+func (f *Filter) Run(in <-chan akinet.ParsedNetworkTraffic, out chan<- akinet.ParsedNetworkTraffic) {
+	defer close(out)
+	for t := range in {
+		if f.Keep(t) {
+			out <- t
+		}
+	}
+}
+
+// Returns false if the traffic should be dropped.
+func (f *Filter) Keep(t akinet.ParsedNetworkTraffic) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.enabled {
+		return true
+	}
+
+	keep := f.keep(t)
+	if keep {
+		f.counters.Kept++
+	} else {
+		f.counters.Dropped++
+	}
+	return keep
+}
+
+func (f *Filter) SetEnabled(enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.enabled = enabled
+}
+
+// Switches filtering on or off according to the logging options of a trace.
+func (f *Filter) ApplyLoggingOptions(opts daemon.LoggingOptions) {
+	f.SetEnabled(opts.FilterThirdPartyTrackers)
+}
+
+func (f *Filter) Counters() FilterCounters {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := f.counters
+	result.ByCategory = make(map[Category]int, len(f.counters.ByCategory))
+	for k, v := range f.counters.ByCategory {
+		result.ByCategory[k] = v
+	}
+	result.BySource = make(map[MatchSource]int, len(f.counters.BySource))
+	for k, v := range f.counters.BySource {
+		result.BySource[k] = v
+	}
+	return result
+}
+
+// Must hold mu when calling keep.
+func (f *Filter) keep(t akinet.ParsedNetworkTraffic) bool {
+	switch c := t.Content.(type) {
+	case akinet.HTTPRequest:
+		// The response will also be dropped because of its connection, so the
+		// request doesn't need to be tracked.
+		if f.isTrackedConnection(c.TLSConnectionID) {
+			return false
+		}
+		host := c.Host
+		if host == "" {
+			host = c.Header.Get("Host")
+		}
+		if category, ok := f.list.Lookup(host); ok {
+			f.found(category, MatchHost, c.GetStreamKey())
+			return false
+		}
+		if referer, err := url.Parse(c.Header.Get("Referer")); err == nil && referer.Host != "" {
+			if category, ok := f.list.Lookup(referer.Host); ok {
+				f.found(category, MatchReferer, c.GetStreamKey())
+				return false
+			}
+		}
+		return true
+	case akinet.HTTPResponse:
+		if f.isTrackedConnection(c.TLSConnectionID) {
+			return false
+		}
+		return !f.untrack(c.GetStreamKey())
+	case akinet.TLSClientHello:
+		if c.Hostname != nil {
+			if category, ok := f.list.Lookup(*c.Hostname); ok {
+				f.found(category, MatchSNI, connectionKey(c.ConnectionID))
+				return false
+			}
+		}
+		return !f.isTrackedConnection(&c.ConnectionID)
+	case akinet.TLSServerHello:
+		return !f.isTrackedConnection(&c.ConnectionID)
+	case akinet.TLSAlert:
+		return !f.isTrackedConnection(&c.ConnectionID)
+	case akinet.TLSEncryptionStarted:
+		return !f.isTrackedConnection(&c.ConnectionID)
+	case akinet.TLSHandshakeMetadata:
+		return !f.isTrackedConnection(&c.ConnectionID)
+	case akinet.TCPPacketMetadata:
+		return !f.isTrackedConnection(&c.ConnectionID)
+	case akinet.TCPConnectionMetadata:
+		return !f.isTrackedConnection(&c.ConnectionID)
+	default:
+		return true
+	}
+}
+
+// Records a tracker request or connection. Must hold mu when calling found.
+func (f *Filter) found(category Category, source MatchSource, key string) {
+	f.counters.ByCategory[category]++
+	f.counters.BySource[source]++
+	f.track(key)
+}
+
+// Must hold mu when calling track.
+func (f *Filter) track(key string) {
+	if _, ok := f.tracked[key]; ok {
+		return
+	}
+	if f.order.Len() >= f.maxTracked {
+		front := f.order.Front()
+		delete(f.tracked, front.Value.(string))
+		f.order.Remove(front)
+	}
+	f.tracked[key] = f.order.PushBack(key)
+}
+
+// Forgets a dropped request. Returns true if it was being tracked. Must hold
+// mu when calling untrack.
+func (f *Filter) untrack(key string) bool {
+	e, ok := f.tracked[key]
+	if ok {
+		delete(f.tracked, key)
+		f.order.Remove(e)
+	}
+	return ok
+}
+
+// Must hold mu when calling isTrackedConnection.
+func (f *Filter) isTrackedConnection(id *akid.ConnectionID) bool {
+	if id == nil {
+		return false
+	}
+	_, ok := f.tracked[connectionKey(*id)]
+	return ok
+}
+
+func connectionKey(id akid.ConnectionID) string {
+	return akid.String(id)
+}
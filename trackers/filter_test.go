@@ -0,0 +1,112 @@
+package trackers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/akitasoftware/akita-libs/akid"
+	"github.com/akitasoftware/akita-libs/akinet"
+	"github.com/akitasoftware/akita-libs/daemon"
+)
+
+func TestFilter(t *testing.T) {
+	streamID := uuid.New()
+	request := func(seq int, host, referer string, tlsConn *akid.ConnectionID) akinet.ParsedNetworkTraffic {
+		header := http.Header{}
+		if referer != "" {
+			header.Set("Referer", referer)
+		}
+		return akinet.ParsedNetworkTraffic{Content: akinet.HTTPRequest{
+			StreamID:        streamID,
+			Seq:             seq,
+			Host:            host,
+			Header:          header,
+			TLSConnectionID: tlsConn,
+		}}
+	}
+	response := func(seq int, tlsConn *akid.ConnectionID) akinet.ParsedNetworkTraffic {
+		return akinet.ParsedNetworkTraffic{Content: akinet.HTTPResponse{
+			StreamID:        streamID,
+			Seq:             seq,
+			TLSConnectionID: tlsConn,
+		}}
+	}
+
+	trackerConn := akid.GenerateConnectionID()
+	otherConn := akid.GenerateConnectionID()
+	sni := "www.google-analytics.com"
+	otherSNI := "api.example.com"
+
+	traffic := []akinet.ParsedNetworkTraffic{
+		request(1, "api.example.com", "", nil),
+		request(2, "ad.doubleclick.net:443", "", nil),
+		request(3, "api.example.com", "https://www.googletagmanager.com/gtm.js", nil),
+		response(1, nil),
+		response(2, nil),
+		response(3, nil),
+		{Content: akinet.TLSClientHello{ConnectionID: trackerConn, Hostname: &sni}},
+		{Content: akinet.TLSClientHello{ConnectionID: otherConn, Hostname: &otherSNI}},
+		{Content: akinet.TLSServerHello{ConnectionID: trackerConn}},
+		{Content: akinet.TCPConnectionMetadata{ConnectionID: trackerConn}},
+		request(4, "", "", &trackerConn),
+		response(4, &trackerConn),
+		request(5, "api.example.com", "", &otherConn),
+	}
+
+	l := NewList()
+	f := NewFilter(FilterOptions{List: l})
+	in := make(chan akinet.ParsedNetworkTraffic, len(traffic))
+	for _, tr := range traffic {
+		in <- tr
+	}
+	close(in)
+	out := make(chan akinet.ParsedNetworkTraffic)
+	go f.Run(in, out)
+	var kept []akinet.ParsedNetworkTraffic
+	for tr := range out {
+		kept = append(kept, tr)
+	}
+
+	expected := []akinet.ParsedNetworkTraffic{traffic[0], traffic[3], traffic[7], traffic[12]}
+	if assert.Len(t, kept, len(expected)) {
+		for i := range expected {
+			assert.Equal(t, expected[i].Content, kept[i].Content)
+		}
+	}
+
+	assert.Equal(t, FilterCounters{
+		Kept:       4,
+		Dropped:    9,
+		ByCategory: map[Category]int{Advertising: 1, Analytics: 2},
+		BySource:   map[MatchSource]int{MatchHost: 1, MatchReferer: 1, MatchSNI: 1},
+	}, f.Counters())
+
+	// Only the tracker connection is still remembered: the dropped requests
+	// were forgotten when their responses arrived.
+	assert.Equal(t, []string{connectionKey(trackerConn)}, trackedKeys(f))
+
+	// Filtering can be switched off by the trace's logging options.
+	f.ApplyLoggingOptions(daemon.LoggingOptions{FilterThirdPartyTrackers: false})
+	assert.True(t, f.Keep(request(6, "ad.doubleclick.net", "", nil)))
+	f.ApplyLoggingOptions(daemon.LoggingOptions{FilterThirdPartyTrackers: true})
+	assert.False(t, f.Keep(request(7, "ad.doubleclick.net", "", nil)))
+
+	// Domains added at runtime are filtered.
+	l.Add("example.com", Other)
+	assert.False(t, f.Keep(request(8, "api.example.com", "", nil)))
+	assert.Equal(t, 2, f.Counters().ByCategory[Advertising])
+	assert.Equal(t, 1, f.Counters().ByCategory[Other])
+}
+
+func trackedKeys(f *Filter) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []string
+	for e := f.order.Front(); e != nil; e = e.Next() {
+		result = append(result, e.Value.(string))
+	}
+	return result
+}
@@ -1,29 +1,162 @@
 package trackers
 
 import (
+	"bufio"
+	"io"
 	"net"
+	"os"
 	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/publicsuffix"
 )
 
-func IsTrackerDomain(rawHost string) bool {
-	// Handle ports in the host.
-	host := rawHost
-	if strings.Contains(rawHost, ":") {
-		h, _, err := net.SplitHostPort(rawHost)
-		if err != nil {
-			return false
+// The kind of service that a tracker domain belongs to.
+type Category string
+
+const (
+	Analytics   Category = "analytics"
+	Advertising Category = "advertising"
+	Social      Category = "social"
+	Other       Category = "other"
+)
+
+func (c Category) valid() bool {
+	switch c {
+	case Analytics, Advertising, Social, Other:
+		return true
+	default:
+		return false
+	}
+}
+
+// A set of tracker domains, each with a category. Safe for concurrent use.
+type List struct {
+	mu      sync.RWMutex
+	domains map[string]Category // protected by mu
+}
+
+// The list used by IsTrackerDomain. Starts out with the built-in domains, and
+// can be extended at runtime.
+var DefaultList = NewList()
+
+// Returns a list of the built-in tracker domains.
+func NewList() *List {
+	l := &List{domains: make(map[string]Category, len(domains))}
+	for domain, category := range domains {
+		l.domains[domain] = category
+	}
+	return l
+}
+
+// Adds a domain to the list, replacing its category if it's already there.
+// Subdomains of the domain are also considered trackers.
+func (l *List) Add(domain string, category Category) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.domains[normalizeHost(domain)] = category
+}
+
+// Adds the domains in a file to the list. See Load for the format.
+func (l *List) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open tracker list")
+	}
+	defer f.Close()
+	return l.Load(f)
+}
+
+// Adds domains to the list. Each line holds a domain and its category
+// (analytics, advertising, social or other), separated by whitespace. Blank
+// lines and lines starting with # are ignored.
+//
+//	# Product analytics
+//	mixpanel.com analytics
+//
+// Nothing is added if any line is malformed.
+func (l *List) Load(r io.Reader) error {
+	added := map[string]Category{}
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-		host = h
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return errors.Errorf("malformed tracker list line %d: expected a domain and a category", lineNum)
+		}
+		category := Category(strings.ToLower(fields[1]))
+		if !category.valid() {
+			return errors.Errorf("unknown tracker category %q on line %d", fields[1], lineNum)
+		}
+		added[normalizeHost(fields[0])] = category
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "failed to read tracker list")
 	}
 
-	// Strip out subdomains.
-	// Example: www.akitasoftware.com -> akitasoftware.com
-	parts := strings.Split(host, ".")
-	if len(parts) < 2 {
-		return false
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for domain, category := range added {
+		l.domains[domain] = category
+	}
+	return nil
+}
+
+// Returns the category of the host if it is a tracker. The host may include a
+// port. A host is a tracker if it, or any of its parent domains down to its
+// registrable domain, is in the list.
+func (l *List) Lookup(rawHost string) (Category, bool) {
+	host := normalizeHost(rawHost)
+	registrable, err := RegistrableDomain(host)
+	if err != nil {
+		return "", false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for {
+		if category, ok := l.domains[host]; ok {
+			return category, true
+		}
+		if host == registrable {
+			return "", false
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return "", false
+		}
+		host = host[i+1:]
+	}
+}
+
+// Returns the registrable domain of the host, which is its public suffix plus
+// one more label, according to the public suffix list. For example,
+// www.example.co.uk -> example.co.uk. The host may include a port.
+func RegistrableDomain(rawHost string) (string, error) {
+	host := normalizeHost(rawHost)
+	if host == "" || net.ParseIP(host) != nil {
+		return "", errors.Errorf("%q has no registrable domain", rawHost)
 	}
-	rootDomain := strings.Join(parts[len(parts)-2:], ".")
+	return publicsuffix.EffectiveTLDPlusOne(host)
+}
 
-	_, ok := domains[strings.ToLower(rootDomain)]
+// Lower-cases the host, and strips any port and trailing dot.
+func normalizeHost(rawHost string) string {
+	host := rawHost
+	if h, _, err := net.SplitHostPort(rawHost); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Returns whether the host belongs to a tracker in DefaultList. The host may
+// include a port.
+func IsTrackerDomain(rawHost string) bool {
+	_, ok := DefaultList.Lookup(rawHost)
 	return ok
 }
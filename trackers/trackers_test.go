@@ -1,6 +1,7 @@
 package trackers
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -43,9 +44,94 @@ func TestIsTrackerDomain(t *testing.T) {
 			host:     "cdn.segment.io:3000",
 			expected: true,
 		},
+		{
+			host:     "CDN.Segment.IO.",
+			expected: true,
+		},
+		{
+			host:     "10.0.0.1:80",
+			expected: false,
+		},
 	}
 
 	for _, c := range testCases {
 		assert.Equal(t, c.expected, IsTrackerDomain(c.host), c.host)
 	}
 }
+
+func TestListLookup(t *testing.T) {
+	l := NewList()
+	l.Add("tracker.co.uk", Analytics)
+	l.Add("pixel.example.com", Advertising)
+
+	testCases := []struct {
+		host     string
+		category Category
+		expected bool
+	}{
+		{host: "tracker.co.uk", category: Analytics, expected: true},
+		{host: "cdn.tracker.co.uk:443", category: Analytics, expected: true},
+		// Shares only the public suffix with a tracker.
+		{host: "shop.co.uk", expected: false},
+		{host: "co.uk", expected: false},
+		{host: "pixel.example.com", category: Advertising, expected: true},
+		{host: "a.pixel.example.com", category: Advertising, expected: true},
+		{host: "www.example.com", expected: false},
+		{host: "doubleclick.net", category: Advertising, expected: true},
+	}
+	for _, c := range testCases {
+		category, ok := l.Lookup(c.host)
+		assert.Equal(t, c.expected, ok, c.host)
+		assert.Equal(t, c.category, category, c.host)
+	}
+
+	// The default list is unchanged.
+	assert.False(t, IsTrackerDomain("tracker.co.uk"))
+}
+
+func TestListLoad(t *testing.T) {
+	l := NewList()
+	err := l.Load(strings.NewReader(`
+# Product analytics
+mixpanel.com analytics
+
+connect.facebook.com Social
+`))
+	assert.NoError(t, err)
+
+	category, ok := l.Lookup("api.mixpanel.com")
+	assert.True(t, ok)
+	assert.Equal(t, Analytics, category)
+	category, ok = l.Lookup("connect.facebook.com")
+	assert.True(t, ok)
+	assert.Equal(t, Social, category)
+	_, ok = l.Lookup("www.facebook.com")
+	assert.False(t, ok)
+
+	assert.Error(t, l.Load(strings.NewReader("example.org\n")))
+	assert.Error(t, l.Load(strings.NewReader("example.org analytics extra\n")))
+	err = l.Load(strings.NewReader("# Unknown category\nexample.org analytics\nexample.net tracking\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 3")
+	}
+	_, ok = l.Lookup("example.org")
+	assert.False(t, ok)
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	for host, expected := range map[string]string{
+		"www.example.co.uk":    "example.co.uk",
+		"example.com:8080":     "example.com",
+		"a.b.foo.blogspot.com": "foo.blogspot.com",
+		"WWW.Example.COM.":     "example.com",
+	} {
+		domain, err := RegistrableDomain(host)
+		assert.NoError(t, err, host)
+		assert.Equal(t, expected, domain, host)
+	}
+
+	for _, host := range []string{"", "com", "co.uk", "[::1]:80"} {
+		_, err := RegistrableDomain(host)
+		assert.Error(t, err, host)
+	}
+}